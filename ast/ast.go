@@ -3,12 +3,14 @@ package ast
 import (
 	"bytes"
 	"gosling/token"
+	"math/big"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Location() token.TokenLocation
 }
 
 type Statement interface {
@@ -23,11 +25,63 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Comments   []token.Token // every // comment in source order, kept for tools like vet
+}
+
+// TypeExpression is a type annotation, e.g. int or fn(int) -> string
+type TypeExpression interface {
+	Node
+	typeNode()
 }
 
 type Identifier struct {
 	Token token.Token // the token.IDENT token
 	Value string
+	Type  TypeExpression // annotation of a let name or parameter, nil if there is none
+
+	// Resolved is set once a resolver has worked out where the name is
+	// bound: Depth environments out from where it is used, in slot Slot of
+	// that environment, or looked up by name there when Slot is -1
+	Resolved bool
+	Depth    int
+	Slot     int
+}
+
+// Scope lists, in slot order, the names a resolver gave slots in the
+// environment of a function call or of an if let
+type Scope struct {
+	Names []string
+}
+
+// NamedType is a type annotation given by name, e.g. int or Result<T>
+type NamedType struct {
+	Token     token.Token // the token.IDENT token
+	Name      string
+	Arguments []TypeExpression
+	// Parameter is set when the name refers to a type parameter of an
+	// enclosing generic function
+	Parameter bool
+}
+
+// ArrayType is the annotation [T]
+type ArrayType struct {
+	Token   token.Token // the token.LBRACKET token
+	Element TypeExpression
+}
+
+// MapType is the annotation {K: V}
+type MapType struct {
+	Token token.Token // the token.LBRACE token
+	Key   TypeExpression
+	Value TypeExpression
+}
+
+// FunctionType is the annotation of a function value, e.g. fn(int) -> string.
+// Result is nil when the annotation has no arrow.
+type FunctionType struct {
+	Token      token.Token // the token.FUNCTION token
+	Parameters []TypeExpression
+	Result     TypeExpression
 }
 
 type LetStatement struct {
@@ -49,6 +103,13 @@ type ExpressionStatement struct {
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // the value instead of Value when it does not fit in an int64
+
+	// Object is the value of the literal, which resolve.Program makes for
+	// the evaluator to return every time rather than make another. It is
+	// set before the program runs, so evaluations running at once only
+	// read it, and is an interface{} since the objects import this package.
+	Object interface{}
 }
 
 type PrefixExpression struct {
@@ -65,10 +126,16 @@ type InfixExpression struct {
 }
 
 type IfExpression struct {
-	Token       token.Token
+	Token token.Token
+	// Binding is the v in if let v = x { ... }, nil for a plain if. When
+	// set, Condition is the option whose value is bound.
+	Binding     *Identifier
 	Condition   Expression
 	Consequence *BlockStatement
 	Alternative *BlockStatement
+	// Scope is the layout of the environment holding the binding, set by
+	// a resolver
+	Scope *Scope
 }
 
 type ForExpression struct {
@@ -87,15 +154,33 @@ type Boolean struct {
 	Value bool
 }
 
+// NoneLiteral is the empty option, none
+type NoneLiteral struct {
+	Token token.Token
+}
+
 type FunctionLiteral struct {
-	Token      token.Token
-	Parameters []*Identifier
-	Body       *BlockStatement
+	Token          token.Token
+	TypeParameters []*Identifier // the T in fn<T>(x: T), empty unless generic
+	Parameters     []*Identifier
+	ReturnType     TypeExpression // nil if the result is not annotated
+	Body           *BlockStatement
+	// Scope is the layout of the environment of a call, set by a resolver
+	Scope *Scope
 }
 
 type StringLiteral struct {
 	Token token.Token
 	Value string
+
+	// Object is the value of the literal, made as for IntegerLiteral
+	Object interface{}
+}
+
+type AssignExpression struct {
+	Token token.Token // the token.ASSIGN token
+	Name  *Identifier
+	Value Expression
 }
 
 type CallExpression struct {
@@ -115,6 +200,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Location() token.TokenLocation {
+	if len(p.Statements) > 0 && p.Statements[0] != nil {
+		return p.Statements[0].Location()
+	}
+	return token.TokenLocation{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -126,13 +218,71 @@ func (p *Program) String() string {
 }
 
 // Identifier methods
-func (i *Identifier) expressionNode()      {}
-func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
-func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) expressionNode()               {}
+func (i *Identifier) TokenLiteral() string          { return i.Token.Literal }
+func (i *Identifier) Location() token.TokenLocation { return i.Token.Location }
+func (i *Identifier) String() string {
+	if i.Type != nil {
+		return i.Value + ": " + i.Type.String()
+	}
+	return i.Value
+}
+
+// NamedType methods
+func (nt *NamedType) typeNode()                     {}
+func (nt *NamedType) TokenLiteral() string          { return nt.Token.Literal }
+func (nt *NamedType) Location() token.TokenLocation { return nt.Token.Location }
+func (nt *NamedType) String() string {
+	if len(nt.Arguments) == 0 {
+		return nt.Name
+	}
+	args := []string{}
+	for _, a := range nt.Arguments {
+		args = append(args, a.String())
+	}
+	return nt.Name + "<" + strings.Join(args, ", ") + ">"
+}
+
+// ArrayType methods
+func (at *ArrayType) typeNode()                     {}
+func (at *ArrayType) TokenLiteral() string          { return at.Token.Literal }
+func (at *ArrayType) Location() token.TokenLocation { return at.Token.Location }
+func (at *ArrayType) String() string                { return "[" + at.Element.String() + "]" }
+
+// MapType methods
+func (mt *MapType) typeNode()                     {}
+func (mt *MapType) TokenLiteral() string          { return mt.Token.Literal }
+func (mt *MapType) Location() token.TokenLocation { return mt.Token.Location }
+func (mt *MapType) String() string {
+	return "{" + mt.Key.String() + ": " + mt.Value.String() + "}"
+}
+
+// FunctionType methods
+func (ft *FunctionType) typeNode()                     {}
+func (ft *FunctionType) TokenLiteral() string          { return ft.Token.Literal }
+func (ft *FunctionType) Location() token.TokenLocation { return ft.Token.Location }
+func (ft *FunctionType) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	if ft.Result != nil {
+		out.WriteString(" -> " + ft.Result.String())
+	}
+
+	return out.String()
+}
 
 // LetStatement methods
-func (ls *LetStatement) statementNode()       {}
-func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) statementNode()                {}
+func (ls *LetStatement) TokenLiteral() string          { return ls.Token.Literal }
+func (ls *LetStatement) Location() token.TokenLocation { return ls.Token.Location }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -150,8 +300,9 @@ func (ls *LetStatement) String() string {
 }
 
 // ReturnStatement methods
-func (rs *ReturnStatement) statementNode()       {}
-func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) statementNode()                {}
+func (rs *ReturnStatement) TokenLiteral() string          { return rs.Token.Literal }
+func (rs *ReturnStatement) Location() token.TokenLocation { return rs.Token.Location }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -167,8 +318,9 @@ func (rs *ReturnStatement) String() string {
 }
 
 // ExpressionStatement methods
-func (es *ExpressionStatement) statementNode()       {}
-func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) statementNode()                {}
+func (es *ExpressionStatement) TokenLiteral() string          { return es.Token.Literal }
+func (es *ExpressionStatement) Location() token.TokenLocation { return es.Token.Location }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -177,13 +329,15 @@ func (es *ExpressionStatement) String() string {
 }
 
 // IntegerLiteral methods
-func (il *IntegerLiteral) expressionNode()      {}
-func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
-func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) expressionNode()               {}
+func (il *IntegerLiteral) TokenLiteral() string          { return il.Token.Literal }
+func (il *IntegerLiteral) Location() token.TokenLocation { return il.Token.Location }
+func (il *IntegerLiteral) String() string                { return il.Token.Literal }
 
 // PrefixExpression methods
-func (pe *PrefixExpression) expressionNode()      {}
-func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) expressionNode()               {}
+func (pe *PrefixExpression) TokenLiteral() string          { return pe.Token.Literal }
+func (pe *PrefixExpression) Location() token.TokenLocation { return pe.Token.Location }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -196,8 +350,9 @@ func (pe *PrefixExpression) String() string {
 }
 
 // InfixExpression methods
-func (ie *InfixExpression) expressionNode()      {}
-func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) expressionNode()               {}
+func (ie *InfixExpression) TokenLiteral() string          { return ie.Token.Literal }
+func (ie *InfixExpression) Location() token.TokenLocation { return ie.Token.Location }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -211,17 +366,28 @@ func (ie *InfixExpression) String() string {
 }
 
 // Boolean methods
-func (b *Boolean) expressionNode()      {}
-func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
-func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) expressionNode()               {}
+func (b *Boolean) TokenLiteral() string          { return b.Token.Literal }
+func (b *Boolean) Location() token.TokenLocation { return b.Token.Location }
+func (b *Boolean) String() string                { return b.Token.Literal }
+
+// NoneLiteral methods
+func (n *NoneLiteral) expressionNode()               {}
+func (n *NoneLiteral) TokenLiteral() string          { return n.Token.Literal }
+func (n *NoneLiteral) Location() token.TokenLocation { return n.Token.Location }
+func (n *NoneLiteral) String() string                { return n.Token.Literal }
 
 // IfExpression methods
-func (ie *IfExpression) expressionNode()      {}
-func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) expressionNode()               {}
+func (ie *IfExpression) TokenLiteral() string          { return ie.Token.Literal }
+func (ie *IfExpression) Location() token.TokenLocation { return ie.Token.Location }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("if")
+	if ie.Binding != nil {
+		out.WriteString(" let " + ie.Binding.String() + " = ")
+	}
 	out.WriteString(ie.Condition.String())
 	out.WriteString(" ")
 	out.WriteString(ie.Consequence.String())
@@ -235,8 +401,9 @@ func (ie *IfExpression) String() string {
 }
 
 // BlockStatement methods
-func (bs *BlockStatement) statementNode()       {}
-func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) statementNode()                {}
+func (bs *BlockStatement) TokenLiteral() string          { return bs.Token.Literal }
+func (bs *BlockStatement) Location() token.TokenLocation { return bs.Token.Location }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -248,8 +415,9 @@ func (bs *BlockStatement) String() string {
 }
 
 // FunctionLliteral methdos
-func (fl *FunctionLiteral) expressionNode()      {}
-func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) expressionNode()               {}
+func (fl *FunctionLiteral) TokenLiteral() string          { return fl.Token.Literal }
+func (fl *FunctionLiteral) Location() token.TokenLocation { return fl.Token.Location }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -259,17 +427,28 @@ func (fl *FunctionLiteral) String() string {
 	}
 
 	out.WriteString(fl.TokenLiteral())
+	if len(fl.TypeParameters) > 0 {
+		typeParams := []string{}
+		for _, tp := range fl.TypeParameters {
+			typeParams = append(typeParams, tp.String())
+		}
+		out.WriteString("<" + strings.Join(typeParams, ", ") + ">")
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(" -> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
 }
 
 // ForExpression methods
-func (fe *ForExpression) expressionNode()      {}
-func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) expressionNode()               {}
+func (fe *ForExpression) TokenLiteral() string          { return fe.Token.Literal }
+func (fe *ForExpression) Location() token.TokenLocation { return fe.Token.Location }
 func (fe *ForExpression) String() string {
 	var out bytes.Buffer
 
@@ -282,8 +461,9 @@ func (fe *ForExpression) String() string {
 }
 
 // CallExpression methods
-func (ce *CallExpression) expressionNode()      {}
-func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) expressionNode()               {}
+func (ce *CallExpression) TokenLiteral() string          { return ce.Token.Literal }
+func (ce *CallExpression) Location() token.TokenLocation { return ce.Token.Location }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -297,7 +477,22 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression methods
+func (ae *AssignExpression) expressionNode()               {}
+func (ae *AssignExpression) TokenLiteral() string          { return ae.Token.Literal }
+func (ae *AssignExpression) Location() token.TokenLocation { return ae.Token.Location }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ae.Name.String())
+	out.WriteString(" = ")
+	out.WriteString(ae.Value.String())
+
+	return out.String()
+}
+
 // StringngLiteral methods
-func (sl *StringLiteral) expressionNode()      {}
-func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) expressionNode()               {}
+func (sl *StringLiteral) TokenLiteral() string          { return sl.Token.Literal }
+func (sl *StringLiteral) Location() token.TokenLocation { return sl.Token.Location }
+func (sl *StringLiteral) String() string                { return sl.Token.Literal }
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"gosling/token"
+	"strings"
 	"testing"
 )
 
@@ -27,3 +28,55 @@ func TestString(t *testing.T) {
 	}
 
 }
+
+func TestInspect(t *testing.T) {
+	// let f = fn(x) { x + 1 }; f(2);
+	x := &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"}
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  &Identifier{Token: token.Token{Type: token.IDENT, Literal: "f"}, Value: "f"},
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+					Parameters: []*Identifier{x},
+					Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &InfixExpression{
+							Left:     x,
+							Operator: "+",
+							Right:    &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+						}},
+					}},
+				},
+			},
+			&ExpressionStatement{Expression: &CallExpression{
+				Function:  &Identifier{Token: token.Token{Type: token.IDENT, Literal: "f"}, Value: "f"},
+				Arguments: []Expression{&IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "2"}, Value: 2}},
+			}},
+			(*LetStatement)(nil),
+		},
+	}
+
+	idents := []string{}
+	Inspect(program, func(n Node) bool {
+		if ident, ok := n.(*Identifier); ok {
+			idents = append(idents, ident.Value)
+		}
+		return true
+	})
+	if strings.Join(idents, " ") != "f x x f" {
+		t.Errorf("wrong identifiers visited, got=%q", idents)
+	}
+
+	idents = idents[:0]
+	Inspect(program, func(n Node) bool {
+		if ident, ok := n.(*Identifier); ok {
+			idents = append(idents, ident.Value)
+		}
+		_, isFunction := n.(*FunctionLiteral)
+		return !isFunction
+	})
+	if strings.Join(idents, " ") != "f f" {
+		t.Errorf("function literal was not skipped, got=%q", idents)
+	}
+}
@@ -0,0 +1,86 @@
+package ast
+
+import "reflect"
+
+// Inspect traverses the tree rooted at node in depth-first order, calling
+// f for each node. If f returns false the children of that node are
+// skipped. Nil children are not visited.
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *Identifier:
+		Inspect(n.Type, f)
+	case *NamedType:
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	case *ArrayType:
+		Inspect(n.Element, f)
+	case *MapType:
+		Inspect(n.Key, f)
+		Inspect(n.Value, f)
+	case *FunctionType:
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(n.Result, f)
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *AssignExpression:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *IfExpression:
+		Inspect(n.Binding, f)
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *ForExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+	case *FunctionLiteral:
+		for _, tp := range n.TypeParameters {
+			Inspect(tp, f)
+		}
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(n.ReturnType, f)
+		Inspect(n.Body, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	}
+}
+
+// isNil catches both a nil interface and a typed nil pointer, which the
+// parser leaves behind for statements it could not finish
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gosling/bench"
+	"gosling/evaluator"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"time"
+)
+
+const benchUsage = "usage: gosling bench [--engine=tree|vm] [--benchtime=d] [--count=n] [--run=regexp] [--baseline=file.json] [--save=file.json] [file.gos|dir...]\n"
+
+// benchCmd runs the benchmarks of the files the arguments name, the
+// standard suite without any, and prints how long each call takes and what
+// it allocates, or how that changed from a baseline saved by an earlier run
+func benchCmd(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	engine := fs.String("engine", bench.EngineTree, "how to run the benchmarks: tree to walk the syntax tree, vm to compile them to bytecode")
+	benchtime := fs.Duration("benchtime", 100*time.Millisecond, "how long each sample runs a benchmark for")
+	count := fs.Int("count", 10, "how many samples to take of each benchmark")
+	run := fs.String("run", "", "only run the benchmarks whose names, such as fib/bench_fib, match this regular expression")
+	baseline := fs.String("baseline", "", "compare with the results saved in this file")
+	save := fs.String("save", "", "save the results to this file, to compare later runs with")
+	maxDepth := fs.Int("max-depth", 0, "maximum call depth, 0 for the default, negative for no limit")
+	passes := optimizeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *engine != bench.EngineTree && *engine != bench.EngineVM {
+		fmt.Fprintf(errOut, "unknown engine %q, want tree or vm\n", *engine)
+		return 2
+	}
+	if *benchtime <= 0 || *count < 1 {
+		fmt.Fprint(errOut, benchUsage)
+		return 2
+	}
+	match, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintf(errOut, "--run: %s\n", err)
+		return 2
+	}
+
+	var old *bench.Baseline
+	if *baseline != "" {
+		if old, err = bench.ReadBaseline(*baseline); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+		if old.Engine != *engine {
+			fmt.Fprintf(errOut, "%s was saved running on %s, not %s\n", *baseline, old.Engine, *engine)
+			return 1
+		}
+	}
+	files := bench.Suite()
+	if fs.NArg() > 0 {
+		if files, err = bench.Files(fs.Args()...); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+	}
+
+	// Ctrl+C stops the benchmark running with a located error
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg := bench.Config{Engine: *engine, Passes: *passes, Options: evaluator.Options{MaxDepth: *maxDepth}}
+	results := &bench.Baseline{Engine: *engine}
+	for _, f := range files {
+		var names []string
+		for _, name := range f.Benchmarks {
+			if match.MatchString(f.Name + "/" + name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		p, err := bench.Load(ctx, f, cfg)
+		if err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+		for _, name := range names {
+			result, err := p.Measure(ctx, name, *benchtime, *count)
+			if err != nil {
+				fmt.Fprintf(errOut, "%s\n", err)
+				return 1
+			}
+			results.Results = append(results.Results, result)
+		}
+	}
+	if len(results.Results) == 0 {
+		fmt.Fprintf(errOut, "no benchmarks match %q\n", *run)
+		return 1
+	}
+
+	if old != nil {
+		err = bench.WriteComparison(out, old.Results, results.Results)
+	} else {
+		err = bench.WriteResults(out, results.Results)
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return 1
+	}
+	if *save != "" {
+		if err := results.Write(*save); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,283 @@
+// Package bench runs the benchmarks of gosling programs and compares their
+// results with those of an earlier run.
+//
+// A benchmark is a function without parameters bound at the top level of a
+// .gos file to a name that starts with bench_:
+//
+//	let bench_fib = fn() { fib(20) };
+//
+// The file runs once to bind its names. Each sample then calls the
+// benchmark over and over, as Go's testing.B does, for long enough to time
+// it well, and counts what the calls allocate. Comparing samples with a
+// baseline tells real changes from noise with a Mann-Whitney U test, as
+// benchstat does.
+package bench
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"gosling/ast"
+	"gosling/compiler"
+	"gosling/evaluator"
+	"gosling/lexer"
+	"gosling/object"
+	"gosling/optimize"
+	"gosling/parser"
+	"gosling/resolve"
+	"gosling/vm"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix starts the names of benchmark functions
+const Prefix = "bench_"
+
+// The engines benchmarks can run on, as gosling run names them
+const (
+	EngineTree = "tree"
+	EngineVM   = "vm"
+)
+
+// ErrNoBenchmarks is the error of a file that has no benchmarks
+var ErrNoBenchmarks = errors.New("no benchmarks, functions bound to names starting with " + Prefix)
+
+// File is a .gos file of benchmarks
+type File struct {
+	Name       string // what its results are reported under, its base name without .gos
+	Path       string
+	Source     string
+	Benchmarks []string // the names of its benchmarks, in the order of the source
+}
+
+// Parse reads the benchmarks of the source of the .gos file at path
+func Parse(path, source string) (*File, error) {
+	program, err := parse(path, source)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Name: strings.TrimSuffix(filepath.Base(path), ".gos"), Path: path, Source: source}
+	for _, s := range program.Statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok || !strings.HasPrefix(let.Name.Value, Prefix) {
+			continue
+		}
+		fn, ok := let.Value.(*ast.FunctionLiteral)
+		if !ok || len(fn.Parameters) > 0 {
+			return nil, fmt.Errorf("%s: %s is not a function without parameters, as a benchmark must be", path, let.Name.Value)
+		}
+		f.Benchmarks = append(f.Benchmarks, let.Name.Value)
+	}
+	if len(f.Benchmarks) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNoBenchmarks)
+	}
+	return f, nil
+}
+
+// ParseFile reads the benchmarks of the .gos file at path
+func ParseFile(path string) (*File, error) {
+	if filepath.Ext(path) != ".gos" {
+		return nil, fmt.Errorf("%s is not a .gos file", path)
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, string(source))
+}
+
+// Files reads the benchmarks of the .gos files paths name, and of those in
+// the directories they name that have any
+func Files(paths ...string) ([]*File, error) {
+	var files []*File
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			f, err := ParseFile(path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(p) != ".gos" {
+				return err
+			}
+			f, err := ParseFile(p)
+			if errors.Is(err, ErrNoBenchmarks) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+//go:embed suite/*.gos
+var suite embed.FS
+
+// Suite returns the standard benchmarks: recursive calls, building
+// strings, closures and loops
+func Suite() []*File {
+	entries, err := suite.ReadDir("suite")
+	if err != nil {
+		panic(err)
+	}
+	var files []*File
+	for _, entry := range entries {
+		name := path.Join("suite", entry.Name())
+		source, err := suite.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		f, err := Parse(name, string(source))
+		if err != nil {
+			panic(err)
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func parse(path, source string) (*ast.Program, error) {
+	l := lexer.LexRepl(source)
+	l.Location.Filename = path
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, fmt.Errorf("%s does not parse:\n\t%s", path, strings.Join(p.Errors(), "\n\t"))
+	}
+	return program, nil
+}
+
+// Config says how to run benchmarks
+type Config struct {
+	Engine  string            // EngineTree or EngineVM
+	Passes  optimize.Options  // the optimisations to apply first
+	Options evaluator.Options // the limits of each call
+}
+
+// Program is a file of benchmarks that has run on an engine, ready for
+// its benchmarks to be called
+type Program struct {
+	file  *File
+	cfg   Config
+	calls map[string]func(ctx context.Context) object.Object
+}
+
+// Load runs the top level of f on the engine of cfg, binding its names
+func Load(ctx context.Context, f *File, cfg Config) (*Program, error) {
+	program, err := parse(f.Path, f.Source)
+	if err != nil {
+		return nil, err
+	}
+	optimize.Program(program, cfg.Passes)
+	p := &Program{file: f, cfg: cfg, calls: map[string]func(context.Context) object.Object{}}
+	switch cfg.Engine {
+	case EngineTree:
+		err = p.loadTree(ctx, program)
+	case EngineVM:
+		err = p.loadVM(ctx, program)
+	default:
+		return nil, fmt.Errorf("unknown engine %q, want %s or %s", cfg.Engine, EngineTree, EngineVM)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// call parses a call of the benchmark name, located in the file it is in
+func (p *Program) call(name string) (*ast.Program, error) {
+	return parse(p.file.Path, name+"()")
+}
+
+// loadTree evaluates the program and calls each benchmark in the
+// environment it leaves, as the REPL runs a line after the ones before
+func (p *Program) loadTree(ctx context.Context, program *ast.Program) error {
+	resolve.Program(program)
+	env := object.NewEnvironment()
+	if err := failed(evaluator.Eval(ctx, program, env, p.cfg.Options)); err != nil {
+		return fmt.Errorf("%s: %w", p.file.Path, err)
+	}
+	for _, name := range p.file.Benchmarks {
+		call, err := p.call(name)
+		if err != nil {
+			return err
+		}
+		resolve.Program(call)
+		p.calls[name] = func(ctx context.Context) object.Object {
+			return evaluator.Eval(ctx, call, env, p.cfg.Options)
+		}
+	}
+	return nil
+}
+
+// loadVM runs the program and compiles each benchmark's call against its
+// symbols, to run on the globals it leaves
+func (p *Program) loadVM(ctx context.Context, program *ast.Program) error {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return fmt.Errorf("%s: %w", p.file.Path, err)
+	}
+	bytecode := comp.Bytecode()
+	machine := vm.New(bytecode, p.cfg.Options)
+	if err := failed(machine.Run(ctx)); err != nil {
+		return fmt.Errorf("%s: %w", p.file.Path, err)
+	}
+	globals := machine.Globals()
+	symbols, constants := comp.SymbolTable(), bytecode.Constants
+	for _, name := range p.file.Benchmarks {
+		call, err := p.call(name)
+		if err != nil {
+			return err
+		}
+		comp := compiler.NewWithState(symbols, constants)
+		if err := comp.Compile(call); err != nil {
+			return err
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+		p.calls[name] = func(ctx context.Context) object.Object {
+			return vm.NewWithGlobalsStore(bytecode, globals, p.cfg.Options).Run(ctx)
+		}
+	}
+	return nil
+}
+
+// Call calls the benchmark name once, returning its value or the error it
+// failed with
+func (p *Program) Call(ctx context.Context, name string) (object.Object, error) {
+	call, ok := p.calls[name]
+	if !ok {
+		return nil, fmt.Errorf("%s has no benchmark %s", p.file.Path, name)
+	}
+	result := call(ctx)
+	if err := failed(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// failed returns the error a program stopped with as a Go error
+func failed(result object.Object) error {
+	if err, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", err.Inspect())
+	}
+	return nil
+}
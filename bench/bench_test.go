@@ -0,0 +1,329 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gosling/evaluator"
+	"gosling/object"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		source     string
+		benchmarks []string
+		err        string
+	}{
+		{"let bench_a = fn() { 1 }; let b = 2; let bench_c = fn() { b };", []string{"bench_a", "bench_c"}, ""},
+		{"let helper = fn(x) { x }; let bench_helper = fn() { helper(1) };", []string{"bench_helper"}, ""},
+		{"let bench_a = fn(n) { n };", nil, "bench_a is not a function without parameters"},
+		{"let bench_a = 1;", nil, "bench_a is not a function without parameters"},
+		{"let a = fn() { 1 }; a();", nil, "no benchmarks"},
+		{"let bench_a = fn() { 1 + };", nil, "does not parse"},
+	}
+	for _, tt := range tests {
+		f, err := Parse("dir/x.gos", tt.source)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse(%q) failed with %v, want an error containing %q", tt.source, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.source, err)
+			continue
+		}
+		if f.Name != "x" {
+			t.Errorf("Parse(%q) named the file %q, want x", tt.source, f.Name)
+		}
+		if !reflect.DeepEqual(f.Benchmarks, tt.benchmarks) {
+			t.Errorf("Parse(%q) found %v, want %v", tt.source, f.Benchmarks, tt.benchmarks)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	f, err := ParseFile(filepath.Join("suite", "fib.gos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bench_fib", "bench_fib_tail"}; !reflect.DeepEqual(f.Benchmarks, want) {
+		t.Errorf("found %v, want %v", f.Benchmarks, want)
+	}
+	if _, err := ParseFile("bench.go"); err == nil {
+		t.Errorf("ParseFile read a .go file")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, source string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.gos", "let bench_a = fn() { 1 };")
+	write("helpers.gos", "let helper = fn() { 1 };")
+	write("notes.txt", "let bench_b = fn() { 1 };")
+	files, err := Files(dir, filepath.Join("suite", "fib.gos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if want := []string{"a", "fib"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("read %v, want %v", names, want)
+	}
+	if _, err := Files(filepath.Join(dir, "helpers.gos")); !errors.Is(err, ErrNoBenchmarks) {
+		t.Errorf("reading a file without benchmarks failed with %v, want ErrNoBenchmarks", err)
+	}
+}
+
+// suiteValues are what the benchmarks of the suite return, which the
+// engines must agree on for their timings to compare the same work
+var suiteValues = map[string]string{
+	"closures/bench_counter": "1001",
+	"closures/bench_compose": "1500",
+	"closures/bench_make":    "999",
+	"fib/bench_fib":          "6765",
+	"fib/bench_fib_tail":     "2880067194370816120",
+	"loops/bench_count":      "49995000",
+	"loops/bench_nested":     "3400",
+	"strings/bench_concat":   "1400",
+	"strings/bench_equal":    "200",
+}
+
+func TestSuite(t *testing.T) {
+	ctx := context.Background()
+	seen := 0
+	for _, engine := range []string{EngineTree, EngineVM} {
+		for _, f := range Suite() {
+			p, err := Load(ctx, f, Config{Engine: engine})
+			if err != nil {
+				t.Fatalf("%s: %v", engine, err)
+			}
+			for _, name := range f.Benchmarks {
+				full := f.Name + "/" + name
+				// a benchmark returns the same each call, or its calls
+				// would not be the same work
+				for i := 0; i < 2; i++ {
+					result, err := p.Call(ctx, name)
+					if err != nil {
+						t.Errorf("%s %s failed: %v", engine, full, err)
+						continue
+					}
+					if got := result.Inspect(); got != suiteValues[full] {
+						t.Errorf("%s %s call %d = %s, want %s", engine, full, i+1, got, suiteValues[full])
+					}
+				}
+				seen++
+			}
+		}
+	}
+	if seen != 2*len(suiteValues) {
+		t.Errorf("ran %d benchmarks, want %d", seen, 2*len(suiteValues))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		source string
+		err    string // of Load, or of the call of bench_a if it loads
+	}{
+		{`let x = 1 + "a"; let bench_a = fn() { 1 };`, "unknown operator"},
+		{`let bench_a = fn() { 1 + "a" };`, "unknown operator"},
+		{`let bench_a = fn() { 1 + bench_a() };`, "maximum call depth"},
+	}
+	for _, engine := range []string{EngineTree, EngineVM} {
+		for _, tt := range tests {
+			f, err := Parse("x.gos", tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := Load(ctx, f, Config{Engine: engine, Options: evaluator.Options{MaxDepth: 100}})
+			if err == nil {
+				_, err = p.Call(ctx, "bench_a")
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s %q failed with %v, want an error containing %q", engine, tt.source, err, tt.err)
+			}
+		}
+	}
+	f := Suite()[0]
+	if _, err := Load(ctx, f, Config{Engine: "jit"}); err == nil {
+		t.Errorf("Load ran on an unknown engine")
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	f, err := Parse("x.gos", `let bench_closure = fn() { fn(x) { x } };`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, engine := range []string{EngineTree, EngineVM} {
+		p, err := Load(context.Background(), f, Config{Engine: engine})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := p.Measure(context.Background(), "bench_closure", time.Millisecond, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Name != "x/bench_closure" || len(result.Samples) != 3 {
+			t.Fatalf("%s: measured %s with %d samples, want x/bench_closure with 3", engine, result.Name, len(result.Samples))
+		}
+		for _, s := range result.Samples {
+			if s.N < 1 || s.NsPerOp <= 0 || s.AllocsPerOp < 1 || s.BytesPerOp <= 0 {
+				t.Errorf("%s: implausible sample %+v of making a closure", engine, s)
+			}
+		}
+		if _, err := p.Measure(context.Background(), "bench_missing", time.Millisecond, 1); err == nil {
+			t.Errorf("%s: measured a benchmark that is not there", engine)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		xs   []float64
+		want Summary
+	}{
+		{nil, Summary{}},
+		{[]float64{3}, Summary{N: 1, Mean: 3}},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, Summary{N: 8, Mean: 5, StdDev: math.Sqrt(32.0 / 7)}},
+	}
+	for _, tt := range tests {
+		got := Summarize(tt.xs)
+		if got.N != tt.want.N || got.Mean != tt.want.Mean || math.Abs(got.StdDev-tt.want.StdDev) > 1e-12 {
+			t.Errorf("Summarize(%v) = %+v, want %+v", tt.xs, got, tt.want)
+		}
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		xs, ys []float64
+		want   float64
+	}{
+		// exact, without ties
+		{[]float64{1, 2, 3}, []float64{4, 5, 6}, 0.1},
+		{[]float64{4, 5, 6}, []float64{1, 2, 3}, 0.1},
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		{[]float64{1, 3, 5}, []float64{2, 4, 6}, 0.7},
+		{[]float64{1, 4, 5}, []float64{2, 3, 6}, 1},
+		// normal approximation, with ties
+		{[]float64{1, 1, 1}, []float64{1, 1, 1}, 1},
+		{[]float64{1, 2, 2, 3, 3}, []float64{3, 4, 4, 5, 6}, 0.0192},
+		{nil, []float64{1}, 1},
+	}
+	for _, tt := range tests {
+		if got := MannWhitney(tt.xs, tt.ys); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("MannWhitney(%v, %v) = %.4f, want %.4f", tt.xs, tt.ys, got, tt.want)
+		}
+	}
+}
+
+// results returns a result of each name with samples of the times
+func results(names []string, times ...[]float64) []*Result {
+	var rs []*Result
+	for i, name := range names {
+		r := &Result{Name: name}
+		for _, ns := range times[i] {
+			r.Samples = append(r.Samples, Sample{N: 100, NsPerOp: ns, AllocsPerOp: 10, BytesPerOp: 160})
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func TestBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	b := &Baseline{Engine: EngineVM, Results: results([]string{"fib/bench_fib"}, []float64{1500, 1510.5})}
+	if err := b.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("read %+v, want %+v", got, b)
+	}
+	if _, err := ReadBaseline("bench.go"); err == nil || !strings.Contains(err.Error(), "not a baseline") {
+		t.Errorf("ReadBaseline read a Go file with %v", err)
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	rs := results([]string{"fib/bench_fib", "loops/bench_count"}, []float64{2e6, 2e6}, []float64{900, 1100})
+	var out bytes.Buffer
+	if err := WriteResults(&out, rs); err != nil {
+		t.Fatal(err)
+	}
+	want := `name               time/op       allocs/op  B/op
+fib/bench_fib      2.00ms ± 0%   10 ± 0%    160 ± 0%
+loops/bench_count  1.00µs ± 14%  10 ± 0%    160 ± 0%
+`
+	if out.String() != want {
+		t.Errorf("wrote\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteComparison(t *testing.T) {
+	names := []string{"fib/bench_fib", "loops/bench_count", "strings/bench_new"}
+	old := results(names[:2], []float64{100, 101, 102, 103, 104}, []float64{100, 101, 102, 103, 104})
+	new := results(names, []float64{90, 91, 92, 93, 94}, []float64{100, 102, 101, 104, 103}, []float64{5})
+	var out bytes.Buffer
+	if err := WriteComparison(&out, old, new); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"fib/bench_fib      102ns ± 2%   92ns ± 2%    -9.80% (p=0.008 n=5+5)",
+		"loops/bench_count  102ns ± 2%   102ns ± 2%   ~ (p=1.000 n=5+5)",
+		"name               old allocs/op  new allocs/op  delta",
+		"fib/bench_fib      10 ± 0%        10 ± 0%        ~ (p=1.000 n=5+5)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("comparison\n%s\ndoes not contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "bench_new") {
+		t.Errorf("comparison\n%s\nincludes a benchmark the baseline does not have", got)
+	}
+}
+
+// BenchmarkSuite runs the standard suite as Go benchmarks, calling each
+// on both engines as gosling bench does
+func BenchmarkSuite(b *testing.B) {
+	ctx := context.Background()
+	for _, engine := range []string{EngineTree, EngineVM} {
+		for _, f := range Suite() {
+			p, err := Load(ctx, f, Config{Engine: engine})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, name := range f.Benchmarks {
+				call := p.calls[name]
+				b.Run(engine+"/"+f.Name+"/"+name, func(b *testing.B) {
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						if result := call(ctx); result.Type() == object.ERROR_OBJ {
+							b.Fatal(result.Inspect())
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"gosling/object"
+	"runtime"
+	"time"
+)
+
+// Sample is one measurement of a benchmark, over N calls
+type Sample struct {
+	N           int     `json:"n"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	AllocsPerOp float64 `json:"allocs_per_op"`
+	BytesPerOp  float64 `json:"bytes_per_op"`
+}
+
+// Result is the samples taken of a benchmark
+type Result struct {
+	Name    string   `json:"name"` // the file's name and the benchmark's, as fib/bench_fib
+	Samples []Sample `json:"samples"`
+}
+
+// NsPerOp returns the time each sample took per call
+func (r *Result) NsPerOp() []float64 {
+	return r.measure(func(s Sample) float64 { return s.NsPerOp })
+}
+
+// AllocsPerOp returns the allocations each sample made per call
+func (r *Result) AllocsPerOp() []float64 {
+	return r.measure(func(s Sample) float64 { return s.AllocsPerOp })
+}
+
+// BytesPerOp returns the bytes each sample allocated per call
+func (r *Result) BytesPerOp() []float64 {
+	return r.measure(func(s Sample) float64 { return s.BytesPerOp })
+}
+
+func (r *Result) measure(of func(Sample) float64) []float64 {
+	xs := make([]float64, len(r.Samples))
+	for i, s := range r.Samples {
+		xs[i] = of(s)
+	}
+	return xs
+}
+
+// maxN bounds the calls of a sample, as testing.B does
+const maxN = 1e9
+
+// Measure takes count samples of the benchmark name, each of as many calls
+// as take about benchtime together
+func (p *Program) Measure(ctx context.Context, name string, benchtime time.Duration, count int) (*Result, error) {
+	call, ok := p.calls[name]
+	if !ok {
+		return nil, fmt.Errorf("%s has no benchmark %s", p.file.Path, name)
+	}
+	result := &Result{Name: p.file.Name + "/" + name}
+
+	// work out how many calls take benchtime, growing n as testing.B
+	// does: by the rate so far with room to spare, but not too fast
+	n := 1
+	for {
+		s, err := sample(ctx, call, n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", result.Name, err)
+		}
+		elapsed := time.Duration(s.NsPerOp * float64(n))
+		if elapsed >= benchtime || n >= maxN {
+			break
+		}
+		next := int(1.2 * float64(benchtime) / max(s.NsPerOp, 1))
+		n = min(max(next, n+1), 100*n, maxN)
+	}
+
+	for i := 0; i < count; i++ {
+		s, err := sample(ctx, call, n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", result.Name, err)
+		}
+		result.Samples = append(result.Samples, s)
+	}
+	return result, nil
+}
+
+// sample calls call n times, timing the calls and counting what they
+// allocate, after a collection so the garbage of earlier samples does not
+// count against them
+func sample(ctx context.Context, call func(context.Context) object.Object, n int) (Sample, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if result := call(ctx); result != nil && result.Type() == object.ERROR_OBJ {
+			return Sample{}, failed(result)
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return Sample{
+		N:           n,
+		NsPerOp:     float64(elapsed.Nanoseconds()) / float64(n),
+		AllocsPerOp: float64(after.Mallocs-before.Mallocs) / float64(n),
+		BytesPerOp:  float64(after.TotalAlloc-before.TotalAlloc) / float64(n),
+	}, nil
+}
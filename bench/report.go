@@ -0,0 +1,138 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// Baseline is a saved run of benchmarks, for later runs to compare with
+type Baseline struct {
+	Engine  string    `json:"engine"`
+	Results []*Result `json:"benchmarks"`
+}
+
+// ReadBaseline reads the baseline saved at path
+func ReadBaseline(path string) (*Baseline, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(contents, &b); err != nil {
+		return nil, fmt.Errorf("%s is not a baseline: %w", path, err)
+	}
+	return &b, nil
+}
+
+// Write saves b to path
+func (b *Baseline) Write(path string) error {
+	contents, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(contents, '\n'), 0o644)
+}
+
+// A measure is one of the quantities a benchmark reports
+type measure struct {
+	unit   string
+	of     func(*Result) []float64
+	format func(float64) string
+}
+
+var measures = []measure{
+	{"time/op", (*Result).NsPerOp, formatNs},
+	{"allocs/op", (*Result).AllocsPerOp, formatCount},
+	{"B/op", (*Result).BytesPerOp, formatCount},
+}
+
+// WriteResults writes the mean and standard deviation of each measure of
+// results as a table
+func WriteResults(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "name")
+	for _, m := range measures {
+		fmt.Fprintf(tw, "\t%s", m.unit)
+	}
+	fmt.Fprintln(tw)
+	for _, r := range results {
+		fmt.Fprint(tw, r.Name)
+		for _, m := range measures {
+			s := Summarize(m.of(r))
+			fmt.Fprintf(tw, "\t%s ± %s", m.format(s.Mean), formatPercent(s.StdDev, s.Mean))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// WriteComparison writes how each measure of results changed from those
+// of old, as benchstat does: a change only where it is significant, ~
+// where it could be noise, with the p-value and the number of samples
+func WriteComparison(w io.Writer, old, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, m := range measures {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "name\told %s\tnew %s\tdelta\n", m.unit, m.unit)
+		for _, d := range Compare(old, results, m.of) {
+			fmt.Fprintf(tw, "%s\t%s ± %s\t%s ± %s\t%s\n", d.Name,
+				m.format(d.Old.Mean), formatPercent(d.Old.StdDev, d.Old.Mean),
+				m.format(d.New.Mean), formatPercent(d.New.StdDev, d.New.Mean),
+				formatDelta(d))
+		}
+	}
+	return tw.Flush()
+}
+
+func formatDelta(d Delta) string {
+	stats := fmt.Sprintf("(p=%.3f n=%d+%d)", d.P, d.Old.N, d.New.N)
+	if !d.Significant() {
+		return "~ " + stats
+	}
+	change := d.Change()
+	if math.IsInf(change, 0) {
+		return "+Inf% " + stats
+	}
+	return fmt.Sprintf("%+.2f%% %s", 100*change, stats)
+}
+
+// formatPercent returns x as a percentage of mean
+func formatPercent(x, mean float64) string {
+	if mean == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.0f%%", 100*x/mean)
+}
+
+// formatNs returns a time in nanoseconds in the unit that suits it
+func formatNs(ns float64) string {
+	switch {
+	case ns >= 1e9:
+		return fmt.Sprintf("%.2fs", ns/1e9)
+	case ns >= 1e6:
+		return fmt.Sprintf("%.2fms", ns/1e6)
+	case ns >= 1e3:
+		return fmt.Sprintf("%.2fµs", ns/1e3)
+	}
+	return fmt.Sprintf("%.0fns", ns)
+}
+
+// formatCount returns a count with three significant figures, as
+// benchstat does
+func formatCount(x float64) string {
+	switch {
+	case x >= 1e9:
+		return fmt.Sprintf("%.3gG", x/1e9)
+	case x >= 1e6:
+		return fmt.Sprintf("%.3gM", x/1e6)
+	case x >= 1e4:
+		return fmt.Sprintf("%.3gk", x/1e3)
+	}
+	return fmt.Sprintf("%.0f", x)
+}
@@ -0,0 +1,179 @@
+package bench
+
+import (
+	"math"
+	"sort"
+)
+
+// Alpha is the p-value below which a change counts as real
+const Alpha = 0.05
+
+// Summary describes a set of measurements
+type Summary struct {
+	N      int
+	Mean   float64
+	StdDev float64 // the sample standard deviation, 0 for a single value
+}
+
+// Summarize returns the mean and standard deviation of xs
+func Summarize(xs []float64) Summary {
+	s := Summary{N: len(xs)}
+	if s.N == 0 {
+		return s
+	}
+	for _, x := range xs {
+		s.Mean += x
+	}
+	s.Mean /= float64(s.N)
+	if s.N > 1 {
+		var squares float64
+		for _, x := range xs {
+			squares += (x - s.Mean) * (x - s.Mean)
+		}
+		s.StdDev = math.Sqrt(squares / float64(s.N-1))
+	}
+	return s
+}
+
+// MannWhitney returns the two-sided p-value of the Mann-Whitney U test: the
+// chance of samples as far apart as xs and ys if both came from the same
+// distribution. It makes no assumption about the shape of the
+// distribution, which for timings has a long tail. Small samples without
+// ties get the exact p-value, others the normal approximation corrected
+// for ties.
+func MannWhitney(xs, ys []float64) float64 {
+	n1, n2 := len(xs), len(ys)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type value struct {
+		x     float64
+		first bool
+	}
+	all := make([]value, 0, n1+n2)
+	for _, x := range xs {
+		all = append(all, value{x, true})
+	}
+	for _, y := range ys {
+		all = append(all, value{y, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].x < all[j].x })
+
+	// rank the values from 1, giving tied ones the mean of their ranks
+	var rankSum, tieTerm float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].x == all[i].x {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieTerm += t*t*t - t
+		}
+		i = j
+	}
+	u := rankSum - float64(n1*(n1+1))/2
+
+	if !ties && n1+n2 <= 40 {
+		return exactMannWhitney(n1, n2, u)
+	}
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		// every value is the same
+		return 1
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	return math.Min(1, math.Erfc(math.Max(z, 0)/math.Sqrt2))
+}
+
+// exactMannWhitney returns the two-sided p-value of u for samples of n1
+// and n2 values without ties, from the number of the orderings of the
+// values that give each U
+func exactMannWhitney(n1, n2 int, u float64) float64 {
+	// counts[i][j][k] orderings of i values from one sample and j from
+	// the other have U = k, built from the largest value being from the
+	// first sample, adding j to U, or from the second
+	counts := make([][][]float64, n1+1)
+	for i := range counts {
+		counts[i] = make([][]float64, n2+1)
+		for j := range counts[i] {
+			counts[i][j] = make([]float64, i*j+1)
+			switch {
+			case i == 0 || j == 0:
+				counts[i][j][0] = 1
+			default:
+				for k := range counts[i][j] {
+					if k >= j && k-j < len(counts[i-1][j]) {
+						counts[i][j][k] += counts[i-1][j][k-j]
+					}
+					if k < len(counts[i][j-1]) {
+						counts[i][j][k] += counts[i][j-1][k]
+					}
+				}
+			}
+		}
+	}
+	dist := counts[n1][n2]
+	var total, below, above float64
+	for k, c := range dist {
+		total += c
+		if float64(k) <= u {
+			below += c
+		}
+		if float64(k) >= u {
+			above += c
+		}
+	}
+	return math.Min(1, 2*math.Min(below, above)/total)
+}
+
+// Delta is how one measure of a benchmark changed from a baseline
+type Delta struct {
+	Name     string
+	Old, New Summary
+	P        float64 // of the change, by MannWhitney
+}
+
+// Significant reports whether the change is more than noise
+func (d Delta) Significant() bool {
+	return d.P < Alpha
+}
+
+// Change returns the change of the mean as a fraction of the old one
+func (d Delta) Change() float64 {
+	if d.Old.Mean == 0 {
+		if d.New.Mean == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (d.New.Mean - d.Old.Mean) / d.Old.Mean
+}
+
+// Compare returns the change of a measure, such as (*Result).NsPerOp, of
+// each benchmark in both old and new, in the order of new
+func Compare(old, new []*Result, measure func(*Result) []float64) []Delta {
+	before := map[string]*Result{}
+	for _, r := range old {
+		before[r.Name] = r
+	}
+	var deltas []Delta
+	for _, r := range new {
+		o, ok := before[r.Name]
+		if !ok {
+			continue
+		}
+		xs, ys := measure(o), measure(r)
+		deltas = append(deltas, Delta{Name: r.Name, Old: Summarize(xs), New: Summarize(ys), P: MannWhitney(xs, ys)})
+	}
+	return deltas
+}
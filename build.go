@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/golang"
+	"gosling/llvm"
+	"gosling/wasm"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const buildUsage = "usage: gosling build (--emit=llvm [--runtime=gosling_runtime.c] | --emit=go | --target=wasm [--wat]) [-o file] file.gos\n"
+
+// build compiles a .gos file ahead of time, to source for another
+// toolchain with --emit or to a module ready to run with --target
+func build(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	emit := fs.String("emit", "", "source to emit: llvm or go")
+	target := fs.String("target", "", "module to build: wasm")
+	output := fs.String("o", "", "file to write, the source file with the extension of what is built by default")
+	runtime := fs.String("runtime", "", "with --emit=llvm, also write the runtime the output links against to this file")
+	wat := fs.Bool("wat", false, "with --target=wasm, also write the module in the text format next to it")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var ext string
+	switch {
+	case fs.NArg() != 1:
+	case *emit == "llvm" && *target == "" && !*wat:
+		ext = ".ll"
+	case *emit == "go" && *target == "" && !*wat && *runtime == "":
+		ext = ".go"
+	case *target == "wasm" && *emit == "" && *runtime == "":
+		ext = ".wasm"
+	}
+	if ext == "" {
+		fmt.Fprint(errOut, buildUsage)
+		return 2
+	}
+	path := fs.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, ".gos") + ext
+	}
+
+	program, ok := parseFile(path, errOut)
+	if !ok {
+		return 1
+	}
+	files := map[string][]byte{}
+	var diagnostics []diag.Diagnostic
+	switch ext {
+	case ".ll":
+		diagnostics = buildLLVM(program, path, *output, *runtime, files)
+	case ".go":
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+		diagnostics = buildGo(program, path, source, *output, files)
+	case ".wasm":
+		diagnostics = buildWasm(program, path, *output, *wat, files)
+	}
+	if len(diagnostics) > 0 {
+		for _, d := range diagnostics {
+			fmt.Fprintf(errOut, "%s\n", d)
+		}
+		return 1
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(name, contents, 0o644); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func buildLLVM(program *ast.Program, path, output, runtime string, files map[string][]byte) []diag.Diagnostic {
+	module, diagnostics := llvm.Compile(program, filepath.Base(path))
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+	files[output] = []byte(module)
+	if runtime != "" {
+		files[runtime] = []byte(llvm.Runtime)
+	}
+	return nil
+}
+
+func buildGo(program *ast.Program, path string, source []byte, output string, files map[string][]byte) []diag.Diagnostic {
+	main, diagnostics := golang.Compile(program, path, source)
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+	files[output] = main
+	return nil
+}
+
+func buildWasm(program *ast.Program, path, output string, wat bool, files map[string][]byte) []diag.Diagnostic {
+	module, diagnostics := wasm.Compile(program, filepath.Base(path))
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+	files[output] = module.Binary()
+	if wat {
+		files[strings.TrimSuffix(output, ".wasm")+".wat"] = []byte(module.Text())
+	}
+	return nil
+}
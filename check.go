@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gosling/diag"
+	"gosling/typecheck"
+	"io"
+)
+
+// checkFiles runs the static type checker over .gos files
+func checkFiles(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	asJSON := fs.Bool("json", false, "print errors as a JSON array")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintf(errOut, "usage: gosling check [--json] file.gos...\n")
+		return 2
+	}
+
+	status := 0
+	errors := []diag.Diagnostic{}
+	for _, path := range fs.Args() {
+		program, ok := parseFile(path, errOut)
+		if !ok {
+			status = 1
+			continue
+		}
+		_, diagnostics := typecheck.Check(program, typecheck.Config{})
+		errors = append(errors, diagnostics...)
+	}
+	if len(errors) > 0 {
+		status = 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(errors); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+		return status
+	}
+	for _, d := range errors {
+		fmt.Fprintf(out, "%s\n", d)
+	}
+	return status
+}
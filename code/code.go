@@ -1,8 +1,11 @@
 package code
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"gosling/token"
+	"sort"
 )
 
 type Instructions []byte
@@ -16,10 +19,121 @@ type Definition struct {
 
 const (
 	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+	OpNone
+
+	OpJump
+	OpJumpNotTruthy
+	// OpUnwrap replaces the option on top of the stack with its value, or
+	// pops it and jumps when it is none. The second operand is the
+	// constant holding the if let binding's name, for error messages.
+	OpUnwrap
+
+	OpGetGlobal
+	OpSetGlobal
+	// OpAssignGlobal is OpSetGlobal for a global that must already be bound
+	OpAssignGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpSetFree
+
+	// Locals captured by closures live in cells. OpGetCell and OpSetCell
+	// read and write the cell in a local slot, OpNewCell puts a fresh one
+	// there for a binding that must not be shared with earlier closures.
+	OpGetCell
+	OpSetCell
+	OpNewCell
+	// OpCellRef and OpFreeRef push a cell itself, for OpClosure to capture
+	OpCellRef
+	OpFreeRef
+	// OpClosure makes a closure of the function in a constant, capturing
+	// the number of cells given by the second operand from the stack
+	OpClosure
+
+	OpCall
+	OpReturnValue
+	OpReturn
+
+	// OpCheckType checks the value on top of the stack against the
+	// annotation held in a constant, leaving the value in place
+	OpCheckType
 )
 
 var definitions = map[Opcode]*Definition{
 	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd:         {"OpAdd", []int{}},
+	OpSub:         {"OpSub", []int{}},
+	OpMul:         {"OpMul", []int{}},
+	OpDiv:         {"OpDiv", []int{}},
+	OpMod:         {"OpMod", []int{}},
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+	OpNone:  {"OpNone", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpUnwrap:        {"OpUnwrap", []int{2, 2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{1}},
+	OpSetLocal:     {"OpSetLocal", []int{1}},
+	OpGetBuiltin:   {"OpGetBuiltin", []int{1}},
+	OpGetFree:      {"OpGetFree", []int{1}},
+	OpSetFree:      {"OpSetFree", []int{1}},
+
+	OpGetCell: {"OpGetCell", []int{1}},
+	OpSetCell: {"OpSetCell", []int{1}},
+	OpNewCell: {"OpNewCell", []int{1}},
+	OpCellRef: {"OpCellRef", []int{1}},
+	OpFreeRef: {"OpFreeRef", []int{1}},
+	OpClosure: {"OpClosure", []int{2, 1}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+
+	OpCheckType: {"OpCheckType", []int{2}},
+}
+
+// constantOperands gives, for each opcode with one, which operand is an
+// index into the constant pool
+var constantOperands = map[Opcode]int{
+	OpConstant:  0,
+	OpClosure:   0,
+	OpUnwrap:    1,
+	OpCheckType: 0,
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -51,9 +165,122 @@ func Make(op Opcode, operands ...int) []byte {
 		switch width {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
 		}
 
 		offset += width
 	}
 	return instruction
 }
+
+// ReadOperands decodes the operands Make encoded for def, returning them
+// and the number of bytes read
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// String prints one instruction per line, prefixed with its offset
+func (ins Instructions) String() string {
+	return ins.Disassemble(nil)
+}
+
+// Disassemble is String with each constant an instruction refers to shown
+// after it, as described by constant. A nil constant shows none of them.
+func (ins Instructions) Disassemble(constant func(index int) string) string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s", i, ins.fmtInstruction(def, operands))
+		if n, ok := constantOperands[Opcode(ins[i])]; ok && constant != nil {
+			fmt.Fprintf(&out, " (%s)", constant(operands[n]))
+		}
+		out.WriteString("\n")
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+// Position ties the instruction at Offset to the source it came from
+type Position struct {
+	Offset   int
+	Location token.TokenLocation
+}
+
+// Positions map instructions back to source locations for error
+// messages. They are sorted by offset and only record changes, so an
+// instruction takes the location of the last position at or before it.
+type Positions []Position
+
+// Add records loc for the instruction at offset
+func (p *Positions) Add(offset int, loc token.TokenLocation) {
+	n := len(*p)
+	switch {
+	case n > 0 && (*p)[n-1].Location == loc:
+	case n > 0 && (*p)[n-1].Offset == offset:
+		(*p)[n-1].Location = loc
+	default:
+		*p = append(*p, Position{Offset: offset, Location: loc})
+	}
+}
+
+// Lookup returns the location of the instruction at offset
+func (p Positions) Lookup(offset int) token.TokenLocation {
+	i := sort.Search(len(p), func(i int) bool { return p[i].Offset > offset })
+	if i == 0 {
+		return token.TokenLocation{}
+	}
+	return p[i-1].Location
+}
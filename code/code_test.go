@@ -1,6 +1,10 @@
 package code
 
-import "testing"
+import (
+	"fmt"
+	"gosling/token"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -9,6 +13,9 @@ func TestMake(t *testing.T) {
 		expected []byte
 	}{
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpUnwrap, []int{65534, 1}, []byte{byte(OpUnwrap), 255, 254, 0, 1}},
 	}
 
 	for _, tt := range tests {
@@ -27,3 +34,153 @@ func TestMake(t *testing.T) {
 		}
 	}
 }
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpUnwrap, []int{12, 65535}, 4},
+		{OpPop, []int{}, 0},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+// Every opcode must have a definition that disassembles to its name
+func TestInstructionsString(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected string
+	}{
+		{OpConstant, []int{65535}, "OpConstant 65535"},
+		{OpPop, nil, "OpPop"},
+		{OpAdd, nil, "OpAdd"},
+		{OpSub, nil, "OpSub"},
+		{OpMul, nil, "OpMul"},
+		{OpDiv, nil, "OpDiv"},
+		{OpMod, nil, "OpMod"},
+		{OpEqual, nil, "OpEqual"},
+		{OpNotEqual, nil, "OpNotEqual"},
+		{OpGreaterThan, nil, "OpGreaterThan"},
+		{OpLessThan, nil, "OpLessThan"},
+		{OpMinus, nil, "OpMinus"},
+		{OpBang, nil, "OpBang"},
+		{OpTrue, nil, "OpTrue"},
+		{OpFalse, nil, "OpFalse"},
+		{OpNull, nil, "OpNull"},
+		{OpNone, nil, "OpNone"},
+		{OpJump, []int{12}, "OpJump 12"},
+		{OpJumpNotTruthy, []int{7}, "OpJumpNotTruthy 7"},
+		{OpUnwrap, []int{20, 3}, "OpUnwrap 20 3"},
+		{OpGetGlobal, []int{1}, "OpGetGlobal 1"},
+		{OpSetGlobal, []int{2}, "OpSetGlobal 2"},
+		{OpAssignGlobal, []int{3}, "OpAssignGlobal 3"},
+		{OpGetLocal, []int{4}, "OpGetLocal 4"},
+		{OpSetLocal, []int{5}, "OpSetLocal 5"},
+		{OpGetBuiltin, []int{1}, "OpGetBuiltin 1"},
+		{OpGetFree, []int{0}, "OpGetFree 0"},
+		{OpSetFree, []int{1}, "OpSetFree 1"},
+		{OpGetCell, []int{2}, "OpGetCell 2"},
+		{OpSetCell, []int{3}, "OpSetCell 3"},
+		{OpNewCell, []int{4}, "OpNewCell 4"},
+		{OpCellRef, []int{5}, "OpCellRef 5"},
+		{OpFreeRef, []int{6}, "OpFreeRef 6"},
+		{OpClosure, []int{65534, 255}, "OpClosure 65534 255"},
+		{OpCall, []int{2}, "OpCall 2"},
+		{OpReturnValue, nil, "OpReturnValue"},
+		{OpReturn, nil, "OpReturn"},
+		{OpCheckType, []int{9}, "OpCheckType 9"},
+	}
+
+	if len(tests) != len(definitions) {
+		t.Errorf("not every opcode is tested. definitions=%d, tests=%d", len(definitions), len(tests))
+	}
+
+	instructions := Instructions{}
+	expected := ""
+	for _, tt := range tests {
+		if _, err := Lookup(byte(tt.op)); err != nil {
+			t.Errorf("no definition for %s: %s", tt.expected, err)
+			continue
+		}
+		expected += fmt.Sprintf("%04d %s\n", len(instructions), tt.expected)
+		instructions = append(instructions, Make(tt.op, tt.operands...)...)
+	}
+
+	if instructions.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, instructions.String())
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	instructions := Instructions{}
+	for _, ins := range [][]byte{
+		Make(OpConstant, 1),
+		Make(OpUnwrap, 12, 0),
+		Make(OpCheckType, 2),
+		Make(OpGetGlobal, 1),
+	} {
+		instructions = append(instructions, ins...)
+	}
+	constants := []string{`"x"`, "5", "let y: int"}
+
+	expected := `0000 OpConstant 1 (5)
+0003 OpUnwrap 12 0 ("x")
+0008 OpCheckType 2 (let y: int)
+0011 OpGetGlobal 1
+`
+	got := instructions.Disassemble(func(i int) string { return constants[i] })
+	if got != expected {
+		t.Errorf("instructions wrongly disassembled.\nwant=%q\ngot=%q", expected, got)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	at := func(line int) token.TokenLocation { return token.TokenLocation{Line: line} }
+
+	var p Positions
+	p.Add(0, at(1))
+	p.Add(3, at(1))
+	p.Add(4, at(2))
+	p.Add(4, at(3))
+	p.Add(9, at(4))
+
+	if len(p) != 3 {
+		t.Fatalf("wrong number of positions. want=3, got=%d (%v)", len(p), p)
+	}
+
+	tests := []struct {
+		offset int
+		line   int
+	}{
+		{0, 1}, {3, 1}, {4, 3}, {8, 3}, {9, 4}, {100, 4},
+	}
+	for _, tt := range tests {
+		if got := p.Lookup(tt.offset).Line; got != tt.line {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.line, got)
+		}
+	}
+}
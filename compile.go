@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"gosling/gosc"
+	"io"
+	"os"
+	"strings"
+)
+
+// compileCmd compiles a .gos file to a .gosc file that `gosling run` can
+// run without parsing it again
+func compileCmd(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	output := fs.String("o", "", "file to write, the source file with a .gosc extension by default")
+	strip := fs.Bool("strip", false, "leave out source positions, so run time errors have no locations")
+	passes := optimizeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(errOut, "usage: gosling compile [-o file.gosc] [--strip] [--fold] [--dead-code] [--inline] file.gos\n")
+		return 2
+	}
+	path := fs.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, ".gos") + gosc.Ext
+	}
+
+	bytecode, ok := compileFile(path, *passes, false, errOut)
+	if !ok {
+		return 1
+	}
+	var buf bytes.Buffer
+	if err := gosc.Write(&buf, bytecode, gosc.Options{StripPositions: *strip}); err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return 1
+	}
+	return 0
+}
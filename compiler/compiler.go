@@ -0,0 +1,540 @@
+package compiler
+
+import (
+	"fmt"
+	"gosling/ast"
+	"gosling/code"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/token"
+)
+
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+	resolver    *resolver
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// location is recorded for the instructions emitted next, so run time
+	// errors point where the evaluator's would
+	location token.TokenLocation
+
+	// err is the first operand that did not fit its width, returned by
+	// Compile once the program is compiled
+	err error
+}
+
+// CompilationScope holds the instructions of the function being compiled
+type CompilationScope struct {
+	table               *SymbolTable
+	instructions        code.Instructions
+	positions           code.Positions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// Bytecode is a compiled program. Globals names each global slot, for
+// the error reported when a global is read before it is bound.
+type Bytecode struct {
+	Instructions code.Instructions
+	Positions    code.Positions
+	Constants    []object.Object
+	Globals      []string
+}
+
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, name := range evaluator.BuiltinNames() {
+		symbolTable.DefineBuiltin(i, name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		resolver:    newResolver(symbolTable),
+		scopes:      []CompilationScope{{table: symbolTable}},
+	}
+}
+
+// NewWithState returns a compiler that carries on from the globals and
+// constants of an earlier one, as the REPL does between lines
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.resolver = newResolver(s)
+	compiler.scopes[0].table = s
+	compiler.constants = constants
+	return compiler
+}
+
+// SymbolTable returns the global symbol table, to pass to NewWithState
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+// Compile compiles node, usually a whole program, after resolving the
+// names in it
+func (c *Compiler) Compile(node ast.Node) error {
+	c.resolver.resolve(node)
+	if err := c.compile(node); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		if err := c.compile(node.Value); err != nil {
+			return err
+		}
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+			c.constants[len(c.constants)-1].(*object.CompiledFunction).Name = node.Name.Value
+		}
+		c.checkType(node.Name, "let "+node.Name.Value)
+		c.setSymbol(c.resolver.symbols[node.Name])
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			c.emit(code.OpNull)
+		} else if err := c.compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: node.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		}
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.NoneLiteral:
+		c.emit(code.OpNone)
+
+	case *ast.PrefixExpression:
+		if err := c.compile(node.Right); err != nil {
+			return err
+		}
+		c.location = node.Token.Location
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if err := c.compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.compile(node.Right); err != nil {
+			return err
+		}
+		c.location = node.Token.Location
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emit(op)
+
+	case *ast.IfExpression:
+		return c.compileIf(node)
+
+	case *ast.ForExpression:
+		loopStart := len(c.currentInstructions())
+		c.location = node.Token.Location
+		if err := c.compile(node.Condition); err != nil {
+			return err
+		}
+		exit := c.emit(code.OpJumpNotTruthy, 9999)
+		if err := c.compile(node.Body); err != nil {
+			return err
+		}
+		// the jump back is where a loop that never ends stops
+		c.location = node.Token.Location
+		c.emit(code.OpJump, loopStart)
+		c.changeOperand(exit, len(c.currentInstructions()))
+		c.emit(code.OpNull)
+
+	case *ast.Identifier:
+		c.location = node.Token.Location
+		c.loadSymbol(c.resolver.symbols[node])
+
+	case *ast.AssignExpression:
+		if err := c.compile(node.Value); err != nil {
+			return err
+		}
+		c.location = node.Name.Token.Location
+		symbol := c.resolver.symbols[node.Name]
+		switch {
+		case symbol.Scope == GlobalScope:
+			c.emit(code.OpAssignGlobal, symbol.Index)
+		case symbol.Scope == FreeScope:
+			c.emit(code.OpSetFree, symbol.Index)
+		case c.isCell(symbol):
+			c.emit(code.OpSetCell, symbol.Index)
+		default:
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node)
+
+	case *ast.CallExpression:
+		if err := c.compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.compile(a); err != nil {
+				return err
+			}
+		}
+		c.location = node.Token.Location
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+}
+
+func (c *Compiler) compileIf(node *ast.IfExpression) error {
+	if err := c.compile(node.Condition); err != nil {
+		return err
+	}
+
+	var jumpOver int
+	if node.Binding != nil {
+		// the value is only bound inside the consequence, afresh each time
+		c.location = node.Condition.Location()
+		jumpOver = c.emit(code.OpUnwrap, 9999, c.addConstant(&object.String{Value: node.Binding.Value}))
+		c.checkType(node.Binding, "if let "+node.Binding.Value)
+		if symbol := c.resolver.symbols[node.Binding]; c.isCell(symbol) {
+			c.emit(code.OpNewCell, symbol.Index)
+		} else {
+			c.setSymbol(symbol)
+		}
+	} else {
+		jumpOver = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpEnd := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpOver, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperand(jumpEnd, len(c.currentInstructions()))
+	return nil
+}
+
+// compileBlockValue compiles a block whose last expression is its value,
+// leaving null if it does not end in one
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.compile(block); err != nil {
+		return err
+	}
+	if n := len(block.Statements); n > 0 {
+		if _, ok := block.Statements[n-1].(*ast.ExpressionStatement); ok {
+			c.removeLastPop()
+			return nil
+		}
+	}
+	c.emit(code.OpNull)
+	return nil
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral) error {
+	table := c.resolver.functions[node]
+	c.enterScope(table)
+
+	// captured parameters move into cells before the body runs
+	for _, p := range node.Parameters {
+		if symbol := c.resolver.symbols[p]; c.isCell(symbol) {
+			c.emit(code.OpGetLocal, symbol.Index)
+			c.emit(code.OpNewCell, symbol.Index)
+		}
+	}
+	if err := c.compile(node.Body); err != nil {
+		return err
+	}
+	if n := len(node.Body.Statements); n > 0 {
+		if _, ok := node.Body.Statements[n-1].(*ast.ExpressionStatement); ok {
+			c.replaceLastPopWithReturn()
+		}
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	names := table.Names()
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+
+	var freeNames []string
+	for _, s := range table.FreeSymbols {
+		freeNames = append(freeNames, s.Name)
+		if s.Scope == FreeScope {
+			c.emit(code.OpFreeRef, s.Index)
+		} else {
+			c.emit(code.OpCellRef, s.Index)
+		}
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions: instructions,
+		Positions:    positions,
+		NumLocals:    len(names),
+		Parameters:   node.Parameters,
+		ReturnType:   node.ReturnType,
+		Names:        names,
+		FreeNames:    freeNames,
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeNames))
+	return nil
+}
+
+// checkType emits a check of the value on the stack against the
+// annotation of ident, if it has one
+func (c *Compiler) checkType(ident *ast.Identifier, what string) {
+	if ident.Type == nil {
+		return
+	}
+	c.location = ident.Location()
+	c.emit(code.OpCheckType, c.addConstant(&object.Annotation{Annotation: ident.Type, What: what}))
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch {
+	case s.Scope == GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case s.Scope == BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case s.Scope == FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case c.isCell(s):
+		c.emit(code.OpGetCell, s.Index)
+	default:
+		c.emit(code.OpGetLocal, s.Index)
+	}
+}
+
+// setSymbol binds s to the value on the stack, for a let
+func (c *Compiler) setSymbol(s Symbol) {
+	switch {
+	case s.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case c.isCell(s):
+		c.emit(code.OpSetCell, s.Index)
+	default:
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// isCell reports whether s is a local of the function being compiled that
+// closures capture
+func (c *Compiler) isCell(s Symbol) bool {
+	return c.scopes[c.scopeIndex].table.IsCell(s)
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Constants:    c.constants,
+		Globals:      c.symbolTable.global().Names(),
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// operandNames says what each operand of an opcode counts, for the error
+// reported when a program needs more of it than the operand can hold
+var operandNames = map[code.Opcode][]string{
+	code.OpConstant:      {"constant"},
+	code.OpJump:          {"jump target"},
+	code.OpJumpNotTruthy: {"jump target"},
+	code.OpUnwrap:        {"jump target", "constant"},
+	code.OpGetGlobal:     {"global"},
+	code.OpSetGlobal:     {"global"},
+	code.OpAssignGlobal:  {"global"},
+	code.OpGetLocal:      {"local"},
+	code.OpSetLocal:      {"local"},
+	code.OpGetBuiltin:    {"builtin"},
+	code.OpGetFree:       {"free variable"},
+	code.OpSetFree:       {"free variable"},
+	code.OpGetCell:       {"local"},
+	code.OpSetCell:       {"local"},
+	code.OpNewCell:       {"local"},
+	code.OpCellRef:       {"local"},
+	code.OpFreeRef:       {"free variable"},
+	code.OpClosure:       {"constant", "free variable count"},
+	code.OpCall:          {"argument count"},
+	code.OpCheckType:     {"constant"},
+}
+
+// checkOperands records an error for the first operand of op too large
+// for its width, which code.Make would silently truncate
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, _ := code.Lookup(byte(op))
+	for i, o := range operands {
+		limit := 1 << (8 * def.OperandWidths[i])
+		if o >= limit && c.err == nil {
+			c.err = fmt.Errorf("%s %d is out of range, the bytecode allows at most %d", operandNames[op][i], o, limit-1)
+		}
+	}
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	scope := &c.scopes[c.scopeIndex]
+	posNewInstruction := len(scope.instructions)
+	scope.positions.Add(posNewInstruction, c.location)
+	scope.instructions = append(scope.instructions, ins...)
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// changeOperand sets the first operand of the instruction at opPos,
+// keeping any others
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	ins := c.currentInstructions()
+	op := code.Opcode(ins[opPos])
+	def, _ := code.Lookup(byte(op))
+	operands, _ := code.ReadOperands(def, ins[opPos+1:])
+	operands[0] = operand
+	c.checkOperands(op, operands)
+
+	c.replaceInstruction(opPos, code.Make(op, operands...))
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) enterScope(table *SymbolTable) {
+	c.scopes = append(c.scopes, CompilationScope{table: table})
+	c.scopeIndex++
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	return instructions
+}
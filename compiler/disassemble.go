@@ -0,0 +1,55 @@
+package compiler
+
+import (
+	"fmt"
+	"gosling/object"
+	"strconv"
+	"strings"
+)
+
+// Disassemble prints the instructions of the program followed by those of
+// each function it compiled, with the constants they use shown inline
+func (b *Bytecode) Disassemble() string {
+	var out strings.Builder
+	constant := func(index int) string {
+		return describeConstant(b.Constants[index])
+	}
+
+	out.WriteString("main:\n")
+	out.WriteString(b.Instructions.Disassemble(constant))
+	for i, c := range b.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fmt.Fprintf(&out, "\nconstant %d, %s:\n", i, describeFunction(fn))
+			out.WriteString(fn.Instructions.Disassemble(constant))
+		}
+	}
+	return out.String()
+}
+
+func describeConstant(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.CompiledFunction:
+		return describeFunction(obj)
+	default:
+		return obj.Inspect()
+	}
+}
+
+// describeFunction gives the name and signature of fn
+func describeFunction(fn *object.CompiledFunction) string {
+	params := []string{}
+	for _, p := range fn.Parameters {
+		params = append(params, p.String())
+	}
+	out := "fn"
+	if fn.Name != "" {
+		out += " " + fn.Name
+	}
+	out += "(" + strings.Join(params, ", ") + ")"
+	if fn.ReturnType != nil {
+		out += " -> " + fn.ReturnType.String()
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package compiler
+
+import (
+	"gosling/lexer"
+	"gosling/parser"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let add = fn(a: int, b) -> int { a + b }; let f = fn() { fn(x) { "x" } };`
+	expected := `main:
+0000 OpClosure 0 0 (fn add(a: int, b) -> int)
+0004 OpSetGlobal 0
+0007 OpClosure 3 0 (fn f())
+0011 OpSetGlobal 1
+
+constant 0, fn add(a: int, b) -> int:
+0000 OpGetLocal 0
+0002 OpGetLocal 1
+0004 OpAdd
+0005 OpReturnValue
+
+constant 2, fn(x):
+0000 OpConstant 1 ("x")
+0003 OpReturnValue
+
+constant 3, fn f():
+0000 OpClosure 2 0 (fn(x))
+0004 OpReturnValue
+`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	got := compiler.Bytecode().Disassemble()
+	if got != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
@@ -0,0 +1,134 @@
+package compiler
+
+import "gosling/ast"
+
+// resolver classifies every name in a program as global, local, free or
+// builtin before any of it is compiled. Capture is only known once the
+// inner functions have been seen, and a function must know which of its
+// locals are cells before its own code is emitted.
+type resolver struct {
+	symbolTable *SymbolTable
+
+	// symbols holds what each identifier refers to, for both the names a
+	// let, parameter or if let binds and the names used
+	symbols map[*ast.Identifier]Symbol
+	// functions holds the table of each function literal, with its slots
+	// and free variables
+	functions map[*ast.FunctionLiteral]*SymbolTable
+	// pending holds the function literals met in the body being resolved,
+	// with the table they are in
+	pending []pendingFunction
+}
+
+type pendingFunction struct {
+	node  *ast.FunctionLiteral
+	outer *SymbolTable
+}
+
+func newResolver(symbolTable *SymbolTable) *resolver {
+	return &resolver{
+		symbolTable: symbolTable,
+		symbols:     make(map[*ast.Identifier]Symbol),
+		functions:   make(map[*ast.FunctionLiteral]*SymbolTable),
+	}
+}
+
+// resolve walks node in the order the compiler emits its code, so that
+// names are bound and looked up as they will be when it runs. The body of
+// a function literal runs later, so it is resolved once the code around
+// it has been, and sees every name that code binds.
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			r.resolve(s)
+		}
+		r.resolvePending()
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			r.resolve(s)
+		}
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+	case *ast.LetStatement:
+		r.resolve(node.Value)
+		r.define(node.Name)
+	case *ast.ReturnStatement:
+		if node.ReturnValue != nil {
+			r.resolve(node.ReturnValue)
+		}
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		if node.Binding != nil {
+			outer := r.symbolTable
+			r.symbolTable = NewBlockSymbolTable(outer)
+			r.define(node.Binding)
+			r.resolve(node.Consequence)
+			r.symbolTable = outer
+		} else {
+			r.resolve(node.Consequence)
+		}
+		if node.Alternative != nil {
+			r.resolve(node.Alternative)
+		}
+	case *ast.ForExpression:
+		r.resolve(node.Condition)
+		r.resolve(node.Body)
+	case *ast.Identifier:
+		r.symbols[node] = r.lookup(node.Value)
+	case *ast.AssignExpression:
+		r.resolve(node.Value)
+		symbol := r.lookup(node.Name.Value)
+		if symbol.Scope == BuiltinScope {
+			// builtins cannot be assigned, an unbound global reports it
+			symbol = r.symbolTable.global().allocate(node.Name.Value)
+		}
+		r.symbols[node.Name] = symbol
+	case *ast.FunctionLiteral:
+		r.pending = append(r.pending, pendingFunction{node: node, outer: r.symbolTable})
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, a := range node.Arguments {
+			r.resolve(a)
+		}
+	}
+}
+
+// resolvePending resolves the bodies of the function literals met so far
+func (r *resolver) resolvePending() {
+	for len(r.pending) > 0 {
+		f := r.pending[0]
+		r.pending = r.pending[1:]
+		r.function(f.node, f.outer)
+	}
+}
+
+func (r *resolver) function(node *ast.FunctionLiteral, outer *SymbolTable) {
+	saved, pending := r.symbolTable, r.pending
+	r.symbolTable, r.pending = NewEnclosedSymbolTable(outer), nil
+	for _, p := range node.Parameters {
+		r.symbols[p] = r.symbolTable.defineParameter(p.Value)
+	}
+	r.resolve(node.Body)
+	r.functions[node] = r.symbolTable
+	r.resolvePending()
+	r.symbolTable, r.pending = saved, pending
+}
+
+func (r *resolver) define(ident *ast.Identifier) {
+	r.symbols[ident] = r.symbolTable.Define(ident.Value)
+}
+
+// lookup finds the symbol for name. A name bound nowhere is taken to be a
+// global, which may be bound by the time the code runs.
+func (r *resolver) lookup(name string) Symbol {
+	if symbol, ok := r.symbolTable.Resolve(name); ok {
+		return symbol
+	}
+	return r.symbolTable.global().Define(name)
+}
@@ -0,0 +1,184 @@
+package compiler
+
+import "sort"
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+	// FreeScope marks a local of an enclosing function, captured by the
+	// closure. Its index is into the closure's free cells.
+	FreeScope SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable maps the names of one scope to the slots holding them. A
+// function gets its own table and slots, a block table only limits where
+// its names are visible and takes its slots from the enclosing function.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store map[string]Symbol
+	// owner is the table whose slots the symbols use, the table itself
+	// unless it is a block
+	owner *SymbolTable
+	// names holds the name given to each slot of an owner
+	names []string
+
+	// FreeSymbols holds, for a function, the symbol each of its free
+	// variables has in the enclosing function
+	FreeSymbols []Symbol
+	// cells marks the slots of an owner that inner functions capture
+	cells map[int]bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{store: make(map[string]Symbol)}
+	s.owner = s
+	return s
+}
+
+// NewEnclosedSymbolTable returns the table of a function inside outer
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable returns a table for names only visible in a block,
+// such as the binding of an if let
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{Outer: outer, store: make(map[string]Symbol), owner: outer.owner}
+}
+
+// Define binds name in this table. Binding a name again reuses its slot,
+// as a second let replaces the first in the evaluator's environment.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	symbol := s.allocate(name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// defineParameter binds name to the next slot, so that every argument
+// has a slot even when parameters share a name
+func (s *SymbolTable) defineParameter(name string) Symbol {
+	symbol := s.allocate(name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// allocate takes a new slot for name without making it visible
+func (s *SymbolTable) allocate(name string) Symbol {
+	owner := s.owner
+	symbol := Symbol{Name: name, Index: len(owner.names), Scope: LocalScope}
+	if owner.Outer == nil {
+		symbol.Scope = GlobalScope
+	}
+	owner.names = append(owner.names, name)
+	return symbol
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// Resolve looks name up in this table and the ones around it. A local of
+// an enclosing function becomes a free variable of every function between
+// it and this table, and a cell of the function declaring it.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	if symbol, ok := s.store[name]; ok {
+		return symbol, true
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	symbol, ok := s.Outer.Resolve(name)
+	if !ok || s.owner != s {
+		// a block sees what its function sees
+		return symbol, ok
+	}
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, true
+	}
+	if symbol.Scope == LocalScope {
+		s.Outer.owner.capture(symbol.Index)
+	}
+	return s.defineFree(symbol), true
+}
+
+// defineFree makes original, a symbol of the enclosing function, a free
+// variable of this one
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	index := len(s.FreeSymbols)
+	for i, free := range s.FreeSymbols {
+		if free == original {
+			index = i
+		}
+	}
+	if index == len(s.FreeSymbols) {
+		s.FreeSymbols = append(s.FreeSymbols, original)
+	}
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: index}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) capture(index int) {
+	if s.cells == nil {
+		s.cells = make(map[int]bool)
+	}
+	s.cells[index] = true
+}
+
+// IsCell reports whether inner functions capture the local symbol, which
+// then lives in a cell
+func (s *SymbolTable) IsCell(symbol Symbol) bool {
+	return symbol.Scope == LocalScope && s.owner.cells[symbol.Index]
+}
+
+// Names returns the name given to each slot of the table's function, or
+// of the globals for the outermost table
+func (s *SymbolTable) Names() []string {
+	return s.owner.names
+}
+
+// NumDefinitions is the number of slots the table's function needs
+func (s *SymbolTable) NumDefinitions() int {
+	return len(s.owner.names)
+}
+
+// global returns the outermost table
+func (s *SymbolTable) global() *SymbolTable {
+	t := s
+	for t.Outer != nil {
+		t = t.Outer
+	}
+	return t
+}
+
+// Symbols returns the names bound in this table itself, leaving out the
+// builtins
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := []Symbol{}
+	for _, symbol := range s.store {
+		if symbol.Scope != BuiltinScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Index < symbols[j].Index })
+	return symbols
+}
@@ -0,0 +1,87 @@
+package compiler
+
+import "testing"
+
+func TestDefineAndResolve(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("wrong symbol for a. got=%+v", a)
+	}
+	if again := global.Define("a"); again != a {
+		t.Errorf("defining a again moved it. got=%+v", again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	b := local.Define("b")
+	block := NewBlockSymbolTable(local)
+	c := block.Define("c")
+	if b.Index != 0 || c != (Symbol{Name: "c", Scope: LocalScope, Index: 1}) {
+		t.Errorf("block does not share the function's slots. b=%+v, c=%+v", b, c)
+	}
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("c is visible outside its block")
+	}
+	if got := local.NumDefinitions(); got != 2 {
+		t.Errorf("wrong number of locals. want=2, got=%d", got)
+	}
+
+	nested := NewEnclosedSymbolTable(block)
+	tests := []struct {
+		name     string
+		expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{"c", Symbol{Name: "c", Scope: FreeScope, Index: 1}},
+	}
+	for _, tt := range tests {
+		got, ok := nested.Resolve(tt.name)
+		if !ok || got != tt.expected {
+			t.Errorf("wrong symbol for %s. want=%+v, got=%+v", tt.name, tt.expected, got)
+		}
+	}
+
+	if got, ok := block.Resolve("b"); !ok || got.Scope != LocalScope {
+		t.Errorf("b should be local inside a block of its function. got=%+v", got)
+	}
+}
+
+func TestDefineBuiltin(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+
+	local := NewEnclosedSymbolTable(global)
+	if got, _ := local.Resolve("len"); got != (Symbol{Name: "len", Scope: BuiltinScope, Index: 0}) {
+		t.Errorf("wrong symbol for len. got=%+v", got)
+	}
+
+	// a let of the same name replaces the builtin
+	if got := global.Define("len"); got.Scope != GlobalScope {
+		t.Errorf("len was not rebound. got=%+v", got)
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	outer := NewEnclosedSymbolTable(global)
+	a := outer.Define("a")
+	b := outer.Define("b")
+	middle := NewEnclosedSymbolTable(outer)
+	inner := NewEnclosedSymbolTable(middle)
+
+	got, _ := inner.Resolve("b")
+	if got != (Symbol{Name: "b", Scope: FreeScope, Index: 0}) {
+		t.Errorf("wrong symbol for b. got=%+v", got)
+	}
+	// the middle function passes b down without using it
+	if len(middle.FreeSymbols) != 1 || middle.FreeSymbols[0] != b {
+		t.Errorf("wrong free symbols of the middle function. got=%+v", middle.FreeSymbols)
+	}
+	if len(inner.FreeSymbols) != 1 || inner.FreeSymbols[0] != (Symbol{Name: "b", Scope: FreeScope, Index: 0}) {
+		t.Errorf("wrong free symbols of the inner function. got=%+v", inner.FreeSymbols)
+	}
+	if !outer.IsCell(b) || outer.IsCell(a) {
+		t.Errorf("only b should be a cell. a=%t, b=%t", outer.IsCell(a), outer.IsCell(b))
+	}
+}
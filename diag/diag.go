@@ -0,0 +1,477 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"gosling/token"
+	"sort"
+	"strings"
+)
+
+// Code is the stable identifier of a diagnostic, e.g. "E0102".
+// Messages may be reworded over time, codes never change meaning.
+type Code string
+
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Definition is the registry entry behind a Code, used by `gosling explain`
+type Definition struct {
+	Code        Code
+	Name        string // short kebab-case name, e.g. "unknown-operator"
+	Summary     string
+	Explanation string
+	Example     string
+}
+
+// Diagnostic is a single coded message tied to a source location
+type Diagnostic struct {
+	Code     Code
+	Severity Severity
+	Message  string
+	Location token.TokenLocation
+}
+
+// Lexer and parser codes live in E00xx, evaluator codes in E01xx, codes
+// only reported by gosling check in E02xx and codes from the backends of
+// gosling build in E03xx.
+// Codes starting with W are warnings from gosling vet and never stop a program.
+const (
+	IllegalCharacter  Code = "E0001"
+	UnexpectedToken   Code = "E0002"
+	NoPrefixParse     Code = "E0003"
+	InvalidInteger    Code = "E0004"
+	InvalidAssignment Code = "E0005"
+
+	UnknownNode           Code = "E0100"
+	UnknownPrefixOperator Code = "E0101"
+	UnknownOperator       Code = "E0102"
+	DivisionByZero        Code = "E0103"
+	ModuloByZero          Code = "E0104"
+	IdentifierNotFound    Code = "E0105"
+	NotAFunction          Code = "E0106"
+	WrongArgumentCount    Code = "E0107"
+	UnsupportedArgument   Code = "E0108"
+	CallDepthExceeded     Code = "E0109"
+	StepBudgetExceeded    Code = "E0110"
+	EvaluationCancelled   Code = "E0111"
+	MemoryLimitExceeded   Code = "E0112"
+	TypeMismatch          Code = "E0113"
+	UnknownType           Code = "E0114"
+	UnwrappedOption       Code = "E0115"
+	IntegerOverflow       Code = "E0116"
+
+	ArgumentTypeMismatch   Code = "E0200"
+	CannotInfer            Code = "E0201"
+	DuplicateTypeParameter Code = "E0202"
+
+	UnsupportedConstruct Code = "E0300"
+	NoStaticType         Code = "E0301"
+
+	UnusedBinding        Code = "W0001"
+	ShadowedName         Code = "W0002"
+	UnreachableCode      Code = "W0003"
+	ConstantCondition    Code = "W0004"
+	MismatchedComparison Code = "W0005"
+	UndefinedCall        Code = "W0006"
+)
+
+var definitions = map[Code]*Definition{
+	IllegalCharacter: {
+		Code:    IllegalCharacter,
+		Name:    "illegal-character",
+		Summary: "the lexer found a character that is not part of the language",
+		Explanation: `Gosling source may only contain letters, digits, whitespace, string
+literals and the operators and delimiters listed in the language
+specification. Any other character is reported as illegal and the
+statement containing it is not evaluated.`,
+		Example: `let x = 5 \ 2;`,
+	},
+	UnexpectedToken: {
+		Code:    UnexpectedToken,
+		Name:    "unexpected-token",
+		Summary: "the parser expected a different token",
+		Explanation: `The parser was in the middle of a construct, such as a let statement
+or a function literal, and the next token did not match what the grammar
+requires. The message names the token that was expected and the one that
+was found.`,
+		Example: `let = 5;`,
+	},
+	NoPrefixParse: {
+		Code:    NoPrefixParse,
+		Name:    "no-prefix-parse",
+		Summary: "a token cannot start an expression",
+		Explanation: `Every expression has to begin with a literal, an identifier, a prefix
+operator, a parenthesis or a keyword such as if, for or fn. Infix
+operators and closing delimiters cannot appear at the start of an
+expression.`,
+		Example: `let x = * 5;`,
+	},
+	InvalidInteger: {
+		Code:    InvalidInteger,
+		Name:    "invalid-integer",
+		Summary: "an integer literal could not be parsed",
+		Explanation: `Integer literals of any size are accepted, one that does not fit in a
+signed 64-bit integer being held as a big integer. A literal starting
+with 0 is read in octal, so it may only use the digits 0 to 7.`,
+		Example: `09`,
+	},
+	InvalidAssignment: {
+		Code:    InvalidAssignment,
+		Name:    "invalid-assignment",
+		Summary: "the left hand side of = is not a name",
+		Explanation: `Only names that were bound with let or as function parameters can be
+assigned to. Literals, calls and other expressions cannot appear on the
+left hand side of "=".`,
+		Example: `5 = 6;`,
+	},
+	UnknownNode: {
+		Code:    UnknownNode,
+		Name:    "unknown-node",
+		Summary: "the evaluator does not know how to run a syntax node",
+		Explanation: `The parser produced a node that the evaluator has no rule for. This is
+an interpreter bug rather than a problem with the program, please report
+it along with the source that triggered it.`,
+		Example: `(no user program should produce this)`,
+	},
+	UnknownPrefixOperator: {
+		Code:    UnknownPrefixOperator,
+		Name:    "unknown-prefix-operator",
+		Summary: "a prefix operator is not supported",
+		Explanation: `Only "-" and "!" may be used as prefix operators. The parser already
+rejects any other prefix, so this is only reported for syntax trees that
+were built by hand, for example by a tool embedding the evaluator.`,
+		Example: `(not reachable from source text)`,
+	},
+	UnknownOperator: {
+		Code:    UnknownOperator,
+		Name:    "unknown-operator",
+		Summary: "an operator was applied to values it does not support",
+		Explanation: `Operators are only defined for certain combinations of types. "+" adds
+two integers or concatenates two strings, "-", "*", "/" and "%" only work
+on integers, "<" and ">" compare integers, and "!" negates a boolean.
+Gosling never converts between types implicitly, so mixing an integer
+with a boolean or a string is an error. The message shows the types of
+both operands.`,
+		Example: `5 + true;`,
+	},
+	DivisionByZero: {
+		Code:        DivisionByZero,
+		Name:        "division-by-zero",
+		Summary:     "an integer was divided by zero",
+		Explanation: `The right hand side of "/" evaluated to 0.`,
+		Example:     `10 / 0;`,
+	},
+	ModuloByZero: {
+		Code:        ModuloByZero,
+		Name:        "modulo-by-zero",
+		Summary:     "the remainder of a division by zero was requested",
+		Explanation: `The right hand side of "%" evaluated to 0.`,
+		Example:     `10 % 0;`,
+	},
+	IdentifierNotFound: {
+		Code:    IdentifierNotFound,
+		Name:    "identifier-not-found",
+		Summary: "a name was used before it was bound",
+		Explanation: `The identifier is not bound by a let statement or function parameter
+in the current scope or any enclosing scope, and it is not the name of a
+builtin function. Check the spelling and make sure the let statement runs
+before the name is used.`,
+		Example: `let a = 5; a + b;`,
+	},
+	NotAFunction: {
+		Code:    NotAFunction,
+		Name:    "not-a-function",
+		Summary: "a value that is not a function was called",
+		Explanation: `Only function literals and builtin functions can be called. The
+message shows the type of the value that was called.`,
+		Example: `let x = 5; x(1);`,
+	},
+	WrongArgumentCount: {
+		Code:    WrongArgumentCount,
+		Name:    "wrong-argument-count",
+		Summary: "a function was called with the wrong number of arguments",
+		Explanation: `Builtin functions check how many arguments they receive, and other
+functions need at least one argument per parameter. The message shows
+how many were passed and how many the function expects.`,
+		Example: `len("one", "two");`,
+	},
+	UnsupportedArgument: {
+		Code:    UnsupportedArgument,
+		Name:    "unsupported-argument",
+		Summary: "a builtin received an argument of the wrong type",
+		Explanation: `Builtin functions only accept certain types, for example len only
+accepts strings. The message shows the type that was passed.`,
+		Example: `len(1);`,
+	},
+	CallDepthExceeded: {
+		Code:    CallDepthExceeded,
+		Name:    "call-depth-exceeded",
+		Summary: "functions were nested deeper than the call depth limit",
+		Explanation: `Each function call that has not yet returned counts towards the call
+depth. Evaluation stops once the depth passes the configured limit, which
+almost always means a recursive function has no base case or never
+reaches it. The limit can be changed with the --max-depth flag of
+gosling run, or with evaluator.Options when embedding the interpreter.`,
+		Example: `let f = fn(n) { f(n) }; f(1);`,
+	},
+	StepBudgetExceeded: {
+		Code:    StepBudgetExceeded,
+		Name:    "step-budget-exceeded",
+		Summary: "evaluation took more steps than allowed",
+		Explanation: `Every expression and statement evaluated counts as one step. When a step
+budget is configured, with the --max-steps flag of gosling run or with
+evaluator.Options, evaluation stops once it is used up. This usually
+points at a loop whose condition never becomes false.`,
+		Example: `for (true) { }`,
+	},
+	EvaluationCancelled: {
+		Code:    EvaluationCancelled,
+		Name:    "evaluation-cancelled",
+		Summary: "evaluation was cancelled or ran past its deadline",
+		Explanation: `The program was still running when its wall-clock timeout expired, set
+with the --timeout flag of gosling run or evaluator.Options, or when the
+context passed to evaluator.Eval was cancelled by the host program.`,
+		Example: `for (true) { }`,
+	},
+	MemoryLimitExceeded: {
+		Code:    MemoryLimitExceeded,
+		Name:    "memory-limit-exceeded",
+		Summary: "the program allocated more than its memory budget",
+		Explanation: `When a memory budget is configured, with the --max-memory flag of
+gosling run or with evaluator.Options, the evaluator keeps an approximate
+count of the bytes used by strings built with "+" and by the environments
+created for let bindings and function calls. Evaluation stops once the
+count passes the budget. Environments are given back when a call returns,
+strings are not, so the count is an upper bound for programs that build
+many short-lived strings.`,
+		Example: `let s = "ab"; for (true) { s = s + s; }`,
+	},
+	TypeMismatch: {
+		Code:    TypeMismatch,
+		Name:    "type-mismatch",
+		Summary: "a value does not have the type its annotation asks for",
+		Explanation: `Let bindings, function parameters and function results may carry a
+type annotation. The value is checked against it when the binding is made,
+when the function is entered and when it returns. gosling check reports
+the mismatches it can see without running the program.`,
+		Example: `let double = fn(x: int) -> int { x * 2 }; double("a");`,
+	},
+	UnknownType: {
+		Code:    UnknownType,
+		Name:    "unknown-type",
+		Summary: "an annotation names a type that does not exist",
+		Explanation: `Type annotations may use int, string, bool, dynamic, arrays [T], maps
+{K: V}, results Result<T>, options Option<T>, function types such as fn(int) -> string and
+the type parameters of an enclosing generic function. Any other name is
+reported, as is a type given the wrong number of type arguments.`,
+		Example: `let x: integer = 5;`,
+	},
+	UnwrappedOption: {
+		Code:    UnwrappedOption,
+		Name:    "unwrapped-option",
+		Summary: "an option was used where the value inside it was needed",
+		Explanation: `Options made with some(v) or none have to be unwrapped before operators
+can use their value. Bind the value with if let v = x { ... }, which skips
+the block for none, or test for it with exists(x). gosling check reports
+the options it can see without running the program.`,
+		Example: `let x = none; x + 1;`,
+	},
+	IntegerOverflow: {
+		Code:    IntegerOverflow,
+		Name:    "integer-overflow",
+		Summary: "an integer result does not fit in 64 bits",
+		Explanation: `Integers that outgrow a signed 64-bit integer are normally promoted to
+big integers of any size. Run with --strict-overflow to report the
+overflow as an error instead, for programs that expect their arithmetic
+to stay within 64 bits.`,
+		Example: `9223372036854775807 + 1;`,
+	},
+	ArgumentTypeMismatch: {
+		Code:    ArgumentTypeMismatch,
+		Name:    "argument-type-mismatch",
+		Summary: "a function was called with an argument it cannot use",
+		Explanation: `gosling check infers the type of each parameter from the operations
+that run on every call of the function. An argument of another type is
+certain to fail once the function runs, so the call is reported. The
+message shows the argument's type and the type the parameter needs.`,
+		Example: `let dec = fn(x) { x - 1 }; dec("a");`,
+	},
+	CannotInfer: {
+		Code:    CannotInfer,
+		Name:    "cannot-infer",
+		Summary: "the type parameters of a generic call could not be worked out",
+		Explanation: `Each call of a generic function such as fn<T>(a: T, b: T) -> T works
+out its type parameters from the arguments. This fails when two arguments
+ask for different types, or when a type parameter only appears in the
+result, so no argument says what it is. Programs still run as before, the
+diagnostic only comes from gosling check.`,
+		Example: `let pick = fn<T>(a: T, b: T) -> T { a }; pick(1, "a");`,
+	},
+	DuplicateTypeParameter: {
+		Code:    DuplicateTypeParameter,
+		Name:    "duplicate-type-parameter",
+		Summary: "a generic function declares the same type parameter twice",
+		Explanation: `Each type parameter of a generic function names a type of its own, so
+fn<T, T> leaves it unclear which T an annotation means. Give each type
+parameter a different name. Programs still run as before, the diagnostic
+only comes from gosling check.`,
+		Example: `let pair = fn<T, T>(a: T, b: T) { a };`,
+	},
+	UnsupportedConstruct: {
+		Code:    UnsupportedConstruct,
+		Name:    "unsupported-construct",
+		Summary: "gosling build cannot compile a construct",
+		Explanation: `The backends of gosling build compile a typed subset of the language:
+integers that fit in 64 bits, booleans, strings, functions and closures,
+let, =, if, for and return. Options, if let, generic functions and values
+whose type changes as the program runs are left to gosling run. The
+message names the construct that is not supported.`,
+		Example: `let x = none;`,
+	},
+	NoStaticType: {
+		Code:    NoStaticType,
+		Name:    "no-static-type",
+		Summary: "gosling build could not give a value a single type",
+		Explanation: `Compiled code needs the type of every binding and parameter before the
+program runs. It is worked out from how each one is used, and this
+fails when nothing says what it is, as for a parameter that is only
+passed along. Annotate the parameter or binding with its type.`,
+		Example: `let f = fn(x) { x };`,
+	},
+	UnusedBinding: {
+		Code:    UnusedBinding,
+		Name:    "unused-binding",
+		Summary: "a let binding is never read",
+		Explanation: `The value bound by this let statement is never used, which often means
+a typo in a later use of the name or leftover code. Names starting with
+an underscore are never reported.`,
+		Example: `let f = fn() { let unused = 5; return 1; };`,
+	},
+	ShadowedName: {
+		Code:    ShadowedName,
+		Name:    "shadowed-name",
+		Summary: "a function parameter hides a name from an enclosing scope",
+		Explanation: `Inside the function the parameter takes the place of the outer name or
+builtin, so the outer value cannot be reached from the body. Rename the
+parameter if the outer value is needed.`,
+		Example: `let x = 1; let f = fn(x) { x };`,
+	},
+	UnreachableCode: {
+		Code:    UnreachableCode,
+		Name:    "unreachable-code",
+		Summary: "statements follow a return in the same block",
+		Explanation: `A return statement leaves the block immediately, so nothing after it
+in the same block is ever evaluated.`,
+		Example: `let f = fn() { return 1; 2; };`,
+	},
+	ConstantCondition: {
+		Code:    ConstantCondition,
+		Name:    "constant-condition",
+		Summary: "a condition is always true or always false",
+		Explanation: `The condition of an if expression, or of a for loop that never runs,
+does not depend on anything that can change, so one branch is dead.
+for (true) is left alone since it is the usual way to write a loop that
+exits with return.`,
+		Example: `if (1 < 2) { 3 } else { 4 }`,
+	},
+	MismatchedComparison: {
+		Code:    MismatchedComparison,
+		Name:    "mismatched-comparison",
+		Summary: "literals of different types are compared",
+		Explanation: `Values of different types are never equal, so == is always false and
+!= always true, while < and > fail at run time.`,
+		Example: `if (5 == "5") { 1 }`,
+	},
+	UndefinedCall: {
+		Code:    UndefinedCall,
+		Name:    "undefined-call",
+		Summary: "a function is called by a name that is never bound",
+		Explanation: `No let statement or parameter in scope binds the name, and it is not a
+builtin, so the call fails with identifier-not-found when it runs.`,
+		Example: `lenght("abc");`,
+	},
+}
+
+// Lookup finds a definition by code ("E0102") or by name ("unknown-operator"),
+// ignoring case. A name may also be written without its dashes, as in
+// "UnknownOperator".
+func Lookup(key string) (*Definition, error) {
+	if def, ok := definitions[Code(strings.ToUpper(key))]; ok {
+		return def, nil
+	}
+	name := normalizeName(key)
+	for _, def := range definitions {
+		if normalizeName(def.Name) == name {
+			return def, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown diagnostic code %q", key)
+}
+
+// normalizeName returns name in lower case without dashes
+func normalizeName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "-", ""))
+}
+
+// Definitions returns every registered definition ordered by code
+func Definitions() []*Definition {
+	defs := make([]*Definition, 0, len(definitions))
+	for _, def := range definitions {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Code < defs[j].Code })
+	return defs
+}
+
+// Name returns the kebab-case name registered for the code
+func (c Code) Name() string {
+	if def, ok := definitions[c]; ok {
+		return def.Name
+	}
+	return ""
+}
+
+// Severity is Warning for W codes and Error for everything else
+func (c Code) Severity() Severity {
+	if strings.HasPrefix(string(c), "W") {
+		return Warning
+	}
+	return Error
+}
+
+func New(code Code, loc token.TokenLocation, format string, a ...interface{}) Diagnostic {
+	return Diagnostic{
+		Code:     code,
+		Severity: code.Severity(),
+		Message:  fmt.Sprintf(format, a...),
+		Location: loc,
+	}
+}
+
+// Format renders a located message the same way the evaluator always has,
+// with the code inserted in front of the message
+func Format(code Code, loc token.TokenLocation, message string) string {
+	if code == "" {
+		return fmt.Sprintf("file: %s line: %d char: %d %s", loc.Filename, loc.Line, loc.LineCh, message)
+	}
+	return fmt.Sprintf("file: %s line: %d char: %d [%s] %s", loc.Filename, loc.Line, loc.LineCh, code, message)
+}
+
+func (d Diagnostic) String() string {
+	return Format(d.Code, d.Location, d.Message)
+}
+
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code     Code                `json:"code"`
+		Name     string              `json:"name"`
+		Severity Severity            `json:"severity"`
+		Message  string              `json:"message"`
+		Location token.TokenLocation `json:"location"`
+	}{d.Code, d.Code.Name(), d.Severity, d.Message, d.Location})
+}
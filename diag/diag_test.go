@@ -0,0 +1,79 @@
+package diag
+
+import (
+	"encoding/json"
+	"gosling/token"
+	"strings"
+	"testing"
+)
+
+func TestDefinitionsComplete(t *testing.T) {
+	names := map[string]Code{}
+	normalized := map[string]Code{}
+	for _, def := range Definitions() {
+		if def.Name == "" || def.Summary == "" || def.Explanation == "" || def.Example == "" {
+			t.Errorf("definition %s is missing fields: %+v", def.Code, def)
+		}
+		if def.Name != strings.ToLower(def.Name) || strings.Contains(def.Name, " ") {
+			t.Errorf("definition %s name %q is not kebab-case", def.Code, def.Name)
+		}
+		if other, ok := names[def.Name]; ok {
+			t.Errorf("name %q used by both %s and %s", def.Name, other, def.Code)
+		}
+		names[def.Name] = def.Code
+		if other, ok := normalized[normalizeName(def.Name)]; ok {
+			t.Errorf("name %q looks up both %s and %s", def.Name, other, def.Code)
+		}
+		normalized[normalizeName(def.Name)] = def.Code
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected Code
+	}{
+		{"E0102", UnknownOperator},
+		{"e0102", UnknownOperator},
+		{"unknown-operator", UnknownOperator},
+		{"identifier-not-found", IdentifierNotFound},
+		{"Unknown-Operator", UnknownOperator},
+		{"calldepthexceeded", CallDepthExceeded},
+		{"CallDepthExceeded", CallDepthExceeded},
+	}
+
+	for _, tt := range tests {
+		def, err := Lookup(tt.key)
+		if err != nil {
+			t.Errorf("Lookup(%q) returned error: %s", tt.key, err)
+			continue
+		}
+		if def.Code != tt.expected {
+			t.Errorf("Lookup(%q) wrong code. want=%s, got=%s", tt.key, tt.expected, def.Code)
+		}
+	}
+
+	if _, err := Lookup("E9999"); err == nil {
+		t.Errorf("expected error for unknown code")
+	}
+}
+
+func TestDiagnosticFormat(t *testing.T) {
+	d := New(UnknownOperator, token.TokenLocation{Filename: "a.gos", Line: 2, LineCh: 5},
+		"unknown operator: %s + %s", "INTEGER", "BOOLEAN")
+
+	expected := "file: a.gos line: 2 char: 5 [E0102] unknown operator: INTEGER + BOOLEAN"
+	if d.String() != expected {
+		t.Errorf("wrong String(). want=%q, got=%q", expected, d.String())
+	}
+
+	out, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %s", err)
+	}
+	expectedJSON := `{"code":"E0102","name":"unknown-operator","severity":"error",` +
+		`"message":"unknown operator: INTEGER + BOOLEAN","location":{"line":2,"char":5,"file":"a.gos"}}`
+	if string(out) != expectedJSON {
+		t.Errorf("wrong json. want=%s, got=%s", expectedJSON, out)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"gosling/difftest"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const difftestUsage = "usage: gosling difftest [--engines=name,...] [--generate=n [--seed=n]] [--out=dir] [--timeout=d] [--max-steps=n] [file.gos|dir...]\n"
+
+// spec is the language specification, whose examples and grammar the
+// differential tests run and generate programs from
+//
+//go:embed language_specification.md
+var spec string
+
+// difftestCmd runs the examples of the specification, the programs the
+// arguments name and, with --generate, random programs through every
+// engine, and reports where they disagree, each with the smallest program
+// it could find that still shows it
+func difftestCmd(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("difftest", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	names := fs.String("engines", "", "the engines to compare, all that can run here by default")
+	list := fs.Bool("list", false, "list the engines that can run here and exit")
+	generate := fs.Int("generate", 0, "also run this many random programs")
+	seed := fs.Int64("seed", 0, "seed of the random programs, the time by default")
+	outDir := fs.String("out", "", "write each divergent program and its reproducer to this directory")
+	timeout := fs.Duration("timeout", difftest.DefaultLimits.Timeout, "wall-clock limit for each run")
+	maxSteps := fs.Int("max-steps", 1000000, "maximum number of steps the interpreters may take, 0 for no limit")
+	module := fs.String("module", "", "directory of the gosling module, for the go engine to build against, found with go env GOMOD by default")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *generate < 0 || *timeout <= 0 {
+		fmt.Fprint(errOut, difftestUsage)
+		return 2
+	}
+	if *module == "" {
+		*module = goslingModule()
+	}
+	engines := difftest.All(*module)
+	if *list {
+		for _, e := range engines {
+			fmt.Fprintln(out, e.Name)
+		}
+		return 0
+	}
+	if *names != "" {
+		var err error
+		if engines, err = difftest.Select(engines, *names); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 2
+		}
+	}
+	if len(engines) < 2 {
+		fmt.Fprintf(errOut, "there is nothing to compare with fewer than two engines\n")
+		return 2
+	}
+
+	work, err := os.MkdirTemp("", "gosling-difftest-")
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return 1
+	}
+	defer os.RemoveAll(work)
+	examples, err := difftest.WriteExamples(spec, work)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return 1
+	}
+	given, err := difftest.Corpus(fs.Args()...)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return 1
+	}
+	example := map[string]bool{}
+	for _, path := range examples {
+		example[path] = true
+	}
+	programs := append(examples, given...)
+	if *generate > 0 {
+		generated, ok := generatePrograms(work, *generate, *seed, out, errOut)
+		if !ok {
+			return 1
+		}
+		programs = append(programs, generated...)
+	}
+
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+	}
+	h := difftest.New(engines)
+	h.Limits = difftest.Limits{Timeout: *timeout, MaxSteps: *maxSteps}
+	ctx := context.Background()
+	ran, divergences := 0, 0
+	for _, path := range programs {
+		report, err := h.Check(ctx, path)
+		if errors.Is(err, difftest.ErrSyntax) {
+			// the examples include fragments of the grammar as well as
+			// whole programs
+			if !example[path] {
+				fmt.Fprintf(errOut, "%s: skipped, it does not parse\n", path)
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+		ran++
+		for _, d := range report.Divergences {
+			divergences++
+			fmt.Fprintf(out, "%s\n", d)
+			reproducer, err := h.Minimise(ctx, d, work)
+			if err != nil {
+				fmt.Fprintf(errOut, "%s\n", err)
+				return 1
+			}
+			fmt.Fprintf(out, "minimised:\n%s\n", indent(reproducer))
+			if *outDir != "" {
+				if err := saveDivergence(*outDir, divergences, d, reproducer, out); err != nil {
+					fmt.Fprintf(errOut, "%s\n", err)
+					return 1
+				}
+			}
+		}
+	}
+	fmt.Fprintf(out, "%d programs on %d engines, %d divergences\n", ran, len(engines), divergences)
+	if divergences > 0 {
+		return 1
+	}
+	return 0
+}
+
+// generatePrograms writes n random programs from the grammar of the
+// specification to dir
+func generatePrograms(dir string, n int, seed int64, out, errOut io.Writer) ([]string, bool) {
+	grammar, err := difftest.SpecGrammar(spec)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return nil, false
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	gen, err := difftest.NewGenerator(grammar, seed)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return nil, false
+	}
+	fmt.Fprintf(out, "generating %d programs with --seed=%d\n", n, seed)
+	var paths []string
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("generated%04d.gos", i+1))
+		if err := os.WriteFile(path, []byte(gen.Program()), 0o644); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return nil, false
+		}
+		paths = append(paths, path)
+	}
+	return paths, true
+}
+
+// saveDivergence writes the program of the nth divergence and its
+// reproducer to dir
+func saveDivergence(dir string, n int, d *difftest.Divergence, reproducer string, out io.Writer) error {
+	source, err := os.ReadFile(d.Path)
+	if err != nil {
+		return err
+	}
+	base := fmt.Sprintf("divergence%03d-%s", n, d.Engine.Name)
+	files := []struct {
+		name     string
+		contents string
+	}{
+		{base + ".gos", string(source)},
+		{base + "-min.gos", reproducer},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.name), []byte(f.contents), 0o644); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "wrote %s\n", filepath.Join(dir, base+"-min.gos"))
+	return nil
+}
+
+func indent(s string) string {
+	return "\t" + strings.ReplaceAll(strings.TrimSuffix(s, "\n"), "\n", "\n\t")
+}
+
+// goslingModule returns the directory of the gosling module the working
+// directory is in, "" if it is not in it or there is no go command
+func goslingModule() string {
+	goMod, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		return ""
+	}
+	path := strings.TrimSpace(string(goMod))
+	contents, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(contents), "module gosling\n") {
+		return ""
+	}
+	return filepath.Dir(path)
+}
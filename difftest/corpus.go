@@ -0,0 +1,68 @@
+package difftest
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Examples returns the source of each gosling example of a specification
+// in markdown, the blocks fenced with ```gosling
+func Examples(spec string) []string {
+	var examples []string
+	var example *strings.Builder
+	for _, line := range strings.Split(spec, "\n") {
+		switch {
+		case example == nil && strings.TrimSpace(line) == "```gosling":
+			example = &strings.Builder{}
+		case example != nil && strings.TrimSpace(line) == "```":
+			examples = append(examples, example.String())
+			example = nil
+		case example != nil:
+			example.WriteString(line + "\n")
+		}
+	}
+	return examples
+}
+
+// WriteExamples writes the examples of spec to dir as example01.gos and on,
+// returning their paths
+func WriteExamples(spec, dir string) ([]string, error) {
+	var paths []string
+	for i, example := range Examples(spec) {
+		path := filepath.Join(dir, fmt.Sprintf("example%02d.gos", i+1))
+		if err := os.WriteFile(path, []byte(example), 0o644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Corpus returns the .gos files paths name: files as they are, and those
+// under directories, in lexical order
+func Corpus(paths ...string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && filepath.Ext(p) == ".gos" {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,280 @@
+// Package difftest runs gosling programs through every way there is of
+// running them, the tree walker, the bytecode vm, the optimiser in front
+// of either, and the native backends, and reports where they disagree.
+//
+// Each engine gives a Result: the final value, what the program printed
+// and the error it stopped with, with its location. Engines that keep
+// integers to 64 bits are compared with the tree walker under strict
+// overflow, the others with the plain tree walker. A Divergence can be
+// cut down to a small program that still shows it with Minimise, and
+// Generator writes random programs from the grammar of the specification
+// to feed the harness more cases than a corpus holds.
+package difftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gosling/diag"
+	"gosling/lexer"
+	"gosling/parser"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result is what running a program shows
+type Result struct {
+	Value  string // the final value as gosling run prints it, "" for none
+	Output string // everything the program wrote to stdout
+	Status int    // the exit status gosling run would have
+
+	// The error the program stopped with, if it did
+	Code    diag.Code
+	Message string
+	Line    int
+	Char    int
+}
+
+func (r Result) String() string {
+	if r.Code == "" && r.Message == "" {
+		return fmt.Sprintf("value %q", r.Value)
+	}
+	return fmt.Sprintf("error %d:%d [%s] %s", r.Line, r.Char, r.Code, r.Message)
+}
+
+// limited reports whether r stopped at a limit, which engines count
+// differently or do not have, so it says nothing about the program
+func (r Result) limited() bool {
+	switch r.Code {
+	case diag.CallDepthExceeded, diag.StepBudgetExceeded, diag.EvaluationCancelled, diag.MemoryLimitExceeded:
+		return true
+	}
+	return false
+}
+
+// Compare returns the first part of got that differs from want, "" if
+// none does. Compiled code does not work out the value of an integer that
+// overflows, so such an error only needs the start of its message to match.
+// Each engine prints a function its own way, from its source, its syntax
+// tree or its compiled code, so any two functions match.
+func Compare(want, got Result) string {
+	switch {
+	case want.Value != got.Value && !(isFunction(want.Value) && isFunction(got.Value)):
+		return "value"
+	case want.Output != got.Output && !(isFunction(want.Output) && isFunction(got.Output)):
+		return "output"
+	case want.Code != got.Code:
+		return "error code"
+	case want.Message != got.Message && !sameOverflow(want, got):
+		return "error message"
+	case want.Line != got.Line || want.Char != got.Char:
+		return "error location"
+	case want.Status != got.Status:
+		return "exit status"
+	}
+	return ""
+}
+
+func sameOverflow(want, got Result) bool {
+	const overflow = "integer overflow:"
+	return want.Code == diag.IntegerOverflow && strings.HasPrefix(want.Message, overflow) && strings.HasPrefix(got.Message, overflow)
+}
+
+// isFunction reports whether a value or the output of a program is a
+// function as the engines print them
+func isFunction(s string) bool {
+	return strings.HasPrefix(s, "fn(") || strings.HasPrefix(s, "fn<")
+}
+
+// errorLine is an error as gosling run and the native backends print it
+var errorLine = regexp.MustCompile(`^file: .* line: (\d+) char: (\d+) (?:\[(E\d+)\] )?(.*)$`)
+
+// fromOutput makes the result of a program that ran as a process
+func fromOutput(stdout, stderr string, status int) Result {
+	r := Result{Output: stdout, Status: status}
+	r.Value = strings.TrimSuffix(stdout, "\n")
+	stderr = strings.TrimSuffix(stderr, "\n")
+	if stderr == "" {
+		return r
+	}
+	m := errorLine.FindStringSubmatch(stderr)
+	if m == nil {
+		r.Message = stderr
+		return r
+	}
+	r.Line, _ = strconv.Atoi(m[1])
+	r.Char, _ = strconv.Atoi(m[2])
+	r.Code, r.Message = diag.Code(m[3]), m[4]
+	return r
+}
+
+// ErrUnsupported is returned by engines that cannot run a program, such as
+// a native backend given a construct it does not compile
+var ErrUnsupported = errors.New("the engine does not support the program")
+
+// ErrSyntax is returned for a program that does not parse
+var ErrSyntax = errors.New("the program does not parse")
+
+// Engine is a way of running programs
+type Engine struct {
+	Name string
+	// Strict is set for engines that keep integers to 64 bits, as
+	// gosling run --strict-overflow does
+	Strict bool
+	// Run runs the .gos file at path, which is known to parse. A program
+	// that runs longer than limits allow stops with an
+	// evaluation-cancelled result, the time spent compiling it aside.
+	Run func(ctx context.Context, path string, limits Limits) (Result, error)
+}
+
+// Limits bound how long a program may run
+type Limits struct {
+	Timeout  time.Duration // wall-clock limit for each run
+	MaxSteps int           // steps the interpreters may take, 0 for no limit
+}
+
+// DefaultLimits are those of Harness when it is given none
+var DefaultLimits = Limits{Timeout: 5 * time.Second}
+
+// Divergence is a program for which an engine disagrees with the engine
+// it is compared with
+type Divergence struct {
+	Path      string
+	Engine    *Engine
+	Reference *Engine
+	Want, Got Result
+	Field     string // the first part of the results that differs
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("%s: %s differs from %s in its %s.\n\t%-11s %s\n\t%-11s %s",
+		d.Path, d.Engine.Name, d.Reference.Name, d.Field, d.Reference.Name+":", d.Want, d.Engine.Name+":", d.Got)
+}
+
+// Report is what a program gave on each engine
+type Report struct {
+	Path string
+	// Results holds the result of each engine that ran the program
+	Results map[string]Result
+	// Skipped names the engines that did not run it or whose result says
+	// nothing, as it stopped at a limit
+	Skipped     []string
+	Divergences []*Divergence
+}
+
+// Harness runs programs through its engines and compares them
+type Harness struct {
+	Engines []*Engine
+	Limits  Limits
+}
+
+// New returns a harness for the given engines, in order. The first
+// engine of each kind, strict or not, is the one the others of its kind
+// are compared with.
+func New(engines []*Engine) *Harness {
+	return &Harness{Engines: engines, Limits: DefaultLimits}
+}
+
+// reference returns the engine e is compared with, nil for e itself
+func (h *Harness) reference(e *Engine) *Engine {
+	for _, r := range h.Engines {
+		if r.Strict == e.Strict {
+			if r == e {
+				return nil
+			}
+			return r
+		}
+	}
+	return nil
+}
+
+// Check runs the program at path through every engine. It returns
+// ErrSyntax if the program does not parse.
+func (h *Harness) Check(ctx context.Context, path string) (*Report, error) {
+	if err := parses(path); err != nil {
+		return nil, err
+	}
+	report := &Report{Path: path, Results: map[string]Result{}}
+	for _, e := range h.Engines {
+		r, err := h.run(ctx, e, path)
+		if errors.Is(err, ErrUnsupported) {
+			report.Skipped = append(report.Skipped, e.Name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, e.Name, err)
+		}
+		if r.limited() {
+			report.Skipped = append(report.Skipped, e.Name)
+			continue
+		}
+		report.Results[e.Name] = r
+	}
+	for _, e := range h.Engines {
+		ref := h.reference(e)
+		got, ok := report.Results[e.Name]
+		if ref == nil || !ok {
+			continue
+		}
+		want, ok := report.Results[ref.Name]
+		if !ok {
+			continue
+		}
+		if field := Compare(want, got); field != "" {
+			report.Divergences = append(report.Divergences, &Divergence{
+				Path: path, Engine: e, Reference: ref, Want: want, Got: got, Field: field,
+			})
+		}
+	}
+	return report, nil
+}
+
+// run runs path on e. An engine that panics gives the result gosling run
+// would, a message on stderr and exit status 2, so a crash shows as a
+// divergence from the engines that do not.
+func (h *Harness) run(ctx context.Context, e *Engine, path string) (r Result, err error) {
+	limits := h.Limits
+	if limits.Timeout <= 0 {
+		limits.Timeout = DefaultLimits.Timeout
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			r, err = Result{Message: fmt.Sprintf("panic: %v", v), Status: 2}, nil
+		}
+	}()
+	return e.Run(ctx, path, limits)
+}
+
+func parses(path string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("the parser panics: %v", v)
+		}
+	}()
+	l := lexer.LexFile(path)
+	if l == nil {
+		return fmt.Errorf("%s is not a .gos file", path)
+	}
+	p := parser.New(l)
+	p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return ErrSyntax
+	}
+	return nil
+}
+
+// writeProgram writes source to a new .gos file in dir
+func writeProgram(dir, pattern, source string) (string, error) {
+	f, err := os.CreateTemp(dir, pattern+"-*.gos")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(source); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
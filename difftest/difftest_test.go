@@ -0,0 +1,348 @@
+package difftest
+
+import (
+	"context"
+	"errors"
+	"gosling/diag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// limits keep the programs that loop for ever, which random ones often
+// do, from holding up the tests
+var limits = Limits{Timeout: time.Second, MaxSteps: 100000}
+
+func readSpec(t *testing.T) string {
+	t.Helper()
+	spec, err := os.ReadFile(filepath.Join("..", "language_specification.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(spec)
+}
+
+// engines returns the engines the tests compare: the interpreters, wasm
+// and llvm if clang is installed. The go backend builds each program with
+// the go command, which its own conformance test does once for all.
+func engines() []*Engine {
+	engines := append(Interpreters(), WASM())
+	if clang, err := exec.LookPath("clang"); err == nil {
+		engines = append(engines, LLVM(clang))
+	}
+	return engines
+}
+
+// check runs path through h and reports each divergence with a
+// minimised reproducer
+func check(t *testing.T, h *Harness, path string) *Report {
+	t.Helper()
+	report, err := h.Check(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range report.Divergences {
+		reproducer, err := h.Minimise(context.Background(), d, t.TempDir())
+		if err != nil {
+			t.Fatalf("minimising %s: %v", d, err)
+		}
+		t.Errorf("%s\nminimised:\n%s", d, reproducer)
+	}
+	return report
+}
+
+// TestCorpus runs the examples of the specification and the programs the
+// backends are tested with through every engine
+func TestCorpus(t *testing.T) {
+	examples, err := WriteExamples(readSpec(t), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	programs, err := filepath.Glob(filepath.Join("..", "*", "testdata", "*.gos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(engines())
+	h.Limits = limits
+	ran := map[string]int{}
+	for _, path := range append(examples, programs...) {
+		report, err := h.Check(context.Background(), path)
+		if errors.Is(err, ErrSyntax) {
+			// fragments of the grammar rather than whole programs
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name := range report.Results {
+			ran[name]++
+		}
+		check(t, h, path)
+	}
+	for _, e := range h.Engines {
+		if ran[e.Name] == 0 {
+			t.Errorf("%s ran none of the corpus", e.Name)
+		}
+	}
+}
+
+// TestGenerated runs random programs through every engine
+func TestGenerated(t *testing.T) {
+	g, err := SpecGrammar(readSpec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := NewGenerator(g, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 300
+	if testing.Short() {
+		n = 50
+	}
+	h := New(engines())
+	h.Limits = limits
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		path, err := writeProgram(dir, "generated", gen.Program())
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, h, path)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	overflow := Result{Status: 1, Code: diag.IntegerOverflow, Message: "integer overflow: 9223372036854775807 + 1 = 9223372036854775808"}
+	tests := []struct {
+		want, got Result
+		field     string
+	}{
+		{Result{Value: "1", Output: "1\n"}, Result{Value: "1", Output: "1\n"}, ""},
+		{Result{Value: "1", Output: "1\n"}, Result{Value: "2", Output: "2\n"}, "value"},
+		{Result{Value: "1", Output: "1\n"}, Result{Value: "1", Output: "1\n1\n"}, "output"},
+		{Result{Value: "fn(x) {\nx\n}", Output: "fn(x) {\nx\n}\n"}, Result{Value: "fn(x) [compiled 0x1]", Output: "fn(x) [compiled 0x1]\n"}, ""},
+		{Result{Value: "fn(x) {\nx\n}"}, Result{Value: "x"}, "value"},
+		{Result{Status: 1, Code: diag.DivisionByZero, Message: "division by zero", Char: 3}, Result{Status: 1, Code: diag.UnknownOperator, Message: "division by zero", Char: 3}, "error code"},
+		{Result{Status: 1, Code: diag.DivisionByZero, Message: "division by zero", Char: 3}, Result{Status: 1, Code: diag.DivisionByZero, Message: "divide by zero", Char: 3}, "error message"},
+		{Result{Status: 1, Code: diag.DivisionByZero, Message: "division by zero", Char: 3}, Result{Status: 1, Code: diag.DivisionByZero, Message: "division by zero", Char: 4}, "error location"},
+		{Result{Status: 1, Code: diag.DivisionByZero, Message: "division by zero"}, Result{Status: 2, Code: diag.DivisionByZero, Message: "division by zero"}, "exit status"},
+		{overflow, Result{Status: 1, Code: diag.IntegerOverflow, Message: "integer overflow: int + int"}, ""},
+		{overflow, Result{Status: 1, Code: diag.IntegerOverflow, Message: "overflow"}, "error message"},
+	}
+	for _, tt := range tests {
+		if field := Compare(tt.want, tt.got); field != tt.field {
+			t.Errorf("Compare(%s, %s) = %q, want %q", tt.want, tt.got, field, tt.field)
+		}
+	}
+}
+
+func TestFromOutput(t *testing.T) {
+	tests := []struct {
+		stdout, stderr string
+		status         int
+		want           Result
+	}{
+		{"3\n", "", 0, Result{Value: "3", Output: "3\n"}},
+		{"", "", 0, Result{}},
+		{"", "file: main.gos line: 2 char: 7 [E0103] division by zero\n", 1,
+			Result{Status: 1, Code: diag.DivisionByZero, Message: "division by zero", Line: 2, Char: 7}},
+		{"", "file: main.gos line: 0 char: 1 out of memory\n", 1,
+			Result{Status: 1, Message: "out of memory", Char: 1}},
+		{"", "Segmentation fault\n", 139, Result{Status: 139, Message: "Segmentation fault"}},
+	}
+	for _, tt := range tests {
+		if got := fromOutput(tt.stdout, tt.stderr, tt.status); got != tt.want {
+			t.Errorf("fromOutput(%q, %q, %d) = %+v, want %+v", tt.stdout, tt.stderr, tt.status, got, tt.want)
+		}
+	}
+}
+
+// wrong returns an engine that runs programs on the tree walker but gets
+// the value wrong of those whose source contains bug
+func wrong(bug string) *Engine {
+	tree := Interpreters()[0]
+	return &Engine{Name: "wrong", Run: func(ctx context.Context, path string, limits Limits) (Result, error) {
+		r, err := tree.Run(ctx, path, limits)
+		source, _ := os.ReadFile(path)
+		if strings.Contains(string(source), bug) {
+			r.Value += "?"
+		}
+		return r, err
+	}}
+}
+
+func TestDivergence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.gos")
+	if err := os.WriteFile(path, []byte("let x = 40;\nx + 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := New([]*Engine{Interpreters()[0], wrong("2")})
+	report, err := h.Check(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Divergences) != 1 {
+		t.Fatalf("got %d divergences, want 1", len(report.Divergences))
+	}
+	d := report.Divergences[0]
+	if d.Engine.Name != "wrong" || d.Reference.Name != "tree" || d.Field != "value" {
+		t.Errorf("got %s", d)
+	}
+	if d.Want.Value != "42" || d.Got.Value != "42?" {
+		t.Errorf("got values %q and %q, want 42 and 42?", d.Want.Value, d.Got.Value)
+	}
+}
+
+func TestPanickingEngine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.gos")
+	if err := os.WriteFile(path, []byte("1 + 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	panics := &Engine{Name: "panics", Run: func(ctx context.Context, path string, limits Limits) (Result, error) {
+		panic("boom")
+	}}
+	report, err := New([]*Engine{Interpreters()[0], panics}).Check(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Divergences) != 1 || report.Divergences[0].Got.Message != "panic: boom" {
+		t.Errorf("got %v, want a divergence for the panic", report.Divergences)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.gos")
+	if err := os.WriteFile(path, []byte("let = 1;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(Interpreters()).Check(context.Background(), path); !errors.Is(err, ErrSyntax) {
+		t.Errorf("got %v, want ErrSyntax", err)
+	}
+}
+
+func TestMinimise(t *testing.T) {
+	source := `let add = fn(a, b) { a + b };
+let double = fn(x) { x * 2 };
+let v = add(double(3), 1);
+if (v > 5) { double(v) } else { v - 19 };
+`
+	path := filepath.Join(t.TempDir(), "main.gos")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := New([]*Engine{Interpreters()[0], wrong("19")})
+	h.Limits = limits
+	report, err := h.Check(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Divergences) != 1 {
+		t.Fatalf("got %d divergences, want 1", len(report.Divergences))
+	}
+	reproducer, err := h.Minimise(context.Background(), report.Divergences[0], t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reproducer != "19\n" {
+		t.Errorf("got reproducer %q, want %q", reproducer, "19\n")
+	}
+}
+
+func TestExamples(t *testing.T) {
+	spec := "# Spec\n\n```gosling\nlet x = 1;\n```\n\ntext\n\n```ebnf\nA = \"a\" .\n```\n\n  ```gosling\nx\n  ```\n"
+	got := Examples(spec)
+	want := []string{"let x = 1;\n", "x\n"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("example %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	engines, err := Select(Interpreters(), "vm, tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(engines) != 2 || engines[0].Name != "tree" || engines[1].Name != "vm" {
+		t.Errorf("got %v, want tree and vm in that order", engines)
+	}
+	if _, err := Select(Interpreters(), "tree,jit"); err == nil || !strings.Contains(err.Error(), `unknown engine "jit"`) {
+		t.Errorf("got %v, want an unknown engine error", err)
+	}
+}
+
+func TestParseGrammar(t *testing.T) {
+	g, err := ParseGrammar(`List = "[" [ Item { "," Item } ] "]" .
+Item = name | List .
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Start != "List" || len(g.Productions) != 2 {
+		t.Fatalf("got start %s and %d productions", g.Start, len(g.Productions))
+	}
+	list := g.Productions["List"]
+	if list.Kind != Sequence || len(list.Items) != 3 || list.Items[1].Kind != Option {
+		t.Errorf("List parsed as %+v", list)
+	}
+	item := g.Productions["Item"]
+	if item.Kind != Alternatives || len(item.Items) != 2 || item.Items[1].Text != "List" {
+		t.Errorf("Item parsed as %+v", item)
+	}
+	if _, err := NewGenerator(g, 1); err == nil || !strings.Contains(err.Error(), "Item refers to name, which is not defined") {
+		t.Errorf("got %v, want an error for the undefined name", err)
+	}
+
+	errs := []struct {
+		src, err string
+	}{
+		{"", "the grammar has no productions"},
+		{"A = \"a\"", "line 1: expected ., found \"\""},
+		{"A = \"a .", "line 1: unterminated terminal"},
+		{"A = .", "line 1: expected an expression"},
+		{"A = \"a\" .\nA = \"b\" .", "line 2: A is defined twice"},
+		{"A = ( \"a\" .", "line 1: expected ), found \".\""},
+	}
+	for _, tt := range errs {
+		if _, err := ParseGrammar(tt.src); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseGrammar(%q) = %v, want an error containing %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	g, err := SpecGrammar(readSpec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Start != "Program" {
+		t.Errorf("the grammar starts at %s, want Program", g.Start)
+	}
+	first, err := NewGenerator(g, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := NewGenerator(g, 42)
+	dir := t.TempDir()
+	for i := 0; i < 200; i++ {
+		program := first.Program()
+		if again := second.Program(); again != program {
+			t.Fatalf("the same seed gave different programs:\n%s\n%s", program, again)
+		}
+		path, err := writeProgram(dir, "generated", program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := parses(path); err != nil {
+			t.Errorf("%v:\n%s", err, program)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package difftest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gosling/ast"
+	"gosling/compiler"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/golang"
+	"gosling/lexer"
+	"gosling/llvm"
+	"gosling/object"
+	"gosling/optimize"
+	"gosling/parser"
+	"gosling/resolve"
+	"gosling/vm"
+	"gosling/wasm"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// allPasses turns on every pass of the optimiser
+var allPasses = optimize.Options{Fold: true, DeadCode: true, Inline: true}
+
+// Interpreters returns the engines that run programs in this process: the
+// tree walker and the vm, each as written and optimised, and both under
+// strict overflow to compare the native backends with
+func Interpreters() []*Engine {
+	return []*Engine{
+		tree("tree", false, optimize.Options{}),
+		tree("tree-opt", false, allPasses),
+		bytecode("vm", false, optimize.Options{}),
+		bytecode("vm-opt", false, allPasses),
+		tree("tree-strict", true, optimize.Options{}),
+		bytecode("vm-strict", true, optimize.Options{}),
+	}
+}
+
+// Natives returns the backends of gosling build that can run here: wasm,
+// which runs in this process, llvm if clang is on PATH and go if the go
+// command is and module names the directory of the gosling module, whose
+// runtime the programs it builds import
+func Natives(module string) []*Engine {
+	engines := []*Engine{WASM()}
+	if clang, err := exec.LookPath("clang"); err == nil {
+		engines = append(engines, LLVM(clang))
+	}
+	if goCmd, err := exec.LookPath("go"); err == nil && module != "" {
+		engines = append(engines, Go(goCmd, module))
+	}
+	return engines
+}
+
+// All returns the interpreters and the natives that can run here
+func All(module string) []*Engine {
+	return append(Interpreters(), Natives(module)...)
+}
+
+// Select returns the engines of all that names lists, comma separated,
+// keeping the order of all
+func Select(all []*Engine, names string) ([]*Engine, error) {
+	want := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			want[name] = true
+		}
+	}
+	var engines []*Engine
+	for _, e := range all {
+		if want[e.Name] {
+			engines = append(engines, e)
+			delete(want, e.Name)
+		}
+	}
+	for name := range want {
+		var names []string
+		for _, e := range all {
+			names = append(names, e.Name)
+		}
+		return nil, fmt.Errorf("unknown engine %q, the engines here are %s", name, strings.Join(names, ", "))
+	}
+	return engines, nil
+}
+
+// parseFile parses the program at path as gosling run does, so errors
+// name the file
+func parseFile(path string) (*ast.Program, error) {
+	p := parser.New(lexer.LexFile(path))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, ErrSyntax
+	}
+	return program, nil
+}
+
+func options(strict bool, limits Limits) evaluator.Options {
+	return evaluator.Options{StrictOverflow: strict, Timeout: limits.Timeout, MaxSteps: limits.MaxSteps}
+}
+
+// fromObject makes the result of a program that ran in this process
+func fromObject(obj object.Object) Result {
+	if err, ok := obj.(*object.Error); ok {
+		return Result{Status: 1, Code: err.Code, Message: err.Message, Line: err.Location.Line, Char: err.Location.LineCh}
+	}
+	if obj == nil || obj == evaluator.NULL {
+		return Result{}
+	}
+	return Result{Value: obj.Inspect(), Output: obj.Inspect() + "\n"}
+}
+
+func tree(name string, strict bool, passes optimize.Options) *Engine {
+	return &Engine{Name: name, Strict: strict, Run: func(ctx context.Context, path string, limits Limits) (Result, error) {
+		program, err := parseFile(path)
+		if err != nil {
+			return Result{}, err
+		}
+		optimize.Program(program, passes)
+		resolve.Program(program)
+		return fromObject(evaluator.Eval(ctx, program, object.NewEnvironment(), options(strict, limits))), nil
+	}}
+}
+
+func bytecode(name string, strict bool, passes optimize.Options) *Engine {
+	return &Engine{Name: name, Strict: strict, Run: func(ctx context.Context, path string, limits Limits) (Result, error) {
+		program, err := parseFile(path)
+		if err != nil {
+			return Result{}, err
+		}
+		optimize.Program(program, passes)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			return Result{Status: 1, Message: err.Error()}, nil
+		}
+		return fromObject(vm.New(comp.Bytecode(), options(strict, limits)).Run(ctx)), nil
+	}}
+}
+
+// The native engines report ErrUnsupported for a program their backend
+// gives diagnostics for, as gosling build refuses to build it
+
+// WASM runs programs built with gosling build --target=wasm under wazero
+func WASM() *Engine {
+	return &Engine{Name: "wasm", Strict: true, Run: func(ctx context.Context, path string, limits Limits) (Result, error) {
+		program, err := parseFile(path)
+		if err != nil {
+			return Result{}, err
+		}
+		module, diagnostics := wasm.Compile(program, path)
+		if len(diagnostics) > 0 {
+			return Result{}, ErrUnsupported
+		}
+		ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+		r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+		defer r.Close(context.Background())
+		wasi_snapshot_preview1.MustInstantiate(ctx, r)
+
+		var stdout, stderr bytes.Buffer
+		config := wazero.NewModuleConfig().WithStdout(&stdout).WithStderr(&stderr)
+		_, err = r.InstantiateWithConfig(ctx, module.Binary(), config)
+		status := 0
+		if err != nil {
+			var exitErr *sys.ExitError
+			switch {
+			case ctx.Err() != nil:
+				return Result{Code: diag.EvaluationCancelled}, nil
+			case strings.Contains(err.Error(), "stack overflow"):
+				// wasm has no tail calls, so deep recursion runs out of
+				// stack where the interpreters reach their call depth
+				return Result{Code: diag.CallDepthExceeded}, nil
+			case !errors.As(err, &exitErr):
+				// any other trap is a crash, as a signal is to a binary
+				return Result{Status: 2, Message: err.Error()}, nil
+			}
+			status = int(exitErr.ExitCode())
+		}
+		return fromOutput(stdout.String(), stderr.String(), status), nil
+	}}
+}
+
+// LLVM runs programs built with gosling build --emit=llvm and clang
+func LLVM(clang string) *Engine {
+	return &Engine{Name: "llvm", Strict: true, Run: func(ctx context.Context, path string, limits Limits) (Result, error) {
+		program, err := parseFile(path)
+		if err != nil {
+			return Result{}, err
+		}
+		module, diagnostics := llvm.Compile(program, path)
+		if len(diagnostics) > 0 {
+			return Result{}, ErrUnsupported
+		}
+		dir, err := os.MkdirTemp("", "difftest-llvm-")
+		if err != nil {
+			return Result{}, err
+		}
+		defer os.RemoveAll(dir)
+		files := map[string]string{"main.ll": module, llvm.RuntimeFile: llvm.Runtime}
+		for name, contents := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+				return Result{}, err
+			}
+		}
+		binary := filepath.Join(dir, "main")
+		build := exec.Command(clang, "-Wno-override-module", "-o", binary, "main.ll", llvm.RuntimeFile)
+		build.Dir = dir
+		if out, err := build.CombinedOutput(); err != nil {
+			return Result{}, fmt.Errorf("clang failed: %v\n%s", err, out)
+		}
+		return runBinary(ctx, limits, binary)
+	}}
+}
+
+// Go runs programs built with gosling build --emit=go and the go command,
+// in a module of their own that finds the runtime in the gosling module
+// at module
+func Go(goCmd, module string) *Engine {
+	return &Engine{Name: "go", Run: func(ctx context.Context, path string, limits Limits) (Result, error) {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return Result{}, err
+		}
+		program, err := parseFile(path)
+		if err != nil {
+			return Result{}, err
+		}
+		main, diagnostics := golang.Compile(program, path, source)
+		if len(diagnostics) > 0 {
+			return Result{}, ErrUnsupported
+		}
+		dir, err := os.MkdirTemp("", "difftest-go-")
+		if err != nil {
+			return Result{}, err
+		}
+		defer os.RemoveAll(dir)
+		goMod := fmt.Sprintf("module difftest\n\ngo 1.24\n\nrequire gosling v0.0.0\n\nreplace gosling => %s\n", module)
+		files := map[string][]byte{"go.mod": []byte(goMod), "main.go": main}
+		if sum, err := os.ReadFile(filepath.Join(module, "go.sum")); err == nil {
+			files["go.sum"] = sum
+		}
+		for name, contents := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), contents, 0o644); err != nil {
+				return Result{}, err
+			}
+		}
+		build := exec.Command(goCmd, "build", "-o", "main", ".")
+		build.Dir = dir
+		build.Env = append(os.Environ(), "GOWORK=off")
+		if out, err := build.CombinedOutput(); err != nil {
+			return Result{}, fmt.Errorf("go build failed: %v\n%s", err, out)
+		}
+		return runBinary(ctx, limits, filepath.Join(dir, "main"))
+	}}
+}
+
+// runBinary runs a program built by a native backend
+func runBinary(ctx context.Context, limits Limits, binary string) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return Result{Code: diag.EvaluationCancelled}, nil
+	}
+	status := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return Result{}, err
+		}
+		status = exitErr.ExitCode()
+	}
+	return fromOutput(stdout.String(), stderr.String(), status), nil
+}
@@ -0,0 +1,556 @@
+package difftest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Grammar is a grammar in the EBNF of the specification: productions
+// written Name = expression . where an expression combines "terminals",
+// names of other productions, alternatives a | b, groups ( ), options [ ]
+// and repetitions { }
+type Grammar struct {
+	Start       string // the first production
+	Productions map[string]*Expr
+}
+
+// ExprKind is the kind of an Expr
+type ExprKind int
+
+const (
+	Terminal ExprKind = iota
+	Name
+	Sequence
+	Alternatives
+	Option
+	Repetition
+)
+
+// Expr is the right hand side of a production or a part of one
+type Expr struct {
+	Kind  ExprKind
+	Text  string // of a terminal, or the production a name refers to
+	Items []*Expr
+}
+
+// SpecGrammar returns the grammar of a specification in markdown, in its
+// block fenced with ```ebnf
+func SpecGrammar(spec string) (*Grammar, error) {
+	start := strings.Index(spec, "```ebnf\n")
+	if start < 0 {
+		return nil, fmt.Errorf("the specification has no ebnf block")
+	}
+	src := spec[start+len("```ebnf\n"):]
+	end := strings.Index(src, "```")
+	if end < 0 {
+		return nil, fmt.Errorf("the ebnf block of the specification does not end")
+	}
+	return ParseGrammar(src[:end])
+}
+
+// ParseGrammar parses the productions of a grammar
+func ParseGrammar(src string) (*Grammar, error) {
+	p := &grammarParser{src: src}
+	g := &Grammar{Productions: map[string]*Expr{}}
+	for p.next(); p.tok != ""; {
+		name := p.tok
+		if !isName(name) {
+			return nil, p.errorf("expected a production name, found %s", name)
+		}
+		if g.Start == "" {
+			g.Start = name
+		}
+		if _, ok := g.Productions[name]; ok {
+			return nil, p.errorf("%s is defined twice", name)
+		}
+		p.next()
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("."); err != nil {
+			return nil, err
+		}
+		g.Productions[name] = e
+	}
+	if g.Start == "" {
+		return nil, fmt.Errorf("the grammar has no productions")
+	}
+	return g, nil
+}
+
+type grammarParser struct {
+	src  string
+	pos  int
+	line int
+	tok  string // "" at the end
+}
+
+func (p *grammarParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line+1, fmt.Sprintf(format, a...))
+}
+
+func isName(tok string) bool {
+	return tok != "" && (unicode.IsLetter(rune(tok[0])) || tok[0] == '_')
+}
+
+func (p *grammarParser) next() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		if p.src[p.pos] == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	start := p.pos
+	switch c := p.src[p.pos]; {
+	case c == '"' || c == '\'':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != c {
+			p.pos++
+		}
+		p.pos++
+	case isName(string(c)):
+		for p.pos < len(p.src) && (isName(string(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.tok = p.src[start:min(p.pos, len(p.src))]
+}
+
+func (p *grammarParser) expect(tok string) error {
+	if p.tok != tok {
+		return p.errorf("expected %s, found %q", tok, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+func (p *grammarParser) expr() (*Expr, error) {
+	var alternatives []*Expr
+	for {
+		seq, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, seq)
+		if p.tok != "|" {
+			break
+		}
+		p.next()
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return &Expr{Kind: Alternatives, Items: alternatives}, nil
+}
+
+func (p *grammarParser) sequence() (*Expr, error) {
+	var items []*Expr
+	for {
+		var item *Expr
+		switch tok := p.tok; {
+		case tok == "", tok == "|", tok == ".", tok == ")", tok == "]", tok == "}":
+			if len(items) == 0 {
+				return nil, p.errorf("expected an expression, found %q", tok)
+			}
+			if len(items) == 1 {
+				return items[0], nil
+			}
+			return &Expr{Kind: Sequence, Items: items}, nil
+		case tok[0] == '"' || tok[0] == '\'':
+			if len(tok) < 2 || tok[len(tok)-1] != tok[0] {
+				return nil, p.errorf("unterminated terminal %s", tok)
+			}
+			item = &Expr{Kind: Terminal, Text: tok[1 : len(tok)-1]}
+			p.next()
+		case isName(tok):
+			item = &Expr{Kind: Name, Text: tok}
+			p.next()
+		case tok == "(" || tok == "[" || tok == "{":
+			closing := map[string]string{"(": ")", "[": "]", "{": "}"}[tok]
+			p.next()
+			inner, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(closing); err != nil {
+				return nil, err
+			}
+			switch tok {
+			case "(":
+				item = inner
+			case "[":
+				item = &Expr{Kind: Option, Items: []*Expr{inner}}
+			default:
+				item = &Expr{Kind: Repetition, Items: []*Expr{inner}}
+			}
+		default:
+			return nil, p.errorf("unexpected %q", tok)
+		}
+		items = append(items, item)
+	}
+}
+
+// Generator writes random programs by expanding the productions of a
+// grammar. Choosing at random gives programs that mostly fail at once on
+// a name that is not bound, so a few productions are steered: identifiers
+// are bound by let statements and parameters and used in their scope,
+// calls are mostly of functions bound by let with as many arguments as
+// they take, and literals are drawn from values that test the edges of the
+// language, such as the largest integer. Past MaxDepth nested productions
+// it takes the alternatives that end soonest.
+type Generator struct {
+	Grammar  *Grammar
+	MaxDepth int
+
+	rand  *rand.Rand
+	cost  map[string]int
+	out   []string
+	stack []string // the productions being expanded
+
+	scopes []*genScope
+	lets   []string // the names of the let statements being expanded
+	params []int    // the parameters of the function literals being expanded
+	binds  []string // the bindings of the if expressions being expanded
+	fresh  int
+}
+
+type genScope struct {
+	values []string
+	types  []string
+	funcs  map[string]int // functions bound by let, by how many parameters they take
+}
+
+// generated are the productions the generator writes itself rather than
+// from the grammar, the lexical ones the specification leaves to prose
+var generated = map[string]bool{"identifier": true, "IntegerLiteral": true, "StringLiteral": true}
+
+// operand are the productions whose expressions are operands, where the
+// generator adds parentheses to keep the derivation it chose
+var operand = map[string]bool{"PrefixExpression": true, "InfixExpression": true, "CallExpression": true}
+
+// NewGenerator returns a generator for g seeded with seed. It reports an
+// error if a production the grammar refers to is not defined, leaving out
+// those of the productions it writes itself.
+func NewGenerator(g *Grammar, seed int64) (*Generator, error) {
+	for name, e := range g.Productions {
+		if generated[name] {
+			continue
+		}
+		var err error
+		walk(e, func(e *Expr) {
+			if e.Kind == Name && g.Productions[e.Text] == nil && !generated[e.Text] && err == nil {
+				err = fmt.Errorf("%s refers to %s, which is not defined", name, e.Text)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	gen := &Generator{Grammar: g, MaxDepth: 14, rand: rand.New(rand.NewSource(seed))}
+	gen.costs()
+	return gen, nil
+}
+
+func walk(e *Expr, f func(*Expr)) {
+	f(e)
+	for _, item := range e.Items {
+		walk(item, f)
+	}
+}
+
+// costs works out how many productions deep the shortest expansion of
+// each production goes
+func (gen *Generator) costs() {
+	const infinite = 1 << 20
+	gen.cost = map[string]int{}
+	for name := range gen.Grammar.Productions {
+		gen.cost[name] = infinite
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, e := range gen.Grammar.Productions {
+			if c := 1 + gen.exprCost(e); c < gen.cost[name] {
+				gen.cost[name] = c
+				changed = true
+			}
+		}
+	}
+}
+
+func (gen *Generator) exprCost(e *Expr) int {
+	switch e.Kind {
+	case Name:
+		if generated[e.Text] {
+			return 1
+		}
+		return gen.cost[e.Text]
+	case Sequence:
+		c := 0
+		for _, item := range e.Items {
+			c = max(c, gen.exprCost(item))
+		}
+		return c
+	case Alternatives:
+		c := gen.exprCost(e.Items[0])
+		for _, item := range e.Items[1:] {
+			c = min(c, gen.exprCost(item))
+		}
+		return c
+	}
+	return 0
+}
+
+// Program returns a new random program
+func (gen *Generator) Program() string {
+	gen.out = gen.out[:0]
+	gen.scopes = []*genScope{{funcs: map[string]int{}}}
+	gen.lets, gen.params, gen.binds, gen.stack, gen.fresh = nil, nil, nil, nil, 0
+	gen.expandName(gen.Grammar.Start, 0)
+
+	var out strings.Builder
+	depth := 0
+	for i, tok := range gen.out {
+		if i > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString(" ")
+		}
+		out.WriteString(tok)
+		switch tok {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		case ";":
+			if depth == 0 {
+				out.WriteString("\n")
+			}
+		}
+	}
+	if !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+func (gen *Generator) emit(toks ...string) {
+	gen.out = append(gen.out, toks...)
+}
+
+func (gen *Generator) scope() *genScope {
+	return gen.scopes[len(gen.scopes)-1]
+}
+
+func (gen *Generator) pushScope() {
+	gen.scopes = append(gen.scopes, &genScope{funcs: map[string]int{}})
+}
+
+func (gen *Generator) popScope() {
+	gen.scopes = gen.scopes[:len(gen.scopes)-1]
+}
+
+// parent returns the production that refers to the one being expanded
+func (gen *Generator) parent() string {
+	if len(gen.stack) < 2 {
+		return ""
+	}
+	return gen.stack[len(gen.stack)-2]
+}
+
+func (gen *Generator) pick(choices []string) string {
+	return choices[gen.rand.Intn(len(choices))]
+}
+
+// visible returns the names bound in the scopes around, by kind
+func (gen *Generator) visible() (values, types []string, funcs map[string]int) {
+	funcs = map[string]int{}
+	for _, s := range gen.scopes {
+		values = append(values, s.values...)
+		types = append(types, s.types...)
+		for name, n := range s.funcs {
+			funcs[name] = n
+		}
+	}
+	return values, types, funcs
+}
+
+func (gen *Generator) expandName(name string, depth int) {
+	gen.stack = append(gen.stack, name)
+	defer func() { gen.stack = gen.stack[:len(gen.stack)-1] }()
+
+	switch name {
+	case "identifier":
+		gen.identifier()
+		return
+	case "IntegerLiteral":
+		gen.emit(gen.pick([]string{"0", "1", "2", "3", "7", "10", "42", "9223372036854775807", "4611686018427387904", "100000000000000000000"}))
+		return
+	case "StringLiteral":
+		gen.emit(`"` + gen.pick([]string{"", "a", "ab", "gosling", "hello world"}) + `"`)
+		return
+	case "CallExpression":
+		if _, _, funcs := gen.visible(); len(funcs) > 0 && gen.rand.Intn(4) > 0 {
+			var names []string
+			for f := range funcs {
+				names = append(names, f)
+			}
+			// map order is random, the choice must not be
+			sort.Strings(names)
+			f := gen.pick(names)
+			gen.emit(f, "(")
+			for i := 0; i < funcs[f]; i++ {
+				if i > 0 {
+					gen.emit(",")
+				}
+				gen.expandName("Expression", depth+1)
+			}
+			gen.emit(")")
+			return
+		}
+	case "IfExpression":
+		gen.binds = append(gen.binds, "")
+		defer func() { gen.binds = gen.binds[:len(gen.binds)-1] }()
+	case "FunctionLiteral", "BlockStatement":
+		gen.pushScope()
+		defer gen.popScope()
+		// the binding of an if let is seen in its first block only
+		if name == "BlockStatement" && gen.parent() == "IfExpression" {
+			if bind := gen.binds[len(gen.binds)-1]; bind != "" {
+				gen.scope().values = append(gen.scope().values, bind)
+				gen.binds[len(gen.binds)-1] = ""
+			}
+		}
+	}
+	if name == "FunctionLiteral" {
+		gen.params = append(gen.params, 0)
+		defer func() { gen.params = gen.params[:len(gen.params)-1] }()
+	}
+
+	before, start := len(gen.lets), len(gen.out)
+	gen.expand(gen.Grammar.Productions[name], depth+1)
+	if name == "Expression" && operand[gen.parent()] && len(gen.out)-start > 1 {
+		// the tokens of an operand written out flat could group
+		// differently, a = b of !a = b say, so it is derived through
+		// Primary = "(" Expression ")" instead
+		gen.out = append(gen.out[:start], append([]string{"("}, append(gen.out[start:], ")")...)...)
+	}
+
+	switch name {
+	case "Parameter":
+		if len(gen.params) > 0 && gen.parent() == "ParameterList" {
+			gen.params[len(gen.params)-1]++
+		}
+	case "FunctionLiteral":
+		// a function bound by let can be called by name
+		n := len(gen.stack)
+		if n >= 3 && gen.stack[n-2] == "Expression" && gen.stack[n-3] == "LetStatement" && len(gen.lets) > 0 {
+			gen.scopes[len(gen.scopes)-2].funcs[gen.lets[len(gen.lets)-1]] = gen.params[len(gen.params)-1]
+		}
+	case "LetStatement":
+		if len(gen.lets) > before {
+			let := gen.lets[len(gen.lets)-1]
+			gen.lets = gen.lets[:len(gen.lets)-1]
+			gen.scope().values = append(gen.scope().values, let)
+		}
+	}
+}
+
+func (gen *Generator) identifier() {
+	values, types, _ := gen.visible()
+	switch gen.parent() {
+	case "LetStatement":
+		gen.fresh++
+		name := fmt.Sprintf("v%d", gen.fresh)
+		gen.lets = append(gen.lets, name)
+		gen.emit(name)
+	case "Parameter":
+		gen.fresh++
+		name := fmt.Sprintf("p%d", gen.fresh)
+		if n := len(gen.stack); n >= 3 && gen.stack[n-3] == "IfExpression" {
+			gen.binds[len(gen.binds)-1] = name
+		} else {
+			gen.scope().values = append(gen.scope().values, name)
+		}
+		gen.emit(name)
+	case "TypeParameters":
+		gen.fresh++
+		name := fmt.Sprintf("T%d", gen.fresh)
+		gen.scope().types = append(gen.scope().types, name)
+		gen.emit(name)
+	case "Type":
+		gen.emit(gen.pick(append([]string{"int", "int", "bool", "string", "Option"}, types...)))
+	case "AssignExpression":
+		gen.emit(gen.pick(values))
+	default:
+		if len(values) == 0 || gen.rand.Intn(8) == 0 {
+			gen.emit(gen.pick([]string{"len", "some", "exists"}))
+			return
+		}
+		gen.emit(gen.pick(values))
+	}
+}
+
+func (gen *Generator) expand(e *Expr, depth int) {
+	deep := depth >= gen.MaxDepth
+	switch e.Kind {
+	case Terminal:
+		gen.emit(e.Text)
+	case Name:
+		gen.expandName(e.Text, depth)
+	case Sequence:
+		for _, item := range e.Items {
+			gen.expand(item, depth)
+		}
+	case Alternatives:
+		choices := e.Items
+		if deep {
+			cheapest := gen.exprCost(e)
+			choices = nil
+			for _, item := range e.Items {
+				if gen.exprCost(item) == cheapest {
+					choices = append(choices, item)
+				}
+			}
+		}
+		// an assignment needs a name in scope to assign to
+		if values, _, _ := gen.visible(); len(values) == 0 {
+			var assignable []*Expr
+			for _, item := range choices {
+				if item.Kind != Name || item.Text != "AssignExpression" {
+					assignable = append(assignable, item)
+				}
+			}
+			if len(assignable) > 0 {
+				choices = assignable
+			}
+		}
+		gen.expand(choices[gen.rand.Intn(len(choices))], depth)
+	case Option:
+		if !deep && gen.rand.Intn(2) == 0 {
+			gen.expand(e.Items[0], depth)
+		}
+	case Repetition:
+		n := 0
+		switch {
+		case len(gen.stack) == 1:
+			// the statements of the program
+			n = 2 + gen.rand.Intn(5)
+		case !deep:
+			n = gen.rand.Intn(3)
+		}
+		for i := 0; i < n; i++ {
+			gen.expand(e.Items[0], depth)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package difftest
+
+import (
+	"context"
+	"errors"
+	"gosling/lexer"
+	"gosling/token"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxTests bounds the programs Minimise runs, as each costs a run on two
+// engines and the native ones build a binary for it
+const maxTests = 1000
+
+// Minimise cuts down the program of d to one that still makes the engine
+// of d differ from its reference in the same part of their results, and
+// returns its source. It drops whole statements and bracketed groups, or
+// all but one, or just their brackets, and runs of tokens found by delta
+// debugging, in turn until neither shrinks the program further. The result
+// may read oddly but it parses. The programs it tries are written to dir.
+// If d does not show once the program is reformatted, which may move the
+// location of an error, the program is returned as it is.
+func (h *Harness) Minimise(ctx context.Context, d *Divergence, dir string) (string, error) {
+	source, err := os.ReadFile(d.Path)
+	if err != nil {
+		return "", err
+	}
+	tokens := tokenize(string(source))
+	tests := 0
+	test := func(tokens []token.Token) (bool, error) {
+		tests++
+		path, err := writeProgram(dir, "candidate", render(tokens))
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(path)
+		return h.diverges(ctx, d, path)
+	}
+
+	ok, err := test(tokens)
+	if err != nil || !ok {
+		return string(source), err
+	}
+	for {
+		size := len(tokens)
+		if tokens, err = cutGroups(tokens, test, &tests); err != nil {
+			return "", err
+		}
+		if tokens, err = cutRuns(tokens, test, &tests); err != nil {
+			return "", err
+		}
+		if len(tokens) == size || tests >= maxTests {
+			break
+		}
+	}
+	return render(tokens), nil
+}
+
+// cutGroups removes the cuts of tokens that the divergence survives, one
+// at a time, until none does
+func cutGroups(tokens []token.Token, test func([]token.Token) (bool, error), tests *int) ([]token.Token, error) {
+	for reduced := true; reduced && *tests < maxTests; {
+		reduced = false
+		for _, cut := range cuts(tokens) {
+			if *tests >= maxTests {
+				break
+			}
+			candidate := remove(tokens, cut)
+			ok, err := test(candidate)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				tokens, reduced = candidate, true
+				break
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// cutRuns removes runs of tokens by delta debugging: halves, then
+// quarters and on down to single tokens
+func cutRuns(tokens []token.Token, test func([]token.Token) (bool, error), tests *int) ([]token.Token, error) {
+	n := 2
+	for len(tokens) >= 2 && *tests < maxTests {
+		chunk := (len(tokens) + n - 1) / n
+		reduced := false
+		for start := 0; start < len(tokens) && *tests < maxTests; start += chunk {
+			end := min(start+chunk, len(tokens))
+			candidate := append(append([]token.Token(nil), tokens[:start]...), tokens[end:]...)
+			ok, err := test(candidate)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				tokens, n, reduced = candidate, max(n-1, 2), true
+				break
+			}
+		}
+		if !reduced {
+			if n >= len(tokens) {
+				break
+			}
+			n = min(2*n, len(tokens))
+		}
+	}
+	return tokens, nil
+}
+
+// diverges reports whether the program at path makes the engine of d
+// differ from its reference as d does
+func (h *Harness) diverges(ctx context.Context, d *Divergence, path string) (bool, error) {
+	if parses(path) != nil {
+		return false, nil
+	}
+	var results [2]Result
+	for i, e := range []*Engine{d.Reference, d.Engine} {
+		r, err := h.run(ctx, e, path)
+		if errors.Is(err, ErrUnsupported) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if r.limited() {
+			return false, nil
+		}
+		results[i] = r
+	}
+	return Compare(results[0], results[1]) == d.Field, nil
+}
+
+// cuts returns the sets of tokens worth removing together, largest first:
+// each statement and each bracketed group, all but one of them, and the
+// brackets of each group. Each set is the indices of its tokens, in order.
+func cuts(tokens []token.Token) [][]int {
+	var cuts [][]int
+	span := func(start, end int) []int {
+		var cut []int
+		for i := start; i < end; i++ {
+			cut = append(cut, i)
+		}
+		return cut
+	}
+	// keep cuts all the tokens but those from start to end
+	keep := func(start, end int) {
+		if end > start && end-start < len(tokens) {
+			cuts = append(cuts, append(span(0, start), span(end, len(tokens))...))
+		}
+	}
+	var opens []int
+	start := 0 // of the statement at the current depth
+	var starts []int
+	for i, tok := range tokens {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			opens = append(opens, i)
+			starts = append(starts, start)
+			start = i + 1
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			if len(opens) == 0 {
+				continue
+			}
+			open := opens[len(opens)-1]
+			opens, start, starts = opens[:len(opens)-1], starts[len(starts)-1], starts[:len(starts)-1]
+			cuts = append(cuts, span(open, i+1), []int{open, i})
+			keep(open+1, i)
+		case token.SEMICOLON:
+			cuts = append(cuts, span(start, i+1))
+			keep(start, i)
+			start = i + 1
+		}
+	}
+	// the parser lets the groups open at the end go unclosed
+	for _, open := range opens {
+		keep(open+1, len(tokens))
+	}
+	sort.SliceStable(cuts, func(i, j int) bool { return len(cuts[i]) > len(cuts[j]) })
+	return cuts
+}
+
+// remove returns tokens without those at the indices of cut
+func remove(tokens []token.Token, cut []int) []token.Token {
+	var rest []token.Token
+	for i, tok := range tokens {
+		if len(cut) > 0 && cut[0] == i {
+			cut = cut[1:]
+			continue
+		}
+		rest = append(rest, tok)
+	}
+	return rest
+}
+
+func tokenize(source string) []token.Token {
+	l := lexer.LexRepl(source)
+	var tokens []token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+// render writes tokens back as source, a statement to a line outside
+// braces
+func render(tokens []token.Token) string {
+	var out strings.Builder
+	depth := 0
+	for i, tok := range tokens {
+		if i > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString(" ")
+		}
+		if tok.Type == token.STRING {
+			out.WriteString(`"` + tok.Literal + `"`)
+		} else {
+			out.WriteString(tok.Literal)
+		}
+		switch tok.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			depth--
+		case token.SEMICOLON:
+			if depth == 0 {
+				out.WriteString("\n")
+			}
+		}
+	}
+	if !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	return out.String()
+}
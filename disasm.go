@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// disasm compiles a .gos file and prints its bytecode, one function at a
+// time, without running it
+func disasm(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	passes := optimizeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(errOut, "usage: gosling disasm [--fold] [--dead-code] [--inline] file.gos\n")
+		return 2
+	}
+
+	bytecode, ok := compileFile(fs.Arg(0), *passes, false, errOut)
+	if !ok {
+		return 1
+	}
+	fmt.Fprint(out, bytecode.Disassemble())
+	return 0
+}
@@ -1,22 +1,76 @@
 package evaluator
 
 import (
+	"gosling/diag"
 	"gosling/object"
+	"gosling/token"
+	"sort"
 )
 
 var builtins = map[string]*object.Builtin{
 	"len": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newError(diag.WrongArgumentCount, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return object.NewInteger(int64(len(arg.Value)))
 			default:
-				return newError("argument to `len` not supported, got %s", args[0].Type())
+				return newError(diag.UnsupportedArgument, "argument to `len` not supported, got %s", args[0].Type())
 			}
 		},
 	},
+	"some": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(diag.WrongArgumentCount, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return &object.Option{Value: args[0]}
+		},
+	},
+	// exists reports whether a value is there, false for none and null
+	"exists": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(diag.WrongArgumentCount, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return nativeBoolToBooleanObject(args[0] != NULL && args[0] != NONE)
+		},
+	},
+}
+
+// IsBuiltin reports whether name refers to a builtin function
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// BuiltinNames lists the builtin functions in the fixed order compiled
+// code refers to them by
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupBuiltin returns the named builtin function, or nil
+func LookupBuiltin(name string) *object.Builtin {
+	return builtins[name]
+}
+
+// CallBuiltin calls the builtin fn with args from a call at loc. A builtin
+// does not know where it was called from, so its errors are given loc.
+func CallBuiltin(fn *object.Builtin, args []object.Object, loc token.TokenLocation) object.Object {
+	result := fn.Fn(args...)
+	if err, ok := result.(*object.Error); ok && err.Location == (token.TokenLocation{}) {
+		located := *err
+		located.Location = loc
+		return &located
+	}
+	return result
 }
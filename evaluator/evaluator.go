@@ -1,96 +1,263 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"gosling/ast"
+	"gosling/diag"
 	"gosling/object"
 	"gosling/token"
+	"gosling/typecheck"
+	"gosling/types"
 	"strings"
+	"time"
 )
 
 var (
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 	NULL  = &object.Null{Value: "null"}
+	NONE  = &object.Option{}
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// DefaultMaxDepth is the call depth used when Options.MaxDepth is zero.
+// It keeps runaway recursion well clear of the Go stack limit.
+const DefaultMaxDepth = 10000
+
+// how many steps to take between checks of the context
+const cancelCheckInterval = 1024
+
+// Rough sizes, in bytes, used for memory accounting. They only need to be
+// in the right ballpark for a budget to be useful.
+const (
+	stringSize      = 16 // an object.String, not counting its contents
+	environmentSize = 64 // an object.Environment and its empty map
+	bindingSize     = 32 // one name bound in an environment
+)
+
+// Options bounds how much work a single evaluation may do.
+// The zero value applies DefaultMaxDepth and no other limits.
+type Options struct {
+	MaxDepth int           // maximum nesting of function calls, negative for no limit
+	MaxSteps int           // maximum number of nodes evaluated, 0 for no limit
+	Timeout  time.Duration // wall-clock limit for the whole evaluation, 0 for none
+
+	// MaxMemory caps the approximate number of bytes held by values the
+	// evaluator creates, 0 for no limit
+	MaxMemory int64
+
+	// StrictOverflow makes an integer result that does not fit in 64 bits
+	// an error rather than a big integer
+	StrictOverflow bool
+}
+
+// Evaluator walks the AST, keeping track of the limits in Options.
+// An Evaluator is not safe for concurrent use.
+type Evaluator struct {
+	opts  Options
+	ctx   context.Context
+	depth int
+	steps int
+
+	// Memory is split in two: strings are charged for good since they can
+	// end up anywhere, environments are given back when a call returns
+	// unless the result is a closure that may have captured them
+	strings int64
+	frames  int64
+	peak    int64
+}
+
+func New(opts Options) *Evaluator {
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	return &Evaluator{opts: opts}
+}
+
+// Eval evaluates node in env. Exceeding a limit or cancelling ctx stops
+// evaluation with an *object.Error located at the node being evaluated.
+func Eval(ctx context.Context, node ast.Node, env *object.Environment, opts Options) object.Object {
+	return New(opts).Eval(ctx, node, env)
+}
+
+func (e *Evaluator) Eval(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	if e.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.opts.Timeout)
+		defer cancel()
+	}
+	e.ctx = ctx
+	e.depth = 0
+	e.steps = 0
+	e.strings = 0
+	e.frames = 0
+	e.peak = 0
+	return e.eval(node, env)
+}
+
+// Steps returns how many nodes the last call to Eval evaluated
+func (e *Evaluator) Steps() int {
+	return e.steps
+}
+
+// Memory returns the approximate number of bytes still accounted for at
+// the end of the last call to Eval
+func (e *Evaluator) Memory() int64 {
+	return e.strings + e.frames
+}
+
+// PeakMemory returns the highest value Memory reached during the last
+// call to Eval
+func (e *Evaluator) PeakMemory() int64 {
+	return e.peak
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := e.step(node); err != nil {
+		return err
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.eval(node.Expression, env)
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return integerLiteral(node)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.NoneLiteral:
+		return NONE
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := e.eval(node.Right, env)
+		if stops(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right, node.Token.Location)
+		return e.integerResult(EvalPrefixExpression(node.Operator, right, node.Token.Location), node.Token.Location)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := e.eval(node.Left, env)
+		if stops(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := e.eval(node.Right, env)
+		if stops(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right, node.Token.Location)
+		result := EvalInfixExpression(node.Operator, left, right, node.Token.Location)
+		if str, ok := result.(*object.String); ok {
+			if err := e.charge(&e.strings, stringSize+int64(len(str.Value)), node.Token.Location); err != nil {
+				return err
+			}
+		}
+		return e.integerResult(result, node.Token.Location)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env, outsideBody)
+	case *ast.ForExpression:
+		return e.evalForExpression(node, env, outsideBody)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if node.ReturnValue == nil {
+			return &object.ReturnValue{Value: NULL}
+		}
+		val := e.eval(node.ReturnValue, env)
+		if stops(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := e.eval(node.Value, env)
+		if stops(val) {
 			return val
 		}
+		if node.Name.Type != nil {
+			if err := CheckAnnotation(node.Name.Type, val, "let "+node.Name.Value, node.Name.Location()); err != nil {
+				return err
+			}
+		}
 
-		env.Set(node.Name.Value, val)
+		if err := e.charge(&e.frames, bindingSize, node.Token.Location); err != nil {
+			return err
+		}
+		if node.Name.Resolved && node.Name.Slot >= 0 {
+			env.SetSlot(node.Name.Slot, val)
+		} else {
+			env.Set(node.Name.Value, val)
+		}
+	case *ast.AssignExpression:
+		val := e.eval(node.Value, env)
+		if stops(val) {
+			return val
+		}
+		if !assign(node.Name, val, env) {
+			return object.NewError(diag.IdentifierNotFound, node.Name.Token.Location, "identifier not found: %s", node.Name.Value)
+		}
+		return val
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Parameters: params, ReturnType: node.ReturnType, Body: body, Env: env, Scope: node.Scope}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
+		function := e.eval(node.Function, env)
+		if stops(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := e.evalExpressions(node.Arguments, env)
+		if len(args) == 1 && stops(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args, node.Token.Location)
+		return e.applyFunction(function, args, node.Token.Location)
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return stringLiteral(node)
 	default:
-		return object.NewError("unknown node type", token.TokenLocation{
+		return object.NewError(diag.UnknownNode, token.TokenLocation{
 			Line:     -1,
 			LineCh:   -1,
 			Filename: "",
-		})
+		}, "unknown node type: %T", node)
+	}
+	return nil
+}
+
+// step counts one unit of work against the step budget and, every so
+// often, checks whether the context has been cancelled
+func (e *Evaluator) step(node ast.Node) *object.Error {
+	e.steps++
+	if e.opts.MaxSteps > 0 && e.steps > e.opts.MaxSteps {
+		return object.NewError(diag.StepBudgetExceeded, node.Location(),
+			"evaluation step budget of %d exhausted", e.opts.MaxSteps)
+	}
+	if e.ctx != nil && e.steps%cancelCheckInterval == 0 {
+		if err := e.ctx.Err(); err != nil {
+			return object.NewError(diag.EvaluationCancelled, node.Location(),
+				"evaluation cancelled: %s", err)
+		}
 	}
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+// charge adds size bytes to counter, failing once the total passes MaxMemory
+func (e *Evaluator) charge(counter *int64, size int64, loc token.TokenLocation) *object.Error {
+	*counter += size
+	total := e.strings + e.frames
+	if total > e.peak {
+		e.peak = total
+	}
+	if e.opts.MaxMemory > 0 && total > e.opts.MaxMemory {
+		return object.NewError(diag.MemoryLimitExceeded, loc,
+			"memory budget of %d bytes exceeded", e.opts.MaxMemory)
+	}
+	return nil
+}
+
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		result = e.eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -103,11 +270,11 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = e.eval(statement, env)
 
 		if result != nil {
 			rt := result.Type()
@@ -120,24 +287,86 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment, pos position) object.Object {
+	condition := e.eval(ie.Condition, env)
+	if stops(condition) {
 		return condition
 	}
+	if ie.Binding != nil {
+		return e.evalIfLet(ie, condition, env, pos)
+	}
 
-	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+	if IsTruthy(condition) {
+		return e.evalBlock(ie.Consequence, env, pos)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.evalBlock(ie.Alternative, env, pos)
 	} else {
 		return NULL
 	}
 }
 
-func isTruthy(obj object.Object) bool {
+// evalIfLet runs the consequence with the value of the option bound in a
+// scope of its own. none, like null, runs the alternative.
+func (e *Evaluator) evalIfLet(ie *ast.IfExpression, condition object.Object, env *object.Environment, pos position) object.Object {
+	switch condition := condition.(type) {
+	case *object.Option:
+		if condition.Value == nil {
+			break
+		}
+		if ie.Binding.Type != nil {
+			if err := CheckAnnotation(ie.Binding.Type, condition.Value, "if let "+ie.Binding.Value, ie.Binding.Location()); err != nil {
+				return err
+			}
+		}
+		if err := e.charge(&e.frames, environmentSize+bindingSize, ie.Token.Location); err != nil {
+			return err
+		}
+		var inner *object.Environment
+		if ie.Scope != nil {
+			inner = object.NewSlotEnvironment(env, ie.Scope.Names)
+			inner.SetSlot(ie.Binding.Slot, condition.Value)
+		} else {
+			inner = object.NewEnclosedEnvironment(env)
+			inner.Set(ie.Binding.Value, condition.Value)
+		}
+		return e.evalBlock(ie.Consequence, inner, pos)
+	case *object.Null:
+	default:
+		return object.NewError(diag.TypeMismatch, ie.Condition.Location(), "cannot use %s as an option for if let %s", condition.Type(), ie.Binding.Value)
+	}
+
+	if ie.Alternative != nil {
+		return e.evalBlock(ie.Alternative, env, pos)
+	}
+	return NULL
+}
+
+// evalForExpression runs a loop. Its value is null, so only the return
+// statements of a loop in a function body make tail calls.
+func (e *Evaluator) evalForExpression(fe *ast.ForExpression, env *object.Environment, pos position) object.Object {
+	for {
+		condition := e.eval(fe.Condition, env)
+		if stops(condition) {
+			return condition
+		}
+		if !IsTruthy(condition) {
+			return NULL
+		}
+
+		result := e.evalBlock(fe.Body, env, pos)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+}
+
+// IsTruthy reports whether obj counts as true in a condition
+func IsTruthy(obj object.Object) bool {
 	switch obj {
-	case NULL:
+	case NULL, NONE:
 		return false
 	case TRUE:
 		return true
@@ -148,19 +377,39 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func evalPrefixExpression(operator string, right object.Object, loc token.TokenLocation) object.Object {
+// EvalPrefixExpression applies a prefix operator to an evaluated operand,
+// reporting errors at loc
+func EvalPrefixExpression(operator string, right object.Object, loc token.TokenLocation) object.Object {
+	if option, ok := right.(*object.Option); ok && operator != "!" {
+		return unwrappedOptionError(option, operator, loc)
+	}
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right, loc)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right, loc)
 	default:
-		return object.NewError(fmt.Sprintf("unknown prefix operator: %s", operator), loc)
+		return object.NewError(diag.UnknownPrefixOperator, loc, "unknown prefix operator: %s", operator)
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object, loc token.TokenLocation) object.Object {
+// EvalInfixExpression applies an infix operator to evaluated operands,
+// reporting errors at loc
+func EvalInfixExpression(operator string, left, right object.Object, loc token.TokenLocation) object.Object {
+	leftOption, leftIsOption := left.(*object.Option)
+	rightOption, rightIsOption := right.(*object.Option)
+	if operator != "==" && operator != "!=" {
+		if leftIsOption {
+			return unwrappedOptionError(leftOption, operator, loc)
+		}
+		if rightIsOption {
+			return unwrappedOptionError(rightOption, operator, loc)
+		}
+	}
+
 	switch {
+	case leftIsOption && rightIsOption:
+		return evalOptionInfixExpression(operator, leftOption, rightOption, loc)
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right, loc)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
@@ -172,41 +421,7 @@ func evalInfixExpression(operator string, left, right object.Object, loc token.T
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	default:
-		return object.NewError(fmt.Sprintf("unknown operator: %s %s %s", left.Type(), operator, right.Type()), loc)
-	}
-}
-
-func evalIntegerInfixExpression(operator string, left, right object.Object, loc token.TokenLocation) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-
-	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "/":
-		if rightVal == 0 {
-			return object.NewError("division by zero", loc)
-		}
-		return &object.Integer{Value: leftVal / rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "%":
-		if rightVal == 0 {
-			return object.NewError("modulo by zero", loc)
-		}
-		return &object.Integer{Value: leftVal % rightVal}
-	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
-	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
-	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
-	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
-	default:
-		return object.NewError(fmt.Sprintf("unknown operator: %s", operator), loc)
+		return object.NewError(diag.UnknownOperator, loc, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -220,7 +435,7 @@ func evalBooleanInfixExpression(operator string, left, right object.Object, loc
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return object.NewError(fmt.Sprintf("unknown operator: %s %s %s", object.BOOLEAN_OBJ, operator, object.BOOLEAN_OBJ), loc)
+		return object.NewError(diag.UnknownOperator, loc, "unknown operator: %s %s %s", object.BOOLEAN_OBJ, operator, object.BOOLEAN_OBJ)
 	}
 }
 
@@ -229,54 +444,100 @@ func evalStringInfixExpression(operator string, left, right object.Object, loc t
 	rightVal := right.(*object.String).Value
 	switch operator {
 	case "+":
-		return &object.String{Value: leftVal + rightVal}
+		return object.NewString(leftVal + rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(strings.Compare(leftVal, rightVal) == 0)
 	case "!=":
 		return nativeBoolToBooleanObject(strings.Compare(leftVal, rightVal) != 0)
 	default:
-		return object.NewError(fmt.Sprintf("unknown operator: %s %s %s", object.STRING_OBJ, operator, object.STRING_OBJ), loc)
+		return object.NewError(diag.UnknownOperator, loc, "unknown operator: %s %s %s", object.STRING_OBJ, operator, object.STRING_OBJ)
 	}
 }
 
+// options are equal if both are none or both hold equal values
+func evalOptionInfixExpression(operator string, left, right *object.Option, loc token.TokenLocation) object.Object {
+	if left.Value == nil || right.Value == nil {
+		equal := left.Value == nil && right.Value == nil
+		if operator == "!=" {
+			return nativeBoolToBooleanObject(!equal)
+		}
+		return nativeBoolToBooleanObject(equal)
+	}
+	return EvalInfixExpression(operator, left.Value, right.Value, loc)
+}
+
+func unwrappedOptionError(option *object.Option, operator string, loc token.TokenLocation) *object.Error {
+	if option.Value == nil {
+		return object.NewError(diag.UnwrappedOption, loc, "cannot use none as an operand of %s, check it with if let first", operator)
+	}
+	return object.NewError(diag.UnwrappedOption, loc, "cannot use %s as an operand of %s, unwrap it with if let first", option.Inspect(), operator)
+}
+
 func evalBangOperatorExpression(right object.Object, loc token.TokenLocation) object.Object {
 	switch right {
 	case TRUE:
 		return FALSE
 	case FALSE:
 		return TRUE
-	case NULL:
+	case NULL, NONE:
 		return TRUE
 	default:
-		return object.NewError(fmt.Sprintf("unknown operator: !%s", right.Type()), loc)
+		if _, ok := right.(*object.Option); ok {
+			return FALSE
+		}
+		return object.NewError(diag.UnknownOperator, loc, "unknown operator: !%s", right.Type())
 	}
 }
 
-func evalMinusPrefixOperatorExpression(right object.Object, loc token.TokenLocation) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return object.NewError(fmt.Sprintf("unknown operator: -%s", right.Type()), loc)
+// integerResult checks an operator's result that needed a big integer
+// against StrictOverflow and charges it, like a string, for good
+func (e *Evaluator) integerResult(result object.Object, loc token.TokenLocation) object.Object {
+	b, ok := result.(*object.BigInt)
+	if !ok {
+		return result
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	if e.opts.StrictOverflow {
+		return CheckOverflow(b, loc)
+	}
+	if err := e.charge(&e.strings, bigIntBytes(b), loc); err != nil {
+		return err
+	}
+	return result
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+	if val, ok := lookup(node, env); ok {
 		return val
 	}
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
 
-	return object.NewError(fmt.Sprintf("identifier not found: %s", node.Value), node.Token.Location)
+	return object.NewError(diag.IdentifierNotFound, node.Token.Location, "identifier not found: %s", node.Value)
+}
+
+// lookup finds a name where the resolver placed it, or by name in a
+// program that was not resolved
+func lookup(node *ast.Identifier, env *object.Environment) (object.Object, bool) {
+	if node.Resolved {
+		return env.Lookup(node.Depth, node.Slot, node.Value)
+	}
+	return env.Get(node.Value)
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func assign(node *ast.Identifier, val object.Object, env *object.Environment) bool {
+	if node.Resolved {
+		return env.AssignAt(node.Depth, node.Slot, node.Value, val)
+	}
+	return env.Assign(node.Value, val)
+}
+
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
-	for _, e := range exps {
-		evaluated := Eval(e, env)
-		if isError(evaluated) {
+	for _, exp := range exps {
+		evaluated := e.eval(exp, env)
+		if stops(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -292,21 +553,263 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func applyFunction(fn object.Object, args []object.Object, loc token.TokenLocation) object.Object {
-	switch fn := fn.(type) {
-	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
-	case *object.Builtin:
-		return fn.Fn(args...)
-	default:
-		return object.NewError(fmt.Sprintf("not a function: %s", fn.Type()), loc)
+// stops reports whether obj cuts short the expression it is part of: an
+// error, or the value of a return statement on its way out of the function
+func stops(obj object.Object) bool {
+	return isError(obj) || (obj != nil && obj.Type() == object.RETURN_VALUE_OBJ)
+}
+
+// applyFunction calls fn. A call the function makes in tail position
+// comes back as a tailCall and is made here in turn, so tail recursion
+// runs in constant Go stack. It still counts toward the call depth and
+// the memory budget as a nested call, as on the vm, so a tail call that
+// never ends stops there.
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object, loc token.TokenLocation) object.Object {
+	depth, frames := e.depth, e.frames
+	defer func() { e.depth = depth }()
+	// where the next call starts from, the frame of its caller for a tail
+	// call
+	callDepth, callFrames := depth, frames
+	// result annotations to check once the last call returns, innermost
+	// last. A tail recursive function repeats the same check, kept once.
+	var checks []resultCheck
+
+	for {
+		var result object.Object
+		switch f := fn.(type) {
+		case *object.Function:
+			if err := e.enterFunction(f, args, loc, callDepth, callFrames); err != nil {
+				e.frames = frames
+				return err
+			}
+			if f.ReturnType != nil {
+				check := resultCheck{texp: f.ReturnType, loc: loc}
+				if len(checks) == 0 || checks[len(checks)-1] != check {
+					checks = append(checks, check)
+				}
+			}
+			result = unwrapReturnValue(e.evalTailBlock(f.Body, extendFunctionEnv(f, args), true))
+			if result == nil {
+				result = NULL
+			}
+			if call, ok := result.(*tailCall); ok {
+				fn, args, loc = call.fn, call.args, call.loc
+				callDepth, callFrames = e.depth, e.frames
+				continue
+			}
+		case *object.Builtin:
+			result = CallBuiltin(f, args, loc)
+		default:
+			e.frames = frames
+			return object.NewError(diag.NotAFunction, loc, "not a function: %s", fn.Type())
+		}
+
+		if _, ok := result.(*object.Function); !ok {
+			e.frames = frames
+		}
+		if !isError(result) {
+			for i := len(checks) - 1; i >= 0; i-- {
+				if err := CheckAnnotation(checks[i].texp, result, "the result", checks[i].loc); err != nil {
+					return err
+				}
+			}
+		}
+		return result
+	}
+}
+
+// enterFunction checks a call of fn made at depth, with frames already
+// charged, and charges its frame
+func (e *Evaluator) enterFunction(fn *object.Function, args []object.Object, loc token.TokenLocation, depth int, frames int64) *object.Error {
+	if e.opts.MaxDepth > 0 && depth >= e.opts.MaxDepth {
+		return object.NewError(diag.CallDepthExceeded, loc, "maximum call depth of %d exceeded", e.opts.MaxDepth)
+	}
+	for i, param := range fn.Parameters {
+		if param.Type != nil && i < len(args) {
+			if err := CheckAnnotation(param.Type, args[i], "parameter "+param.Value, loc); err != nil {
+				return err
+			}
+		}
+	}
+	if len(args) < len(fn.Parameters) {
+		return object.NewError(diag.WrongArgumentCount, loc, "wrong number of arguments. got=%d, want=%d", len(args), len(fn.Parameters))
+	}
+	e.frames = frames
+	if err := e.charge(&e.frames, environmentSize+bindingSize*int64(len(args)), loc); err != nil {
+		return err
+	}
+	e.depth = depth + 1
+	return nil
+}
+
+type resultCheck struct {
+	texp ast.TypeExpression
+	loc  token.TokenLocation
+}
+
+// tailCall is what a call in tail position evaluates to: the call still
+// to be made, by applyFunction once the caller's frame is done with. It
+// never leaves applyFunction.
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+	loc  token.TokenLocation
+}
+
+const tailCallObj object.ObjectType = "TAIL_CALL"
+
+func (tc *tailCall) Type() object.ObjectType { return tailCallObj }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalTailBlock evaluates a block of a function body. Return statements
+// in it end the function, and when last is set the block's value is the
+// function's, so the calls they make are tail calls.
+func (e *Evaluator) evalTailBlock(block *ast.BlockStatement, env *object.Environment, last bool) object.Object {
+	if err := e.step(block); err != nil {
+		return err
+	}
+	var result object.Object
+
+	for i, statement := range block.Statements {
+		result = e.evalTailStatement(statement, env, last && i == len(block.Statements)-1)
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func (e *Evaluator) evalTailStatement(stmt ast.Statement, env *object.Environment, last bool) object.Object {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		if err := e.step(stmt); err != nil {
+			return err
+		}
+		if stmt.ReturnValue == nil {
+			return &object.ReturnValue{Value: NULL}
+		}
+		val := e.evalTail(stmt.ReturnValue, env, true)
+		if stops(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+	case *ast.ExpressionStatement:
+		if err := e.step(stmt); err != nil {
+			return err
+		}
+		return e.evalTail(stmt.Expression, env, last)
+	}
+	return e.eval(stmt, env)
+}
+
+// evalTail evaluates an expression of a function body, returning a call
+// whose value would be the function's as a tailCall
+func (e *Evaluator) evalTail(exp ast.Expression, env *object.Environment, last bool) object.Object {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		if !last {
+			break
+		}
+		if err := e.step(exp); err != nil {
+			return err
+		}
+		function := e.eval(exp.Function, env)
+		if stops(function) {
+			return function
+		}
+		args := e.evalExpressions(exp.Arguments, env)
+		if len(args) == 1 && stops(args[0]) {
+			return args[0]
+		}
+		return &tailCall{fn: function, args: args, loc: exp.Token.Location}
+	case *ast.IfExpression:
+		if err := e.step(exp); err != nil {
+			return err
+		}
+		return e.evalIfExpression(exp, env, inBody(last))
+	case *ast.ForExpression:
+		if err := e.step(exp); err != nil {
+			return err
+		}
+		return e.evalForExpression(exp, env, inBody(false))
 	}
+	return e.eval(exp, env)
+}
+
+// position is where a block is evaluated: outside a function body, in
+// one, or in one as the block whose value is the function's
+type position int
 
+const (
+	outsideBody position = iota
+	inBodyPosition
+	tailPosition
+)
+
+func inBody(last bool) position {
+	if last {
+		return tailPosition
+	}
+	return inBodyPosition
+}
+
+// evalBlock evaluates a block of an if or for found at pos. A block that
+// ends without an expression, or is empty, has the value null.
+func (e *Evaluator) evalBlock(block *ast.BlockStatement, env *object.Environment, pos position) object.Object {
+	var result object.Object
+	if pos == outsideBody {
+		result = e.eval(block, env)
+	} else {
+		result = e.evalTailBlock(block, env, pos == tailPosition)
+	}
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// CheckAnnotation returns an error if val does not have the annotated type
+func CheckAnnotation(texp ast.TypeExpression, val object.Object, what string, loc token.TokenLocation) *object.Error {
+	want, err := typecheck.Resolve(texp)
+	if err != nil {
+		return object.NewError(diag.UnknownType, texp.Location(), "%s", err)
+	}
+	if !hasType(val, want) {
+		return object.NewError(diag.TypeMismatch, loc, "cannot use %s as %s for %s", val.Type(), want, what)
+	}
+	return nil
+}
+
+func hasType(val object.Object, want types.Type) bool {
+	switch want := want.(type) {
+	case *types.DynamicType:
+		return true
+	case *types.OptionType:
+		option, ok := val.(*object.Option)
+		return ok && (option.Value == nil || hasType(option.Value, want.ValueType))
+	case *types.FunctionType:
+		switch val.(type) {
+		case *object.Function, *object.CompiledFunction, *object.Closure, *object.Builtin:
+			return typecheck.TypeOf(val).IsAssignableTo(want)
+		}
+		return false
+	}
+	return typecheck.TypeOf(val).IsType(want)
 }
 
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+	if fn.Scope != nil {
+		env := object.NewSlotEnvironment(fn.Env, fn.Scope.Names)
+		for paramIdx, param := range fn.Parameters {
+			env.SetSlot(param.Slot, args[paramIdx])
+		}
+		return env
+	}
 	env := object.NewEnclosedEnvironment(fn.Env)
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -321,6 +824,28 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+// integerLiteral returns the value of node, the one resolve.Program made
+// for it as the compiler makes a constant. A literal that was not resolved
+// gets a new value each time.
+func integerLiteral(node *ast.IntegerLiteral) object.Object {
+	if obj, ok := node.Object.(object.Object); ok {
+		return obj
+	}
+	if node.Big != nil {
+		return &object.BigInt{Value: node.Big}
+	}
+	return object.NewInteger(node.Value)
+}
+
+// stringLiteral returns the value of node, made like that of an integer
+// literal
+func stringLiteral(node *ast.StringLiteral) object.Object {
+	if obj, ok := node.Object.(object.Object); ok {
+		return obj
+	}
+	return object.NewString(node.Value)
+}
+
 // rather than creating a new instance of the boolean object
 // just evaluate it and return a predefined var from startup time
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -331,6 +856,6 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return FALSE
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+func newError(code diag.Code, format string, a ...interface{}) *object.Error {
+	return &object.Error{Code: code, Message: fmt.Sprintf(format, a...)}
 }
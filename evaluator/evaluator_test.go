@@ -1,11 +1,17 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"gosling/diag"
 	"gosling/lexer"
 	"gosling/object"
 	"gosling/parser"
+	"gosling/resolve"
+	"math/big"
+	"runtime/debug"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -43,9 +49,10 @@ func testEval(input string) object.Object {
 	p := parser.New(l)
 
 	program := p.ParseProgram()
+	resolve.Program(program)
 	env := object.NewEnvironment()
 
-	return Eval(program, env)
+	return Eval(context.Background(), program, env, Options{})
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -140,7 +147,7 @@ func TestBangOperatorError(t *testing.T) {
 		input    string
 		expected string
 	}{
-		{"!5", fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 0, "unknown operator: !INTEGER")},
+		{"!5", fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 2, diag.UnknownOperator, "unknown operator: !INTEGER")},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -188,11 +195,22 @@ func TestReturnStatements(t *testing.T) {
 		{"return 2 * 5; 9;", 10},
 		{"9; return 2 * 5; 9;", 10},
 		{"if (10 > 1) { return 10; }", 10},
+		{"let x = if (true) { return 7; } else { 1 }; x + 1", 7},
+		{"let f = fn() { let x = if (true) { return 7; } else { 1 }; x + 1 }; f()", 7},
+		{"let f = fn() { -(if (true) { return 7; }) }; f()", 7},
+		{"let f = fn(x) { x }; let g = fn() { f(if (true) { return 7; }) + 1 }; g()", 7},
+		{"let f = fn() { if (for (true) { return 7; }) { 1 } }; f()", 7},
+		{"let f = fn() { if let x = (if (true) { return 7; }) { 1 } }; f()", 7},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
+
+	for _, input := range []string{"return; 9;", "let f = fn() { return; 1 }; f()", "let f = fn() { if (true) { return } 1 }; f()",
+		"if (true) { }", "if (true) { let x = 1; }", "let f = fn() { }; f()", "let f = fn() { let x = 1; }; f()"} {
+		testEmptyObject(t, testEval(input))
+	}
 }
 
 func TestErrorHandling(t *testing.T) {
@@ -202,35 +220,55 @@ func TestErrorHandling(t *testing.T) {
 	}{
 		{
 			"5 + true;",
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 4, "unknown operator: INTEGER + BOOLEAN"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 4, diag.UnknownOperator, "unknown operator: INTEGER + BOOLEAN"),
 		},
 		{
 			"5 + true; 5;",
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 4, "unknown operator: INTEGER + BOOLEAN"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 4, diag.UnknownOperator, "unknown operator: INTEGER + BOOLEAN"),
+		},
+		{
+			`len(1)`,
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 5, diag.UnsupportedArgument, "argument to `len` not supported, got INTEGER"),
+		},
+		{
+			"let x = 1;\nsome(1, 2)",
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 1, 5, diag.WrongArgumentCount, "wrong number of arguments. got=2, want=1"),
+		},
+		{
+			"1 + exists()",
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 12, diag.WrongArgumentCount, "wrong number of arguments. got=0, want=1"),
+		},
+		{
+			"-(if (true) { })",
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 2, diag.UnknownOperator, "unknown operator: -NULL"),
+		},
+		{
+			"let f = fn() { let x = 1; }; -f()",
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 31, diag.UnknownOperator, "unknown operator: -NULL"),
 		},
 		{
 			"-true",
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 2, "unknown operator: -BOOLEAN"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 2, diag.UnknownOperator, "unknown operator: -BOOLEAN"),
 		},
 		{
 			"true + false;",
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 7, "unknown operator: BOOLEAN + BOOLEAN"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 7, diag.UnknownOperator, "unknown operator: BOOLEAN + BOOLEAN"),
 		},
 		{
 			"5; true + false; 5",
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 10, "unknown operator: BOOLEAN + BOOLEAN"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 10, diag.UnknownOperator, "unknown operator: BOOLEAN + BOOLEAN"),
 		},
 		{
 			"if (10 > 1) { true + false; }",
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 21, "unknown operator: BOOLEAN + BOOLEAN"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 21, diag.UnknownOperator, "unknown operator: BOOLEAN + BOOLEAN"),
 		},
 		{
 			"foobar",
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 0, "identifier not found: foobar"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 2, diag.IdentifierNotFound, "identifier not found: foobar"),
 		},
 		{
 			`"Hello" - "World"`,
-			fmt.Sprintf("file: %s line: %d char: %d %s", "", 0, 10, "unknown operator: STRING - STRING"),
+			fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 10, diag.UnknownOperator, "unknown operator: STRING - STRING"),
 		},
 	}
 
@@ -400,3 +438,474 @@ func TestBuiltinFunctions(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected diag.Code
+	}{
+		{"5 + true;", diag.UnknownOperator},
+		{"-true", diag.UnknownOperator},
+		{"10 / 0", diag.DivisionByZero},
+		{"10 % 0", diag.ModuloByZero},
+		{"foobar", diag.IdentifierNotFound},
+		{"let x = 5; x(1);", diag.NotAFunction},
+		{`len("one", "two")`, diag.WrongArgumentCount},
+		{"let f = fn(a, b) { a }; f(1);", diag.WrongArgumentCount},
+		{`len(1)`, diag.UnsupportedArgument},
+		{"none + 1", diag.UnwrappedOption},
+		{"if let x = 5 { x }", diag.TypeMismatch},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q, got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Code != tt.expected {
+			t.Errorf("wrong error code for %q, expected=%s, got=%s",
+				tt.input, tt.expected, errObj.Code)
+		}
+	}
+}
+
+func TestForExpressions(t *testing.T) {
+	testEmptyObject(t, testEval("for (false) { 10 }"))
+	testIntegerObject(t, testEval("let f = fn() { for (true) { return 5; } }; f();"), 5)
+	testErrorObject(t, testEval("for (true) { 5 + true; }"),
+		fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 17, diag.UnknownOperator, "unknown operator: INTEGER + BOOLEAN"))
+}
+
+func TestEvaluationLimits(t *testing.T) {
+	countdown := "let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(10);"
+	// the recursive call is not a tail call
+	nestedCountdown := "let f = fn(n) { if (n > 0) { f(n - 1) * 2 } else { 0 } }; f(10);"
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		opts     Options
+		expected diag.Code
+	}{
+		{"let f = fn(n) { f(n) }; f(1);", context.Background(), Options{}, diag.CallDepthExceeded},
+		{countdown, context.Background(), Options{MaxDepth: 5}, diag.CallDepthExceeded},
+		{countdown, context.Background(), Options{MaxDepth: 11}, ""},
+		// tail calls count toward the depth as nested calls do
+		{"let f = fn(n) { return f(n); }; f(1);", context.Background(), Options{}, diag.CallDepthExceeded},
+		{"let f = fn(n) { g(n) }; let g = fn(n) { f(n) }; f(1);", context.Background(), Options{}, diag.CallDepthExceeded},
+		{"let f = fn(n) { f(n) + 1 }; f(1);", context.Background(), Options{}, diag.CallDepthExceeded},
+		{nestedCountdown, context.Background(), Options{MaxDepth: 5}, diag.CallDepthExceeded},
+		{nestedCountdown, context.Background(), Options{MaxDepth: 11}, ""},
+		{"let f = fn(n) { f(n) }; f(1);", context.Background(), Options{MaxDepth: -1, MaxSteps: 10000}, diag.StepBudgetExceeded},
+		{"for (true) { }", context.Background(), Options{MaxSteps: 1000}, diag.StepBudgetExceeded},
+		{countdown, context.Background(), Options{MaxSteps: 1000}, ""},
+		{"for (true) { }", context.Background(), Options{Timeout: 10 * time.Millisecond}, diag.EvaluationCancelled},
+		{"for (true) { }", cancelled, Options{}, diag.EvaluationCancelled},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		evaluated := Eval(tt.ctx, program, object.NewEnvironment(), tt.opts)
+		if tt.expected == "" {
+			testIntegerObject(t, evaluated, 0)
+			continue
+		}
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q with %+v, got=%T(%+v)",
+				tt.input, tt.opts, evaluated, evaluated)
+			continue
+		}
+		if errObj.Code != tt.expected {
+			t.Errorf("wrong error code for %q with %+v, expected=%s, got=%s",
+				tt.input, tt.opts, tt.expected, errObj.Code)
+		}
+		if errObj.Location.LineCh == 0 {
+			t.Errorf("error for %q is not located: %s", tt.input, errObj.Inspect())
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// a tail call still counts toward the call depth, so these run with no
+	// limit on it
+	unlimited := Options{MaxDepth: -1}
+	tests := []struct {
+		input    string
+		opts     Options
+		expected interface{}
+	}{
+		{"let loop = fn(i, acc) { if (i == 0) { return acc; } return loop(i - 1, acc + i); }; loop(1000000, 0);", unlimited, 500000500000},
+		{"let loop = fn(i) { if (i > 0) { loop(i - 1) } else { 7 } }; loop(100000);", unlimited, 7},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(100001);", unlimited, false},
+		{"let loop = fn(o, n) { if let v = o { if (v == 0) { n } else { loop(some(v - 1), n + 1) } } else { -1 } }; loop(some(50000), 0);", unlimited, 50000},
+		{"let loop = fn(i) { for (true) { return if (i == 0) { 3 } else { loop(i - 1) }; } }; loop(50000);", unlimited, 3},
+		{`let f = fn(s) { len(s) }; f("four");`, Options{}, 4},
+		{"let loop = fn(i) { if (i > 0) { loop(i - 1) } else { 7 } }; loop(9999);", Options{}, 7},
+		{"let loop = fn(i) { if (i > 0) { loop(i - 1) } else { 7 } }; loop(10000);", Options{}, "maximum call depth of 10000 exceeded"},
+		{"let f = fn(i) { if (i == 0) { 0 } else { f(i - 1) + 1 } }; f(20000);", Options{}, "maximum call depth of 10000 exceeded"},
+		{"let f = fn(i) { let r = f(i - 1); r }; f(20000);", Options{}, "maximum call depth of 10000 exceeded"},
+		{"let f = fn(i) -> int { if (i == 0) { true } else { f(i - 1) } }; f(100000);", unlimited, "cannot use BOOLEAN as int for the result"},
+		{"let g = fn() -> string { 1 }; let f = fn(i) { if (i == 0) { g() } else { f(i - 1) } }; f(100000);", unlimited, "cannot use INTEGER as string for the result"},
+		{"let f = fn() { 5(1) }; f();", Options{}, "not a function: INTEGER"},
+	}
+
+	// a tail call does not grow the Go stack, so the million calls above
+	// fit in far less of it than as many nested calls would need
+	defer debug.SetMaxStack(debug.SetMaxStack(64 << 20))
+
+	for _, tt := range tests {
+		e := New(tt.opts)
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.Eval(context.Background(), program, object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong result for %q. want error %q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 1; a = a + 1; a;", 2},
+		{"let a = 1; a = 5;", 5},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let i = 0; let sum = 0; for (i < 10) { i = i + 1; sum = sum + i; } sum;", 55},
+		{"let a = 1; let f = fn() { a = 10; }; f(); a;", 10},
+		{"let a = 1; let f = fn(a) { a = 10; }; f(2); a;", 1},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	testErrorObject(t, testEval("b = 5;"),
+		fmt.Sprintf("file: %s line: %d char: %d [%s] %s", "", 0, 2, diag.IdentifierNotFound, "identifier not found: b"))
+}
+
+func TestMemoryLimits(t *testing.T) {
+	tests := []struct {
+		input     string
+		maxMemory int64
+		expected  diag.Code
+	}{
+		{`let s = "ab"; for (true) { s = s + s; }`, 1 << 20, diag.MemoryLimitExceeded},
+		{"let n = 2; for (true) { n = n * n; }", 1 << 20, diag.MemoryLimitExceeded},
+		{`let s = ""; let i = 0; for (i < 100) { s = s + "x"; i = i + 1; } len(s);`, 1 << 20, ""},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(100000);", 1 << 20, diag.MemoryLimitExceeded},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) * 2 } else { 0 } }; f(100000);", 1 << 20, diag.MemoryLimitExceeded},
+		// frames are given back on return, so repeating a call does not add up
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; let i = 0; for (i < 1000) { f(10); i = i + 1; } f(0);", 1 << 12, ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		e := New(Options{MaxMemory: tt.maxMemory, MaxDepth: -1})
+		evaluated := e.Eval(context.Background(), program, object.NewEnvironment())
+		if e.PeakMemory() <= 0 {
+			t.Errorf("no memory accounted for %q", tt.input)
+		}
+		if tt.expected == "" {
+			if isError(evaluated) {
+				t.Errorf("unexpected error for %q: %s", tt.input, evaluated.Inspect())
+			}
+			if e.PeakMemory() > tt.maxMemory {
+				t.Errorf("peak memory for %q over budget. got=%d", tt.input, e.PeakMemory())
+			}
+			continue
+		}
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q, got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Code != tt.expected {
+			t.Errorf("wrong error code for %q, expected=%s, got=%s",
+				tt.input, tt.expected, errObj.Code)
+		}
+		if e.PeakMemory() <= tt.maxMemory {
+			t.Errorf("peak memory for %q should pass the budget. got=%d", tt.input, e.PeakMemory())
+		}
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{} // int64 result or the error message
+	}{
+		{"let x: int = 5; x;", int64(5)},
+		{"let f = fn(a: int, b) -> int { a + b }; f(1, 2);", int64(3)},
+		{"let f = fn(a: dynamic) { a }; f(1);", int64(1)},
+		{"let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) }; apply(fn(n) { n * 2 }, 4);", int64(8)},
+		{`let f: fn(string) -> int = len; f("abc");`, int64(3)},
+		{`let x: int = "a";`, "cannot use STRING as int for let x"},
+		{`let f = fn(a: int) { a }; f("a");`, "cannot use STRING as int for parameter a"},
+		{`let f = fn(a) -> string { a }; f(1);`, "cannot use INTEGER as string for the result"},
+		{`let f = fn() -> int { if (false) { 1 } }; f();`, "cannot use NULL as int for the result"},
+		{"let apply = fn(f: fn(int) -> int) { f(1) }; apply(5);", "cannot use INTEGER as fn(int) -> int for parameter f"},
+		{"let g = fn(s: string) { s }; let apply = fn(f: fn(int)) { f(1) }; apply(g);", "cannot use FUNCTION as fn(int) -> dynamic for parameter f"},
+		{"let x: integer = 5;", "unknown type: integer"},
+		{"let id = fn<T>(x: T) -> T { x }; id(5);", int64(5)},
+		{"let f = fn<T>(x: T) { x + 1 }; f(1);", int64(2)},
+		{"let f = fn<T>(xs: [T]) { xs }; f(1);", "cannot use INTEGER as [dynamic] for parameter xs"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int64:
+			testIntegerObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message for %q. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // what the result inspects as, or the error message
+	}{
+		{"some(5)", "some(5)"},
+		{"none", "none"},
+		{"some(none)", "some(none)"},
+		{"exists(some(1))", "true"},
+		{"exists(none)", "false"},
+		{"exists(1)", "true"},
+		{"exists(if (false) { 1 })", "false"},
+		{"if let x = some(5) { x * 2 }", "10"},
+		{"if let x = none { x } else { 0 }", "0"},
+		{"if let x = none { x }", "null"},
+		{"if let x = if (false) { 1 } { x } else { 0 }", "0"},
+		{"let x = 1; if let x = some(2) { x }; x", "1"},
+		{"let find = fn(n) { if (n > 0) { some(n) } else { none } }; if let v = find(3) { v } else { 0 }", "3"},
+		{"if (some(false)) { 1 } else { 2 }", "1"},
+		{"if (none) { 1 } else { 2 }", "2"},
+		{"!none", "true"},
+		{"!some(1)", "false"},
+		{"none == none", "true"},
+		{"some(1) == some(1)", "true"},
+		{"some(1) != some(2)", "true"},
+		{"some(1) == none", "false"},
+		{"if let x: int = some(1) { x }", "1"},
+		{`if let x: int = some("a") { x }`, "cannot use STRING as int for if let x"},
+		{"let x: Option<int> = none; x", "none"},
+		{"let x: Option<int> = some(1); x", "some(1)"},
+		{`let x: Option<int> = some("a"); x`, "cannot use OPTION as Option<int> for let x"},
+		{"none + 1", "cannot use none as an operand of +, check it with if let first"},
+		{"1 * some(2)", "cannot use some(2) as an operand of *, unwrap it with if let first"},
+		{"-none", "cannot use none as an operand of -, check it with if let first"},
+		{"if let x = 5 { x }", "cannot use INTEGER as an option for if let x"},
+		{"some(1, 2)", "wrong number of arguments. got=2, want=1"},
+		{"exists()", "wrong number of arguments. got=0, want=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // what the result inspects as, or the error message
+		big      bool   // whether the result should be held as a big integer
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808", true},
+		{"-9223372036854775807 - 2", "-9223372036854775809", true},
+		{"4294967296 * 4294967296", "18446744073709551616", true},
+		{"-1 * -9223372036854775808", "9223372036854775808", true},
+		{"-9223372036854775808 * -1", "9223372036854775808", true},
+		{"-9223372036854775808 / -1", "9223372036854775808", true},
+		{"-9223372036854775808 % -1", "0", false},
+		{"-(-9223372036854775808)", "9223372036854775808", true},
+		{"-9223372036854775808", "-9223372036854775808", false},
+		{"99999999999999999999", "99999999999999999999", true},
+		{"99999999999999999999 - 99999999999999999998", "1", false},
+		{"(9223372036854775807 + 1) - 1", "9223372036854775807", false},
+		{"-99999999999999999999 / 7", "-14285714285714285714", true},
+		{"-99999999999999999999 % 7", "-1", false},
+		{"99999999999999999999 / 0", "division by zero", false},
+		{"99999999999999999999 % 0", "modulo by zero", false},
+		{"99999999999999999999 > 5", "true", false},
+		{"5 < -99999999999999999999", "false", false},
+		{"9223372036854775807 + 1 == 9223372036854775808", "true", false},
+		{"9223372036854775807 + 1 != 9223372036854775807", "true", false},
+		{"99999999999999999999 == true", "false", false},
+		{"99999999999999999999 + true", "unknown operator: INTEGER + BOOLEAN", false},
+		{"let x: int = 99999999999999999999; x", "99999999999999999999", true},
+		{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)", "15511210043330985984000000", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+		if _, isBig := evaluated.(*object.BigInt); isBig != tt.big {
+			t.Errorf("wrong representation for %q. want big=%t, got=%T", tt.input, tt.big, evaluated)
+		}
+	}
+}
+
+func TestStrictOverflow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // what the result inspects as, or the error message
+	}{
+		{"9223372036854775807 + 1", "integer overflow: 9223372036854775808 does not fit in 64 bits"},
+		{"let x = 3037000500; x * x", "integer overflow: 9223372037000250000 does not fit in 64 bits"},
+		{"-(-9223372036854775808)", "integer overflow: 9223372036854775808 does not fit in 64 bits"},
+		{"-9223372036854775808", "-9223372036854775808"},
+		{"9223372036854775807 + 0", "9223372036854775807"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(context.Background(), program, object.NewEnvironment(), Options{StrictOverflow: true})
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+			if errObj.Code != diag.IntegerOverflow || errObj.Location.LineCh == 0 {
+				t.Errorf("wrong error for %q: %s", tt.input, errObj.Inspect())
+			}
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestIntegerHashKeys(t *testing.T) {
+	small := &object.Integer{Value: 42}
+	large := &object.BigInt{Value: big.NewInt(42)}
+	if small.HashKey() != large.HashKey() {
+		t.Errorf("equal integers have different hash keys. small=%+v, big=%+v", small.HashKey(), large.HashKey())
+	}
+
+	a := testEval("99999999999999999999 + 1").(object.Hashable)
+	b := testEval("100000000000000000000").(object.Hashable)
+	c := testEval("-100000000000000000000").(object.Hashable)
+	if a.HashKey() != b.HashKey() {
+		t.Errorf("equal big integers have different hash keys")
+	}
+	if a.HashKey() == c.HashKey() {
+		t.Errorf("big integers of opposite signs have the same hash key")
+	}
+	if small.HashKey() == (&object.String{Value: "42"}).HashKey() {
+		t.Errorf("an integer and a string have the same hash key")
+	}
+}
+
+func TestSharedValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		shared   bool
+	}{
+		{"-129", -129, false},
+		{"-128", -128, true},
+		{"0", 0, true},
+		{"1000 + 24", 1024, true},
+		{"1000 + 25", 1025, false},
+		{"-(2 * 64)", -128, true},
+		{"-(3 * 43)", -129, false},
+		{`len("gosling")`, 7, true},
+		{"10 % 3", 1, true},
+	}
+
+	for _, tt := range tests {
+		first, second := testEval(tt.input), testEval(tt.input)
+		if !testIntegerObject(t, first, tt.expected) {
+			continue
+		}
+		if shared := first == second; shared != tt.shared {
+			t.Errorf("%q evaluates to the same object twice: %t, want %t", tt.input, shared, tt.shared)
+		}
+	}
+
+	// a literal is made once however often it is evaluated, and every
+	// empty string is the same
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New(`let f = fn() { 2000 }; let g = fn() { "gosling" }; let a = f(); let b = f(); let c = g(); let d = g(); let e = ""; let h = "" + "";`)).ParseProgram()
+	resolve.Program(program)
+	if result := Eval(context.Background(), program, env, Options{}); result != nil && result.Type() == object.ERROR_OBJ {
+		t.Fatal(result.Inspect())
+	}
+	for _, pair := range [][2]string{{"a", "b"}, {"c", "d"}, {"e", "h"}} {
+		x, _ := env.Get(pair[0])
+		y, _ := env.Get(pair[1])
+		if x == nil || x != y {
+			t.Errorf("%s and %s are different objects: %v and %v", pair[0], pair[1], x, y)
+		}
+	}
+}
+
+// allocationPrograms are loops of the kind that allocate most: counters,
+// small sums and strings built up from nothing
+var allocationPrograms = []struct {
+	name  string
+	input string
+}{
+	{"count", "let i = 0; for (i < 1000) { i = i + 1; } i"},
+	{"nested", "let n = 0; let i = 0; for (i < 30) { let j = 0; for (j < 30) { n = n + j % 7; j = j + 1; } i = i + 1; } n"},
+	{"sum", "let sum = 0; let i = 0; for (i < 1000) { sum = sum + i * 3; i = i + 1; } sum"},
+	{"fib", "let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)"},
+	{"strings", `let s = ""; let i = 0; for (i < 100) { s = s + "" + "ab"; i = i + 1; } len(s)`},
+}
+
+func BenchmarkAllocations(b *testing.B) {
+	for _, tt := range allocationPrograms {
+		b.Run(tt.name, func(b *testing.B) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			resolve.Program(program)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Eval(context.Background(), program, object.NewEnvironment(), Options{})
+			}
+		})
+	}
+}
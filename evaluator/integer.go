@@ -0,0 +1,160 @@
+package evaluator
+
+import (
+	"gosling/diag"
+	"gosling/object"
+	"gosling/token"
+	"math"
+	"math/big"
+)
+
+// bigIntSize is the rough size of an object.BigInt, not counting its digits
+const bigIntSize = 32
+
+// AddInt returns a + b and whether it fit in an int64
+func AddInt(a, b int64) (int64, bool) {
+	sum := a + b
+	return sum, (a^sum)&(b^sum) >= 0
+}
+
+// SubInt returns a - b and whether it fit in an int64
+func SubInt(a, b int64) (int64, bool) {
+	diff := a - b
+	return diff, (a^b)&(a^diff) >= 0
+}
+
+// MulInt returns a * b and whether it fit in an int64
+func MulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	product := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return product, false
+	}
+	return product, product/b == a
+}
+
+// CheckOverflow reports an integer that did not fit in 64 bits as an
+// overflow, for the strict mode of Options
+func CheckOverflow(result object.Object, loc token.TokenLocation) *object.Error {
+	if _, ok := result.(*object.BigInt); ok {
+		return object.NewError(diag.IntegerOverflow, loc, "integer overflow: %s does not fit in 64 bits", result.Inspect())
+	}
+	return nil
+}
+
+// bigIntBytes is what a big integer is charged against MaxMemory
+func bigIntBytes(b *object.BigInt) int64 {
+	return bigIntSize + int64(len(b.Value.Bits()))*8
+}
+
+// integerFrom returns v as an Integer when it fits in one
+func integerFrom(v *big.Int) object.Object {
+	if v.IsInt64() {
+		return object.NewInteger(v.Int64())
+	}
+	return &object.BigInt{Value: v}
+}
+
+func toBig(obj object.Object) *big.Int {
+	if b, ok := obj.(*object.BigInt); ok {
+		return b.Value
+	}
+	return big.NewInt(obj.(*object.Integer).Value)
+}
+
+func evalIntegerInfixExpression(operator string, left, right object.Object, loc token.TokenLocation) object.Object {
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if !lok || !rok {
+		return evalBigIntInfixExpression(operator, toBig(left), toBig(right), loc)
+	}
+	leftVal, rightVal := l.Value, r.Value
+
+	switch operator {
+	case "+":
+		if sum, ok := AddInt(leftVal, rightVal); ok {
+			return object.NewInteger(sum)
+		}
+	case "-":
+		if diff, ok := SubInt(leftVal, rightVal); ok {
+			return object.NewInteger(diff)
+		}
+	case "/":
+		if rightVal == 0 {
+			return object.NewError(diag.DivisionByZero, loc, "division by zero")
+		}
+		if leftVal != math.MinInt64 || rightVal != -1 {
+			return object.NewInteger(leftVal / rightVal)
+		}
+	case "*":
+		if product, ok := MulInt(leftVal, rightVal); ok {
+			return object.NewInteger(product)
+		}
+	case "%":
+		if rightVal == 0 {
+			return object.NewError(diag.ModuloByZero, loc, "modulo by zero")
+		}
+		return object.NewInteger(leftVal % rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return object.NewError(diag.UnknownOperator, loc, "unknown operator: %s", operator)
+	}
+	// the result overflowed
+	return evalBigIntInfixExpression(operator, big.NewInt(leftVal), big.NewInt(rightVal), loc)
+}
+
+// evalBigIntInfixExpression works like evalIntegerInfixExpression on
+// integers of any size, rounding divisions towards zero as int64 does
+func evalBigIntInfixExpression(operator string, left, right *big.Int, loc token.TokenLocation) object.Object {
+	switch operator {
+	case "+":
+		return integerFrom(new(big.Int).Add(left, right))
+	case "-":
+		return integerFrom(new(big.Int).Sub(left, right))
+	case "/":
+		if right.Sign() == 0 {
+			return object.NewError(diag.DivisionByZero, loc, "division by zero")
+		}
+		return integerFrom(new(big.Int).Quo(left, right))
+	case "*":
+		return integerFrom(new(big.Int).Mul(left, right))
+	case "%":
+		if right.Sign() == 0 {
+			return object.NewError(diag.ModuloByZero, loc, "modulo by zero")
+		}
+		return integerFrom(new(big.Int).Rem(left, right))
+	case "<":
+		return nativeBoolToBooleanObject(left.Cmp(right) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(left.Cmp(right) > 0)
+	case "==":
+		return nativeBoolToBooleanObject(left.Cmp(right) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(left.Cmp(right) != 0)
+	default:
+		return object.NewError(diag.UnknownOperator, loc, "unknown operator: %s", operator)
+	}
+}
+
+func evalMinusPrefixOperatorExpression(right object.Object, loc token.TokenLocation) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			return integerFrom(new(big.Int).Neg(big.NewInt(right.Value)))
+		}
+		return object.NewInteger(-right.Value)
+	case *object.BigInt:
+		return integerFrom(new(big.Int).Neg(right.Value))
+	default:
+		return object.NewError(diag.UnknownOperator, loc, "unknown operator: -%s", right.Type())
+	}
+}
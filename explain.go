@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"gosling/diag"
+	"io"
+	"strings"
+)
+
+// explain prints the long-form description of a diagnostic code,
+// or lists every code when called without arguments
+func explain(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 {
+		for _, def := range diag.Definitions() {
+			fmt.Fprintf(out, "%s %-24s %s\n", def.Code, def.Name, def.Summary)
+		}
+		return 0
+	}
+
+	status := 0
+	for i, arg := range args {
+		def, err := diag.Lookup(arg)
+		if err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			status = 1
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "%s %s: %s\n\n", def.Code, def.Name, def.Summary)
+		fmt.Fprintf(out, "%s\n\n", def.Explanation)
+		fmt.Fprintf(out, "Example:\n\n    %s\n", strings.ReplaceAll(def.Example, "\n", "\n    "))
+	}
+	return status
+}
@@ -2,6 +2,9 @@ module gosling
 
 go 1.24.1
 
-require golang.org/x/term v0.32.0
+require (
+	github.com/tetratelabs/wazero v1.9.0
+	golang.org/x/term v0.32.0
+)
 
 require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,523 @@
+// Package golang translates a program to the source of a Go main package
+// that runs it, on the runtime in gosling/golang/gort.
+//
+// Every environment the evaluator would make, the program's, a call's or
+// an if let's, becomes a Go block declaring a variable for each name bound
+// in it, so closures become Go closures over the variables of the calls
+// they were made in. A name is read from the first of the variables of the
+// enclosing blocks that is bound, as the evaluator reads it from the first
+// environment that binds it. Expressions turn into calls of the runtime,
+// and ifs and loops into Go ifs and loops. Each statement is preceded by a
+// comment quoting the line of the source it came from.
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/object"
+	"gosling/token"
+	"gosling/typecheck"
+	"gosling/types"
+	"strconv"
+	"strings"
+)
+
+// Compile translates program, parsed from source read from filename. Its
+// errors are located in filename, so it should be named as it would be
+// to gosling run.
+func Compile(program *ast.Program, filename string, source []byte) ([]byte, []diag.Diagnostic) {
+	t := &transpiler{lines: strings.Split(string(source), "\n"), commented: -1}
+	body := t.scoped(&scope{}, func() {
+		declare(program, t.scope)
+		t.statements(program.Statements, outsideBody, "return")
+	})
+	if len(t.diagnostics) > 0 {
+		return nil, t.diagnostics
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gosling build --emit=go from %s. DO NOT EDIT.\n\n", filename)
+	out.WriteString("package main\n\nimport \"gosling/golang/gort\"\n\n")
+	fmt.Fprintf(&out, "func main() {\n\tgort.Main(%s, program)\n}\n\n", strconv.Quote(filename))
+	for i, sig := range t.signatures {
+		fmt.Fprintf(&out, "var sig%d = %s\n\n", i, sig)
+	}
+	fmt.Fprintf(&out, "func program() gort.Value {\n%s}\n", body)
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		panic("golang: invalid output: " + err.Error())
+	}
+	return formatted, nil
+}
+
+// position is where a block is translated, as in the evaluator: outside
+// a function body, in one, or in one as the block whose value is the
+// function's, where calls are tail calls
+type position int
+
+const (
+	outsideBody position = iota
+	inBody
+	tailPosition
+)
+
+func inBodyAt(last bool) position {
+	if last {
+		return tailPosition
+	}
+	return inBody
+}
+
+// scope is a Go block standing for an environment
+type scope struct {
+	outer *scope
+	depth int
+	names []string
+	bound map[string]bool
+	read  map[string]bool
+}
+
+func (s *scope) bind(name string) {
+	if s.bound == nil {
+		s.bound = map[string]bool{}
+		s.read = map[string]bool{}
+	}
+	if !s.bound[name] {
+		s.bound[name] = true
+		s.names = append(s.names, name)
+	}
+}
+
+// variable is the Go variable of name in s. Gosling names have no digits,
+// so the depth keeps them apart from everything else in the output.
+func (s *scope) variable(name string) string {
+	return fmt.Sprintf("%s_%d", name, s.depth)
+}
+
+// declare binds every name a let binds in node, leaving out the scopes
+// nested in it
+func declare(node ast.Node, s *scope) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			s.bind(n.Name.Value)
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.IfExpression:
+			if n.Binding != nil {
+				declare(n.Condition, s)
+				declare(n.Alternative, s)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+type transpiler struct {
+	lines []string
+	out   *bytes.Buffer
+	scope *scope
+	temps int
+	// held holds the temporaries, which are read once set
+	held map[string]bool
+	// signatures holds the Go source of the signature of each function
+	// literal
+	signatures []string
+	// commented is the line the last comment quoted
+	commented int
+	// inValue counts the ifs and loops being translated whose values are
+	// used, a return in which is not supported
+	inValue     int
+	diagnostics []diag.Diagnostic
+}
+
+func (t *transpiler) emit(format string, a ...interface{}) {
+	fmt.Fprintf(t.out, format+"\n", a...)
+}
+
+// scoped translates the statements body writes into a block for s,
+// declaring its variables first, and returns the block's contents
+func (t *transpiler) scoped(s *scope, body func()) string {
+	if t.scope != nil {
+		s.outer, s.depth = t.scope, t.scope.depth+1
+	}
+	out, outer := t.out, t.scope
+	t.out, t.scope = &bytes.Buffer{}, s
+	body()
+	block := t.out.String()
+	t.out, t.scope = out, outer
+
+	if len(s.names) == 0 {
+		return block
+	}
+	var decl strings.Builder
+	vars := make([]string, len(s.names))
+	for i, name := range s.names {
+		vars[i] = s.variable(name)
+	}
+	fmt.Fprintf(&decl, "var %s gort.Value\n", strings.Join(vars, ", "))
+	for _, name := range s.names {
+		if !s.read[name] {
+			fmt.Fprintf(&decl, "_ = %s\n", s.variable(name))
+		}
+	}
+	return decl.String() + block
+}
+
+// variables lists the Go variables that may hold name, innermost first,
+// as pointers for the runtime
+func (t *transpiler) variables(name string) string {
+	var vars []string
+	for s := t.scope; s != nil; s = s.outer {
+		if s.bound[name] {
+			s.read[name] = true
+			vars = append(vars, "&"+s.variable(name))
+		}
+	}
+	return strings.Join(vars, ", ")
+}
+
+// bound is the variable a let or parameter binds name to
+func (t *transpiler) bound(name string) string {
+	return t.scope.variable(name)
+}
+
+func (t *transpiler) temp() string {
+	t.temps++
+	name := fmt.Sprintf("t%d", t.temps)
+	if t.held == nil {
+		t.held = map[string]bool{}
+	}
+	t.held[name] = true
+	return name
+}
+
+// hold keeps the value of a translated expression in a temporary, so that
+// statements written after it cannot change it
+func (t *transpiler) hold(value string) string {
+	if t.held[value] {
+		return value
+	}
+	name := t.temp()
+	t.emit("%s := %s", name, value)
+	return name
+}
+
+// comment quotes the source line of node, unless it was the last quoted
+func (t *transpiler) comment(node ast.Node) {
+	line := node.Location().Line
+	if line == t.commented || line < 0 || line >= len(t.lines) {
+		return
+	}
+	t.commented = line
+	t.emit("// line %d: %s", line, strings.TrimSpace(t.lines[line]))
+}
+
+func (t *transpiler) unsupported(node ast.Node, format string, a ...interface{}) {
+	t.diagnostics = append(t.diagnostics, diag.New(diag.UnsupportedConstruct, node.Location(), format, a...))
+}
+
+func at(loc token.TokenLocation) string {
+	return fmt.Sprintf("gort.At(%d, %d)", loc.Line, loc.LineCh)
+}
+
+// statements translates a block at pos. The value of the last statement
+// goes to result: returned if it is "return", dropped if it is empty and
+// otherwise assigned to the variable it names.
+func (t *transpiler) statements(stmts []ast.Statement, pos position, result string) {
+	var value string
+	for i, stmt := range stmts {
+		last := i == len(stmts)-1
+		t.comment(stmt)
+		value = ""
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			v := t.expr(stmt.Value, false)
+			if stmt.Name.Type != nil {
+				v = fmt.Sprintf("gort.Check(%s, %s, %q, %s)", t.typ(stmt.Name.Type), v, "let "+stmt.Name.Value, at(stmt.Name.Location()))
+			}
+			t.emit("%s = %s", t.bound(stmt.Name.Value), v)
+		case *ast.ReturnStatement:
+			if t.inValue > 0 {
+				t.unsupported(stmt, "cannot compile a return in an if or for whose value is used")
+			}
+			if stmt.ReturnValue == nil {
+				t.emit("return gort.Null")
+			} else {
+				t.emit("return %s", t.expr(stmt.ReturnValue, pos != outsideBody))
+			}
+			if last {
+				return
+			}
+		case *ast.ExpressionStatement:
+			target := ""
+			if last {
+				target = result
+			}
+			switch exp := stmt.Expression.(type) {
+			case *ast.IfExpression:
+				inner := outsideBody
+				if pos != outsideBody {
+					inner = inBodyAt(last && pos == tailPosition)
+				}
+				t.ifStatement(exp, inner, target)
+				if target == "return" {
+					return
+				}
+				continue
+			case *ast.ForExpression:
+				inner := outsideBody
+				if pos != outsideBody {
+					inner = inBody
+				}
+				t.forStatement(exp, inner)
+				if last {
+					value = "gort.Null"
+				}
+			default:
+				value = t.expr(exp, last && pos == tailPosition)
+			}
+		}
+		if !last {
+			if value != "" {
+				t.emit("_ = %s", value)
+			}
+			continue
+		}
+		switch {
+		case result == "return" && value == "":
+			t.emit("return nil")
+		case result == "return":
+			t.emit("return %s", value)
+		case result == "" && value != "":
+			t.emit("_ = %s", value)
+		case value != "":
+			t.emit("%s = %s", result, value)
+		}
+	}
+	if len(stmts) == 0 && result == "return" {
+		t.emit("return nil")
+	}
+}
+
+// ifStatement translates an if at pos, giving its value to result as
+// statements does
+func (t *transpiler) ifStatement(ie *ast.IfExpression, pos position, result string) {
+	condition := t.expr(ie.Condition, false)
+	if ie.Binding != nil {
+		t.emit("if value, ok := gort.Unwrap(%s, %q, %s); ok {", at(ie.Condition.Location()), ie.Binding.Value, condition)
+		s := &scope{}
+		s.bind(ie.Binding.Value)
+		t.out.WriteString(t.scoped(s, func() {
+			declare(ie.Consequence, s)
+			value := "value"
+			if ie.Binding.Type != nil {
+				value = fmt.Sprintf("gort.Check(%s, value, %q, %s)", t.typ(ie.Binding.Type), "if let "+ie.Binding.Value, at(ie.Binding.Location()))
+			}
+			t.emit("%s = %s", t.bound(ie.Binding.Value), value)
+			t.statements(ie.Consequence.Statements, pos, result)
+		}))
+	} else {
+		t.emit("if gort.Truthy(%s) {", condition)
+		t.statements(ie.Consequence.Statements, pos, result)
+	}
+	switch {
+	case ie.Alternative != nil:
+		t.emit("} else {")
+		t.statements(ie.Alternative.Statements, pos, result)
+	case result == "return":
+		t.emit("} else {")
+		t.emit("return gort.Null")
+	case result != "":
+		t.emit("} else {")
+		t.emit("%s = gort.Null", result)
+	}
+	t.emit("}")
+}
+
+func (t *transpiler) forStatement(fe *ast.ForExpression, pos position) {
+	t.emit("for {")
+	t.emit("if !gort.Truthy(%s) {", t.expr(fe.Condition, false))
+	t.emit("break")
+	t.emit("}")
+	t.statements(fe.Body.Statements, pos, "")
+	t.emit("}")
+}
+
+// hoists reports whether translating exp writes statements, which would
+// run before the expressions to its left are evaluated
+func hoists(exp ast.Expression) bool {
+	found := false
+	ast.Inspect(exp, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.IfExpression, *ast.ForExpression:
+			found = true
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// operands translates exps, to be evaluated left to right
+func (t *transpiler) operands(exps ...ast.Expression) []string {
+	values := make([]string, len(exps))
+	for i, exp := range exps {
+		if hoists(exp) {
+			for j := 0; j < i; j++ {
+				values[j] = t.hold(values[j])
+			}
+		}
+		values[i] = t.expr(exp, false)
+	}
+	return values
+}
+
+// expr translates an expression to a Go expression, writing the
+// statements it needs first. A call in tail position is a tail call.
+func (t *transpiler) expr(exp ast.Expression, tail bool) string {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			return fmt.Sprintf("gort.BigInt(%q)", exp.Big.String())
+		}
+		return fmt.Sprintf("gort.Int(%d)", exp.Value)
+	case *ast.Boolean:
+		if exp.Value {
+			return "gort.True"
+		}
+		return "gort.False"
+	case *ast.StringLiteral:
+		return fmt.Sprintf("gort.String(%s)", strconv.Quote(exp.Value))
+	case *ast.NoneLiteral:
+		return "gort.None"
+	case *ast.Identifier:
+		vars := t.variables(exp.Value)
+		if vars == "" {
+			return fmt.Sprintf("gort.Lookup(%s, %q)", at(exp.Token.Location), exp.Value)
+		}
+		return fmt.Sprintf("gort.Lookup(%s, %q, %s)", at(exp.Token.Location), exp.Value, vars)
+	case *ast.PrefixExpression:
+		return fmt.Sprintf("gort.Prefix(%s, %q, %s)", at(exp.Token.Location), exp.Operator, t.expr(exp.Right, false))
+	case *ast.InfixExpression:
+		values := t.operands(exp.Left, exp.Right)
+		return fmt.Sprintf("gort.Infix(%s, %q, %s, %s)", at(exp.Token.Location), exp.Operator, values[0], values[1])
+	case *ast.AssignExpression:
+		value := t.expr(exp.Value, false)
+		vars := t.variables(exp.Name.Value)
+		if vars == "" {
+			return fmt.Sprintf("gort.Assign(%s, %q, %s)", at(exp.Name.Token.Location), exp.Name.Value, value)
+		}
+		return fmt.Sprintf("gort.Assign(%s, %q, %s, %s)", at(exp.Name.Token.Location), exp.Name.Value, value, vars)
+	case *ast.FunctionLiteral:
+		return t.function(exp)
+	case *ast.CallExpression:
+		values := t.operands(append([]ast.Expression{exp.Function}, exp.Arguments...)...)
+		call := "gort.Call"
+		if tail {
+			call = "gort.Tail"
+		}
+		return fmt.Sprintf("%s(%s, %s)", call, at(exp.Token.Location), strings.Join(values, ", "))
+	case *ast.IfExpression:
+		pos := outsideBody
+		if tail {
+			pos = tailPosition
+		}
+		result := t.temp()
+		t.emit("var %s gort.Value", result)
+		t.inValue++
+		t.ifStatement(exp, pos, result)
+		t.inValue--
+		return result
+	case *ast.ForExpression:
+		t.inValue++
+		t.forStatement(exp, outsideBody)
+		t.inValue--
+		return "gort.Null"
+	}
+	t.unsupported(exp, "cannot compile %T", exp)
+	return "nil"
+}
+
+// function translates a function literal to a Go closure whose variables
+// are those of a call
+func (t *transpiler) function(fl *ast.FunctionLiteral) string {
+	source := (&object.Function{Parameters: fl.Parameters, ReturnType: fl.ReturnType, Body: fl.Body}).Inspect()
+	var sig strings.Builder
+	fmt.Fprintf(&sig, "&gort.Signature{\nSource: %s,\n", strconv.Quote(source))
+	if len(fl.Parameters) > 0 {
+		sig.WriteString("Params: []gort.Param{\n")
+		for _, p := range fl.Parameters {
+			if p.Type != nil {
+				fmt.Fprintf(&sig, "{Name: %q, Type: %s},\n", p.Value, t.typ(p.Type))
+			} else {
+				fmt.Fprintf(&sig, "{Name: %q},\n", p.Value)
+			}
+		}
+		sig.WriteString("},\n")
+	}
+	if fl.ReturnType != nil {
+		fmt.Fprintf(&sig, "Result: %s,\n", t.typ(fl.ReturnType))
+	}
+	sig.WriteString("}")
+	name := fmt.Sprintf("sig%d", len(t.signatures))
+	t.signatures = append(t.signatures, sig.String())
+
+	inValue := t.inValue
+	t.inValue = 0
+	s := &scope{}
+	for _, p := range fl.Parameters {
+		s.bind(p.Value)
+	}
+	body := t.scoped(s, func() {
+		declare(fl.Body, s)
+		for i, p := range fl.Parameters {
+			t.emit("%s = args[%d]", t.bound(p.Value), i)
+		}
+		t.statements(fl.Body.Statements, tailPosition, "return")
+	})
+	t.inValue = inValue
+	return fmt.Sprintf("gort.Func(%s, func(args []gort.Value) gort.Value {\n%s})", name, body)
+}
+
+// typ translates an annotation. One that does not resolve is reported
+// where the evaluator would report it, when a value is checked against it.
+func (t *transpiler) typ(texp ast.TypeExpression) string {
+	resolved, err := typecheck.Resolve(texp)
+	if err != nil {
+		loc := texp.Location()
+		return fmt.Sprintf("gort.UnknownType(%d, %d, %q)", loc.Line, loc.LineCh, err.Error())
+	}
+	return goType(resolved)
+}
+
+func goType(t types.Type) string {
+	switch t := t.(type) {
+	case *types.IntegerType:
+		return "gort.IntType"
+	case *types.StringType:
+		return "gort.StringType"
+	case *types.BooleanType:
+		return "gort.BoolType"
+	case *types.OptionType:
+		return "gort.OptionOf(" + goType(t.ValueType) + ")"
+	case *types.ResultType:
+		return "gort.ResultOf(" + goType(t.ValueType) + ")"
+	case *types.ArrayType:
+		return "gort.ArrayOf(" + goType(t.ElementType) + ")"
+	case *types.MapType:
+		return "gort.MapOf(" + goType(t.KeyType) + ", " + goType(t.ValueType) + ")"
+	case *types.FunctionType:
+		params := make([]string, len(t.Parameters))
+		for i, p := range t.Parameters {
+			params[i] = goType(p)
+		}
+		return "gort.FuncOf([]*gort.Type{" + strings.Join(params, ", ") + "}, " + goType(t.Result) + ")"
+	}
+	return "gort.DynamicType"
+}
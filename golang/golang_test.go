@@ -0,0 +1,190 @@
+package golang_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/difftest"
+	"gosling/evaluator"
+	"gosling/golang"
+	"gosling/internal/golden"
+	"gosling/lexer"
+	"gosling/object"
+	"gosling/parser"
+	"gosling/resolve"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// parseFile parses a program as gosling run does, so run time errors name
+// the file. It reports false if the program does not parse.
+func parseFile(path string) (*ast.Program, bool) {
+	p := parser.New(lexer.LexFile(path))
+	program := p.ParseProgram()
+	return program, len(p.Errors()) == 0
+}
+
+func compile(t *testing.T, path, filename string) []byte {
+	t.Helper()
+	program, ok := parseFile(path)
+	if !ok {
+		t.Fatalf("%s does not parse", path)
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, diagnostics := golang.Compile(program, filename, source)
+	if len(diagnostics) > 0 {
+		t.Fatalf("%s: unexpected diagnostics: %v", path, diagnostics)
+	}
+	return main
+}
+
+// TestGolden translates each program and compares the output with its .go
+// file in testdata
+func TestGolden(t *testing.T) {
+	for _, path := range golden.Programs(t) {
+		golden.Compare(t, path, ".go", compile(t, path, filepath.Base(path)), *update)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+	}{
+		{"let f = fn() { let x = if (true) { return 1; }; x };", "cannot compile a return in an if or for whose value is used"},
+		{"let f = fn(n) { return if (n) { return 1; } else { 2 }; };", "cannot compile a return in an if or for whose value is used"},
+		{"let x = 1 + for (false) { return 2; };", "cannot compile a return in an if or for whose value is used"},
+	}
+
+	for _, tt := range tests {
+		_, diagnostics := golang.Compile(golden.Parse(t, tt.input), "test.gos", []byte(tt.input))
+		golden.Diagnostic(t, tt.input, diagnostics, diag.UnsupportedConstruct, tt.message)
+	}
+}
+
+// output is what running a program prints and how it exits
+type output struct {
+	stdout, stderr string
+	status         int
+}
+
+// interpret runs a program as gosling run does. It reports false if the
+// program runs too long to compare.
+func interpret(program *ast.Program) (output, bool) {
+	resolve.Program(program)
+	result := evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{Timeout: 5 * time.Second})
+	if err, ok := result.(*object.Error); ok {
+		if err.Code == diag.EvaluationCancelled {
+			return output{}, false
+		}
+		return output{stderr: err.Inspect() + "\n", status: 1}, true
+	}
+	if result == nil || result == evaluator.NULL {
+		return output{}, true
+	}
+	return output{stdout: result.Inspect() + "\n"}, true
+}
+
+// TestConformance translates the examples of the specification and the
+// programs the backends are tested with, builds them with the go command
+// and checks that the binaries print and exit as the interpreter does
+func TestConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Go programs")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go command is not installed")
+	}
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The translated programs make up a module of their own that finds
+	// the runtime in this one
+	dir := t.TempDir()
+	goMod := fmt.Sprintf("module conformance\n\ngo 1.24\n\nrequire gosling v0.0.0\n\nreplace gosling => %s\n", root)
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := os.ReadFile(filepath.Join(root, "go.sum")); err == nil {
+		if err := os.WriteFile(filepath.Join(dir, "go.sum"), sum, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spec, err := os.ReadFile(filepath.Join("..", "language_specification.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples, err := difftest.WriteExamples(string(spec), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := append(examples, golden.Programs(t)...)
+	want := map[string]output{}
+	var names []string
+	for _, path := range sources {
+		program, ok := parseFile(path)
+		if !ok {
+			// fragments of the grammar rather than whole programs
+			continue
+		}
+		expected, ok := interpret(program)
+		if !ok {
+			t.Logf("%s: skipped, it runs too long", path)
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".gos")
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "main.go"), compile(t, path, path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want[name] = expected
+		names = append(names, name)
+	}
+
+	build := exec.Command(goCmd, "build", "-o", "bin"+string(filepath.Separator), "./...")
+	build.Dir = dir
+	build.Env = append(os.Environ(), "GOWORK=off")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %v\n%s", err, out)
+	}
+
+	for _, name := range names {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(filepath.Join(dir, "bin", name))
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		got := output{}
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("%s: %v", name, err)
+			}
+			got.status = exitErr.ExitCode()
+		}
+		got.stdout, got.stderr = stdout.String(), stderr.String()
+		if got != want[name] {
+			t.Errorf("%s: the binary differs from the interpreter.\nwant=%+v\ngot=%+v", name, want[name], got)
+		}
+	}
+	if len(names) == 0 {
+		t.Fatal("no programs to compare")
+	}
+}
@@ -0,0 +1,211 @@
+package gort
+
+import (
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/token"
+	"gosling/typecheck"
+	"gosling/types"
+)
+
+// Type is a type annotation of the program. One naming no type is only
+// reported once a value is checked against it, as in the evaluator.
+type Type struct {
+	t   types.Type
+	err *object.Error
+}
+
+var (
+	IntType     = &Type{t: typecheck.Integer}
+	StringType  = &Type{t: typecheck.String}
+	BoolType    = &Type{t: typecheck.Boolean}
+	DynamicType = &Type{t: typecheck.Dynamic}
+)
+
+func OptionOf(value *Type) *Type {
+	return &Type{t: &types.OptionType{ValueType: value.t}}
+}
+
+func ResultOf(value *Type) *Type {
+	return &Type{t: &types.ResultType{ValueType: value.t}}
+}
+
+func ArrayOf(element *Type) *Type {
+	return &Type{t: &types.ArrayType{ElementType: element.t}}
+}
+
+func MapOf(key, value *Type) *Type {
+	return &Type{t: &types.MapType{KeyType: key.t, ValueType: value.t}}
+}
+
+func FuncOf(params []*Type, result *Type) *Type {
+	fn := &types.FunctionType{Parameters: make([]types.Type, len(params)), Result: result.t}
+	for i, p := range params {
+		fn.Parameters[i] = p.t
+	}
+	return &Type{t: fn}
+}
+
+// UnknownType is an annotation that does not resolve, written at line
+// and char
+func UnknownType(line, char int, message string) *Type {
+	return &Type{err: &object.Error{Code: diag.UnknownType, Message: message, Location: token.TokenLocation{Line: line, LineCh: char}}}
+}
+
+// orDynamic is the type an annotation gives a function's own type,
+// dynamic where it is missing or does not resolve
+func (t *Type) orDynamic() types.Type {
+	if t == nil || t.err != nil {
+		return typecheck.Dynamic
+	}
+	return t.t
+}
+
+// Check raises an error if val does not have type t, and returns val
+func Check(t *Type, val Value, what string, loc token.TokenLocation) Value {
+	if t.err != nil {
+		err := *t.err
+		err.Location.Filename = filename
+		raise(&err)
+	}
+	if !hasType(val, t.t) {
+		raise(object.NewError(diag.TypeMismatch, loc, "cannot use %s as %s for %s", val.Type(), t.t, what))
+	}
+	return val
+}
+
+func hasType(val Value, want types.Type) bool {
+	switch want := want.(type) {
+	case *types.DynamicType:
+		return true
+	case *types.OptionType:
+		option, ok := val.(*object.Option)
+		return ok && (option.Value == nil || hasType(option.Value, want.ValueType))
+	case *types.FunctionType:
+		switch val := val.(type) {
+		case *Function:
+			return val.sig.typ().IsAssignableTo(want)
+		case *object.Builtin:
+			return typecheck.TypeOf(val).IsAssignableTo(want)
+		}
+		return false
+	}
+	if fn, ok := val.(*Function); ok {
+		return fn.sig.typ().IsType(want)
+	}
+	return typecheck.TypeOf(val).IsType(want)
+}
+
+// Signature is what a function literal tells about its functions: the
+// source they print as and their annotations
+type Signature struct {
+	Source string
+	Params []Param
+	Result *Type // nil if the result is not annotated
+}
+
+type Param struct {
+	Name string
+	Type *Type // nil if the parameter is not annotated
+}
+
+func (s *Signature) typ() *types.FunctionType {
+	fn := &types.FunctionType{Parameters: make([]types.Type, len(s.Params)), Result: s.Result.orDynamic()}
+	for i, p := range s.Params {
+		fn.Parameters[i] = p.Type.orDynamic()
+	}
+	return fn
+}
+
+// Function is a function value, a Go closure over the variables of the
+// calls and if lets it was made in
+type Function struct {
+	sig  *Signature
+	body func(args []Value) Value
+}
+
+func (f *Function) Type() object.ObjectType { return object.FUNCTION_OBJ }
+func (f *Function) Inspect() string         { return f.sig.Source }
+
+// Func makes a function whose calls run body. The arguments are checked
+// against sig before body sees them.
+func Func(sig *Signature, body func(args []Value) Value) Value {
+	return &Function{sig: sig, body: body}
+}
+
+// tailCall is what a call in tail position returns: the call still to be
+// made, by Call once the caller has returned
+type tailCall struct {
+	fn   Value
+	args []Value
+	loc  token.TokenLocation
+}
+
+const tailCallObj object.ObjectType = "TAIL_CALL"
+
+func (tc *tailCall) Type() object.ObjectType { return tailCallObj }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// Tail is a call in tail position, which the function making it returns
+// for Call to make in its place. Tail recursion so runs in constant Go
+// stack, still counting toward the depth as it does in the evaluator.
+func Tail(loc token.TokenLocation, fn Value, args ...Value) Value {
+	return &tailCall{fn: fn, args: args, loc: loc}
+}
+
+type resultCheck struct {
+	t   *Type
+	loc token.TokenLocation
+}
+
+// Call calls fn with args, then the calls it makes in tail position
+func Call(loc token.TokenLocation, fn Value, args ...Value) Value {
+	start := depth
+	defer func() { depth = start }()
+	// the depth the next call is made at, its caller's for a tail call
+	at := start
+	// result annotations to check once the last call returns, innermost
+	// last. A tail recursive function repeats the same check, kept once.
+	var checks []resultCheck
+
+	for {
+		var result Value
+		switch f := fn.(type) {
+		case *Function:
+			if at >= MaxDepth {
+				raise(object.NewError(diag.CallDepthExceeded, loc, "maximum call depth of %d exceeded", MaxDepth))
+			}
+			for i, param := range f.sig.Params {
+				if param.Type != nil && i < len(args) {
+					Check(param.Type, args[i], "parameter "+param.Name, loc)
+				}
+			}
+			if len(args) < len(f.sig.Params) {
+				raise(object.NewError(diag.WrongArgumentCount, loc, "wrong number of arguments. got=%d, want=%d", len(args), len(f.sig.Params)))
+			}
+			depth = at + 1
+			if f.sig.Result != nil {
+				check := resultCheck{t: f.sig.Result, loc: loc}
+				if len(checks) == 0 || checks[len(checks)-1] != check {
+					checks = append(checks, check)
+				}
+			}
+			result = f.body(args)
+			if call, ok := result.(*tailCall); ok {
+				fn, args, loc = call.fn, call.args, call.loc
+				at = depth
+				continue
+			}
+		case *object.Builtin:
+			result = check(evaluator.CallBuiltin(f, args, loc))
+		default:
+			raise(object.NewError(diag.NotAFunction, loc, "not a function: %s", fn.Type()))
+		}
+
+		for i := len(checks) - 1; i >= 0; i-- {
+			Check(checks[i].t, result, "the result", checks[i].loc)
+		}
+		return result
+	}
+}
@@ -0,0 +1,157 @@
+// Package gort is the runtime of the Go programs that the golang package
+// writes. Their values are those of the object package, and operators,
+// builtins, calls and annotations go through the evaluator's own rules,
+// so a translated program prints and fails as the interpreted one does.
+//
+// An error stops a program wherever it happens, so rather than being
+// passed back up by every operation it is raised as a panic, which Main
+// recovers to report.
+package gort
+
+import (
+	"fmt"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/token"
+	"io"
+	"math/big"
+	"os"
+)
+
+// Value is a value of the program
+type Value = object.Object
+
+var (
+	Null  Value = evaluator.NULL
+	None  Value = evaluator.NONE
+	True  Value = evaluator.TRUE
+	False Value = evaluator.FALSE
+)
+
+// MaxDepth is how deep calls may nest, as in gosling run
+const MaxDepth = evaluator.DefaultMaxDepth
+
+var (
+	// filename is the file the program was translated from, for the
+	// locations of errors
+	filename string
+	// depth is the number of calls in progress
+	depth int
+)
+
+// Main runs program, translated from file, and exits. Like gosling run it
+// prints the value of the last statement, or the error that stopped the
+// program and exits with status 1.
+func Main(file string, program func() Value) {
+	os.Exit(run(file, program, os.Stdout, os.Stderr))
+}
+
+func run(file string, program func() Value, out, errOut io.Writer) (status int) {
+	filename = file
+	depth = 0
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*object.Error)
+			if !ok {
+				panic(r)
+			}
+			fmt.Fprintf(errOut, "%s\n", err.Inspect())
+			status = 1
+		}
+	}()
+	if result := program(); result != nil && result != Null {
+		fmt.Fprintf(out, "%s\n", result.Inspect())
+	}
+	return 0
+}
+
+// At is a location in the program's file
+func At(line, char int) token.TokenLocation {
+	return token.TokenLocation{Line: line, LineCh: char, Filename: filename}
+}
+
+// raise stops the program with err
+func raise(err *object.Error) {
+	panic(err)
+}
+
+// check raises val if it is an error
+func check(val Value) Value {
+	if err, ok := val.(*object.Error); ok {
+		raise(err)
+	}
+	return val
+}
+
+func Int(v int64) Value {
+	return &object.Integer{Value: v}
+}
+
+// BigInt is an integer literal too large for an int64, in decimal
+func BigInt(digits string) Value {
+	v, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		panic("gort: bad integer literal " + digits)
+	}
+	return &object.BigInt{Value: v}
+}
+
+func String(s string) Value {
+	return &object.String{Value: s}
+}
+
+func Prefix(loc token.TokenLocation, operator string, right Value) Value {
+	return check(evaluator.EvalPrefixExpression(operator, right, loc))
+}
+
+func Infix(loc token.TokenLocation, operator string, left, right Value) Value {
+	return check(evaluator.EvalInfixExpression(operator, left, right, loc))
+}
+
+// Truthy reports whether a condition holds
+func Truthy(val Value) bool {
+	return evaluator.IsTruthy(val)
+}
+
+// Lookup reads a name from the first of vars that is bound, innermost
+// first, as the evaluator reads it from the first environment out that
+// binds it. With none bound it is a builtin or not found.
+func Lookup(loc token.TokenLocation, name string, vars ...*Value) Value {
+	for _, v := range vars {
+		if *v != nil {
+			return *v
+		}
+	}
+	if builtin := evaluator.LookupBuiltin(name); builtin != nil {
+		return builtin
+	}
+	raise(object.NewError(diag.IdentifierNotFound, loc, "identifier not found: %s", name))
+	return nil
+}
+
+// Assign rebinds name in the first of vars that is bound, as Lookup
+// would have found it, and returns val
+func Assign(loc token.TokenLocation, name string, val Value, vars ...*Value) Value {
+	for _, v := range vars {
+		if *v != nil {
+			*v = val
+			return val
+		}
+	}
+	raise(object.NewError(diag.IdentifierNotFound, loc, "identifier not found: %s", name))
+	return nil
+}
+
+// Unwrap returns the value held by the option an if let binds to name,
+// and false for none or null, which run the alternative
+func Unwrap(loc token.TokenLocation, name string, option Value) (Value, bool) {
+	switch option := option.(type) {
+	case *object.Option:
+		return option.Value, option.Value != nil
+	case *object.Null:
+		return nil, false
+	}
+	raise(object.NewError(diag.TypeMismatch, loc, "cannot use %s as an option for if let %s", option.Type(), name))
+	return nil, false
+}
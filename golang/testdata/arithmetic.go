@@ -0,0 +1,19 @@
+// Code generated by gosling build --emit=go from arithmetic.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("arithmetic.gos", program)
+}
+
+func program() gort.Value {
+	var a_0, b_0 gort.Value
+	// line 0: let a = 7;
+	a_0 = gort.Int(7)
+	// line 1: let b = -3;
+	b_0 = gort.Prefix(gort.At(1, 9), "-", gort.Int(3))
+	// line 2: a * b + a / b - a % b
+	return gort.Infix(gort.At(2, 15), "-", gort.Infix(gort.At(2, 7), "+", gort.Infix(gort.At(2, 3), "*", gort.Lookup(gort.At(2, 1), "a", &a_0), gort.Lookup(gort.At(2, 5), "b", &b_0)), gort.Infix(gort.At(2, 11), "/", gort.Lookup(gort.At(2, 9), "a", &a_0), gort.Lookup(gort.At(2, 13), "b", &b_0))), gort.Infix(gort.At(2, 19), "%", gort.Lookup(gort.At(2, 17), "a", &a_0), gort.Lookup(gort.At(2, 21), "b", &b_0)))
+}
@@ -0,0 +1,97 @@
+// Code generated by gosling build --emit=go from closures.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("closures.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(step: int) {\nlet n = 0;fn()n = (n + step)n\n}",
+	Params: []gort.Param{
+		{Name: "step", Type: gort.IntType},
+	},
+}
+
+var sig1 = &gort.Signature{
+	Source: "fn() {\nn = (n + step)n\n}",
+}
+
+var sig2 = &gort.Signature{
+	Source: "fn(f: fn(int) -> int, g: fn(int) -> int) {\nfn(x: int)f(g(x))\n}",
+	Params: []gort.Param{
+		{Name: "f", Type: gort.FuncOf([]*gort.Type{gort.IntType}, gort.IntType)},
+		{Name: "g", Type: gort.FuncOf([]*gort.Type{gort.IntType}, gort.IntType)},
+	},
+}
+
+var sig3 = &gort.Signature{
+	Source: "fn(x: int) {\nf(g(x))\n}",
+	Params: []gort.Param{
+		{Name: "x", Type: gort.IntType},
+	},
+}
+
+var sig4 = &gort.Signature{
+	Source: "fn(x: int) {\n(x * 2)\n}",
+	Params: []gort.Param{
+		{Name: "x", Type: gort.IntType},
+	},
+}
+
+var sig5 = &gort.Signature{
+	Source: "fn(x: int) {\n(x + 1)\n}",
+	Params: []gort.Param{
+		{Name: "x", Type: gort.IntType},
+	},
+}
+
+func program() gort.Value {
+	var counter_0, compose_0, c_0, double_0, inc_0 gort.Value
+	// line 0: let counter = fn(step: int) {
+	counter_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var step_1, n_1 gort.Value
+		step_1 = args[0]
+		// line 1: let n = 0;
+		n_1 = gort.Int(0)
+		// line 2: fn() { n = n + step; n }
+		return gort.Func(sig1, func(args []gort.Value) gort.Value {
+			_ = gort.Assign(gort.At(2, 9), "n", gort.Infix(gort.At(2, 15), "+", gort.Lookup(gort.At(2, 13), "n", &n_1), gort.Lookup(gort.At(2, 17), "step", &step_1)), &n_1)
+			return gort.Lookup(gort.At(2, 23), "n", &n_1)
+		})
+	})
+	// line 4: let compose = fn(f: fn(int) -> int, g: fn(int) -> int) {
+	compose_0 = gort.Func(sig2, func(args []gort.Value) gort.Value {
+		var f_1, g_1 gort.Value
+		f_1 = args[0]
+		g_1 = args[1]
+		// line 5: fn(x: int) { f(g(x)) }
+		return gort.Func(sig3, func(args []gort.Value) gort.Value {
+			var x_2 gort.Value
+			x_2 = args[0]
+			return gort.Tail(gort.At(5, 16), gort.Lookup(gort.At(5, 15), "f", &f_1), gort.Call(gort.At(5, 18), gort.Lookup(gort.At(5, 17), "g", &g_1), gort.Lookup(gort.At(5, 19), "x", &x_2)))
+		})
+	})
+	// line 7: let c = counter(5);
+	c_0 = gort.Call(gort.At(7, 16), gort.Lookup(gort.At(7, 9), "counter", &counter_0), gort.Int(5))
+	// line 8: c();
+	_ = gort.Call(gort.At(8, 2), gort.Lookup(gort.At(8, 1), "c", &c_0))
+	// line 9: c();
+	_ = gort.Call(gort.At(9, 2), gort.Lookup(gort.At(9, 1), "c", &c_0))
+	// line 10: let double = fn(x: int) { x * 2 };
+	double_0 = gort.Func(sig4, func(args []gort.Value) gort.Value {
+		var x_1 gort.Value
+		x_1 = args[0]
+		return gort.Infix(gort.At(10, 29), "*", gort.Lookup(gort.At(10, 27), "x", &x_1), gort.Int(2))
+	})
+	// line 11: let inc = fn(x: int) { x + 1 };
+	inc_0 = gort.Func(sig5, func(args []gort.Value) gort.Value {
+		var x_1 gort.Value
+		x_1 = args[0]
+		return gort.Infix(gort.At(11, 26), "+", gort.Lookup(gort.At(11, 24), "x", &x_1), gort.Int(1))
+	})
+	// line 12: compose(double, inc)(c())
+	return gort.Call(gort.At(12, 21), gort.Call(gort.At(12, 8), gort.Lookup(gort.At(12, 1), "compose", &compose_0), gort.Lookup(gort.At(12, 9), "double", &double_0), gort.Lookup(gort.At(12, 17), "inc", &inc_0)), gort.Call(gort.At(12, 23), gort.Lookup(gort.At(12, 22), "c", &c_0)))
+}
@@ -0,0 +1,44 @@
+// Code generated by gosling build --emit=go from divide.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("divide.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(a: int, b: int) -> int {\n(a / b)\n}",
+	Params: []gort.Param{
+		{Name: "a", Type: gort.IntType},
+		{Name: "b", Type: gort.IntType},
+	},
+	Result: gort.IntType,
+}
+
+func program() gort.Value {
+	var divide_0, steps_0, n_0 gort.Value
+	// line 0: let divide = fn(a: int, b: int) -> int { a / b };
+	divide_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var a_1, b_1 gort.Value
+		a_1 = args[0]
+		b_1 = args[1]
+		return gort.Infix(gort.At(0, 45), "/", gort.Lookup(gort.At(0, 43), "a", &a_1), gort.Lookup(gort.At(0, 47), "b", &b_1))
+	})
+	// line 1: let steps = 0;
+	steps_0 = gort.Int(0)
+	// line 2: let n = 3;
+	n_0 = gort.Int(3)
+	// line 3: for (true) {
+	for {
+		if !gort.Truthy(gort.True) {
+			break
+		}
+		// line 4: steps = steps + divide(12, n);
+		_ = gort.Assign(gort.At(4, 2), "steps", gort.Infix(gort.At(4, 16), "+", gort.Lookup(gort.At(4, 10), "steps", &steps_0), gort.Call(gort.At(4, 24), gort.Lookup(gort.At(4, 18), "divide", &divide_0), gort.Int(12), gort.Lookup(gort.At(4, 29), "n", &n_0))), &steps_0)
+		// line 5: n = n - 1;
+		_ = gort.Assign(gort.At(5, 2), "n", gort.Infix(gort.At(5, 8), "-", gort.Lookup(gort.At(5, 6), "n", &n_0), gort.Int(1)), &n_0)
+	}
+	return gort.Null
+}
@@ -0,0 +1,34 @@
+// Code generated by gosling build --emit=go from fib.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("fib.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(n: int) -> int {\nif(n < 2) return n;(fib((n - 1)) + fib((n - 2)))\n}",
+	Params: []gort.Param{
+		{Name: "n", Type: gort.IntType},
+	},
+	Result: gort.IntType,
+}
+
+func program() gort.Value {
+	var fib_0 gort.Value
+	// line 0: let fib = fn(n: int) -> int {
+	fib_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var n_1 gort.Value
+		n_1 = args[0]
+		// line 1: if (n < 2) { return n; }
+		if gort.Truthy(gort.Infix(gort.At(1, 8), "<", gort.Lookup(gort.At(1, 6), "n", &n_1), gort.Int(2))) {
+			return gort.Lookup(gort.At(1, 22), "n", &n_1)
+		}
+		// line 2: fib(n - 1) + fib(n - 2)
+		return gort.Infix(gort.At(2, 13), "+", gort.Call(gort.At(2, 5), gort.Lookup(gort.At(2, 2), "fib", &fib_0), gort.Infix(gort.At(2, 8), "-", gort.Lookup(gort.At(2, 6), "n", &n_1), gort.Int(1))), gort.Call(gort.At(2, 18), gort.Lookup(gort.At(2, 15), "fib", &fib_0), gort.Infix(gort.At(2, 21), "-", gort.Lookup(gort.At(2, 19), "n", &n_1), gort.Int(2))))
+	})
+	// line 4: fib(20)
+	return gort.Call(gort.At(4, 4), gort.Lookup(gort.At(4, 1), "fib", &fib_0), gort.Int(20))
+}
@@ -0,0 +1,46 @@
+// Code generated by gosling build --emit=go from loop.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("loop.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(n: int) -> int {\nlet i = 0;let total = 0;for(i < n) if((i % 2) == 0) total = (total + i)i = (i + 1)total\n}",
+	Params: []gort.Param{
+		{Name: "n", Type: gort.IntType},
+	},
+	Result: gort.IntType,
+}
+
+func program() gort.Value {
+	var sum_0 gort.Value
+	// line 0: let sum = fn(n: int) -> int {
+	sum_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var n_1, i_1, total_1 gort.Value
+		n_1 = args[0]
+		// line 1: let i = 0;
+		i_1 = gort.Int(0)
+		// line 2: let total = 0;
+		total_1 = gort.Int(0)
+		// line 3: for (i < n) {
+		for {
+			if !gort.Truthy(gort.Infix(gort.At(3, 9), "<", gort.Lookup(gort.At(3, 7), "i", &i_1), gort.Lookup(gort.At(3, 11), "n", &n_1))) {
+				break
+			}
+			// line 4: if (i % 2 == 0) { total = total + i; }
+			if gort.Truthy(gort.Infix(gort.At(4, 14), "==", gort.Infix(gort.At(4, 9), "%", gort.Lookup(gort.At(4, 7), "i", &i_1), gort.Int(2)), gort.Int(0))) {
+				_ = gort.Assign(gort.At(4, 21), "total", gort.Infix(gort.At(4, 35), "+", gort.Lookup(gort.At(4, 29), "total", &total_1), gort.Lookup(gort.At(4, 37), "i", &i_1)), &total_1)
+			}
+			// line 5: i = i + 1;
+			_ = gort.Assign(gort.At(5, 3), "i", gort.Infix(gort.At(5, 9), "+", gort.Lookup(gort.At(5, 7), "i", &i_1), gort.Int(1)), &i_1)
+		}
+		// line 7: total
+		return gort.Lookup(gort.At(7, 2), "total", &total_1)
+	})
+	// line 9: sum(100) > 2000
+	return gort.Infix(gort.At(9, 10), ">", gort.Call(gort.At(9, 4), gort.Lookup(gort.At(9, 1), "sum", &sum_0), gort.Int(100)), gort.Int(2000))
+}
@@ -0,0 +1,54 @@
+// Code generated by gosling build --emit=go from nested.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("nested.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(a: int) {\nlet middle = fn(b: int)fn(c: int)((a + b) + c);middle(10)\n}",
+	Params: []gort.Param{
+		{Name: "a", Type: gort.IntType},
+	},
+}
+
+var sig1 = &gort.Signature{
+	Source: "fn(b: int) {\nfn(c: int)((a + b) + c)\n}",
+	Params: []gort.Param{
+		{Name: "b", Type: gort.IntType},
+	},
+}
+
+var sig2 = &gort.Signature{
+	Source: "fn(c: int) {\n((a + b) + c)\n}",
+	Params: []gort.Param{
+		{Name: "c", Type: gort.IntType},
+	},
+}
+
+func program() gort.Value {
+	var outer_0 gort.Value
+	// line 0: let outer = fn(a: int) {
+	outer_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var a_1, middle_1 gort.Value
+		a_1 = args[0]
+		// line 1: let middle = fn(b: int) {
+		middle_1 = gort.Func(sig1, func(args []gort.Value) gort.Value {
+			var b_2 gort.Value
+			b_2 = args[0]
+			// line 2: fn(c: int) { a + b + c }
+			return gort.Func(sig2, func(args []gort.Value) gort.Value {
+				var c_3 gort.Value
+				c_3 = args[0]
+				return gort.Infix(gort.At(2, 22), "+", gort.Infix(gort.At(2, 18), "+", gort.Lookup(gort.At(2, 16), "a", &a_1), gort.Lookup(gort.At(2, 20), "b", &b_2)), gort.Lookup(gort.At(2, 24), "c", &c_3))
+			})
+		})
+		// line 4: middle(10)
+		return gort.Tail(gort.At(4, 8), gort.Lookup(gort.At(4, 2), "middle", &middle_1), gort.Int(10))
+	})
+	// line 6: outer(100)(1000)
+	return gort.Call(gort.At(6, 11), gort.Call(gort.At(6, 6), gort.Lookup(gort.At(6, 1), "outer", &outer_0), gort.Int(100)), gort.Int(1000))
+}
@@ -0,0 +1,106 @@
+// Code generated by gosling build --emit=go from options.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("options.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(limit: int, pred: fn(int) -> bool) -> Option<int> {\nlet i = 0;for(i < limit) ifpred(i) return some(i);i = (i + 1)none\n}",
+	Params: []gort.Param{
+		{Name: "limit", Type: gort.IntType},
+		{Name: "pred", Type: gort.FuncOf([]*gort.Type{gort.IntType}, gort.BoolType)},
+	},
+	Result: gort.OptionOf(gort.IntType),
+}
+
+var sig1 = &gort.Signature{
+	Source: "fn(n: int) -> bool {\n((n * n) > 50)\n}",
+	Params: []gort.Param{
+		{Name: "n", Type: gort.IntType},
+	},
+	Result: gort.BoolType,
+}
+
+var sig2 = &gort.Signature{
+	Source: "fn(o: Option<int>) -> string {\nif let x = o foundelse missing\n}",
+	Params: []gort.Param{
+		{Name: "o", Type: gort.OptionOf(gort.IntType)},
+	},
+	Result: gort.StringType,
+}
+
+var sig3 = &gort.Signature{
+	Source: "fn(s) {\n(n * 2)\n}",
+	Params: []gort.Param{
+		{Name: "s"},
+	},
+}
+
+func program() gort.Value {
+	var find_0, square_0, describe_0, first_0 gort.Value
+	// line 0: let find = fn(limit: int, pred: fn(int) -> bool) -> Option<int> {
+	find_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var limit_1, pred_1, i_1 gort.Value
+		limit_1 = args[0]
+		pred_1 = args[1]
+		// line 1: let i = 0;
+		i_1 = gort.Int(0)
+		// line 2: for (i < limit) {
+		for {
+			if !gort.Truthy(gort.Infix(gort.At(2, 9), "<", gort.Lookup(gort.At(2, 7), "i", &i_1), gort.Lookup(gort.At(2, 11), "limit", &limit_1))) {
+				break
+			}
+			// line 3: if (pred(i)) { return some(i); }
+			if gort.Truthy(gort.Call(gort.At(3, 11), gort.Lookup(gort.At(3, 7), "pred", &pred_1), gort.Lookup(gort.At(3, 12), "i", &i_1))) {
+				return gort.Tail(gort.At(3, 29), gort.Lookup(gort.At(3, 25), "some"), gort.Lookup(gort.At(3, 30), "i", &i_1))
+			}
+			// line 4: i = i + 1;
+			_ = gort.Assign(gort.At(4, 3), "i", gort.Infix(gort.At(4, 9), "+", gort.Lookup(gort.At(4, 7), "i", &i_1), gort.Int(1)), &i_1)
+		}
+		// line 6: none
+		return gort.None
+	})
+	// line 8: let square = fn(n: int) -> bool { n * n > 50 };
+	square_0 = gort.Func(sig1, func(args []gort.Value) gort.Value {
+		var n_1 gort.Value
+		n_1 = args[0]
+		return gort.Infix(gort.At(8, 41), ">", gort.Infix(gort.At(8, 37), "*", gort.Lookup(gort.At(8, 35), "n", &n_1), gort.Lookup(gort.At(8, 39), "n", &n_1)), gort.Int(50))
+	})
+	// line 9: let describe = fn(o: Option<int>) -> string {
+	describe_0 = gort.Func(sig2, func(args []gort.Value) gort.Value {
+		var o_1 gort.Value
+		o_1 = args[0]
+		// line 10: if let x = o { "found" } else { "missing" }
+		if value, ok := gort.Unwrap(gort.At(10, 13), "x", gort.Lookup(gort.At(10, 13), "o", &o_1)); ok {
+			var x_2 gort.Value
+			_ = x_2
+			x_2 = value
+			return gort.String("found")
+		} else {
+			return gort.String("missing")
+		}
+	})
+	// line 12: let first = find(100, square);
+	first_0 = gort.Call(gort.At(12, 17), gort.Lookup(gort.At(12, 13), "find", &find_0), gort.Int(100), gort.Lookup(gort.At(12, 23), "square", &square_0))
+	// line 13: if let n = first {
+	if value, ok := gort.Unwrap(gort.At(13, 12), "n", gort.Lookup(gort.At(13, 12), "first", &first_0)); ok {
+		var n_1, len_1 gort.Value
+		n_1 = value
+		// line 14: let len = fn(s) { n * 2 };
+		len_1 = gort.Func(sig3, func(args []gort.Value) gort.Value {
+			var s_2 gort.Value
+			_ = s_2
+			s_2 = args[0]
+			return gort.Infix(gort.At(14, 22), "*", gort.Lookup(gort.At(14, 20), "n", &n_1), gort.Int(2))
+		})
+		// line 15: len("ignored") + n
+		return gort.Infix(gort.At(15, 17), "+", gort.Call(gort.At(15, 5), gort.Lookup(gort.At(15, 2), "len", &len_1), gort.String("ignored")), gort.Lookup(gort.At(15, 19), "n", &n_1))
+	} else {
+		// line 16: } else { describe(find(3, square)) }
+		return gort.Call(gort.At(16, 18), gort.Lookup(gort.At(16, 10), "describe", &describe_0), gort.Call(gort.At(16, 23), gort.Lookup(gort.At(16, 19), "find", &find_0), gort.Int(3), gort.Lookup(gort.At(16, 27), "square", &square_0)))
+	}
+}
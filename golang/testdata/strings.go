@@ -0,0 +1,40 @@
+// Code generated by gosling build --emit=go from strings.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("strings.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(name: string) -> string {\nif(name == ) hello, caféelse (hello,  + name)\n}",
+	Params: []gort.Param{
+		{Name: "name", Type: gort.StringType},
+	},
+	Result: gort.StringType,
+}
+
+func program() gort.Value {
+	var greet_0, s_0 gort.Value
+	// line 0: let greet = fn(name: string) -> string {
+	greet_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var name_1 gort.Value
+		name_1 = args[0]
+		// line 1: if (name == "") { "hello, café" } else { "hello, " + name }
+		if gort.Truthy(gort.Infix(gort.At(1, 12), "==", gort.Lookup(gort.At(1, 6), "name", &name_1), gort.String(""))) {
+			return gort.String("hello, café")
+		} else {
+			return gort.Infix(gort.At(1, 54), "+", gort.String("hello, "), gort.Lookup(gort.At(1, 56), "name", &name_1))
+		}
+	})
+	// line 3: let s = greet("world");
+	s_0 = gort.Call(gort.At(3, 14), gort.Lookup(gort.At(3, 9), "greet", &greet_0), gort.String("world"))
+	// line 4: if (len(s) != 12) { greet("") } else { s }
+	if gort.Truthy(gort.Infix(gort.At(4, 13), "!=", gort.Call(gort.At(4, 8), gort.Lookup(gort.At(4, 5), "len"), gort.Lookup(gort.At(4, 9), "s", &s_0)), gort.Int(12))) {
+		return gort.Call(gort.At(4, 26), gort.Lookup(gort.At(4, 21), "greet", &greet_0), gort.String(""))
+	} else {
+		return gort.Lookup(gort.At(4, 40), "s", &s_0)
+	}
+}
@@ -0,0 +1,55 @@
+// Code generated by gosling build --emit=go from tail.gos. DO NOT EDIT.
+
+package main
+
+import "gosling/golang/gort"
+
+func main() {
+	gort.Main("tail.gos", program)
+}
+
+var sig0 = &gort.Signature{
+	Source: "fn(n: int, total: int) -> int {\nif(n == 0) return total;count((n - 1), (total + n))\n}",
+	Params: []gort.Param{
+		{Name: "n", Type: gort.IntType},
+		{Name: "total", Type: gort.IntType},
+	},
+	Result: gort.IntType,
+}
+
+var sig1 = &gort.Signature{
+	Source: "fn(n) {\nif(n == 0) 0else (1 + deep((n - 1)))\n}",
+	Params: []gort.Param{
+		{Name: "n"},
+	},
+}
+
+func program() gort.Value {
+	var count_0, deep_0, sum_0 gort.Value
+	// line 0: let count = fn(n: int, total: int) -> int {
+	count_0 = gort.Func(sig0, func(args []gort.Value) gort.Value {
+		var n_1, total_1 gort.Value
+		n_1 = args[0]
+		total_1 = args[1]
+		// line 1: if (n == 0) { return total; }
+		if gort.Truthy(gort.Infix(gort.At(1, 9), "==", gort.Lookup(gort.At(1, 6), "n", &n_1), gort.Int(0))) {
+			return gort.Lookup(gort.At(1, 23), "total", &total_1)
+		}
+		// line 2: count(n - 1, total + n)
+		return gort.Tail(gort.At(2, 7), gort.Lookup(gort.At(2, 2), "count", &count_0), gort.Infix(gort.At(2, 10), "-", gort.Lookup(gort.At(2, 8), "n", &n_1), gort.Int(1)), gort.Infix(gort.At(2, 21), "+", gort.Lookup(gort.At(2, 15), "total", &total_1), gort.Lookup(gort.At(2, 23), "n", &n_1)))
+	})
+	// line 4: let deep = fn(n) { if (n == 0) { 0 } else { 1 + deep(n - 1) } };
+	deep_0 = gort.Func(sig1, func(args []gort.Value) gort.Value {
+		var n_1 gort.Value
+		n_1 = args[0]
+		if gort.Truthy(gort.Infix(gort.At(4, 27), "==", gort.Lookup(gort.At(4, 24), "n", &n_1), gort.Int(0))) {
+			return gort.Int(0)
+		} else {
+			return gort.Infix(gort.At(4, 47), "+", gort.Int(1), gort.Call(gort.At(4, 53), gort.Lookup(gort.At(4, 49), "deep", &deep_0), gort.Infix(gort.At(4, 56), "-", gort.Lookup(gort.At(4, 54), "n", &n_1), gort.Int(1))))
+		}
+	})
+	// line 5: let sum = count(5000, 0);
+	sum_0 = gort.Call(gort.At(5, 16), gort.Lookup(gort.At(5, 11), "count", &count_0), gort.Int(5000), gort.Int(0))
+	// line 6: deep(sum)
+	return gort.Call(gort.At(6, 5), gort.Lookup(gort.At(6, 1), "deep", &deep_0), gort.Lookup(gort.At(6, 6), "sum", &sum_0))
+}
@@ -0,0 +1,116 @@
+package gosc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"gosling/ast"
+	"gosling/compiler"
+	"os"
+	"path/filepath"
+)
+
+// Cache keeps compiled programs on disk, keyed by a hash of their source,
+// so a file that has not changed is not parsed and compiled again. It
+// keeps parsed programs for the tree walker alongside.
+type Cache struct {
+	Dir string
+}
+
+// DefaultCache returns the cache in the user's cache directory
+func DefaultCache() (*Cache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{Dir: filepath.Join(dir, "gosling")}, nil
+}
+
+// key identifies a compilation of source read from filename. The name is
+// part of it because locations in the bytecode refer to it.
+func key(filename string, source []byte) string {
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint16(nil, Version))
+	h.Write([]byte(filename))
+	h.Write([]byte{0})
+	h.Write(source)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(filename string, source []byte) string {
+	return filepath.Join(c.Dir, key(filename, source)+Ext)
+}
+
+// Load returns the compiled form of source if the cache holds one. An
+// entry that cannot be read counts as missing.
+func (c *Cache) Load(filename string, source []byte) (*compiler.Bytecode, bool) {
+	data, err := os.ReadFile(c.path(filename, source))
+	if err != nil {
+		return nil, false
+	}
+	bytecode, err := Decode(data)
+	if err != nil {
+		return nil, false
+	}
+	return bytecode, true
+}
+
+// Store saves the compiled form of source
+func (c *Cache) Store(filename string, source []byte, bytecode *compiler.Bytecode) error {
+	var buf bytes.Buffer
+	if err := Write(&buf, bytecode, Options{}); err != nil {
+		return err
+	}
+	return c.write(c.path(filename, source), buf.Bytes())
+}
+
+// LoadProgram returns source as the parser makes it, if the cache holds
+// it. An entry that cannot be read counts as missing.
+func (c *Cache) LoadProgram(filename string, source []byte) (*ast.Program, bool) {
+	data, err := os.ReadFile(c.programPath(filename, source))
+	if err != nil {
+		return nil, false
+	}
+	program, err := DecodeProgram(data)
+	if err != nil {
+		return nil, false
+	}
+	return program, true
+}
+
+// StoreProgram saves source as the parser made it, for the tree walker,
+// which has no bytecode to keep
+func (c *Cache) StoreProgram(filename string, source []byte, program *ast.Program) error {
+	data, err := EncodeProgram(filename, program)
+	if err != nil {
+		return err
+	}
+	return c.write(c.programPath(filename, source), data)
+}
+
+func (c *Cache) programPath(filename string, source []byte) string {
+	return filepath.Join(c.Dir, key(filename, source)+ProgramExt)
+}
+
+// write puts data in a temporary file and renames it to path, so a reader
+// never sees half of an entry
+func (c *Cache) write(path string, data []byte) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.Dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,86 @@
+// Package gosc reads and writes compiled programs as .gosc files.
+//
+// A file is laid out as
+//
+//	magic     "GOSC"
+//	version   uint16
+//	flags     byte, flagPositions if source positions are included
+//	filename  string, the source file every location refers to
+//	globals   count, then one string per global slot
+//	constants count, then one tagged constant each
+//	main      instructions, then positions if flagged
+//	checksum  uint32, CRC-32 (IEEE) of everything before it
+//
+// Counts and lengths are unsigned varints, other integers signed varints
+// unless given a width above, which are big endian like the operands in
+// code.Instructions. A string is its length followed by its bytes.
+//
+// The package also encodes parsed programs the same way, for the Cache to
+// keep what the tree walker runs; see EncodeProgram.
+package gosc
+
+import (
+	"errors"
+	"gosling/ast"
+	"gosling/token"
+)
+
+// Magic starts every .gosc file
+const Magic = "GOSC"
+
+// Version is the format written, and the only one read, of bytecode and
+// of parsed programs. It changes whenever either layout or the
+// instruction set does.
+const Version = 3
+
+// Ext is the extension of compiled files
+const Ext = ".gosc"
+
+const flagPositions = 1 << 0
+
+// constant tags
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagBoolean
+	tagNull
+	tagOption
+	tagBuiltin
+	tagFunction
+	tagAnnotation
+	tagBigInt
+)
+
+// type expression tags, typeNone standing for a missing annotation
+const (
+	typeNone byte = iota
+	typeNamed
+	typeArray
+	typeMap
+	typeFunction
+)
+
+// maxNesting bounds how deeply options and type expressions may nest, so
+// corrupt input cannot exhaust the stack
+const maxNesting = 64
+
+// ErrCorrupt is wrapped by every error about malformed input
+var ErrCorrupt = errors.New("corrupt bytecode file")
+
+// typeToken returns the token a type expression of the given tag starts
+// with, as the parser would have made it
+func typeToken(tag byte, name string, loc token.TokenLocation) token.Token {
+	switch tag {
+	case typeArray:
+		return token.Token{Type: token.LBRACKET, Literal: "[", Location: loc}
+	case typeMap:
+		return token.Token{Type: token.LBRACE, Literal: "{", Location: loc}
+	case typeFunction:
+		return token.Token{Type: token.FUNCTION, Literal: "fn", Location: loc}
+	}
+	return token.Token{Type: token.IDENT, Literal: name, Location: loc}
+}
+
+func identifier(name string, loc token.TokenLocation, texp ast.TypeExpression) *ast.Identifier {
+	return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name, Location: loc}, Value: name, Type: texp}
+}
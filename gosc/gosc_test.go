@@ -0,0 +1,360 @@
+package gosc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"gosling/code"
+	"gosling/compiler"
+	"gosling/evaluator"
+	"gosling/internal/golden"
+	"gosling/lexer"
+	"gosling/object"
+	"gosling/parser"
+	"gosling/resolve"
+	"gosling/vm"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	return comp.Bytecode()
+}
+
+func encode(t *testing.T, bytecode *compiler.Bytecode, opts Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, bytecode, opts); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	program := compile(t, `
+let add = fn(a: int, b) -> int { a + b };
+let apply = fn<T>(f: fn(T) -> Option<T>, xs: [T], m: {string: T}) { f };
+let s: string = "hello";
+if let v: int = some(add(1, 2)) { v } else { len(s) };
+for (false) { none };
+let x = -1; x = x * 2; !true;
+let counter = fn() { let n = 0; fn() { n = n + 1 } };
+let huge = -99999999999999999999;
+`)
+	// constants the compiler does not make yet, but which a pool may hold
+	program.Constants = append(program.Constants,
+		evaluator.TRUE,
+		evaluator.FALSE,
+		evaluator.NULL,
+		evaluator.NONE,
+		&object.Option{Value: &object.Option{Value: &object.String{Value: "nested"}}},
+		evaluator.LookupBuiltin("len"),
+		&object.Integer{Value: -1 << 63},
+	)
+
+	decoded, err := Decode(encode(t, program, Options{}))
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, program) {
+		t.Errorf("round trip changed the program.\nwant=%s\ngot=%s", program.Disassemble(), decoded.Disassemble())
+	}
+	for i, c := range program.Constants {
+		if _, ok := c.(*object.CompiledFunction); ok {
+			continue
+		}
+		if got := decoded.Constants[i]; got.Inspect() != c.Inspect() {
+			t.Errorf("constant %d changed. want=%s, got=%s", i, c.Inspect(), got.Inspect())
+		}
+	}
+}
+
+func TestStripPositions(t *testing.T) {
+	program := compile(t, "let f = fn(a: int) { a / 0 }; f(1);")
+	full := encode(t, program, Options{})
+	stripped := encode(t, program, Options{StripPositions: true})
+	if len(stripped) >= len(full) {
+		t.Errorf("stripping positions did not shrink the file. full=%d, stripped=%d", len(full), len(stripped))
+	}
+
+	decoded, err := Decode(stripped)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if decoded.Positions != nil {
+		t.Errorf("stripped file has positions: %v", decoded.Positions)
+	}
+	if decoded.Instructions.String() != program.Instructions.String() {
+		t.Errorf("instructions changed.\nwant=%s\ngot=%s", program.Instructions, decoded.Instructions)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	bytecode := &compiler.Bytecode{Constants: []object.Object{&object.Error{Message: "boom"}}}
+	if err := Write(&bytes.Buffer{}, bytecode, Options{}); err == nil {
+		t.Errorf("expected an error for an error constant")
+	}
+}
+
+// resum replaces the checksum, so the corruption under test is the one
+// the decoder has to find
+func resum(data []byte) []byte {
+	body := data[:len(data)-4]
+	return binary.BigEndian.AppendUint32(append([]byte{}, body...), crc32.ChecksumIEEE(body))
+}
+
+func TestCorruptInput(t *testing.T) {
+	good := encode(t, compile(t, "let f = fn(a) { if (a > 1) { a } else { 0 } }; f(2);"), Options{})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("GOSX"), good[4:]...)},
+		{"other version", append(append([]byte(Magic), 0, 99), good[6:]...)},
+		{"flipped bit", func() []byte {
+			data := append([]byte{}, good...)
+			data[len(data)/2] ^= 0x10
+			return data
+		}()},
+		{"trailing data", resum(append(append([]byte{}, good[:len(good)-4]...), 0, 0, 0, 0, 0))},
+		{"unknown flag", func() []byte {
+			data := append([]byte{}, good...)
+			data[6] |= 0x80
+			return resum(data)
+		}()},
+		{"huge count", resum(append(append(append([]byte{}, good[:7]...), 0xff, 0xff, 0xff, 0xff, 0x0f), 0, 0, 0, 0))},
+		{"bad big integer", func() []byte {
+			data := encode(t, compile(t, "99999999999999999999"), Options{})
+			i := bytes.Index(data, []byte("9999"))
+			data[i] = 'x'
+			return resum(data)
+		}()},
+	}
+	for i := 0; i < len(good)-4; i++ {
+		tests = append(tests, struct {
+			name string
+			data []byte
+		}{"truncated", resum(append(append([]byte{}, good[:i]...), 0, 0, 0, 0))})
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(tt.data)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected ErrCorrupt, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestInvalidInstructions(t *testing.T) {
+	ins := func(parts ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		bytecode *compiler.Bytecode
+	}{
+		{"unknown opcode", &compiler.Bytecode{Instructions: code.Instructions{255}}},
+		{"cut short", &compiler.Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2]}},
+		{"missing constant", &compiler.Bytecode{Instructions: code.Make(code.OpConstant, 0)}},
+		{"missing global", &compiler.Bytecode{Instructions: code.Make(code.OpGetGlobal, 0)}},
+		{"local outside a function", &compiler.Bytecode{Instructions: code.Make(code.OpGetLocal, 0)}},
+		{"missing builtin", &compiler.Bytecode{Instructions: code.Make(code.OpGetBuiltin, 200)}},
+		{"jump past the end", &compiler.Bytecode{Instructions: code.Make(code.OpJump, 100)}},
+		{"jump into an instruction", &compiler.Bytecode{Instructions: ins(code.Make(code.OpJump, 4), code.Make(code.OpConstant, 0)), Constants: []object.Object{&object.Integer{}}}},
+		{"check without an annotation", &compiler.Bytecode{Instructions: code.Make(code.OpCheckType, 0), Constants: []object.Object{&object.Integer{}}}},
+		{"free variable outside a closure", &compiler.Bytecode{Instructions: code.Make(code.OpGetFree, 0)}},
+		{"cell outside a function", &compiler.Bytecode{Instructions: code.Make(code.OpCellRef, 0)}},
+		{"closure of a non-function", &compiler.Bytecode{Instructions: code.Make(code.OpClosure, 0, 0), Constants: []object.Object{&object.Integer{}}}},
+		{"closure with the wrong free count", &compiler.Bytecode{Instructions: code.Make(code.OpClosure, 0, 1), Constants: []object.Object{&object.CompiledFunction{}}}},
+		{"pop from an empty stack", &compiler.Bytecode{Instructions: code.Make(code.OpPop)}},
+		{"call without a function", &compiler.Bytecode{Instructions: ins(code.Make(code.OpTrue), code.Make(code.OpCall, 1))}},
+		{"paths with different stacks", &compiler.Bytecode{Instructions: ins(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 5), code.Make(code.OpTrue), code.Make(code.OpPop))}},
+		{"closure capturing a value", &compiler.Bytecode{
+			Instructions: ins(code.Make(code.OpTrue), code.Make(code.OpClosure, 0, 1)),
+			Constants:    []object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpReturn), FreeNames: []string{"x"}}},
+		}},
+		{"function running off its end", &compiler.Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 0),
+			Constants:    []object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpNull)}},
+		}},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(encode(t, tt.bytecode, Options{}))
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected ErrCorrupt, got %v", tt.name, err)
+		}
+	}
+}
+
+// Whatever a changed byte leaves of a program either fails to decode or
+// runs, perhaps to an error, without crashing the VM
+func TestMutatedPrograms(t *testing.T) {
+	good := encode(t, compile(t, `
+let f = fn(a, g) { if (a > 1) { g(a) } else { 0 } };
+if let v = some(f(2, fn(x) { x * 2 })) { v } else { -1 };
+let counter = fn() { let n = 0; fn() { n = n + 1 } };
+counter()();
+`), Options{})
+
+	for i := len(Magic) + 2; i < len(good)-4; i++ {
+		for _, delta := range []byte{1, 2, 0x10, 0x80, 0xff} {
+			data := append([]byte{}, good...)
+			data[i] += delta
+			bytecode, err := Decode(resum(data))
+			if err != nil {
+				continue
+			}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("byte %d + %d: the VM panicked: %v", i, delta, r)
+					}
+				}()
+				vm.New(bytecode, evaluator.Options{MaxSteps: 10000}).Run(context.Background())
+			}()
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	source := []byte("let a = 1; a + 1")
+	program := compile(t, string(source))
+
+	if _, ok := cache.Load("a.gos", source); ok {
+		t.Fatalf("empty cache returned a program")
+	}
+	if err := cache.Store("a.gos", source, program); err != nil {
+		t.Fatalf("store failed: %s", err)
+	}
+
+	loaded, ok := cache.Load("a.gos", source)
+	if !ok {
+		t.Fatalf("stored program was not loaded")
+	}
+	if loaded.Instructions.String() != program.Instructions.String() {
+		t.Errorf("cached instructions differ.\nwant=%s\ngot=%s", program.Instructions, loaded.Instructions)
+	}
+	if _, ok := cache.Load("a.gos", []byte("let a = 2; a + 1")); ok {
+		t.Errorf("changed source hit the cache")
+	}
+	if _, ok := cache.Load("b.gos", source); ok {
+		t.Errorf("same source under another name hit the cache")
+	}
+}
+
+func TestProgramRoundTrip(t *testing.T) {
+	for _, path := range golden.Programs(t) {
+		program := golden.ParseFile(t, path)
+		data, err := EncodeProgram(path, program)
+		if err != nil {
+			t.Fatalf("%s: encode failed: %s", path, err)
+		}
+		decoded, err := DecodeProgram(data)
+		if err != nil {
+			t.Fatalf("%s: decode failed: %s", path, err)
+		}
+		if !reflect.DeepEqual(decoded, program) {
+			t.Errorf("%s: round trip changed the program.\nwant=%s\ngot=%s", path, program, decoded)
+		}
+	}
+
+	program := golden.Parse(t, `// a comment
+let f = fn<T>(x: T, g: fn() -> [T]) -> {string: Option<T>} { return; };
+if let v = some(1) { v = -v } else { none };
+for (!false) { 99999999999999999999 % 2 };
+"s" + f(1, fn() { none })`)
+	data, err := EncodeProgram("", program)
+	if err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+	decoded, err := DecodeProgram(data)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, program) {
+		t.Errorf("round trip changed the program.\nwant=%s\ngot=%s", program, decoded)
+	}
+}
+
+func TestCorruptProgram(t *testing.T) {
+	good, err := EncodeProgram("a.gos", golden.Parse(t, "let f = fn(a) { if (a > 1) { a } else { 0 } }; f(2);"))
+	if err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+
+	tests := [][]byte{
+		nil,
+		append([]byte(Magic), good[4:]...),
+		append(append([]byte(ProgramMagic), 0, 99), good[6:]...),
+		resum(append(append([]byte{}, good[:len(good)-4]...), 0)),
+	}
+	for i := 0; i < len(good)-4; i++ {
+		tests = append(tests, resum(append(append([]byte{}, good[:i]...), 0, 0, 0, 0)))
+	}
+	for i := 6; i < len(good)-4; i++ {
+		// any byte may be a tag, so a changed one must not make a node
+		// without the parts the evaluator reads
+		data := append([]byte{}, good...)
+		data[i] ^= 0x07
+		tests = append(tests, resum(data))
+	}
+
+	for i, data := range tests {
+		program, err := DecodeProgram(data)
+		if err == nil {
+			resolve.Program(program)
+			evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{MaxSteps: 10000})
+			continue
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("test %d: expected ErrCorrupt, got %v", i, err)
+		}
+	}
+}
+
+func TestProgramCache(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	source := []byte("let a = 1; a + 1")
+	program := golden.Parse(t, string(source))
+
+	if _, ok := cache.LoadProgram("a.gos", source); ok {
+		t.Fatalf("empty cache returned a program")
+	}
+	if err := cache.StoreProgram("a.gos", source, program); err != nil {
+		t.Fatalf("store failed: %s", err)
+	}
+	loaded, ok := cache.LoadProgram("a.gos", source)
+	if !ok {
+		t.Fatalf("stored program was not loaded")
+	}
+	if !reflect.DeepEqual(loaded, program) {
+		t.Errorf("cached program differs.\nwant=%s\ngot=%s", program, loaded)
+	}
+	if _, ok := cache.LoadProgram("a.gos", []byte("let a = 2; a + 1")); ok {
+		t.Errorf("changed source hit the cache")
+	}
+	if _, ok := cache.Load("a.gos", source); ok {
+		t.Errorf("a parsed program was loaded as bytecode")
+	}
+}
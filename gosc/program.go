@@ -0,0 +1,380 @@
+package gosc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gosling/ast"
+	"gosling/token"
+	"hash/crc32"
+	"math/big"
+)
+
+// A parsed program, which the cache keeps for the tree walker, is laid
+// out as
+//
+//	magic     "GOSP"
+//	version   uint16, the same Version as bytecode
+//	filename  string, the source file every location refers to
+//	comments  count, then one token each
+//	program   count, then one statement each
+//	checksum  uint32, CRC-32 (IEEE) of everything before it
+//
+// A token is its type, literal, line, character and whether it has the
+// filename. A node is its tag and token, then its fields in the order
+// package ast declares them, with nodeNil for a missing one.
+
+// ProgramMagic starts every encoded parsed program
+const ProgramMagic = "GOSP"
+
+// ProgramExt is the extension of parsed programs in the cache
+const ProgramExt = ".gosp"
+
+// node tags
+const (
+	nodeNil byte = iota
+	nodeLet
+	nodeReturn
+	nodeExpression
+	nodeBlock
+	nodeIdentifier
+	nodeInteger
+	nodeString
+	nodeBoolean
+	nodeNone
+	nodePrefix
+	nodeInfix
+	nodeIf
+	nodeFor
+	nodeFunction
+	nodeAssign
+	nodeCall
+)
+
+// EncodeProgram encodes a parsed program read from filename. It is
+// encoded as the parser made it, so a program that has been through a
+// resolver or an optimising pass comes back without what they did.
+func EncodeProgram(filename string, program *ast.Program) ([]byte, error) {
+	e := &encoder{positions: true, filename: filename}
+	e.buf = append(e.buf, ProgramMagic...)
+	e.buf = binary.BigEndian.AppendUint16(e.buf, Version)
+	e.string(filename)
+
+	e.uvarint(len(program.Comments))
+	for _, c := range program.Comments {
+		e.token(c)
+	}
+	e.uvarint(len(program.Statements))
+	for _, s := range program.Statements {
+		e.node(s)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	e.buf = binary.BigEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf))
+	return e.buf, nil
+}
+
+func (e *encoder) token(tok token.Token) {
+	if tok.Location.Filename != "" && tok.Location.Filename != e.filename && e.err == nil {
+		e.err = fmt.Errorf("cannot encode a token from %s in a program from %s", tok.Location.Filename, e.filename)
+	}
+	e.string(string(tok.Type))
+	e.string(tok.Literal)
+	e.location(tok.Location)
+	e.bool(tok.Location.Filename != "")
+}
+
+func (e *encoder) node(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		e.buf = append(e.buf, nodeLet)
+		e.token(node.Token)
+		e.node(node.Name)
+		e.node(node.Value)
+	case *ast.ReturnStatement:
+		e.buf = append(e.buf, nodeReturn)
+		e.token(node.Token)
+		e.node(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		e.buf = append(e.buf, nodeExpression)
+		e.token(node.Token)
+		e.node(node.Expression)
+	case *ast.BlockStatement:
+		e.buf = append(e.buf, nodeBlock)
+		e.token(node.Token)
+		e.uvarint(len(node.Statements))
+		for _, s := range node.Statements {
+			e.node(s)
+		}
+	case *ast.Identifier:
+		e.buf = append(e.buf, nodeIdentifier)
+		e.token(node.Token)
+		e.string(node.Value)
+		e.typeExpression(node.Type)
+	case *ast.IntegerLiteral:
+		e.buf = append(e.buf, nodeInteger)
+		e.token(node.Token)
+		e.varint(node.Value)
+		e.bool(node.Big != nil)
+		if node.Big != nil {
+			e.string(node.Big.String())
+		}
+	case *ast.StringLiteral:
+		e.buf = append(e.buf, nodeString)
+		e.token(node.Token)
+		e.string(node.Value)
+	case *ast.Boolean:
+		e.buf = append(e.buf, nodeBoolean)
+		e.token(node.Token)
+		e.bool(node.Value)
+	case *ast.NoneLiteral:
+		e.buf = append(e.buf, nodeNone)
+		e.token(node.Token)
+	case *ast.PrefixExpression:
+		e.buf = append(e.buf, nodePrefix)
+		e.token(node.Token)
+		e.string(node.Operator)
+		e.node(node.Right)
+	case *ast.InfixExpression:
+		e.buf = append(e.buf, nodeInfix)
+		e.token(node.Token)
+		e.node(node.Left)
+		e.string(node.Operator)
+		e.node(node.Right)
+	case *ast.IfExpression:
+		e.buf = append(e.buf, nodeIf)
+		e.token(node.Token)
+		if node.Binding != nil {
+			e.node(node.Binding)
+		} else {
+			e.buf = append(e.buf, nodeNil)
+		}
+		e.node(node.Condition)
+		e.node(node.Consequence)
+		if node.Alternative != nil {
+			e.node(node.Alternative)
+		} else {
+			e.buf = append(e.buf, nodeNil)
+		}
+	case *ast.ForExpression:
+		e.buf = append(e.buf, nodeFor)
+		e.token(node.Token)
+		e.node(node.Condition)
+		e.node(node.Body)
+	case *ast.FunctionLiteral:
+		e.buf = append(e.buf, nodeFunction)
+		e.token(node.Token)
+		e.uvarint(len(node.TypeParameters))
+		for _, tp := range node.TypeParameters {
+			e.node(tp)
+		}
+		e.uvarint(len(node.Parameters))
+		for _, p := range node.Parameters {
+			e.node(p)
+		}
+		e.typeExpression(node.ReturnType)
+		e.node(node.Body)
+	case *ast.AssignExpression:
+		e.buf = append(e.buf, nodeAssign)
+		e.token(node.Token)
+		e.node(node.Name)
+		e.node(node.Value)
+	case *ast.CallExpression:
+		e.buf = append(e.buf, nodeCall)
+		e.token(node.Token)
+		e.node(node.Function)
+		e.uvarint(len(node.Arguments))
+		for _, a := range node.Arguments {
+			e.node(a)
+		}
+	default:
+		if node != nil && e.err == nil {
+			e.err = fmt.Errorf("cannot encode a %T", node)
+		}
+		e.buf = append(e.buf, nodeNil)
+	}
+}
+
+// DecodeProgram decodes a parsed program encoded by EncodeProgram. Data
+// that does not hold together gives an error wrapping ErrCorrupt, and
+// every node the parser always fills in is there in what it returns.
+func DecodeProgram(data []byte) (*ast.Program, error) {
+	header := len(ProgramMagic) + 2
+	if len(data) < header || string(data[:len(ProgramMagic)]) != ProgramMagic {
+		return nil, fmt.Errorf("%w: not a gosling program file", ErrCorrupt)
+	}
+	if version := binary.BigEndian.Uint16(data[len(ProgramMagic):]); version != Version {
+		return nil, fmt.Errorf("%w: unsupported version %d, want %d", ErrCorrupt, version, Version)
+	}
+	if len(data) < header+4 {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrCorrupt)
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	d := &decoder{data: body, pos: header, positions: true}
+	d.filename = d.string()
+	program := &ast.Program{}
+	if n := d.count(); n > 0 {
+		program.Comments = make([]token.Token, n)
+		for i := range program.Comments {
+			program.Comments[i] = d.token()
+		}
+	}
+	program.Statements = d.statements()
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("%d bytes of trailing data", len(d.data)-d.pos)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return program, nil
+}
+
+func (d *decoder) token() token.Token {
+	tok := token.Token{Type: token.TokenType(d.string()), Literal: d.string()}
+	tok.Location = d.location()
+	if d.bool() {
+		tok.Location.Filename = d.filename
+	} else {
+		tok.Location.Filename = ""
+	}
+	return tok
+}
+
+func (d *decoder) statements() []ast.Statement {
+	statements := make([]ast.Statement, d.count())
+	for i := range statements {
+		statements[i] = d.statement()
+	}
+	return statements
+}
+
+// node reads a node of any kind, nil for nodeNil. The methods below read
+// one of a kind, failing if it is missing unless they allow that.
+func (d *decoder) node() ast.Node {
+	tag := d.byte()
+	if tag == nodeNil || d.err != nil {
+		return nil
+	}
+	tok := d.token()
+
+	switch tag {
+	case nodeLet:
+		return &ast.LetStatement{Token: tok, Name: d.identifier(false), Value: d.expression(false)}
+	case nodeReturn:
+		return &ast.ReturnStatement{Token: tok, ReturnValue: d.expression(true)}
+	case nodeExpression:
+		return &ast.ExpressionStatement{Token: tok, Expression: d.expression(false)}
+	case nodeBlock:
+		return &ast.BlockStatement{Token: tok, Statements: d.statements()}
+	case nodeIdentifier:
+		return &ast.Identifier{Token: tok, Value: d.string(), Type: d.typeExpression(0)}
+	case nodeInteger:
+		literal := &ast.IntegerLiteral{Token: tok, Value: d.varint()}
+		if d.bool() {
+			text := d.string()
+			value, ok := new(big.Int).SetString(text, 10)
+			if !ok {
+				d.fail("invalid big integer %q", text)
+			}
+			literal.Big = value
+		}
+		return literal
+	case nodeString:
+		return &ast.StringLiteral{Token: tok, Value: d.string()}
+	case nodeBoolean:
+		return &ast.Boolean{Token: tok, Value: d.bool()}
+	case nodeNone:
+		return &ast.NoneLiteral{Token: tok}
+	case nodePrefix:
+		return &ast.PrefixExpression{Token: tok, Operator: d.string(), Right: d.expression(false)}
+	case nodeInfix:
+		left := d.expression(false)
+		return &ast.InfixExpression{Token: tok, Left: left, Operator: d.string(), Right: d.expression(false)}
+	case nodeIf:
+		binding := d.identifier(true)
+		condition := d.expression(false)
+		consequence := d.block(false)
+		return &ast.IfExpression{Token: tok, Binding: binding, Condition: condition, Consequence: consequence, Alternative: d.block(true)}
+	case nodeFor:
+		condition := d.expression(false)
+		return &ast.ForExpression{Token: tok, Condition: condition, Body: d.block(false)}
+	case nodeFunction:
+		fn := &ast.FunctionLiteral{Token: tok}
+		if n := d.count(); n > 0 {
+			fn.TypeParameters = make([]*ast.Identifier, n)
+			for i := range fn.TypeParameters {
+				fn.TypeParameters[i] = d.identifier(false)
+			}
+		}
+		fn.Parameters = make([]*ast.Identifier, d.count())
+		for i := range fn.Parameters {
+			fn.Parameters[i] = d.identifier(false)
+		}
+		fn.ReturnType = d.typeExpression(0)
+		fn.Body = d.block(false)
+		return fn
+	case nodeAssign:
+		name := d.identifier(false)
+		return &ast.AssignExpression{Token: tok, Name: name, Value: d.expression(false)}
+	case nodeCall:
+		call := &ast.CallExpression{Token: tok, Function: d.expression(false)}
+		call.Arguments = make([]ast.Expression, d.count())
+		for i := range call.Arguments {
+			call.Arguments[i] = d.expression(false)
+		}
+		return call
+	default:
+		d.fail("unknown node tag %d", tag)
+		return nil
+	}
+}
+
+func (d *decoder) statement() ast.Statement {
+	node := d.node()
+	statement, ok := node.(ast.Statement)
+	if !ok && d.err == nil {
+		d.fail("want a statement, got %T", node)
+	}
+	return statement
+}
+
+func (d *decoder) expression(optional bool) ast.Expression {
+	node := d.node()
+	if node == nil && optional {
+		return nil
+	}
+	expression, ok := node.(ast.Expression)
+	if !ok && d.err == nil {
+		d.fail("want an expression, got %T", node)
+	}
+	return expression
+}
+
+func (d *decoder) identifier(optional bool) *ast.Identifier {
+	node := d.node()
+	if node == nil && optional {
+		return nil
+	}
+	ident, ok := node.(*ast.Identifier)
+	if !ok && d.err == nil {
+		d.fail("want an identifier, got %T", node)
+	}
+	return ident
+}
+
+func (d *decoder) block(optional bool) *ast.BlockStatement {
+	node := d.node()
+	if node == nil && optional {
+		return nil
+	}
+	block, ok := node.(*ast.BlockStatement)
+	if !ok && d.err == nil {
+		d.fail("want a block, got %T", node)
+	}
+	return block
+}
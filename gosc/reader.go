@@ -0,0 +1,536 @@
+package gosc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gosling/ast"
+	"gosling/code"
+	"gosling/compiler"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/token"
+	"hash/crc32"
+	"io"
+	"math/big"
+)
+
+// Read decodes a compiled program from r. Anything that does not hold
+// together, from a bad checksum to a jump into the middle of an
+// instruction, gives an error wrapping ErrCorrupt. Every operand of what
+// Read returns refers to something that exists, and every instruction
+// finds the values it takes on the stack, so the VM can run whatever Read
+// accepts.
+func Read(r io.Reader) (*compiler.Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Decode is Read for data already in memory
+func Decode(data []byte) (*compiler.Bytecode, error) {
+	header := len(Magic) + 2
+	if len(data) < header || string(data[:len(Magic)]) != Magic {
+		return nil, fmt.Errorf("%w: not a gosling bytecode file", ErrCorrupt)
+	}
+	if version := binary.BigEndian.Uint16(data[len(Magic):]); version != Version {
+		return nil, fmt.Errorf("%w: unsupported version %d, want %d", ErrCorrupt, version, Version)
+	}
+	if len(data) < header+4 {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrCorrupt)
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	d := &decoder{data: body, pos: header}
+	bytecode := d.program()
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("%d bytes of trailing data", len(d.data)-d.pos)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := validate(bytecode); err != nil {
+		return nil, err
+	}
+	return bytecode, nil
+}
+
+// decoder reads the parts of a file in order. The first failure is kept
+// in err, after which every read returns a zero value.
+type decoder struct {
+	data      []byte
+	pos       int
+	err       error
+	positions bool
+	filename  string
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) program() *compiler.Bytecode {
+	flags := d.byte()
+	if flags&^flagPositions != 0 {
+		d.fail("unknown flags %#x", flags)
+	}
+	d.positions = flags&flagPositions != 0
+	d.filename = d.string()
+
+	bytecode := &compiler.Bytecode{}
+	bytecode.Globals = make([]string, d.count())
+	for i := range bytecode.Globals {
+		bytecode.Globals[i] = d.string()
+	}
+	bytecode.Constants = make([]object.Object, d.count())
+	for i := range bytecode.Constants {
+		bytecode.Constants[i] = d.constant(0)
+	}
+	bytecode.Instructions, bytecode.Positions = d.instructions()
+	return bytecode
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.pos >= len(d.data) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) bool() bool {
+	switch b := d.byte(); b {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		d.fail("invalid boolean %d", b)
+		return false
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, read := binary.Uvarint(d.data[d.pos:])
+	if read <= 0 {
+		d.fail("invalid varint at offset %d", d.pos)
+		return 0
+	}
+	d.pos += read
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, read := binary.Varint(d.data[d.pos:])
+	if read <= 0 {
+		d.fail("invalid varint at offset %d", d.pos)
+		return 0
+	}
+	d.pos += read
+	return n
+}
+
+// count reads the number of items that follow. Each takes at least a
+// byte, so a count larger than what is left is corrupt, which keeps
+// allocations bounded by the size of the input.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail("count %d runs past the end of data", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// strings reads a list of strings, an empty one as nil like the
+// compiler's
+func (d *decoder) strings() []string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	list := make([]string, n)
+	for i := range list {
+		list[i] = d.string()
+	}
+	return list
+}
+
+func (d *decoder) location() token.TokenLocation {
+	if !d.positions {
+		return token.TokenLocation{}
+	}
+	loc := token.TokenLocation{Line: int(d.varint()), LineCh: int(d.varint())}
+	if loc.LineCh != 0 {
+		// characters count from 1, so 0 is an instruction with no location
+		loc.Filename = d.filename
+	}
+	return loc
+}
+
+func (d *decoder) constant(depth int) object.Object {
+	if depth > maxNesting {
+		d.fail("constants nested too deeply")
+		return nil
+	}
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+	case tagBigInt:
+		text := d.string()
+		value, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			d.fail("invalid big integer %q", text)
+			return nil
+		}
+		return &object.BigInt{Value: value}
+	case tagString:
+		return &object.String{Value: d.string()}
+	case tagBoolean:
+		if d.bool() {
+			return evaluator.TRUE
+		}
+		return evaluator.FALSE
+	case tagNull:
+		return evaluator.NULL
+	case tagOption:
+		if !d.bool() {
+			return evaluator.NONE
+		}
+		return &object.Option{Value: d.constant(depth + 1)}
+	case tagBuiltin:
+		name := d.string()
+		builtin := evaluator.LookupBuiltin(name)
+		if builtin == nil && d.err == nil {
+			d.fail("unknown builtin %q", name)
+		}
+		return builtin
+	case tagFunction:
+		return d.function()
+	case tagAnnotation:
+		what := d.string()
+		texp := d.typeExpression(0)
+		if texp == nil && d.err == nil {
+			d.fail("annotation for %s has no type", what)
+		}
+		return &object.Annotation{Annotation: texp, What: what}
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{Name: d.string()}
+	fn.NumLocals = int(d.uvarint())
+	fn.Parameters = make([]*ast.Identifier, d.count())
+	for i := range fn.Parameters {
+		name := d.string()
+		loc := d.location()
+		fn.Parameters[i] = identifier(name, loc, d.typeExpression(0))
+	}
+	fn.ReturnType = d.typeExpression(0)
+	fn.Names = d.strings()
+	fn.FreeNames = d.strings()
+	fn.Instructions, fn.Positions = d.instructions()
+
+	if d.err == nil && (len(fn.Names) != fn.NumLocals || len(fn.Parameters) > fn.NumLocals) {
+		d.fail("function %q has %d locals, %d names and %d parameters", fn.Name, fn.NumLocals, len(fn.Names), len(fn.Parameters))
+	}
+	return fn
+}
+
+func (d *decoder) instructions() (code.Instructions, code.Positions) {
+	ins := code.Instructions(d.bytes())
+	if !d.positions {
+		return ins, nil
+	}
+	positions := make(code.Positions, d.count())
+	for i := range positions {
+		offset := int(d.uvarint())
+		if d.err == nil && (offset >= len(ins) || i > 0 && offset <= positions[i-1].Offset) {
+			d.fail("position for offset %d is out of order", offset)
+		}
+		positions[i] = code.Position{Offset: offset, Location: d.location()}
+	}
+	return ins, positions
+}
+
+func (d *decoder) typeExpression(depth int) ast.TypeExpression {
+	if depth > maxNesting {
+		d.fail("type nested too deeply")
+		return nil
+	}
+	tag := d.byte()
+	if tag == typeNone {
+		return nil
+	}
+	loc := d.location()
+
+	switch tag {
+	case typeNamed:
+		name := d.string()
+		named := &ast.NamedType{Token: typeToken(tag, name, loc), Name: name, Parameter: d.bool()}
+		for i, n := 0, d.count(); i < n; i++ {
+			named.Arguments = append(named.Arguments, d.requiredType(depth+1))
+		}
+		return named
+	case typeArray:
+		return &ast.ArrayType{Token: typeToken(tag, "", loc), Element: d.requiredType(depth + 1)}
+	case typeMap:
+		key := d.requiredType(depth + 1)
+		return &ast.MapType{Token: typeToken(tag, "", loc), Key: key, Value: d.requiredType(depth + 1)}
+	case typeFunction:
+		fn := &ast.FunctionType{Token: typeToken(tag, "", loc), Parameters: []ast.TypeExpression{}}
+		for i, n := 0, d.count(); i < n; i++ {
+			fn.Parameters = append(fn.Parameters, d.requiredType(depth+1))
+		}
+		fn.Result = d.typeExpression(depth + 1)
+		return fn
+	default:
+		d.fail("unknown type tag %d", tag)
+		return nil
+	}
+}
+
+// requiredType reads a type expression that cannot be missing, such as
+// the element type of an array
+func (d *decoder) requiredType(depth int) ast.TypeExpression {
+	texp := d.typeExpression(depth)
+	if texp == nil && d.err == nil {
+		d.fail("missing type")
+	}
+	return texp
+}
+
+// validate checks every instruction of bytecode against what it refers
+// to, since the vm trusts its operands
+func validate(bytecode *compiler.Bytecode) error {
+	if err := validateInstructions(bytecode, "the program", bytecode.Instructions, false, 0, 0); err != nil {
+		return err
+	}
+	for _, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if err := validateInstructions(bytecode, "function "+fn.Inspect(), fn.Instructions, true, fn.NumLocals, len(fn.FreeNames)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateInstructions(bytecode *compiler.Bytecode, what string, ins code.Instructions, function bool, numLocals, numFree int) error {
+	numBuiltins := len(evaluator.BuiltinNames())
+	// where each instruction starts, and the jumps to check against them
+	starts := map[int]bool{len(ins): true}
+	jumps := map[int]int{}
+	fail := func(offset int, format string, a ...interface{}) error {
+		return fmt.Errorf("%w: %s, offset %d: %s", ErrCorrupt, what, offset, fmt.Sprintf(format, a...))
+	}
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return fail(i, "%s", err)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fail(i, "%s is cut short", def.Name)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		starts[i] = true
+		var bad bool
+		switch code.Opcode(ins[i]) {
+		case code.OpConstant:
+			bad = operands[0] >= len(bytecode.Constants)
+		case code.OpCheckType:
+			bad = operands[0] >= len(bytecode.Constants)
+			if !bad {
+				_, ok := bytecode.Constants[operands[0]].(*object.Annotation)
+				bad = !ok
+			}
+		case code.OpUnwrap:
+			jumps[i] = operands[0]
+			bad = operands[1] >= len(bytecode.Constants)
+			if !bad {
+				_, ok := bytecode.Constants[operands[1]].(*object.String)
+				bad = !ok
+			}
+		case code.OpJump, code.OpJumpNotTruthy:
+			jumps[i] = operands[0]
+		case code.OpGetGlobal, code.OpSetGlobal, code.OpAssignGlobal:
+			bad = operands[0] >= len(bytecode.Globals)
+		case code.OpGetLocal, code.OpSetLocal,
+			code.OpGetCell, code.OpSetCell, code.OpNewCell, code.OpCellRef:
+			bad = operands[0] >= numLocals
+		case code.OpGetFree, code.OpSetFree, code.OpFreeRef:
+			bad = operands[0] >= numFree
+		case code.OpClosure:
+			bad = operands[0] >= len(bytecode.Constants)
+			if !bad {
+				fn, ok := bytecode.Constants[operands[0]].(*object.CompiledFunction)
+				bad = !ok || len(fn.FreeNames) != operands[1]
+			}
+		case code.OpGetBuiltin:
+			bad = operands[0] >= numBuiltins
+		}
+		if bad {
+			return fail(i, "%s has an operand out of range", def.Name)
+		}
+		i += 1 + read
+	}
+	for offset, target := range jumps {
+		if !starts[target] {
+			return fail(offset, "jump to %d is not to an instruction", target)
+		}
+	}
+	return checkStack(ins, function, fail)
+}
+
+// checkStack follows every path through ins as the VM runs it, keeping the
+// values each instruction finds on the stack above the frame's locals:
+// whether each is a cell, as only OpCellRef and OpFreeRef push and only
+// OpClosure takes. No instruction may take values that are not there,
+// paths that meet must agree on the stack, and a function must return
+// rather than run off its end.
+func checkStack(ins code.Instructions, function bool, fail func(int, string, ...interface{}) error) error {
+	stacks := map[int][]bool{}
+	var work []int
+	reach := func(from, offset int, stack []bool) error {
+		if offset == len(ins) {
+			if function {
+				return fail(from, "the function runs off its end")
+			}
+			return nil
+		}
+		if seen, ok := stacks[offset]; ok {
+			if !sameStack(seen, stack) {
+				return fail(offset, "paths reach it with %d and %d values on the stack", len(seen), len(stack))
+			}
+			return nil
+		}
+		stacks[offset] = stack
+		work = append(work, offset)
+		return nil
+	}
+	if err := reach(0, 0, []bool{}); err != nil {
+		return err
+	}
+
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		def, _ := code.Lookup(ins[i])
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+
+		pops, pushes, cell := 0, 1, false
+		switch op := code.Opcode(ins[i]); op {
+		case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpNone,
+			code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree, code.OpGetCell:
+		case code.OpCellRef, code.OpFreeRef:
+			cell = true
+		case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpAssignGlobal,
+			code.OpSetLocal, code.OpSetFree, code.OpSetCell, code.OpNewCell:
+			pops, pushes = 1, 0
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			pops = 2
+		case code.OpMinus, code.OpBang, code.OpUnwrap, code.OpCheckType:
+			pops = 1
+		case code.OpClosure:
+			pops = operands[1]
+		case code.OpCall:
+			pops = operands[0] + 1
+		case code.OpReturnValue:
+			pops, pushes = 1, 0
+		case code.OpJump, code.OpReturn:
+			pushes = 0
+		}
+		stack := stacks[i]
+		if len(stack) < pops {
+			return fail(i, "%s takes %d from a stack of %d values", def.Name, pops, len(stack))
+		}
+		taken := stack[len(stack)-pops:]
+		if code.Opcode(ins[i]) == code.OpClosure {
+			for _, isCell := range taken {
+				if !isCell {
+					return fail(i, "%s captures a value that is not a cell", def.Name)
+				}
+			}
+		}
+		after := append(append([]bool{}, stack[:len(stack)-pops]...), make([]bool, pushes)...)
+		if cell {
+			after[len(after)-1] = true
+		}
+
+		var err error
+		switch code.Opcode(ins[i]) {
+		case code.OpJump:
+			err = reach(i, operands[0], after)
+		case code.OpJumpNotTruthy:
+			if err = reach(i, operands[0], after); err == nil {
+				err = reach(i, next, after)
+			}
+		case code.OpUnwrap:
+			// none jumps to the alternative without the option's value
+			if err = reach(i, operands[0], after[:len(after)-1]); err == nil {
+				err = reach(i, next, after)
+			}
+		case code.OpReturnValue, code.OpReturn:
+		default:
+			err = reach(i, next, after)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameStack(a, b []bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
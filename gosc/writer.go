@@ -0,0 +1,246 @@
+package gosc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gosling/ast"
+	"gosling/code"
+	"gosling/compiler"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/token"
+	"hash/crc32"
+	"io"
+)
+
+// Options controls what Write includes
+type Options struct {
+	// StripPositions leaves out the source positions, making files smaller
+	// at the cost of run time errors without locations
+	StripPositions bool
+}
+
+// Write encodes bytecode to w
+func Write(w io.Writer, bytecode *compiler.Bytecode, opts Options) error {
+	e := &encoder{positions: !opts.StripPositions}
+	if e.positions {
+		filename, err := sourceFile(bytecode)
+		if err != nil {
+			return err
+		}
+		e.filename = filename
+	}
+
+	e.buf = append(e.buf, Magic...)
+	e.buf = binary.BigEndian.AppendUint16(e.buf, Version)
+	var flags byte
+	if e.positions {
+		flags |= flagPositions
+	}
+	e.buf = append(e.buf, flags)
+	e.string(e.filename)
+
+	e.uvarint(len(bytecode.Globals))
+	for _, name := range bytecode.Globals {
+		e.string(name)
+	}
+	e.uvarint(len(bytecode.Constants))
+	for _, c := range bytecode.Constants {
+		if err := e.constant(c, 0); err != nil {
+			return err
+		}
+	}
+	e.instructions(bytecode.Instructions, bytecode.Positions)
+
+	e.buf = binary.BigEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf))
+	_, err := w.Write(e.buf)
+	return err
+}
+
+type encoder struct {
+	buf       []byte
+	positions bool
+	filename  string
+	// err is the first node EncodeProgram could not encode
+	err error
+}
+
+func (e *encoder) uvarint(n int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+func (e *encoder) varint(n int64) {
+	e.buf = binary.AppendVarint(e.buf, n)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// location writes a line and character, the filename being the file's
+func (e *encoder) location(loc token.TokenLocation) {
+	if !e.positions {
+		return
+	}
+	e.varint(int64(loc.Line))
+	e.varint(int64(loc.LineCh))
+}
+
+func (e *encoder) constant(obj object.Object, depth int) error {
+	if depth > maxNesting {
+		return fmt.Errorf("cannot encode %s: nested too deeply", obj.Inspect())
+	}
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf = append(e.buf, tagInteger)
+		e.varint(obj.Value)
+	case *object.BigInt:
+		e.buf = append(e.buf, tagBigInt)
+		e.string(obj.Value.String())
+	case *object.String:
+		e.buf = append(e.buf, tagString)
+		e.string(obj.Value)
+	case *object.Boolean:
+		e.buf = append(e.buf, tagBoolean)
+		e.bool(obj.Value)
+	case *object.Null:
+		e.buf = append(e.buf, tagNull)
+	case *object.Option:
+		e.buf = append(e.buf, tagOption)
+		e.bool(obj.Value != nil)
+		if obj.Value != nil {
+			return e.constant(obj.Value, depth+1)
+		}
+	case *object.Builtin:
+		name, ok := builtinName(obj)
+		if !ok {
+			return fmt.Errorf("cannot encode a builtin that is not one of the language's")
+		}
+		e.buf = append(e.buf, tagBuiltin)
+		e.string(name)
+	case *object.CompiledFunction:
+		e.buf = append(e.buf, tagFunction)
+		e.function(obj)
+	case *object.Annotation:
+		e.buf = append(e.buf, tagAnnotation)
+		e.string(obj.What)
+		e.typeExpression(obj.Annotation)
+	default:
+		return fmt.Errorf("cannot encode a constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.string(fn.Name)
+	e.uvarint(fn.NumLocals)
+	e.uvarint(len(fn.Parameters))
+	for _, p := range fn.Parameters {
+		e.string(p.Value)
+		e.location(p.Location())
+		e.typeExpression(p.Type)
+	}
+	e.typeExpression(fn.ReturnType)
+	e.uvarint(len(fn.Names))
+	for _, name := range fn.Names {
+		e.string(name)
+	}
+	e.uvarint(len(fn.FreeNames))
+	for _, name := range fn.FreeNames {
+		e.string(name)
+	}
+	e.instructions(fn.Instructions, fn.Positions)
+}
+
+func (e *encoder) instructions(ins code.Instructions, positions code.Positions) {
+	e.uvarint(len(ins))
+	e.buf = append(e.buf, ins...)
+	if !e.positions {
+		return
+	}
+	e.uvarint(len(positions))
+	for _, p := range positions {
+		e.uvarint(p.Offset)
+		e.location(p.Location)
+	}
+}
+
+func (e *encoder) typeExpression(texp ast.TypeExpression) {
+	switch texp := texp.(type) {
+	case *ast.NamedType:
+		e.buf = append(e.buf, typeNamed)
+		e.location(texp.Location())
+		e.string(texp.Name)
+		e.bool(texp.Parameter)
+		e.uvarint(len(texp.Arguments))
+		for _, a := range texp.Arguments {
+			e.typeExpression(a)
+		}
+	case *ast.ArrayType:
+		e.buf = append(e.buf, typeArray)
+		e.location(texp.Location())
+		e.typeExpression(texp.Element)
+	case *ast.MapType:
+		e.buf = append(e.buf, typeMap)
+		e.location(texp.Location())
+		e.typeExpression(texp.Key)
+		e.typeExpression(texp.Value)
+	case *ast.FunctionType:
+		e.buf = append(e.buf, typeFunction)
+		e.location(texp.Location())
+		e.uvarint(len(texp.Parameters))
+		for _, p := range texp.Parameters {
+			e.typeExpression(p)
+		}
+		e.typeExpression(texp.Result)
+	default:
+		e.buf = append(e.buf, typeNone)
+	}
+}
+
+// sourceFile returns the one file the locations in bytecode refer to
+func sourceFile(bytecode *compiler.Bytecode) (string, error) {
+	filename := ""
+	check := func(positions code.Positions) error {
+		for _, p := range positions {
+			if p.Location.Filename == "" || p.Location.Filename == filename {
+				continue
+			}
+			if filename != "" {
+				return fmt.Errorf("cannot encode positions from both %s and %s", filename, p.Location.Filename)
+			}
+			filename = p.Location.Filename
+		}
+		return nil
+	}
+
+	if err := check(bytecode.Positions); err != nil {
+		return "", err
+	}
+	for _, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if err := check(fn.Positions); err != nil {
+				return "", err
+			}
+		}
+	}
+	return filename, nil
+}
+
+func builtinName(builtin *object.Builtin) (string, bool) {
+	for _, name := range evaluator.BuiltinNames() {
+		if evaluator.LookupBuiltin(name) == builtin {
+			return name, true
+		}
+	}
+	return "", false
+}
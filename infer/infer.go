@@ -0,0 +1,689 @@
+// Package infer gives every expression of a program one static type, for
+// the backends of gosling build that compile to languages without the
+// evaluator's dynamic values.
+//
+// Only a subset of the language can be typed this way: integers that fit
+// in 64 bits, booleans, strings, and functions taking and returning them,
+// bound with let and changed with =, with if, for and return. Types are
+// inferred by unification without generalising, so a function has a single
+// type and a binding keeps the type it is first given. Anything else is
+// reported as an unsupported construct naming the node.
+//
+// Names are scoped statically, as the resolver scopes them: a function
+// owns its parameters and every let in its body outside nested functions,
+// and the program owns the rest. A name must be bound before it is read,
+// which Check enforces within each function. Globals read by a function
+// are taken to be bound by the time it is called.
+package infer
+
+import (
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/token"
+	"gosling/typecheck"
+	"gosling/types"
+	"strconv"
+	"strings"
+)
+
+// Null is the type of what has no value, such as a let statement, an if
+// without an else or the result of a function that returns nothing
+var Null types.Type = &nullType{}
+
+type nullType struct{}
+
+func (t *nullType) IsType(other types.Type) bool {
+	_, ok := types.Prune(other).(*nullType)
+	return ok
+}
+func (t *nullType) IsAssignableTo(other types.Type) bool { return t.IsType(other) }
+func (t *nullType) Kind() string                         { return "NULL" }
+func (t *nullType) String() string                       { return "null" }
+
+// Info is what Check learned about a program
+type Info struct {
+	// Types maps every expression to its type, fully resolved
+	Types map[ast.Expression]types.Type
+	// Bindings maps every identifier naming a binding, be it a let name,
+	// a parameter, an assignment target or a use, to the binding. Names of
+	// builtins are left out.
+	Bindings  map[*ast.Identifier]*Binding
+	Functions map[*ast.FunctionLiteral]*Function
+	// Globals are the bindings of the program, in the order of their
+	// first let
+	Globals []*Binding
+}
+
+// Binding is a name bound by one or more lets or as a parameter
+type Binding struct {
+	Name  string
+	Type  types.Type
+	Owner *Function // nil for globals
+	// Captured is set for a local used by a function nested in its owner
+	Captured bool
+	// Function is set when the binding is let once, to a function literal,
+	// and never assigned, so calls through it always reach that function
+	Function *Function
+
+	lets     int
+	assigned bool
+	literal  *ast.FunctionLiteral
+	first    token.TokenLocation
+}
+
+// Function is a function literal
+type Function struct {
+	Literal *ast.FunctionLiteral
+	Type    *types.FunctionType
+	Outer   *Function // the function it is nested in, nil at the top level
+	// Name is the name of the let binding the literal, if any
+	Name   string
+	Params []*Binding
+	// Locals are the bindings of the lets in the body
+	Locals []*Binding
+	// Free are the locals of enclosing functions that the function or the
+	// functions nested in it use, in the order they are first used
+	Free []*Binding
+}
+
+// Len is the type of the only builtin the backends support
+var Len = &types.FunctionType{Parameters: []types.Type{typecheck.String}, Result: typecheck.Integer}
+
+// Check types program, reporting everything outside the subset that can be
+// typed statically
+func Check(program *ast.Program) (*Info, []diag.Diagnostic) {
+	c := &checker{
+		info: &Info{
+			Types:     make(map[ast.Expression]types.Type),
+			Bindings:  make(map[*ast.Identifier]*Binding),
+			Functions: make(map[*ast.FunctionLiteral]*Function),
+		},
+		bound: make(map[*Binding]bool),
+	}
+
+	top := &scope{names: make(map[string]*Binding)}
+	for _, stmt := range program.Statements {
+		c.declare(stmt, top)
+	}
+	for _, stmt := range program.Statements {
+		c.bind(stmt, top)
+	}
+	for _, b := range c.all {
+		if b.lets == 1 && !b.assigned && b.literal != nil {
+			b.Function = c.info.Functions[b.literal]
+		}
+	}
+
+	for i, stmt := range program.Statements {
+		c.statement(stmt, i == len(program.Statements)-1)
+	}
+	c.finish()
+	return c.info, c.diagnostics
+}
+
+type checker struct {
+	info        *Info
+	diagnostics []diag.Diagnostic
+	all         []*Binding
+
+	// fn is the function being checked, nil for the program
+	fn *Function
+	// bound holds the bindings of fn that are certainly bound at the
+	// point being checked
+	bound map[*Binding]bool
+	// deferred checks that need the final types
+	pluses      []*ast.InfixExpression
+	comparisons []*ast.InfixExpression
+}
+
+type scope struct {
+	outer *scope
+	fn    *Function
+	names map[string]*Binding
+}
+
+func (s *scope) lookup(name string) *Binding {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func (c *checker) unsupported(node ast.Node, format string, a ...interface{}) types.Type {
+	c.diagnostics = append(c.diagnostics, diag.New(diag.UnsupportedConstruct, node.Location(), format, a...))
+	return typecheck.Dynamic
+}
+
+func (c *checker) newBinding(name string, owner *Function, loc token.TokenLocation) *Binding {
+	b := &Binding{Name: name, Type: types.NewVariable(0), Owner: owner, first: loc}
+	c.all = append(c.all, b)
+	return b
+}
+
+// declare binds every let in node to s, leaving out nested functions
+func (c *checker) declare(node ast.Node, s *scope) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			if _, ok := s.names[n.Name.Value]; !ok {
+				b := c.newBinding(n.Name.Value, s.fn, n.Name.Location())
+				s.names[n.Name.Value] = b
+				if s.fn == nil {
+					c.info.Globals = append(c.info.Globals, b)
+				} else {
+					s.fn.Locals = append(s.fn.Locals, b)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// bind links every identifier in node to its binding and works out what
+// each function captures
+func (c *checker) bind(node ast.Node, s *scope) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			fn := &Function{Literal: n, Outer: s.fn}
+			c.info.Functions[n] = fn
+			inner := &scope{outer: s, fn: fn, names: make(map[string]*Binding)}
+			for _, p := range n.Parameters {
+				b := c.newBinding(p.Value, fn, p.Location())
+				b.lets++
+				inner.names[p.Value] = b
+				fn.Params = append(fn.Params, b)
+				c.info.Bindings[p] = b
+			}
+			c.declare(n.Body, inner)
+			c.bind(n.Body, inner)
+			return false
+		case *ast.LetStatement:
+			c.bind(n.Value, s)
+			b := s.names[n.Name.Value]
+			b.lets++
+			if lit, ok := n.Value.(*ast.FunctionLiteral); ok {
+				b.literal = lit
+				c.info.Functions[lit].Name = n.Name.Value
+			}
+			c.info.Bindings[n.Name] = b
+			return false
+		case *ast.AssignExpression:
+			c.bind(n.Value, s)
+			if b := c.use(n.Name, s); b != nil {
+				b.assigned = true
+			}
+			return false
+		case *ast.IfExpression:
+			// if let is reported when types are checked
+			if n.Binding != nil {
+				return false
+			}
+		case *ast.Identifier:
+			c.use(n, s)
+		}
+		return true
+	})
+}
+
+func (c *checker) use(ident *ast.Identifier, s *scope) *Binding {
+	b := s.lookup(ident.Value)
+	if b == nil {
+		return nil
+	}
+	c.info.Bindings[ident] = b
+	if b.Owner != nil && b.Owner != s.fn {
+		b.Captured = true
+		for fn := s.fn; fn != b.Owner; fn = fn.Outer {
+			if !contains(fn.Free, b) {
+				fn.Free = append(fn.Free, b)
+			}
+		}
+	}
+	return b
+}
+
+func contains(list []*Binding, b *Binding) bool {
+	for _, other := range list {
+		if other == b {
+			return true
+		}
+	}
+	return false
+}
+
+// unify makes a and b the same type, reporting at node if they cannot be
+func (c *checker) unify(node ast.Node, a, b types.Type) bool {
+	if err := types.Unify(a, b); err != nil {
+		c.unsupported(node, "cannot compile %s: %s", describe(node), err)
+		return false
+	}
+	return true
+}
+
+// statement checks stmt and reports whether running it may go on to the
+// next statement. Only the value of the last statement of a function or
+// of the program is used.
+func (c *checker) statement(stmt ast.Statement, used bool) bool {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		t := c.expression(stmt.Value, true)
+		b := c.info.Bindings[stmt.Name]
+		if stmt.Name.Type != nil {
+			if want, ok := c.annotation(stmt.Name.Type); ok {
+				c.unify(stmt, want, t)
+			}
+		}
+		if types.Prune(t) == Null {
+			c.unsupported(stmt, "cannot compile %s: the value has no type, as it may not produce one", describe(stmt))
+		} else {
+			c.unify(stmt, b.Type, t)
+		}
+		c.bound[b] = true
+	case *ast.ReturnStatement:
+		if c.fn == nil {
+			c.unsupported(stmt, "cannot compile a return statement outside a function")
+			return false
+		}
+		t := Null
+		if stmt.ReturnValue != nil {
+			t = c.expression(stmt.ReturnValue, true)
+		}
+		c.unify(stmt, c.fn.Type.Result, t)
+		return false
+	case *ast.ExpressionStatement:
+		if ie, ok := stmt.Expression.(*ast.IfExpression); ok {
+			t, falls := c.ifExpression(ie, used)
+			c.info.Types[ie] = t
+			return falls
+		}
+		c.expression(stmt.Expression, used)
+	}
+	return true
+}
+
+// block checks the statements of b, returning the type of its value and
+// whether running it may reach its end
+func (c *checker) block(b *ast.BlockStatement, used bool) (types.Type, bool) {
+	if b == nil || len(b.Statements) == 0 {
+		return Null, true
+	}
+	falls := true
+	for i, stmt := range b.Statements {
+		if !c.statement(stmt, used && i == len(b.Statements)-1) {
+			falls = false
+		}
+	}
+	if last, ok := b.Statements[len(b.Statements)-1].(*ast.ExpressionStatement); ok && used {
+		return c.info.Types[last.Expression], falls
+	}
+	return Null, falls
+}
+
+// branch checks a block that may not run, forgetting what it binds
+func (c *checker) branch(b *ast.BlockStatement, used bool) (types.Type, bool, map[*Binding]bool) {
+	saved := c.bound
+	c.bound = make(map[*Binding]bool, len(saved))
+	for b, ok := range saved {
+		c.bound[b] = ok
+	}
+	t, falls := c.block(b, used)
+	bound := c.bound
+	c.bound = saved
+	return t, falls, bound
+}
+
+func (c *checker) ifExpression(ie *ast.IfExpression, used bool) (types.Type, bool) {
+	if ie.Binding != nil {
+		return c.unsupported(ie, "cannot compile %s: options are not supported", describe(ie)), true
+	}
+	c.condition(ie.Condition)
+	cons, consFalls, consBound := c.branch(ie.Consequence, used)
+	if ie.Alternative == nil {
+		return Null, true
+	}
+	alt, altFalls, altBound := c.branch(ie.Alternative, used)
+	// what both branches bind is bound after the if
+	for b := range consBound {
+		if altBound[b] {
+			c.bound[b] = true
+		}
+	}
+	switch {
+	case !used:
+		return Null, consFalls || altFalls
+	case consFalls && altFalls:
+		c.unify(ie, cons, alt)
+		return cons, true
+	case consFalls:
+		return cons, true
+	case altFalls:
+		return alt, true
+	}
+	return Null, false
+}
+
+func (c *checker) condition(exp ast.Expression) {
+	t := c.expression(exp, true)
+	if err := types.Unify(typecheck.Boolean, t); err != nil {
+		c.unsupported(exp, "cannot compile the condition %s: it has type %s, only booleans are supported", exp.String(), types.Prune(t))
+	}
+}
+
+// expression checks exp and records its type
+func (c *checker) expression(exp ast.Expression, used bool) types.Type {
+	t := c.infer(exp, used)
+	c.info.Types[exp] = t
+	return t
+}
+
+func (c *checker) infer(exp ast.Expression, used bool) types.Type {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			return c.unsupported(exp, "cannot compile the integer literal %s: it does not fit in 64 bits", exp.String())
+		}
+		return typecheck.Integer
+	case *ast.Boolean:
+		return typecheck.Boolean
+	case *ast.StringLiteral:
+		return typecheck.String
+	case *ast.NoneLiteral:
+		return c.unsupported(exp, "cannot compile none: options are not supported")
+	case *ast.Identifier:
+		return c.identifier(exp)
+	case *ast.PrefixExpression:
+		right := c.expression(exp.Right, true)
+		switch exp.Operator {
+		case "!":
+			c.unify(exp, typecheck.Boolean, right)
+			return typecheck.Boolean
+		case "-":
+			c.unify(exp, typecheck.Integer, right)
+			return typecheck.Integer
+		}
+		return c.unsupported(exp, "cannot compile the operator %s", exp.Operator)
+	case *ast.InfixExpression:
+		return c.infix(exp)
+	case *ast.IfExpression:
+		t, _ := c.ifExpression(exp, used)
+		return t
+	case *ast.ForExpression:
+		c.condition(exp.Condition)
+		c.branch(exp.Body, false)
+		return Null
+	case *ast.FunctionLiteral:
+		return c.function(exp)
+	case *ast.CallExpression:
+		return c.call(exp)
+	case *ast.AssignExpression:
+		value := c.expression(exp.Value, true)
+		b := c.info.Bindings[exp.Name]
+		if b == nil {
+			return c.notFound(exp.Name)
+		}
+		c.checkBound(exp.Name, b)
+		c.unify(exp, b.Type, value)
+		return value
+	}
+	return c.unsupported(exp, "cannot compile %s", describe(exp))
+}
+
+func (c *checker) identifier(ident *ast.Identifier) types.Type {
+	b := c.info.Bindings[ident]
+	if b != nil {
+		c.checkBound(ident, b)
+		return b.Type
+	}
+	switch ident.Value {
+	case "len":
+		return Len
+	case "some", "exists":
+		return c.unsupported(ident, "cannot compile the builtin %s: options are not supported", ident.Value)
+	}
+	return c.notFound(ident)
+}
+
+func (c *checker) notFound(ident *ast.Identifier) types.Type {
+	c.diagnostics = append(c.diagnostics, diag.New(diag.IdentifierNotFound, ident.Location(), "identifier not found: %s", ident.Value))
+	return typecheck.Dynamic
+}
+
+// checkBound reports a local of the current function read before a let
+// has bound it, where the evaluator would look further out
+func (c *checker) checkBound(ident *ast.Identifier, b *Binding) {
+	if b.Owner == c.fn && !c.bound[b] {
+		c.unsupported(ident, "cannot compile %s: it is used before it is bound", ident.Value)
+		c.bound[b] = true
+	}
+}
+
+func (c *checker) infix(exp *ast.InfixExpression) types.Type {
+	left := c.expression(exp.Left, true)
+	right := c.expression(exp.Right, true)
+	switch exp.Operator {
+	case "+":
+		c.pluses = append(c.pluses, exp)
+		c.unify(exp, left, right)
+		return left
+	case "-", "*", "/", "%":
+		if c.unify(exp, typecheck.Integer, left) {
+			c.unify(exp, typecheck.Integer, right)
+		}
+		return typecheck.Integer
+	case "<", ">":
+		if c.unify(exp, typecheck.Integer, left) {
+			c.unify(exp, typecheck.Integer, right)
+		}
+		return typecheck.Boolean
+	case "==", "!=":
+		c.comparisons = append(c.comparisons, exp)
+		c.unify(exp, left, right)
+		return typecheck.Boolean
+	}
+	return c.unsupported(exp, "cannot compile the operator %s", exp.Operator)
+}
+
+func (c *checker) function(lit *ast.FunctionLiteral) types.Type {
+	fn := c.info.Functions[lit]
+	if len(lit.TypeParameters) > 0 {
+		return c.unsupported(lit, "cannot compile the generic function %s: generic functions are not supported", describe(lit))
+	}
+	fn.Type = &types.FunctionType{Result: types.NewVariable(0)}
+	for i, p := range lit.Parameters {
+		t := fn.Params[i].Type
+		if p.Type != nil {
+			if want, ok := c.annotation(p.Type); ok {
+				c.unify(p, want, t)
+			}
+		}
+		fn.Type.Parameters = append(fn.Type.Parameters, t)
+	}
+	if lit.ReturnType != nil {
+		if want, ok := c.annotation(lit.ReturnType); ok {
+			c.unify(lit, fn.Type.Result, want)
+		}
+	}
+
+	// free locals of this function must be bound before the closure is
+	// made, except for the name the closure itself is being bound to
+	for _, b := range fn.Free {
+		if b.Owner == c.fn && !c.bound[b] && !(b.Name == fn.Name && b.literal == lit) {
+			c.unsupported(lit, "cannot compile %s: it captures %s before %s is bound", describe(lit), b.Name, b.Name)
+		}
+	}
+
+	outer, outerBound := c.fn, c.bound
+	c.fn, c.bound = fn, make(map[*Binding]bool)
+	for _, p := range fn.Params {
+		c.bound[p] = true
+	}
+	t, falls := c.block(lit.Body, true)
+	if falls {
+		c.unify(lit, fn.Type.Result, t)
+	}
+	c.fn, c.bound = outer, outerBound
+	return fn.Type
+}
+
+// annotation returns the type an annotation names, if the backends
+// support it
+func (c *checker) annotation(texp ast.TypeExpression) (types.Type, bool) {
+	t, err := typecheck.Resolve(texp)
+	if err != nil {
+		c.unsupported(texp, "cannot compile the annotation %s: %s", texp.String(), err)
+		return nil, false
+	}
+	if !supported(t) {
+		c.unsupported(texp, "cannot compile the annotation %s: only int, bool, string and functions of them are supported", texp.String())
+		return nil, false
+	}
+	return t, true
+}
+
+func supported(t types.Type) bool {
+	switch t := types.Prune(t).(type) {
+	case *types.IntegerType, *types.BooleanType, *types.StringType, *nullType:
+		return true
+	case *types.FunctionType:
+		if t.Variadic {
+			return false
+		}
+		for _, p := range t.Parameters {
+			if types.Prune(p) == Null || !supported(p) {
+				return false
+			}
+		}
+		return supported(t.Result)
+	}
+	return false
+}
+
+func (c *checker) call(call *ast.CallExpression) types.Type {
+	callee := c.expression(call.Function, true)
+	args := make([]types.Type, len(call.Arguments))
+	for i, a := range call.Arguments {
+		args[i] = c.expression(a, true)
+	}
+	if fn, ok := types.Prune(callee).(*types.FunctionType); ok && len(fn.Parameters) != len(args) {
+		return c.unsupported(call, "cannot compile %s: the function takes %s, not %d", describe(call), arguments(len(fn.Parameters)), len(args))
+	}
+	result := types.NewVariable(0)
+	if !c.unify(call, callee, &types.FunctionType{Parameters: args, Result: result}) {
+		return typecheck.Dynamic
+	}
+	return result
+}
+
+// finish resolves every recorded type and reports what is still unknown
+// or not supported once all of the program has been seen
+func (c *checker) finish() {
+	for _, exp := range c.pluses {
+		switch types.Prune(c.info.Types[exp.Left]).(type) {
+		case *types.IntegerType, *types.StringType, *types.DynamicType:
+		default:
+			c.unsupported(exp, "cannot compile %s: + only adds integers and joins strings", describe(exp))
+		}
+	}
+	for _, exp := range c.comparisons {
+		switch types.Prune(c.info.Types[exp.Left]).(type) {
+		case *types.IntegerType, *types.BooleanType, *types.StringType, *types.DynamicType:
+		default:
+			c.unsupported(exp, "cannot compile %s: only integers, booleans and strings can be compared", describe(exp))
+		}
+	}
+	if len(c.diagnostics) > 0 {
+		// what is left unknown is most likely a result of those
+		return
+	}
+
+	for _, b := range c.all {
+		t, ok := resolved(b.Type)
+		if !ok {
+			c.diagnostics = append(c.diagnostics, diag.New(diag.NoStaticType, b.first, "cannot work out the type of %s, annotate it", b.Name))
+			continue
+		}
+		b.Type = t
+	}
+	for _, fn := range c.info.Functions {
+		if fn.Type == nil {
+			continue
+		}
+		if t, ok := resolved(fn.Type); ok {
+			fn.Type = t.(*types.FunctionType)
+		}
+	}
+	for exp, t := range c.info.Types {
+		if t, ok := resolved(t); ok {
+			c.info.Types[exp] = t
+		} else if len(c.diagnostics) == 0 {
+			c.diagnostics = append(c.diagnostics, diag.New(diag.NoStaticType, exp.Location(), "cannot work out the type of %s", describe(exp)))
+		}
+	}
+}
+
+// resolved copies t with its variables replaced by what they stand for,
+// reporting false if one is still unknown
+func resolved(t types.Type) (types.Type, bool) {
+	switch t := types.Prune(t).(type) {
+	case *types.FunctionType:
+		fn := &types.FunctionType{Parameters: make([]types.Type, len(t.Parameters))}
+		for i, p := range t.Parameters {
+			r, ok := resolved(p)
+			if !ok {
+				return nil, false
+			}
+			fn.Parameters[i] = r
+		}
+		r, ok := resolved(t.Result)
+		if !ok {
+			return nil, false
+		}
+		fn.Result = r
+		return fn, supported(fn)
+	case *types.Variable, *types.DynamicType:
+		return nil, false
+	default:
+		return t, supported(t)
+	}
+}
+
+// describe names a node for diagnostics
+// arguments counts n arguments in words, as in "takes 1 argument"
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return strconv.Itoa(n) + " arguments"
+}
+
+func describe(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		return "let " + node.Name.Value
+	case *ast.FunctionLiteral:
+		return signature(node)
+	case *ast.IfExpression:
+		if node.Binding != nil {
+			return "if let " + node.Binding.Value
+		}
+		return "if (" + node.Condition.String() + ")"
+	case *ast.ForExpression:
+		return "for (" + node.Condition.String() + ")"
+	}
+	return node.String()
+}
+
+// signature is fn followed by the names of the parameters, leaving out
+// the body that String would include
+func signature(fn *ast.FunctionLiteral) string {
+	names := make([]string, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		names[i] = p.Value
+	}
+	return "fn(" + strings.Join(names, ", ") + ")"
+}
@@ -0,0 +1,123 @@
+package infer
+
+import (
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/lexer"
+	"gosling/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestBindingTypes(t *testing.T) {
+	tests := []struct {
+		input string
+		types map[string]string
+	}{
+		{"let x = 1; let y = x < 2;", map[string]string{"x": "int", "y": "bool"}},
+		{"let s = \"a\" + \"b\"; let n = len(s);", map[string]string{"s": "string", "n": "int"}},
+		{"let inc = fn(x: int) { x + 1 };", map[string]string{"inc": "fn(int) -> int", "x": "int"}},
+		{"let f = fn(n) { if (n < 2) { n } else { f(n - 1) } };", map[string]string{"f": "fn(int) -> int", "n": "int"}},
+		{"let i = 0; let loop = fn() { for (i < 3) { i = i + 1; } };", map[string]string{"i": "int", "loop": "fn() -> null"}},
+		{"let apply = fn(f: fn(int) -> bool, x: int) { f(x) };", map[string]string{"apply": "fn(fn(int) -> bool, int) -> bool"}},
+	}
+
+	for _, tt := range tests {
+		info, diagnostics := Check(parse(t, tt.input))
+		if len(diagnostics) > 0 {
+			t.Errorf("unexpected diagnostics for %q: %v", tt.input, diagnostics)
+			continue
+		}
+		for ident, b := range info.Bindings {
+			want, ok := tt.types[ident.Value]
+			if ok && b.Type.String() != want {
+				t.Errorf("wrong type for %s in %q. want=%s, got=%s", ident.Value, tt.input, want, b.Type)
+			}
+		}
+	}
+}
+
+func TestCaptures(t *testing.T) {
+	program := parse(t, "let a = 1; let f = fn(x: int) { let y = x; let g = fn() { fn() { a + y } }; g()() };")
+	info, diagnostics := Check(program)
+	if len(diagnostics) > 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	var f, g, inner *Function
+	for _, fn := range info.Functions {
+		switch {
+		case fn.Name == "f":
+			f = fn
+		case fn.Name == "g":
+			g = fn
+		case fn.Name == "":
+			inner = fn
+		}
+	}
+	if f == nil || g == nil || inner == nil {
+		t.Fatalf("functions not found: f=%v g=%v inner=%v", f, g, inner)
+	}
+	if len(f.Free) != 0 {
+		t.Errorf("f should capture nothing, got %d bindings", len(f.Free))
+	}
+	for _, fn := range []*Function{g, inner} {
+		if len(fn.Free) != 1 || fn.Free[0].Name != "y" {
+			t.Errorf("%s should capture y alone, got %v", signature(fn.Literal), fn.Free)
+		}
+	}
+	if inner.Outer != g || g.Outer != f || f.Outer != nil {
+		t.Errorf("wrong nesting of functions")
+	}
+
+	for _, b := range append(f.Params, f.Locals...) {
+		if want := b.Name == "y"; b.Captured != want {
+			t.Errorf("wrong Captured for %s. want=%t, got=%t", b.Name, want, b.Captured)
+		}
+	}
+	if len(info.Globals) != 2 || info.Globals[0].Captured {
+		t.Errorf("globals are not captured, they are reached directly")
+	}
+	for _, b := range f.Locals {
+		if b.Name == "g" && b.Function != g {
+			t.Errorf("g should be known to always call its literal")
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input   string
+		code    diag.Code
+		message string
+	}{
+		{"let x = none;", diag.UnsupportedConstruct, "cannot compile none: options are not supported"},
+		{"let x = some(1);", diag.UnsupportedConstruct, "cannot compile the builtin some: options are not supported"},
+		{"let f = fn(x) { x };", diag.NoStaticType, "cannot work out the type of f, annotate it"},
+		{"let f = fn(c: bool) { if (c) { let v = 1; } v };", diag.UnsupportedConstruct, "cannot compile v: it is used before it is bound"},
+		{"let x = 1; x = true;", diag.UnsupportedConstruct, "cannot compile x = true: cannot use bool as int"},
+		{"let f = fn(b: bool) { b + b };", diag.UnsupportedConstruct, "cannot compile (b + b): + only adds integers and joins strings"},
+		{"missing", diag.IdentifierNotFound, "identifier not found: missing"},
+	}
+
+	for _, tt := range tests {
+		_, diagnostics := Check(parse(t, tt.input))
+		if len(diagnostics) == 0 {
+			t.Errorf("expected a diagnostic for %q", tt.input)
+			continue
+		}
+		d := diagnostics[0]
+		if d.Code != tt.code || d.Message != tt.message {
+			t.Errorf("wrong diagnostic for %q. want=[%s] %q, got=[%s] %q", tt.input, tt.code, tt.message, d.Code, d.Message)
+		}
+	}
+}
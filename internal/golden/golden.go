@@ -0,0 +1,93 @@
+// Package golden holds what the tests of the backends share: the programs
+// every backend is tested with, in testdata, and helpers to parse them and
+// to compare what a backend makes of each with the file it should match.
+// Only tests import it.
+package golden
+
+import (
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/lexer"
+	"gosling/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Corpus is the directory of the programs every backend is tested with,
+// from the directory of the package whose tests are running
+var Corpus = filepath.Join("..", "internal", "golden", "testdata")
+
+func Parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// ParseFile parses a program as gosling run does, so run time errors name
+// the file
+func ParseFile(t testing.TB, path string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.LexFile(path))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %s: %v", path, p.Errors())
+	}
+	return program
+}
+
+// Programs returns the programs of the corpus, then those in the testdata
+// directory of the package being tested, which only its backend runs
+func Programs(t testing.TB) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(Corpus, "*.gos"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no programs in %s: %v", Corpus, err)
+	}
+	own, err := filepath.Glob(filepath.Join("testdata", "*.gos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(paths, own...)
+}
+
+// Compare checks got against the golden file of the program at path, the
+// file in the testdata directory of the package being tested named after
+// the program with the extension ext. With update, which tests set from
+// their own -update flag, it writes got there instead.
+func Compare(t testing.TB, path, ext string, got []byte, update bool) {
+	t.Helper()
+	golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(path), ".gos")+ext)
+	if update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%s: %v (run go test -update to create it)", path, err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s: output differs from %s, run go test -update if the change is intended.\ngot:\n%s", path, golden, got)
+	}
+}
+
+// Diagnostic checks that the first of the diagnostics a backend gave for
+// input has code and message
+func Diagnostic(t testing.TB, input string, diagnostics []diag.Diagnostic, code diag.Code, message string) {
+	t.Helper()
+	if len(diagnostics) == 0 {
+		t.Errorf("expected a diagnostic for %q", input)
+		return
+	}
+	d := diagnostics[0]
+	if d.Code != code || d.Message != message {
+		t.Errorf("wrong diagnostic for %q. want=[%s] %q, got=[%s] %q", input, code, message, d.Code, d.Message)
+	}
+}
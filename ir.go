@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gosling/ir"
+	"io"
+	"os"
+	"strings"
+)
+
+const irUsage = "usage: gosling ir [--passes=pass,...] [--dump-after=pass,...|all] file.gos|file.ir\n"
+
+// irCmd builds a .gos file into the IR, or reads a .ir file, runs a
+// pipeline of passes over it and prints the module, after the stages
+// --dump-after names or at the end
+func irCmd(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("ir", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	pipeline := fs.String("passes", ir.DefaultPipeline, "the passes to run, in order")
+	dumpAfter := fs.String("dump-after", "", "print the module after these passes, build for the module as built, or all")
+	list := fs.Bool("list", false, "list the passes and exit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *list {
+		for _, p := range ir.Passes() {
+			fmt.Fprintf(out, "%-14s %s\n", p.Name, p.Summary)
+		}
+		return 0
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(errOut, irUsage)
+		return 2
+	}
+	passes, err := ir.ParsePipeline(*pipeline)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return 2
+	}
+	dump := map[string]bool{}
+	for _, stage := range strings.Split(*dumpAfter, ",") {
+		if stage = strings.TrimSpace(stage); stage == "" {
+			continue
+		}
+		if stage != "all" && stage != "build" && ir.LookupPass(stage) == nil {
+			fmt.Fprintf(errOut, "cannot dump after %q: it is not a pass\n", stage)
+			return 2
+		}
+		dump[stage] = true
+	}
+
+	path := fs.Arg(0)
+	m, ok := readModule(path, errOut)
+	if !ok {
+		return 1
+	}
+	if dump["all"] || dump["build"] {
+		ir.Dump(out, "build", m)
+	}
+	pm := &ir.PassManager{Passes: passes}
+	if len(dump) > 0 {
+		pm.AfterPass = func(p *ir.Pass, m *ir.Module) {
+			if dump["all"] || dump[p.Name] {
+				ir.Dump(out, p.Name, m)
+			}
+		}
+	}
+	if err := pm.Run(m); err != nil {
+		fmt.Fprintf(errOut, "%s: %s\n", path, err)
+		return 1
+	}
+	if len(dump) == 0 {
+		fmt.Fprint(out, m)
+	}
+	return 0
+}
+
+// readModule builds the module of a .gos file or parses and verifies a
+// .ir file
+func readModule(path string, errOut io.Writer) (*ir.Module, bool) {
+	if !strings.HasSuffix(path, ".ir") {
+		program, ok := parseFile(path, errOut)
+		if !ok {
+			return nil, false
+		}
+		m, diagnostics := ir.Build(program)
+		for _, d := range diagnostics {
+			fmt.Fprintf(errOut, "%s\n", d)
+		}
+		return m, len(diagnostics) == 0
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return nil, false
+	}
+	m, err := ir.Parse(string(src))
+	if err == nil {
+		err = ir.Verify(m)
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "%s:%s\n", path, err)
+		return nil, false
+	}
+	return m, true
+}
@@ -0,0 +1,442 @@
+package ir
+
+import (
+	"fmt"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/infer"
+	"gosling/token"
+	"gosling/types"
+)
+
+// Build turns program into a module of the gos dialect. It takes the
+// subset of the language that package infer can type.
+//
+// Each function literal becomes a func.func taking the variables it
+// captures, then its parameters, and the program itself becomes @main,
+// returning the value of its last statement. Globals are gos.global and
+// every other binding a gos.var of the function owning it.
+func Build(program *ast.Program) (*Module, []diag.Diagnostic) {
+	info, diagnostics := infer.Check(program)
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+
+	b := &builder{
+		info:      info,
+		m:         NewModule(),
+		symbols:   make(map[*infer.Function]string),
+		usedNames: make(map[string]int),
+	}
+	for _, g := range info.Globals {
+		op := NewOp("gos.global", nil)
+		op.Attributes["sym_name"] = SymbolAttr("g." + g.Name)
+		op.Attributes["type"] = TypeAttr{Type: irType(g.Type)}
+		b.m.Body.Append(op)
+	}
+	b.main(program)
+	for len(b.pending) > 0 {
+		fn := b.pending[0]
+		b.pending = b.pending[1:]
+		b.function(fn)
+	}
+	if len(b.diagnostics) > 0 {
+		return nil, b.diagnostics
+	}
+	return b.m, nil
+}
+
+type builder struct {
+	info        *infer.Info
+	m           *Module
+	diagnostics []diag.Diagnostic
+
+	// symbols holds the names given to functions, pending those whose
+	// bodies are still to be built
+	symbols   map[*infer.Function]string
+	usedNames map[string]int
+	pending   []*infer.Function
+}
+
+func (b *builder) unsupported(node ast.Node, format string, a ...interface{}) {
+	b.diagnostics = append(b.diagnostics, diag.New(diag.UnsupportedConstruct, node.Location(), format, a...))
+}
+
+// symbol returns the name of fn's func.func, queueing its body
+func (b *builder) symbol(fn *infer.Function) string {
+	if name, ok := b.symbols[fn]; ok {
+		return name
+	}
+	base := "fn"
+	if fn.Name != "" {
+		base = "fn." + fn.Name
+	}
+	n := b.usedNames[base]
+	b.usedNames[base]++
+	name := base
+	if fn.Name == "" || n > 0 {
+		name = fmt.Sprintf("%s.%d", base, n)
+	}
+	b.symbols[fn] = name
+	b.pending = append(b.pending, fn)
+	return name
+}
+
+// irType is the type of values of t, nil for null
+func irType(t types.Type) Type {
+	switch t := t.(type) {
+	case *types.IntegerType:
+		return I64
+	case *types.BooleanType:
+		return I1
+	case *types.StringType:
+		return Str
+	case *types.FunctionType:
+		return &ClosureType{Func: funcType(t)}
+	}
+	return nil
+}
+
+func funcType(t *types.FunctionType) *FunctionType {
+	fn := &FunctionType{Result: irType(t.Result)}
+	for _, p := range t.Parameters {
+		fn.Params = append(fn.Params, irType(p))
+	}
+	return fn
+}
+
+// newFunction adds a func.func to the module
+func (b *builder) newFunction(name string, t *FunctionType) *Operation {
+	op := NewOp("func.func", nil)
+	op.Attributes["sym_name"] = SymbolAttr(name)
+	op.Attributes["function_type"] = TypeAttr{Type: t}
+	entry := op.AddRegion().Entry()
+	for _, p := range t.Params {
+		entry.AddArg(p)
+	}
+	b.m.Body.Append(op)
+	return op
+}
+
+// body builds the operations of one function, or of main
+type body struct {
+	b  *builder
+	fn *infer.Function // nil for main
+
+	entry *Block
+	// prologue is the number of operations at the start of the entry
+	// block that define variables, before the function's own
+	prologue int
+	// refs holds the variables of the bindings used so far
+	refs map[*infer.Binding]*Value
+	// block is where operations are being added
+	block *Block
+}
+
+func (b *builder) newBody(fn *infer.Function, op *Operation) *body {
+	entry := op.Regions[0].Entry()
+	return &body{b: b, fn: fn, entry: entry, block: entry, refs: make(map[*infer.Binding]*Value)}
+}
+
+func (b *builder) main(program *ast.Program) {
+	var result Type
+	if n := len(program.Statements); n > 0 {
+		if es, ok := program.Statements[n-1].(*ast.ExpressionStatement); ok {
+			result = irType(b.info.Types[es.Expression])
+		}
+	}
+	op := b.newFunction("main", &FunctionType{Result: result})
+	e := b.newBody(nil, op)
+	var last *Value
+	for _, stmt := range program.Statements {
+		last = e.statement(stmt)
+		if e.terminated() {
+			return
+		}
+	}
+	e.ret(result, last)
+}
+
+func (b *builder) function(fn *infer.Function) {
+	t := funcType(fn.Type)
+	var captured []Type
+	for _, free := range fn.Free {
+		captured = append(captured, &RefType{Elem: irType(free.Type)})
+	}
+	sig := &FunctionType{Params: append(captured, t.Params...), Result: t.Result}
+	op := b.newFunction(b.symbol(fn), sig)
+	e := b.newBody(fn, op)
+	for i, free := range fn.Free {
+		e.refs[free] = e.entry.Args[i]
+	}
+	for _, local := range append(append([]*infer.Binding{}, fn.Params...), fn.Locals...) {
+		e.refs[local] = e.prologueOp(NewOp("gos.var", nil, &RefType{Elem: irType(local.Type)})).Result()
+	}
+	for i, p := range fn.Params {
+		e.add(NewOp("gos.store", []*Value{e.entry.Args[len(fn.Free)+i], e.refs[p]}))
+	}
+
+	v := e.block_(fn.Literal.Body)
+	if !e.terminated() {
+		e.ret(t.Result, v)
+	}
+}
+
+// ret returns v from the function, ending the block where control cannot
+// get to if there is no value to return
+func (e *body) ret(result Type, v *Value) {
+	switch {
+	case result == nil:
+		e.add(NewOp("gos.return", nil))
+	case v == nil:
+		e.add(NewOp("gos.unreachable", nil))
+	default:
+		e.add(NewOp("gos.return", []*Value{v}))
+	}
+}
+
+func (e *body) add(op *Operation) *Operation {
+	return e.block.Append(op)
+}
+
+// prologueOp adds op to the start of the function, where it dominates all
+// the function's own operations
+func (e *body) prologueOp(op *Operation) *Operation {
+	e.entry.Insert(e.prologue, op)
+	e.prologue++
+	return op
+}
+
+// at gives op the location of a node, for the error it may fail with
+func at(op *Operation, loc token.TokenLocation) *Operation {
+	op.Loc = &token.TokenLocation{Line: loc.Line, LineCh: loc.LineCh}
+	return op
+}
+
+// terminated reports whether the current block has ended, after which
+// control cannot reach what follows
+func (e *body) terminated() bool {
+	t := e.block.Terminator()
+	return t != nil && Definition(t.Name).Terminator
+}
+
+// ref returns the variable holding a binding
+func (e *body) ref(b *infer.Binding) *Value {
+	if v, ok := e.refs[b]; ok {
+		return v
+	}
+	// a global, as the others are defined up front
+	op := NewOp("gos.address_of", nil, &RefType{Elem: irType(b.Type)})
+	op.Attributes["global"] = SymbolAttr("g." + b.Name)
+	v := e.prologueOp(op).Result()
+	e.refs[b] = v
+	return v
+}
+
+func (e *body) constant(value Attribute) *Value {
+	op := NewOp("gos.constant", nil, attributeType(value))
+	op.Attributes["value"] = value
+	return e.add(op).Result()
+}
+
+// block_ builds the statements of a block and returns the value of the
+// last one, nil if it has none
+func (e *body) block_(b *ast.BlockStatement) *Value {
+	var result *Value
+	if b == nil {
+		return nil
+	}
+	for _, stmt := range b.Statements {
+		result = e.statement(stmt)
+		if e.terminated() {
+			break
+		}
+	}
+	return result
+}
+
+func (e *body) statement(stmt ast.Statement) *Value {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		v := e.expression(stmt.Value)
+		e.add(NewOp("gos.store", []*Value{v, e.ref(e.b.info.Bindings[stmt.Name])}))
+	case *ast.ReturnStatement:
+		var v *Value
+		if stmt.ReturnValue != nil {
+			v = e.expression(stmt.ReturnValue)
+		}
+		if e.terminated() {
+			return nil
+		}
+		if v == nil || irType(e.fn.Type.Result) == nil {
+			e.add(NewOp("gos.return", nil))
+		} else {
+			e.add(NewOp("gos.return", []*Value{v}))
+		}
+	case *ast.ExpressionStatement:
+		return e.expression(stmt.Expression)
+	}
+	return nil
+}
+
+var arithmetic = map[string]string{
+	"+": "gos.add", "-": "gos.sub", "*": "gos.mul", "/": "gos.div", "%": "gos.rem",
+	"<": "gos.lt", ">": "gos.gt", "==": "gos.eq", "!=": "gos.ne",
+}
+
+func (e *body) expression(exp ast.Expression) *Value {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return e.constant(IntegerAttr(exp.Value))
+	case *ast.Boolean:
+		return e.constant(BoolAttr(exp.Value))
+	case *ast.StringLiteral:
+		return e.constant(StringAttr(exp.Value))
+	case *ast.Identifier:
+		b := e.b.info.Bindings[exp]
+		if b == nil {
+			e.b.unsupported(exp, "cannot compile the builtin %s as a value, only calls of it", exp.Value)
+			return e.constant(IntegerAttr(0))
+		}
+		return e.add(NewOp("gos.load", []*Value{e.ref(b)}, irType(b.Type))).Result()
+	case *ast.AssignExpression:
+		v := e.expression(exp.Value)
+		e.add(NewOp("gos.store", []*Value{v, e.ref(e.b.info.Bindings[exp.Name])}))
+		return v
+	case *ast.PrefixExpression:
+		right := e.expression(exp.Right)
+		if exp.Operator == "!" {
+			return e.add(NewOp("gos.not", []*Value{right}, I1)).Result()
+		}
+		return e.add(at(NewOp("gos.neg", []*Value{right}, I64), exp.Token.Location)).Result()
+	case *ast.InfixExpression:
+		left := e.expression(exp.Left)
+		right := e.expression(exp.Right)
+		name := arithmetic[exp.Operator]
+		result := I64
+		switch {
+		case SameType(left.Type, Str) && exp.Operator == "+":
+			name, result = "gos.concat", Str
+		case name == "gos.lt" || name == "gos.gt" || name == "gos.eq" || name == "gos.ne":
+			result = I1
+		}
+		op := NewOp(name, []*Value{left, right}, result)
+		if result == I64 {
+			at(op, exp.Token.Location)
+		}
+		return e.add(op).Result()
+	case *ast.IfExpression:
+		return e.ifExpression(exp)
+	case *ast.ForExpression:
+		e.forExpression(exp)
+		return nil
+	case *ast.FunctionLiteral:
+		fn := e.b.info.Functions[exp]
+		var captured []*Value
+		for _, free := range fn.Free {
+			captured = append(captured, e.ref(free))
+		}
+		op := NewOp("gos.closure", captured, irType(fn.Type))
+		op.Attributes["callee"] = SymbolAttr(e.b.symbol(fn))
+		return e.add(op).Result()
+	case *ast.CallExpression:
+		return e.call(exp)
+	}
+	e.b.unsupported(exp, "cannot compile %s", exp.String())
+	return nil
+}
+
+// region builds a block of statements into the block of a region and
+// returns the value of the last statement
+func (e *body) region(r *Region, b *ast.BlockStatement) *Value {
+	saved := e.block
+	e.block = r.Entry()
+	v := e.block_(b)
+	e.block = saved
+	return v
+}
+
+func (e *body) ifExpression(ie *ast.IfExpression) *Value {
+	cond := e.expression(ie.Condition)
+	var results []Type
+	if t := irType(e.b.info.Types[ie]); t != nil {
+		results = append(results, t)
+	}
+	op := NewOp("gos.if", []*Value{cond}, results...)
+	falls := false
+	for _, branch := range []*ast.BlockStatement{ie.Consequence, ie.Alternative} {
+		r := op.AddRegion()
+		v := e.region(r, branch)
+		end := r.Entry()
+		if t := end.Terminator(); t != nil && Definition(t.Name).Terminator {
+			continue
+		}
+		falls = true
+		switch {
+		case len(results) == 0:
+			end.Append(NewOp("gos.yield", nil))
+		case v == nil:
+			// the branch ends in an if whose branches all return
+			end.Append(NewOp("gos.unreachable", nil))
+		default:
+			end.Append(NewOp("gos.yield", []*Value{v}))
+		}
+	}
+	e.add(op)
+	if !falls {
+		e.add(NewOp("gos.unreachable", nil))
+		return nil
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return op.Result()
+}
+
+func (e *body) forExpression(fe *ast.ForExpression) {
+	op := NewOp("gos.for", nil)
+	cond := op.AddRegion()
+	saved := e.block
+	e.block = cond.Entry()
+	c := e.expression(fe.Condition)
+	e.add(NewOp("gos.condition", []*Value{c}))
+	e.block = saved
+
+	body := op.AddRegion()
+	e.region(body, fe.Body)
+	if t := body.Entry().Terminator(); t == nil || !Definition(t.Name).Terminator {
+		body.Entry().Append(NewOp("gos.yield", nil))
+	}
+	e.add(op)
+}
+
+func (e *body) call(call *ast.CallExpression) *Value {
+	var results []Type
+	if t := irType(e.b.info.Types[call]); t != nil {
+		results = append(results, t)
+	}
+	var op *Operation
+	if ident, ok := call.Function.(*ast.Identifier); ok {
+		b := e.b.info.Bindings[ident]
+		switch {
+		case b == nil && ident.Value == "len":
+			arg := e.expression(call.Arguments[0])
+			return e.add(NewOp("gos.len", []*Value{arg}, I64)).Result()
+		case b != nil && b.Function != nil && len(b.Function.Free) == 0:
+			// the function needs no closure, call it directly
+			op = NewOp("func.call", nil, results...)
+			op.Attributes["callee"] = SymbolAttr(e.b.symbol(b.Function))
+		}
+	}
+	if op == nil {
+		op = NewOp("gos.call", []*Value{e.expression(call.Function)}, results...)
+	}
+	for _, a := range call.Arguments {
+		op.Operands = append(op.Operands, e.expression(a))
+	}
+	e.add(op)
+	if len(results) == 0 {
+		return nil
+	}
+	return op.Result()
+}
@@ -0,0 +1,249 @@
+package ir
+
+func init() {
+	registerPass(&Pass{
+		Name:    "canonicalize",
+		Summary: "simplify algebraic identities, constant branches and blocks",
+		Run:     Canonicalize,
+	})
+}
+
+// Canonicalize simplifies the functions of m, until nothing more can be:
+//
+//   - operations that give one of their operands, such as x + 0, x * 1
+//     and the negation of a negation, are replaced by it, and a value
+//     compared with itself by the answer
+//   - a branch on a constant goes only the way it takes, and a gos.if on
+//     a constant becomes the region it runs
+//   - a block only branched to from a cf.br is merged into the block
+//     branching, and one doing nothing but branching on is skipped
+//   - a block argument given the same value by every branch to the block
+//     is replaced by that value, and blocks that cannot be reached are
+//     erased
+func Canonicalize(m *Module) error {
+	return forEachFunction(m, func(fn *Operation) error {
+		fixpoint(func() bool {
+			changed := false
+			fn.Walk(func(op *Operation) {
+				if op.block != nil && simplify(op) {
+					changed = true
+				}
+			})
+			for _, r := range cfgRegions(fn) {
+				if removeUnreachable(r) || simplifyBlocks(r) {
+					changed = true
+				}
+			}
+			return changed
+		})
+		return nil
+	})
+}
+
+func isConstant(v *Value, want Attribute) bool {
+	a, ok := ConstantOf(v)
+	return ok && a == want
+}
+
+// simplify applies the patterns for single operations to op
+func simplify(op *Operation) bool {
+	operand := func(i int) *Value { return op.Operands[i] }
+	switch op.Name {
+	case "gos.add", "arith.addi":
+		if isConstant(operand(1), IntegerAttr(0)) {
+			replaceOp(op, operand(0))
+			return true
+		}
+		if isConstant(operand(0), IntegerAttr(0)) {
+			replaceOp(op, operand(1))
+			return true
+		}
+	case "gos.sub", "arith.subi", "gos.div", "arith.divsi":
+		identity := IntegerAttr(0)
+		if op.Name == "gos.div" || op.Name == "arith.divsi" {
+			identity = 1
+		}
+		if isConstant(operand(1), identity) {
+			replaceOp(op, operand(0))
+			return true
+		}
+	case "gos.mul", "arith.muli":
+		if isConstant(operand(1), IntegerAttr(1)) {
+			replaceOp(op, operand(0))
+			return true
+		}
+		if isConstant(operand(0), IntegerAttr(1)) {
+			replaceOp(op, operand(1))
+			return true
+		}
+	case "gos.not":
+		if inner := operand(0).op; inner != nil && inner.Name == "gos.not" {
+			replaceOp(op, inner.Operands[0])
+			return true
+		}
+	case "arith.xori":
+		if isConstant(operand(1), BoolAttr(false)) {
+			replaceOp(op, operand(0))
+			return true
+		}
+		if inner := operand(0).op; inner != nil && inner.Name == "arith.xori" &&
+			isConstant(operand(1), BoolAttr(true)) && isConstant(inner.Operands[1], BoolAttr(true)) {
+			replaceOp(op, inner.Operands[0])
+			return true
+		}
+	case "gos.eq", "gos.ne", "arith.cmpi":
+		if operand(0) != operand(1) {
+			return false
+		}
+		predicate := op.Attributes["predicate"]
+		switch {
+		case op.Name == "gos.eq", predicate == EnumAttr("eq"):
+			replaceWithConstant(op, BoolAttr(true))
+		case op.Name == "gos.ne", predicate == EnumAttr("ne"), predicate == EnumAttr("slt"), predicate == EnumAttr("sgt"):
+			replaceWithConstant(op, BoolAttr(false))
+		}
+		return true
+	case "cf.cond_br":
+		taken := -1
+		if isConstant(operand(0), BoolAttr(true)) {
+			taken = 0
+		} else if isConstant(operand(0), BoolAttr(false)) {
+			taken = 1
+		} else if a, b := op.Successors[0], op.Successors[1]; a.Block == b.Block && sameValues(a.Args, b.Args) {
+			taken = 0
+		}
+		if taken < 0 {
+			return false
+		}
+		br := NewOp("cf.br", nil)
+		br.Successors = []*Successor{op.Successors[taken]}
+		op.block.Insert(op.Index(), br)
+		op.Erase()
+		return true
+	case "gos.if":
+		if isConstant(operand(0), BoolAttr(true)) {
+			inline(op, op.Regions[0])
+			return true
+		}
+		if isConstant(operand(0), BoolAttr(false)) {
+			inline(op, op.Regions[1])
+			return true
+		}
+	}
+	return false
+}
+
+func sameValues(a, b []*Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// inline replaces op with the operations of r, its results with what r
+// yields. If r returns instead, what follows op is dropped.
+func inline(op *Operation, r *Region) {
+	b := op.block
+	ops := r.Entry().Ops
+	end := ops[len(ops)-1]
+	i := op.Index()
+	if end.Name == "gos.yield" {
+		ops = ops[:len(ops)-1]
+		for j, res := range op.Results {
+			ReplaceAllUses(res, end.Operands[j])
+		}
+	} else {
+		// the rest of the block cannot be reached
+		for _, dead := range b.Ops[i+1:] {
+			dead.block = nil
+		}
+		b.Ops = b.Ops[:i+1]
+	}
+	op.Erase()
+	for j, inner := range ops {
+		b.Insert(i+j, inner)
+	}
+}
+
+// simplifyBlocks applies the patterns for the blocks of r
+func simplifyBlocks(r *Region) bool {
+	changed := false
+	for _, b := range append([]*Block(nil), r.Blocks[1:]...) {
+		if b.region == nil {
+			continue // merged already
+		}
+		in := edges(b)
+
+		// skip a block that only branches on
+		if t := b.Terminator(); len(b.Ops) == 1 && len(b.Args) == 0 && t.Name == "cf.br" && t.Successors[0].Block != b {
+			for _, s := range in {
+				s.Block = t.Successors[0].Block
+				s.Args = append([]*Value(nil), t.Successors[0].Args...)
+			}
+			if len(in) > 0 {
+				changed = true
+			}
+			continue
+		}
+
+		// merge a block into its only predecessor
+		if len(in) == 1 {
+			pred := predecessorOf(in[0])
+			if t := pred.Terminator(); pred != b && t.Name == "cf.br" {
+				for i, arg := range b.Args {
+					ReplaceAllUses(arg, in[0].Args[i])
+				}
+				t.Erase()
+				for _, op := range b.Ops {
+					pred.Append(op)
+				}
+				eraseBlock(b)
+				changed = true
+				continue
+			}
+		}
+
+		// replace an argument given the same value by every branch
+		for i := 0; i < len(b.Args); i++ {
+			arg := b.Args[i]
+			var same *Value
+			ok := len(in) > 0
+			for _, s := range in {
+				v := s.Args[i]
+				switch {
+				case v == arg:
+				case same == nil:
+					same = v
+				case v != same:
+					ok = false
+				}
+			}
+			if ok && same != nil {
+				ReplaceAllUses(arg, same)
+				removeArg(b, i)
+				i--
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// predecessorOf returns the block whose terminator has s
+func predecessorOf(s *Successor) *Block {
+	for _, b := range s.Block.region.Blocks {
+		if t := b.Terminator(); t != nil {
+			for _, other := range t.Successors {
+				if other == s {
+					return b
+				}
+			}
+		}
+	}
+	return nil
+}
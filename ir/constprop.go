@@ -0,0 +1,93 @@
+package ir
+
+func init() {
+	registerPass(&Pass{
+		Name:    "const-prop",
+		Summary: "compute operations on constants and propagate constants through block arguments",
+		Run:     ConstProp,
+	})
+}
+
+// ConstProp replaces operations whose operands are all constants with
+// their result, where the result is known without running the program:
+// arithmetic that would overflow or divide by zero is left to fail when
+// it runs. A block argument that every branch to the block gives the same
+// constant becomes that constant, so what uses it can be computed in turn.
+func ConstProp(m *Module) error {
+	return forEachFunction(m, func(fn *Operation) error {
+		fixpoint(func() bool {
+			changed := false
+			fn.Walk(func(op *Operation) {
+				if op.block != nil && fold(op) {
+					changed = true
+				}
+			})
+			for _, r := range cfgRegions(fn) {
+				if propagateArgs(r) {
+					changed = true
+				}
+			}
+			return changed
+		})
+		return nil
+	})
+}
+
+// fold replaces op with its result if its operands are constants
+func fold(op *Operation) bool {
+	def := Definition(op.Name)
+	if def == nil || def.Fold == nil || len(op.Operands) == 0 || len(op.Results) != 1 {
+		return false
+	}
+	operands := make([]Attribute, len(op.Operands))
+	for i, o := range op.Operands {
+		a, ok := ConstantOf(o)
+		if !ok {
+			return false
+		}
+		operands[i] = a
+	}
+	result, ok := def.Fold(op, operands)
+	if !ok {
+		return false
+	}
+	replaceWithConstant(op, result)
+	return true
+}
+
+// propagateArgs replaces the arguments of the blocks of r that are given
+// the same constant along every branch
+func propagateArgs(r *Region) bool {
+	changed := false
+	for _, b := range r.Blocks[1:] {
+		in := edges(b)
+		for i := 0; i < len(b.Args); i++ {
+			arg := b.Args[i]
+			var value Attribute
+			var from *Operation
+			ok := len(in) > 0
+			for _, s := range in {
+				if s.Args[i] == arg {
+					continue
+				}
+				a, isConst := ConstantOf(s.Args[i])
+				switch {
+				case !isConst, value != nil && a != value:
+					ok = false
+				case value == nil:
+					value, from = a, s.Args[i].op
+				}
+			}
+			if !ok || value == nil {
+				continue
+			}
+			c := newConstant(value, from)
+			b.Insert(0, c)
+			ReplaceAllUses(arg, c.Result())
+			removeArg(b, i)
+			i--
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,155 @@
+package ir
+
+func init() {
+	registerPass(&Pass{
+		Name:    "dce",
+		Summary: "remove unreachable blocks, unused operations, arguments and variables, and functions and globals @main does not reach",
+		Run:     DCE,
+	})
+}
+
+// DCE removes what does not change what the program does: blocks that
+// cannot be reached, pure operations whose results are not used, block
+// arguments that are not used, variables that are only stored to, and the
+// functions and globals that @main does not refer to, directly or through
+// the functions it refers to.
+func DCE(m *Module) error {
+	err := forEachFunction(m, func(fn *Operation) error {
+		for _, r := range cfgRegions(fn) {
+			removeUnreachable(r)
+		}
+		fixpoint(func() bool {
+			return removeUnused(fn)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	removeUnreferenced(m)
+	return nil
+}
+
+// removeUnreachable erases the blocks of r that cannot be reached from its
+// entry, reporting whether there were any
+func removeUnreachable(r *Region) bool {
+	reached := map[*Block]bool{}
+	work := []*Block{r.Entry()}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		if reached[b] {
+			continue
+		}
+		reached[b] = true
+		if t := b.Terminator(); t != nil {
+			for _, s := range t.Successors {
+				work = append(work, s.Block)
+			}
+		}
+	}
+	changed := false
+	for _, b := range append([]*Block(nil), r.Blocks...) {
+		if !reached[b] {
+			eraseBlock(b)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeUnused makes one sweep over fn removing what is unused, reporting
+// whether it removed anything
+func removeUnused(fn *Operation) bool {
+	// uses counts the uses of each value, leaving out those by the
+	// branches of a block passing an argument back to itself
+	uses := map[*Value]int{}
+	stores := map[*Value][]*Operation{}
+	fn.Walk(func(op *Operation) {
+		for _, o := range op.Operands {
+			uses[o]++
+		}
+		if op.Name == "gos.store" {
+			stores[op.Operands[1]] = append(stores[op.Operands[1]], op)
+		}
+		for _, s := range op.Successors {
+			for i, a := range s.Args {
+				if a != s.Block.Args[i] {
+					uses[a]++
+				}
+			}
+		}
+	})
+
+	changed := false
+	fn.Walk(func(op *Operation) {
+		if op.block == nil {
+			return
+		}
+		def := Definition(op.Name)
+		if def == nil || !def.Pure || len(op.Results) == 0 {
+			return
+		}
+		if op.Name == "gos.var" && uses[op.Result()] == len(stores[op.Result()]) {
+			// written but never read
+			for _, store := range stores[op.Result()] {
+				store.Erase()
+			}
+			op.Erase()
+			changed = true
+			return
+		}
+		for _, r := range op.Results {
+			if uses[r] > 0 {
+				return
+			}
+		}
+		op.Erase()
+		changed = true
+	})
+
+	for _, r := range cfgRegions(fn) {
+		for _, b := range r.Blocks[1:] {
+			for i := 0; i < len(b.Args); i++ {
+				if uses[b.Args[i]] == 0 {
+					removeArg(b, i)
+					i--
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// removeUnreferenced removes the functions and globals of m that @main does
+// not refer to. A module without @main is left as it is.
+func removeUnreferenced(m *Module) {
+	main := m.Lookup("main")
+	if main == nil {
+		return
+	}
+	live := map[*Operation]bool{main: true}
+	work := []*Operation{main}
+	for len(work) > 0 {
+		fn := work[len(work)-1]
+		work = work[:len(work)-1]
+		fn.Walk(func(op *Operation) {
+			for _, a := range op.Attributes {
+				sym, ok := a.(SymbolAttr)
+				if !ok {
+					continue
+				}
+				if target := m.Lookup(string(sym)); target != nil && !live[target] {
+					live[target] = true
+					work = append(work, target)
+				}
+			}
+		})
+	}
+	for _, op := range append([]*Operation(nil), m.Body.Ops...) {
+		if !live[op] {
+			op.Erase()
+		}
+	}
+}
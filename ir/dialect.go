@@ -0,0 +1,600 @@
+package ir
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// OpDef describes an operation of one of the dialects
+type OpDef struct {
+	Name string
+	// Pure is set when the operation has no effect beyond its results and
+	// cannot fail, so one whose results are unused can be removed
+	Pure bool
+	// Terminator is set for the operations that end a block
+	Terminator bool
+	// Verify checks the operation's operands, results, attributes and
+	// regions. Those of the regions are verified on their own.
+	Verify func(op *Operation, m *Module) error
+	// Fold computes the result of the operation given the constant values
+	// of its operands. It reports false when the result is not known until
+	// the program runs, such as that of a division by zero, which must
+	// fail then.
+	Fold func(op *Operation, operands []Attribute) (Attribute, bool)
+}
+
+var registry = map[string]*OpDef{}
+
+func register(defs ...*OpDef) {
+	for _, def := range defs {
+		registry[def.Name] = def
+	}
+}
+
+// Definition returns the definition of the operation called name, nil for
+// one that no dialect has
+func Definition(name string) *OpDef {
+	return registry[name]
+}
+
+// OpNames returns the names of every operation of every dialect, sorted
+func OpNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// opError is an error in op, naming it and where it came from
+func opError(op *Operation, format string, a ...interface{}) error {
+	msg := fmt.Sprintf("'%s' op %s", op.Name, fmt.Sprintf(format, a...))
+	if op.Loc != nil {
+		return fmt.Errorf("loc(%d:%d): %s", op.Loc.Line, op.Loc.LineCh, msg)
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+func typeList(ts []Type) string {
+	s := "("
+	for i, t := range ts {
+		if i > 0 {
+			s += ", "
+		}
+		s += t.String()
+	}
+	return s + ")"
+}
+
+func valueTypes(vs []*Value) []Type {
+	ts := make([]Type, len(vs))
+	for i, v := range vs {
+		ts[i] = v.Type
+	}
+	return ts
+}
+
+func sameTypes(a, b []Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !SameType(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// fixed verifies an operation taking and giving values of given types
+// and having no regions
+func fixed(operands []Type, results ...Type) func(op *Operation, m *Module) error {
+	return func(op *Operation, m *Module) error {
+		if got := valueTypes(op.Operands); !sameTypes(got, operands) {
+			return opError(op, "expects operands %s, got %s", typeList(operands), typeList(got))
+		}
+		if got := valueTypes(op.Results); !sameTypes(got, results) {
+			return opError(op, "expects results %s, got %s", typeList(results), typeList(got))
+		}
+		return noRegions(op)
+	}
+}
+
+func noRegions(op *Operation) error {
+	if len(op.Regions) > 0 {
+		return opError(op, "expects no regions")
+	}
+	return nil
+}
+
+// counts checks the number of operands and results
+func counts(op *Operation, operands, results int) error {
+	if len(op.Operands) != operands {
+		return opError(op, "expects %d operands, got %d", operands, len(op.Operands))
+	}
+	if len(op.Results) != results {
+		return opError(op, "expects %d results, got %d", results, len(op.Results))
+	}
+	return nil
+}
+
+func symbolAttr(op *Operation, name string) (SymbolAttr, error) {
+	sym, ok := op.Attributes[name].(SymbolAttr)
+	if !ok {
+		return "", opError(op, "expects a symbol attribute %s", name)
+	}
+	return sym, nil
+}
+
+func typeAttr(op *Operation, name string) (Type, error) {
+	t, ok := op.Attributes[name].(TypeAttr)
+	if !ok {
+		return nil, opError(op, "expects a type attribute %s", name)
+	}
+	return t.Type, nil
+}
+
+// function returns the func.func named by op's symbol attribute
+func function(op *Operation, m *Module, attr string) (*FunctionType, error) {
+	sym, err := symbolAttr(op, attr)
+	if err != nil {
+		return nil, err
+	}
+	callee := m.Lookup(string(sym))
+	if callee == nil || callee.Name != "func.func" {
+		return nil, opError(op, "refers to %s, which is not a function", sym)
+	}
+	t, _ := callee.Attributes["function_type"].(TypeAttr)
+	fn, ok := t.Type.(*FunctionType)
+	if !ok {
+		return nil, opError(op, "refers to %s, which has no type", sym)
+	}
+	return fn, nil
+}
+
+// resultTypes is the results a call of a function of type fn has
+func resultTypes(fn *FunctionType) []Type {
+	if fn.Result == nil {
+		return nil
+	}
+	return []Type{fn.Result}
+}
+
+// enclosing returns the nearest operation named name that op is nested in
+func enclosing(op *Operation, name string) *Operation {
+	for b := op.block; b != nil && b.region != nil; b = b.region.op.block {
+		if b.region.op.Name == name {
+			return b.region.op
+		}
+	}
+	return nil
+}
+
+// returns verifies the operands of a return from fn
+func returns(op *Operation, fn *Operation) error {
+	t, _ := fn.Attributes["function_type"].(TypeAttr)
+	ft, ok := t.Type.(*FunctionType)
+	if !ok {
+		return nil // reported by the function
+	}
+	if got := valueTypes(op.Operands); !sameTypes(got, resultTypes(ft)) {
+		return opError(op, "returns %s from a function returning %s", typeList(got), typeList(resultTypes(ft)))
+	}
+	return nil
+}
+
+func verifyConstant(op *Operation, m *Module) error {
+	if err := counts(op, 0, 1); err != nil {
+		return err
+	}
+	value, ok := op.Attributes["value"]
+	t := attributeType(value)
+	if !ok || t == nil || (op.Dialect() == "arith" && t == Str) {
+		return opError(op, "expects a constant value attribute")
+	}
+	if !SameType(t, op.Result().Type) {
+		return opError(op, "has a value of type %s but a result of type %s", t, op.Result().Type)
+	}
+	return noRegions(op)
+}
+
+func foldConstant(op *Operation, operands []Attribute) (Attribute, bool) {
+	return op.Attributes["value"], true
+}
+
+// singleBlocks checks that op has n regions of one block each, which take
+// no arguments
+func singleBlocks(op *Operation, n int) error {
+	if len(op.Regions) != n {
+		return opError(op, "expects %d regions, got %d", n, len(op.Regions))
+	}
+	for _, r := range op.Regions {
+		if len(r.Blocks) != 1 || len(r.Entry().Args) != 0 {
+			return opError(op, "expects regions of one block without arguments")
+		}
+	}
+	return nil
+}
+
+// Integer arithmetic traps where the result does not fit in 64 bits, so
+// folding gives up there, leaving the operation to fail when it runs.
+
+func addInts(a, b int64) (int64, bool) {
+	r := a + b
+	return r, (r > a) == (b > 0)
+}
+
+func subInts(a, b int64) (int64, bool) {
+	r := a - b
+	return r, (r < a) == (b > 0)
+}
+
+func mulInts(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	r := a * b
+	return r, r/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+func divInts(a, b int64) (int64, bool) {
+	if b == 0 || (a == math.MinInt64 && b == -1) {
+		return 0, false
+	}
+	return a / b, true
+}
+
+func remInts(a, b int64) (int64, bool) {
+	if b == 0 {
+		return 0, false
+	}
+	if b == -1 {
+		return 0, true
+	}
+	return a % b, true
+}
+
+func intFold(f func(a, b int64) (int64, bool)) func(*Operation, []Attribute) (Attribute, bool) {
+	return func(op *Operation, operands []Attribute) (Attribute, bool) {
+		a, ok1 := operands[0].(IntegerAttr)
+		b, ok2 := operands[1].(IntegerAttr)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		r, ok := f(int64(a), int64(b))
+		if !ok {
+			return nil, false
+		}
+		return IntegerAttr(r), true
+	}
+}
+
+// compare folds a comparison of two constants of the same type
+func compare(predicate string, a, b Attribute) (Attribute, bool) {
+	switch predicate {
+	case "eq":
+		return BoolAttr(a == b), true
+	case "ne":
+		return BoolAttr(a != b), true
+	}
+	x, ok1 := a.(IntegerAttr)
+	y, ok2 := b.(IntegerAttr)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	switch predicate {
+	case "slt":
+		return BoolAttr(x < y), true
+	case "sgt":
+		return BoolAttr(x > y), true
+	}
+	return nil, false
+}
+
+func compareFold(predicate string) func(*Operation, []Attribute) (Attribute, bool) {
+	return func(op *Operation, operands []Attribute) (Attribute, bool) {
+		return compare(predicate, operands[0], operands[1])
+	}
+}
+
+// equality verifies gos.eq and gos.ne, which compare two values of any
+// type but a reference or a closure
+func equality(op *Operation, m *Module) error {
+	if err := counts(op, 2, 1); err != nil {
+		return err
+	}
+	a, b := op.Operands[0].Type, op.Operands[1].Type
+	if !SameType(a, b) {
+		return opError(op, "compares values of different types %s and %s", a, b)
+	}
+	if !SameType(a, I64) && !SameType(a, I1) && !SameType(a, Str) {
+		return opError(op, "cannot compare values of type %s", a)
+	}
+	if !SameType(op.Result().Type, I1) {
+		return opError(op, "expects a result of type i1")
+	}
+	return noRegions(op)
+}
+
+var predicates = map[string]bool{"eq": true, "ne": true, "slt": true, "sgt": true}
+
+func init() {
+	i64s := []Type{I64, I64}
+	i1s := []Type{I1, I1}
+
+	// gos, the high level dialect
+	register(
+		&OpDef{Name: "gos.constant", Pure: true, Verify: func(op *Operation, m *Module) error {
+			return verifyConstant(op, m)
+		}, Fold: foldConstant},
+		&OpDef{Name: "gos.add", Verify: fixed(i64s, I64), Fold: intFold(addInts)},
+		&OpDef{Name: "gos.sub", Verify: fixed(i64s, I64), Fold: intFold(subInts)},
+		&OpDef{Name: "gos.mul", Verify: fixed(i64s, I64), Fold: intFold(mulInts)},
+		&OpDef{Name: "gos.div", Verify: fixed(i64s, I64), Fold: intFold(divInts)},
+		&OpDef{Name: "gos.rem", Verify: fixed(i64s, I64), Fold: intFold(remInts)},
+		&OpDef{Name: "gos.neg", Verify: fixed([]Type{I64}, I64), Fold: func(op *Operation, operands []Attribute) (Attribute, bool) {
+			return intFold(subInts)(op, []Attribute{IntegerAttr(0), operands[0]})
+		}},
+		&OpDef{Name: "gos.not", Pure: true, Verify: fixed([]Type{I1}, I1), Fold: func(op *Operation, operands []Attribute) (Attribute, bool) {
+			b, ok := operands[0].(BoolAttr)
+			return !b, ok
+		}},
+		&OpDef{Name: "gos.lt", Pure: true, Verify: fixed(i64s, I1), Fold: compareFold("slt")},
+		&OpDef{Name: "gos.gt", Pure: true, Verify: fixed(i64s, I1), Fold: compareFold("sgt")},
+		&OpDef{Name: "gos.eq", Pure: true, Verify: equality, Fold: compareFold("eq")},
+		&OpDef{Name: "gos.ne", Pure: true, Verify: equality, Fold: compareFold("ne")},
+		&OpDef{Name: "gos.concat", Pure: true, Verify: fixed([]Type{Str, Str}, Str), Fold: func(op *Operation, operands []Attribute) (Attribute, bool) {
+			a, ok1 := operands[0].(StringAttr)
+			b, ok2 := operands[1].(StringAttr)
+			return a + b, ok1 && ok2
+		}},
+		&OpDef{Name: "gos.len", Pure: true, Verify: fixed([]Type{Str}, I64), Fold: func(op *Operation, operands []Attribute) (Attribute, bool) {
+			s, ok := operands[0].(StringAttr)
+			return IntegerAttr(len(s)), ok
+		}},
+
+		&OpDef{Name: "gos.var", Pure: true, Verify: func(op *Operation, m *Module) error {
+			if err := counts(op, 0, 1); err != nil {
+				return err
+			}
+			if _, ok := op.Result().Type.(*RefType); !ok {
+				return opError(op, "expects a result of reference type")
+			}
+			return noRegions(op)
+		}},
+		&OpDef{Name: "gos.load", Pure: true, Verify: func(op *Operation, m *Module) error {
+			if err := counts(op, 1, 1); err != nil {
+				return err
+			}
+			ref, ok := op.Operands[0].Type.(*RefType)
+			if !ok || !SameType(ref.Elem, op.Result().Type) {
+				return opError(op, "loads a %s from a %s", op.Result().Type, op.Operands[0].Type)
+			}
+			return noRegions(op)
+		}},
+		&OpDef{Name: "gos.store", Verify: func(op *Operation, m *Module) error {
+			if err := counts(op, 2, 0); err != nil {
+				return err
+			}
+			ref, ok := op.Operands[1].Type.(*RefType)
+			if !ok || !SameType(ref.Elem, op.Operands[0].Type) {
+				return opError(op, "stores a %s to a %s", op.Operands[0].Type, op.Operands[1].Type)
+			}
+			return noRegions(op)
+		}},
+		&OpDef{Name: "gos.global", Verify: func(op *Operation, m *Module) error {
+			if err := counts(op, 0, 0); err != nil {
+				return err
+			}
+			if op.block != m.Body {
+				return opError(op, "must be at the top level of the module")
+			}
+			if _, err := symbolAttr(op, "sym_name"); err != nil {
+				return err
+			}
+			if _, err := typeAttr(op, "type"); err != nil {
+				return err
+			}
+			return noRegions(op)
+		}},
+		&OpDef{Name: "gos.address_of", Pure: true, Verify: func(op *Operation, m *Module) error {
+			if err := counts(op, 0, 1); err != nil {
+				return err
+			}
+			sym, err := symbolAttr(op, "global")
+			if err != nil {
+				return err
+			}
+			global := m.Lookup(string(sym))
+			if global == nil || global.Name != "gos.global" {
+				return opError(op, "refers to %s, which is not a global", sym)
+			}
+			t, _ := global.Attributes["type"].(TypeAttr)
+			if !SameType(op.Result().Type, &RefType{Elem: t.Type}) {
+				return opError(op, "gives a %s for a global of type %s", op.Result().Type, t.Type)
+			}
+			return noRegions(op)
+		}},
+		&OpDef{Name: "gos.closure", Pure: true, Verify: func(op *Operation, m *Module) error {
+			fn, err := function(op, m, "callee")
+			if err != nil {
+				return err
+			}
+			if len(op.Results) != 1 || len(op.Operands) > len(fn.Params) {
+				return opError(op, "expects one result and at most %d operands", len(fn.Params))
+			}
+			captured := fn.Params[:len(op.Operands)]
+			for i, o := range op.Operands {
+				if _, ok := o.Type.(*RefType); !ok || !SameType(o.Type, captured[i]) {
+					return opError(op, "captures a %s for a parameter of type %s", o.Type, captured[i])
+				}
+			}
+			want := &ClosureType{Func: &FunctionType{Params: fn.Params[len(op.Operands):], Result: fn.Result}}
+			if !SameType(op.Result().Type, want) {
+				return opError(op, "expects a result of type %s, got %s", want, op.Result().Type)
+			}
+			return noRegions(op)
+		}},
+		&OpDef{Name: "gos.call", Verify: func(op *Operation, m *Module) error {
+			if len(op.Operands) == 0 {
+				return opError(op, "expects a closure operand")
+			}
+			closure, ok := op.Operands[0].Type.(*ClosureType)
+			if !ok {
+				return opError(op, "calls a %s, which is not a closure", op.Operands[0].Type)
+			}
+			return call(op, closure.Func, op.Operands[1:])
+		}},
+		&OpDef{Name: "func.call", Verify: func(op *Operation, m *Module) error {
+			fn, err := function(op, m, "callee")
+			if err != nil {
+				return err
+			}
+			return call(op, fn, op.Operands)
+		}},
+
+		&OpDef{Name: "gos.if", Verify: func(op *Operation, m *Module) error {
+			if len(op.Operands) != 1 || !SameType(op.Operands[0].Type, I1) || len(op.Results) > 1 {
+				return opError(op, "expects an i1 condition and at most one result")
+			}
+			return singleBlocks(op, 2)
+		}},
+		&OpDef{Name: "gos.for", Verify: func(op *Operation, m *Module) error {
+			if err := counts(op, 0, 0); err != nil {
+				return err
+			}
+			return singleBlocks(op, 2)
+		}},
+		&OpDef{Name: "gos.yield", Terminator: true, Verify: func(op *Operation, m *Module) error {
+			parent := op.block.region.op
+			switch {
+			case parent.Name == "gos.if":
+				if got, want := valueTypes(op.Operands), valueTypes(parent.Results); !sameTypes(got, want) {
+					return opError(op, "yields %s from a gos.if giving %s", typeList(got), typeList(want))
+				}
+			case parent.Name == "gos.for" && op.block.region == parent.Regions[1]:
+				if len(op.Operands) > 0 {
+					return opError(op, "yields a value from the body of a gos.for")
+				}
+			default:
+				return opError(op, "must end a region of gos.if or the body of gos.for")
+			}
+			return nil
+		}},
+		&OpDef{Name: "gos.condition", Terminator: true, Verify: func(op *Operation, m *Module) error {
+			parent := op.block.region.op
+			if parent.Name != "gos.for" || op.block.region != parent.Regions[0] {
+				return opError(op, "must end the condition of gos.for")
+			}
+			return fixed([]Type{I1})(op, m)
+		}},
+		&OpDef{Name: "gos.unreachable", Terminator: true, Verify: fixed(nil)},
+		&OpDef{Name: "gos.return", Terminator: true, Verify: func(op *Operation, m *Module) error {
+			fn := enclosing(op, "func.func")
+			if fn == nil {
+				return opError(op, "must be in a func.func")
+			}
+			return returns(op, fn)
+		}},
+	)
+
+	// func, the dialect of functions
+	register(
+		&OpDef{Name: "func.func", Verify: func(op *Operation, m *Module) error {
+			if err := counts(op, 0, 0); err != nil {
+				return err
+			}
+			if op.block != m.Body {
+				return opError(op, "must be at the top level of the module")
+			}
+			if _, err := symbolAttr(op, "sym_name"); err != nil {
+				return err
+			}
+			t, err := typeAttr(op, "function_type")
+			if err != nil {
+				return err
+			}
+			fn, ok := t.(*FunctionType)
+			if !ok {
+				return opError(op, "expects a function type, got %s", t)
+			}
+			if len(op.Regions) != 1 || len(op.Regions[0].Blocks) == 0 {
+				return opError(op, "expects a body")
+			}
+			if got := valueTypes(op.Regions[0].Entry().Args); !sameTypes(got, fn.Params) {
+				return opError(op, "has arguments %s but a type taking %s", typeList(got), typeList(fn.Params))
+			}
+			return nil
+		}},
+		&OpDef{Name: "func.return", Terminator: true, Verify: func(op *Operation, m *Module) error {
+			fn := op.block.region.op
+			if fn.Name != "func.func" {
+				return opError(op, "must be in the body of a func.func, not of %s", fn.Name)
+			}
+			return returns(op, fn)
+		}},
+	)
+
+	// cf, branches between the blocks of a region
+	register(
+		&OpDef{Name: "cf.br", Terminator: true, Verify: func(op *Operation, m *Module) error {
+			if len(op.Successors) != 1 {
+				return opError(op, "expects one successor")
+			}
+			return fixed(nil)(op, m)
+		}},
+		&OpDef{Name: "cf.cond_br", Terminator: true, Verify: func(op *Operation, m *Module) error {
+			if len(op.Successors) != 2 {
+				return opError(op, "expects two successors")
+			}
+			return fixed([]Type{I1})(op, m)
+		}},
+	)
+
+	// arith, integer arithmetic. Like the gos dialect's it fails on
+	// overflow and division by zero.
+	register(
+		&OpDef{Name: "arith.constant", Pure: true, Verify: verifyConstant, Fold: foldConstant},
+		&OpDef{Name: "arith.addi", Verify: fixed(i64s, I64), Fold: intFold(addInts)},
+		&OpDef{Name: "arith.subi", Verify: fixed(i64s, I64), Fold: intFold(subInts)},
+		&OpDef{Name: "arith.muli", Verify: fixed(i64s, I64), Fold: intFold(mulInts)},
+		&OpDef{Name: "arith.divsi", Verify: fixed(i64s, I64), Fold: intFold(divInts)},
+		&OpDef{Name: "arith.remsi", Verify: fixed(i64s, I64), Fold: intFold(remInts)},
+		&OpDef{Name: "arith.xori", Pure: true, Verify: fixed(i1s, I1), Fold: func(op *Operation, operands []Attribute) (Attribute, bool) {
+			a, ok1 := operands[0].(BoolAttr)
+			b, ok2 := operands[1].(BoolAttr)
+			return BoolAttr(a != b), ok1 && ok2
+		}},
+		&OpDef{Name: "arith.cmpi", Pure: true, Verify: func(op *Operation, m *Module) error {
+			p, ok := op.Attributes["predicate"].(EnumAttr)
+			if !ok || !predicates[string(p)] {
+				return opError(op, "expects a predicate of eq, ne, slt or sgt")
+			}
+			if err := counts(op, 2, 1); err != nil {
+				return err
+			}
+			a := op.Operands[0].Type
+			if !SameType(a, op.Operands[1].Type) || !(SameType(a, I64) || SameType(a, I1) && (p == "eq" || p == "ne")) {
+				return opError(op, "cannot compare %s and %s with %s", a, op.Operands[1].Type, p)
+			}
+			return fixed([]Type{a, a}, I1)(op, m)
+		}, Fold: func(op *Operation, operands []Attribute) (Attribute, bool) {
+			p, _ := op.Attributes["predicate"].(EnumAttr)
+			return compare(string(p), operands[0], operands[1])
+		}},
+	)
+}
+
+// call verifies the arguments and results of a call of a function of
+// type fn
+func call(op *Operation, fn *FunctionType, args []*Value) error {
+	if got := valueTypes(args); !sameTypes(got, fn.Params) {
+		return opError(op, "passes %s to a function taking %s", typeList(got), typeList(fn.Params))
+	}
+	if got := valueTypes(op.Results); !sameTypes(got, resultTypes(fn)) {
+		return opError(op, "expects results %s, got %s", typeList(resultTypes(fn)), typeList(got))
+	}
+	return noRegions(op)
+}
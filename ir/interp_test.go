@@ -0,0 +1,242 @@
+package ir
+
+import (
+	"fmt"
+	"gosling/diag"
+	"gosling/token"
+	"strconv"
+)
+
+// An interpreter of modules at any level, for checking that the passes
+// keep what programs do
+
+// trap is a run time error of the program
+type trap struct {
+	code diag.Code
+	loc  token.TokenLocation
+}
+
+func (t *trap) Error() string { return fmt.Sprintf("[%s] at %d:%d", t.code, t.loc.Line, t.loc.LineCh) }
+
+type ref struct{ value interface{} }
+
+type closure struct {
+	fn       *Operation
+	captured []interface{}
+}
+
+type interp struct {
+	m       *Module
+	globals map[string]*ref
+	steps   int
+}
+
+// errTooLong stops a program that runs longer than the tests allow
+var errTooLong = fmt.Errorf("the program runs too long")
+
+// interpret runs the @main of m and returns its result, nil for none
+func interpret(m *Module) (interface{}, error) {
+	in := &interp{m: m, globals: map[string]*ref{}}
+	main := m.Lookup("main")
+	if main == nil {
+		return nil, fmt.Errorf("no @main")
+	}
+	return in.call(main, nil)
+}
+
+// show prints a result as gosling run would
+func show(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (in *interp) call(fn *Operation, args []interface{}) (interface{}, error) {
+	env := map[*Value]interface{}{}
+	b := fn.Regions[0].Entry()
+	for i, a := range b.Args {
+		env[a] = args[i]
+	}
+	for {
+		t, vals, err := in.run(b, env)
+		if err != nil {
+			return nil, err
+		}
+		switch t.Name {
+		case "func.return", "gos.return":
+			if len(vals) == 0 {
+				return nil, nil
+			}
+			return vals[0], nil
+		case "cf.br":
+			b = in.jump(t.Successors[0], env)
+		case "cf.cond_br":
+			if vals[0].(bool) {
+				b = in.jump(t.Successors[0], env)
+			} else {
+				b = in.jump(t.Successors[1], env)
+			}
+		default:
+			return nil, fmt.Errorf("%s reached", t.Name)
+		}
+	}
+}
+
+func (in *interp) jump(s *Successor, env map[*Value]interface{}) *Block {
+	vals := make([]interface{}, len(s.Args))
+	for i, a := range s.Args {
+		vals[i] = env[a]
+	}
+	for i, a := range s.Block.Args {
+		env[a] = vals[i]
+	}
+	return s.Block
+}
+
+// run runs the operations of b up to its terminator, which it returns
+// with its operands, or up to a gos.return nested in its operations
+func (in *interp) run(b *Block, env map[*Value]interface{}) (*Operation, []interface{}, error) {
+	for _, op := range b.Ops {
+		if in.steps++; in.steps > 10000000 {
+			return nil, nil, errTooLong
+		}
+		operands := make([]interface{}, len(op.Operands))
+		for i, o := range op.Operands {
+			operands[i] = env[o]
+		}
+		if Definition(op.Name).Terminator {
+			return op, operands, nil
+		}
+		var result interface{}
+		fail := func(code diag.Code) (*Operation, []interface{}, error) {
+			return nil, nil, &trap{code: code, loc: *op.Loc}
+		}
+		switch op.Name {
+		case "gos.constant", "arith.constant":
+			switch v := op.Attributes["value"].(type) {
+			case IntegerAttr:
+				result = int64(v)
+			case BoolAttr:
+				result = bool(v)
+			case StringAttr:
+				result = string(v)
+			}
+		case "gos.add", "arith.addi", "gos.sub", "arith.subi", "gos.mul", "arith.muli", "gos.neg":
+			if op.Name == "gos.neg" {
+				operands = []interface{}{int64(0), operands[0]}
+			}
+			f := map[string]func(a, b int64) (int64, bool){"add": addInts, "sub": subInts, "mul": mulInts, "neg": subInts}[op.Name[len(op.Dialect())+1:][:3]]
+			r, ok := f(operands[0].(int64), operands[1].(int64))
+			if !ok {
+				return fail(diag.IntegerOverflow)
+			}
+			result = r
+		case "gos.div", "arith.divsi", "gos.rem", "arith.remsi":
+			a, d := operands[0].(int64), operands[1].(int64)
+			div := op.Name == "gos.div" || op.Name == "arith.divsi"
+			switch {
+			case d == 0 && div:
+				return fail(diag.DivisionByZero)
+			case d == 0:
+				return fail(diag.ModuloByZero)
+			case div:
+				r, ok := divInts(a, d)
+				if !ok {
+					return fail(diag.IntegerOverflow)
+				}
+				result = r
+			default:
+				result, _ = remInts(a, d)
+			}
+		case "gos.not":
+			result = !operands[0].(bool)
+		case "arith.xori":
+			result = operands[0].(bool) != operands[1].(bool)
+		case "gos.lt", "gos.gt", "gos.eq", "gos.ne", "arith.cmpi":
+			p := string(cmpPredicates[op.Name])
+			if op.Name == "arith.cmpi" {
+				p = string(op.Attributes["predicate"].(EnumAttr))
+			}
+			switch p {
+			case "eq":
+				result = operands[0] == operands[1]
+			case "ne":
+				result = operands[0] != operands[1]
+			case "slt":
+				result = operands[0].(int64) < operands[1].(int64)
+			case "sgt":
+				result = operands[0].(int64) > operands[1].(int64)
+			}
+		case "gos.concat":
+			result = operands[0].(string) + operands[1].(string)
+		case "gos.len":
+			result = int64(len(operands[0].(string)))
+		case "gos.var":
+			result = &ref{}
+		case "gos.address_of":
+			sym := string(op.Attributes["global"].(SymbolAttr))
+			if in.globals[sym] == nil {
+				in.globals[sym] = &ref{}
+			}
+			result = in.globals[sym]
+		case "gos.load":
+			result = operands[0].(*ref).value
+		case "gos.store":
+			operands[1].(*ref).value = operands[0]
+		case "gos.closure":
+			result = &closure{fn: in.m.Lookup(string(op.Attributes["callee"].(SymbolAttr))), captured: operands}
+		case "gos.call", "func.call":
+			var fn *Operation
+			var args []interface{}
+			if op.Name == "gos.call" {
+				c := operands[0].(*closure)
+				fn, args = c.fn, append(append([]interface{}{}, c.captured...), operands[1:]...)
+			} else {
+				fn, args = in.m.Lookup(string(op.Attributes["callee"].(SymbolAttr))), operands
+			}
+			r, err := in.call(fn, args)
+			if err != nil {
+				return nil, nil, err
+			}
+			result = r
+		case "gos.if":
+			region := op.Regions[1]
+			if operands[0].(bool) {
+				region = op.Regions[0]
+			}
+			t, vals, err := in.run(region.Entry(), env)
+			if err != nil || t.Name != "gos.yield" {
+				return t, vals, err
+			}
+			if len(vals) > 0 {
+				result = vals[0]
+			}
+		case "gos.for":
+			for {
+				t, vals, err := in.run(op.Regions[0].Entry(), env)
+				if err != nil || t.Name != "gos.condition" {
+					return t, vals, err
+				}
+				if !vals[0].(bool) {
+					break
+				}
+				t, vals, err = in.run(op.Regions[1].Entry(), env)
+				if err != nil || t.Name != "gos.yield" {
+					return t, vals, err
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("cannot run %s", op.Name)
+		}
+		if len(op.Results) > 0 {
+			env[op.Result()] = result
+		}
+	}
+	return nil, nil, fmt.Errorf("a block without a terminator")
+}
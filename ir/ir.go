@@ -0,0 +1,309 @@
+// Package ir is an intermediate representation of programs in SSA form,
+// structured like MLIR: a module holds operations, which may hold regions
+// of blocks of further operations. Each operation belongs to a dialect,
+// named by the prefix of its name.
+//
+// Build turns a program into the gos dialect, which mirrors the syntax
+// tree: bindings are variables that are loaded and stored, and if and for
+// are operations whose regions hold their blocks. The lower pass turns it
+// into the low level cf and arith dialects, where control flow is
+// branches between the blocks of a function, variables that closures do
+// not share become SSA values passed as block arguments, and integer and
+// boolean operations are arith's. Strings, closures and shared variables
+// stay in the gos dialect, for backends to provide.
+//
+// Modules print as text and parse back, and Verify checks the rules of
+// each dialect. A PassManager runs passes over a module, such as the
+// canonicalize, const-prop and dce optimisations, which apply to both
+// levels.
+package ir
+
+import "gosling/token"
+
+// Module is the top level of the IR, holding functions and globals
+type Module struct {
+	Body *Block
+}
+
+func NewModule() *Module {
+	return &Module{Body: &Block{}}
+}
+
+// Operation is a single operation. Which operands, results, attributes,
+// successors and regions it has depends on its name.
+type Operation struct {
+	Name       string
+	Operands   []*Value
+	Results    []*Value
+	Attributes map[string]Attribute
+	// Successors are the blocks a terminator branches to, with the
+	// arguments each is given
+	Successors []*Successor
+	Regions    []*Region
+	// Loc is the place in the source the operation came from, for the
+	// run time errors of those that may fail, nil if it is not known
+	Loc *token.TokenLocation
+
+	block *Block
+}
+
+type Successor struct {
+	Block *Block
+	Args  []*Value
+}
+
+// Region is a list of blocks belonging to an operation. Control enters
+// at the first block.
+type Region struct {
+	Blocks []*Block
+
+	op *Operation
+}
+
+// Block is a list of operations, the last of which is a terminator when
+// the block is in a region
+type Block struct {
+	Args []*Value
+	Ops  []*Operation
+
+	region *Region
+}
+
+// Value is the result of an operation or the argument of a block
+type Value struct {
+	Type Type
+
+	op    *Operation // nil for a block argument
+	block *Block     // the block of an argument
+}
+
+// Op returns the operation defining v, nil for a block argument
+func (v *Value) Op() *Operation { return v.op }
+
+// NewOp makes an operation whose results have the given types
+func NewOp(name string, operands []*Value, results ...Type) *Operation {
+	op := &Operation{Name: name, Operands: operands, Attributes: map[string]Attribute{}}
+	for _, t := range results {
+		op.Results = append(op.Results, &Value{Type: t, op: op})
+	}
+	return op
+}
+
+// Result returns the first result of op
+func (op *Operation) Result() *Value {
+	return op.Results[0]
+}
+
+// Block returns the block op is in
+func (op *Operation) Block() *Block { return op.block }
+
+// Dialect is the prefix of the operation's name
+func (op *Operation) Dialect() string {
+	for i := range op.Name {
+		if op.Name[i] == '.' {
+			return op.Name[:i]
+		}
+	}
+	return ""
+}
+
+// AddRegion gives op a new region with one empty block and returns it
+func (op *Operation) AddRegion() *Region {
+	r := &Region{op: op}
+	r.Append(&Block{})
+	op.Regions = append(op.Regions, r)
+	return r
+}
+
+// Op returns the operation the region belongs to
+func (r *Region) Op() *Operation { return r.op }
+
+// Entry returns the first block of the region
+func (r *Region) Entry() *Block { return r.Blocks[0] }
+
+// Append adds b at the end of the region
+func (r *Region) Append(b *Block) *Block {
+	b.region = r
+	r.Blocks = append(r.Blocks, b)
+	return b
+}
+
+// Region returns the region the block is in, nil for the module's body
+func (b *Block) Region() *Region { return b.region }
+
+// AddArg gives b a new argument of type t
+func (b *Block) AddArg(t Type) *Value {
+	v := &Value{Type: t, block: b}
+	b.Args = append(b.Args, v)
+	return v
+}
+
+// Append adds op at the end of b and returns it
+func (b *Block) Append(op *Operation) *Operation {
+	op.block = b
+	b.Ops = append(b.Ops, op)
+	return op
+}
+
+// Insert adds op to b before the operation at index i
+func (b *Block) Insert(i int, op *Operation) {
+	op.block = b
+	b.Ops = append(b.Ops, nil)
+	copy(b.Ops[i+1:], b.Ops[i:])
+	b.Ops[i] = op
+}
+
+// Terminator returns the last operation of b, nil if b is empty
+func (b *Block) Terminator() *Operation {
+	if len(b.Ops) == 0 {
+		return nil
+	}
+	return b.Ops[len(b.Ops)-1]
+}
+
+// Index returns the position of op in its block
+func (op *Operation) Index() int {
+	for i, other := range op.block.Ops {
+		if other == op {
+			return i
+		}
+	}
+	return -1
+}
+
+// Erase removes op from its block
+func (op *Operation) Erase() {
+	b := op.block
+	if i := op.Index(); i >= 0 {
+		b.Ops = append(b.Ops[:i], b.Ops[i+1:]...)
+	}
+	op.block = nil
+}
+
+// Walk calls f for every operation nested in b, each before those in its
+// regions. The operations may be erased or replaced as they are visited.
+func (b *Block) Walk(f func(op *Operation)) {
+	for _, op := range append([]*Operation(nil), b.Ops...) {
+		f(op)
+		for _, r := range op.Regions {
+			for _, inner := range append([]*Block(nil), r.Blocks...) {
+				inner.Walk(f)
+			}
+		}
+	}
+}
+
+// Functions returns the func.func operations of the module
+func (m *Module) Functions() []*Operation {
+	var fns []*Operation
+	for _, op := range m.Body.Ops {
+		if op.Name == "func.func" {
+			fns = append(fns, op)
+		}
+	}
+	return fns
+}
+
+// Lookup returns the function or global named by a symbol, or nil
+func (m *Module) Lookup(symbol string) *Operation {
+	for _, op := range m.Body.Ops {
+		if name, ok := op.Attributes["sym_name"].(SymbolAttr); ok && string(name) == symbol {
+			return op
+		}
+	}
+	return nil
+}
+
+// Walk calls f for every operation nested in the regions of op
+func (op *Operation) Walk(f func(op *Operation)) {
+	for _, r := range op.Regions {
+		for _, b := range append([]*Block(nil), r.Blocks...) {
+			b.Walk(f)
+		}
+	}
+}
+
+// Uses returns the operations that use v as an operand or successor
+// argument
+func Uses(v *Value) []*Operation {
+	var uses []*Operation
+	walkScope(v, func(op *Operation) {
+		if usesValue(op, v) {
+			uses = append(uses, op)
+		}
+	})
+	return uses
+}
+
+func usesValue(op *Operation, v *Value) bool {
+	for _, o := range op.Operands {
+		if o == v {
+			return true
+		}
+	}
+	for _, s := range op.Successors {
+		for _, a := range s.Args {
+			if a == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walkScope calls f for every operation that may use v: those of the
+// function v is defined in, or of the module for a value outside any
+func walkScope(v *Value, f func(op *Operation)) {
+	b := v.block
+	if v.op != nil {
+		b = v.op.block
+	}
+	for b != nil && b.region != nil {
+		op := b.region.op
+		if op.Name == "func.func" {
+			op.Walk(f)
+			return
+		}
+		b = op.block
+	}
+	if b != nil {
+		b.Walk(f)
+	}
+}
+
+// ReplaceAllUses makes every use of old a use of new
+func ReplaceAllUses(old, new *Value) {
+	walkScope(old, func(op *Operation) {
+		for i, o := range op.Operands {
+			if o == old {
+				op.Operands[i] = new
+			}
+		}
+		for _, s := range op.Successors {
+			for i, a := range s.Args {
+				if a == old {
+					s.Args[i] = new
+				}
+			}
+		}
+	})
+}
+
+// Predecessors returns the blocks of b's region that branch to b, once
+// for each branch
+func (b *Block) Predecessors() []*Block {
+	var preds []*Block
+	if b.region == nil {
+		return nil
+	}
+	for _, other := range b.region.Blocks {
+		if t := other.Terminator(); t != nil {
+			for _, s := range t.Successors {
+				if s.Block == b {
+					preds = append(preds, other)
+				}
+			}
+		}
+	}
+	return preds
+}
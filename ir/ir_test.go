@@ -0,0 +1,439 @@
+package ir
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/internal/golden"
+	"gosling/object"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func build(t *testing.T, program *ast.Program) *Module {
+	t.Helper()
+	m, diagnostics := Build(program)
+	if len(diagnostics) > 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+	if err := Verify(m); err != nil {
+		t.Fatalf("Build gave a malformed module: %v\n%s", err, m)
+	}
+	return m
+}
+
+// stages builds program and runs the default pipeline over it, calling f
+// with the module as Build gives it and after each pass
+func stages(t *testing.T, program *ast.Program, f func(stage string, m *Module)) {
+	t.Helper()
+	m := build(t, program)
+	f("build", m)
+	passes, err := ParsePipeline(DefaultPipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := &PassManager{Passes: passes, AfterPass: func(p *Pass, m *Module) { f(p.Name, m) }}
+	if err := pm.Run(m); err != nil {
+		t.Fatalf("%v\n%s", err, m)
+	}
+}
+
+// TestGolden builds each program and compares the module after each pass
+// of the default pipeline with its .ir file in testdata
+func TestGolden(t *testing.T) {
+	for _, path := range golden.Programs(t) {
+		var out strings.Builder
+		stages(t, golden.ParseFile(t, path), func(stage string, m *Module) {
+			Dump(&out, stage, m)
+		})
+
+		golden.Compare(t, path, ".ir", []byte(out.String()), *update)
+	}
+}
+
+// TestRoundTrip checks that parsing the text of a module gives the same
+// module back, at every stage
+func TestRoundTrip(t *testing.T) {
+	for _, path := range golden.Programs(t) {
+		stages(t, golden.ParseFile(t, path), func(stage string, m *Module) {
+			text := m.String()
+			parsed, err := Parse(text)
+			if err != nil {
+				t.Errorf("%s after %s: %v\n%s", path, stage, err, text)
+				return
+			}
+			if err := Verify(parsed); err != nil {
+				t.Errorf("%s after %s: the parsed module is malformed: %v", path, stage, err)
+			}
+			if got := parsed.String(); got != text {
+				t.Errorf("%s after %s: the text changed.\nwant:\n%s\ngot:\n%s", path, stage, text, got)
+			}
+		})
+	}
+}
+
+// TestSemantics runs the module at each stage and checks that it gives
+// what the evaluator does with strict overflow, failing with the same
+// error at the same place if it fails
+func TestSemantics(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3",
+		"let x = 5; let y = x * 2; y - x",
+		"-(3 - 10)",
+		"!(1 < 2) == false",
+		`"a" + "b" == "ab"`,
+		`len("hello" + "")`,
+		"9223372036854775807 + 1",
+		"let big = 9223372036854775807; let f = fn(x: int) -> int { x * 2 }; f(big)",
+		"-9223372036854775807 - 1 - 1",
+		"let z = 0; 10 / z",
+		"let z = 0; 10 % z",
+		"7 % -1",
+		"let n = 0; let i = 0; for (i < 5) { n = n + i; i = i + 1; } n",
+		"let a = 1; if (a > 0) { a = 2; } else { a = 3; } a",
+		"let f = fn(x: int) -> int { if (x > 0) { return 1; } 0 }; f(1) + f(-1)",
+		"let g = 3; let f = fn() -> int { g = g + 1; g }; f(); f()",
+		"let mk = fn(x: int) -> fn(int) -> int { fn(y: int) -> int { x + y } }; mk(2)(3)",
+		"if (true) { 1 } else { 2 / 0 }",
+		"let x = 4; if (x == 4) { \"yes\" } else { \"no\" }",
+	}
+	run := func(name string, program *ast.Program) {
+		result := evaluator.Eval(context.Background(), program, object.NewEnvironment(),
+			evaluator.Options{StrictOverflow: true, Timeout: 5 * time.Second})
+		stages(t, program, func(stage string, m *Module) {
+			got, err := interpret(m)
+			var tr *trap
+			switch want := result.(type) {
+			case *object.Error:
+				if !errors.As(err, &tr) {
+					t.Errorf("%s after %s: want error [%s] %s, got %v %v", name, stage, want.Code, want.Message, show(got), err)
+				} else if tr.code != want.Code || tr.loc.Line != want.Location.Line || tr.loc.LineCh != want.Location.LineCh {
+					t.Errorf("%s after %s: want error [%s] at %d:%d, got %v", name, stage, want.Code, want.Location.Line, want.Location.LineCh, tr)
+				}
+			default:
+				if err != nil {
+					t.Errorf("%s after %s: %v\n%s", name, stage, err, m)
+					return
+				}
+				expected := ""
+				if result != nil && result != evaluator.NULL {
+					expected = result.Inspect()
+				}
+				if got := show(got); got != expected && !(got == "<nil>" && expected == "") {
+					t.Errorf("%s after %s: want %q, got %q\n%s", name, stage, expected, got, m)
+				}
+			}
+		})
+	}
+	for _, input := range inputs {
+		run(input, golden.Parse(t, input))
+	}
+	for _, path := range golden.Programs(t) {
+		run(path, golden.ParseFile(t, path))
+	}
+}
+
+func TestBuildUnsupported(t *testing.T) {
+	tests := []struct {
+		input   string
+		code    diag.Code
+		message string
+	}{
+		{"let y = len;", diag.UnsupportedConstruct, "cannot compile the builtin len as a value, only calls of it"},
+		{"let x = none;", diag.UnsupportedConstruct, "cannot compile none: options are not supported"},
+		{"let f = fn(x) { x };", diag.NoStaticType, "cannot work out the type of f, annotate it"},
+	}
+	for _, tt := range tests {
+		_, diagnostics := Build(golden.Parse(t, tt.input))
+		golden.Diagnostic(t, tt.input, diagnostics, tt.code, tt.message)
+	}
+}
+
+// TestPasses runs passes over small modules and compares what they give
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		pipeline string
+		input    string
+		expected string
+	}{
+		{
+			"const-prop",
+			`func.func @main() -> i64 {
+  %0 = arith.constant {value = 6} : i64
+  %1 = arith.constant {value = 7} : i64
+  %2 = arith.muli %0, %1 : i64 loc(1:2)
+  func.return %2
+}`,
+			`func.func @main() -> i64 {
+  %0 = arith.constant {value = 6} : i64
+  %1 = arith.constant {value = 7} : i64
+  %2 = arith.constant {value = 42} : i64
+  func.return %2
+}`,
+		},
+		{
+			// left to trap when the program runs
+			"const-prop,dce",
+			`func.func @main() -> i64 {
+  %0 = arith.constant {value = 9223372036854775807} : i64
+  %1 = arith.constant {value = 1} : i64
+  %2 = arith.addi %0, %1 : i64 loc(1:2)
+  %3 = arith.constant {value = 0} : i64
+  %4 = arith.divsi %1, %3 : i64 loc(2:2)
+  func.return %2
+}`,
+			`func.func @main() -> i64 {
+  %0 = arith.constant {value = 9223372036854775807} : i64
+  %1 = arith.constant {value = 1} : i64
+  %2 = arith.addi %0, %1 : i64 loc(1:2)
+  %3 = arith.constant {value = 0} : i64
+  %4 = arith.divsi %1, %3 : i64 loc(2:2)
+  func.return %2
+}`,
+		},
+		{
+			"canonicalize,dce",
+			`func.func @main(%arg0: i64) -> i64 {
+  %0 = arith.constant {value = true} : i1
+  cf.cond_br %0, ^bb1, ^bb2
+^bb1:
+  %1 = arith.constant {value = 0} : i64
+  %2 = arith.addi %arg0, %1 : i64 loc(1:2)
+  cf.br ^bb3(%2)
+^bb2:
+  cf.br ^bb3(%arg0)
+^bb3(%3: i64):
+  func.return %3
+}`,
+			`func.func @main(%arg0: i64) -> i64 {
+  func.return %arg0
+}`,
+		},
+		{
+			"canonicalize",
+			`func.func @main(%arg0: i1) -> i1 {
+  %0 = gos.not %arg0 : i1
+  %1 = gos.not %0 : i1
+  func.return %1
+}`,
+			`func.func @main(%arg0: i1) -> i1 {
+  %0 = gos.not %arg0 : i1
+  func.return %arg0
+}`,
+		},
+		{
+			"dce",
+			`func.func @main() -> i64 {
+  %0 = gos.var {type = i64} : !gos.ref<i64>
+  %1 = arith.constant {value = 1} : i64
+  gos.store %1, %0
+  func.return %1
+}
+func.func @unused() {
+  func.return
+}`,
+			`func.func @main() -> i64 {
+  %0 = arith.constant {value = 1} : i64
+  func.return %0
+}`,
+		},
+		{
+			"lower",
+			`func.func @main() -> i64 {
+  %0 = gos.var {type = i64} : !gos.ref<i64>
+  %1 = gos.constant {value = 1} : i64
+  gos.store %1, %0
+  %2 = gos.constant {value = true} : i1
+  gos.if %2 {
+    %3 = gos.constant {value = 2} : i64
+    gos.store %3, %0
+    gos.yield
+  } {
+    gos.yield
+  }
+  %4 = gos.load %0 : i64
+  gos.return %4
+}`,
+			`func.func @main() -> i64 {
+  %0 = arith.constant {value = 1} : i64
+  %1 = arith.constant {value = true} : i1
+  cf.cond_br %1, ^bb1, ^bb2
+^bb1:
+  %2 = arith.constant {value = 2} : i64
+  cf.br ^bb3(%2)
+^bb2:
+  cf.br ^bb3(%0)
+^bb3(%3: i64):
+  func.return %3
+}`,
+		},
+	}
+	indent := func(s string) string {
+		lines := strings.Split(s, "\n")
+		for i := range lines {
+			lines[i] = "  " + lines[i]
+		}
+		return "module {\n" + strings.Join(lines, "\n") + "\n}\n"
+	}
+	for _, tt := range tests {
+		m, err := Parse(indent(tt.input))
+		if err != nil {
+			t.Fatalf("%v\n%s", err, tt.input)
+		}
+		passes, err := ParsePipeline(tt.pipeline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (&PassManager{Passes: passes}).Run(m); err != nil {
+			t.Errorf("%s: %v", tt.pipeline, err)
+			continue
+		}
+		if got, want := m.String(), indent(tt.expected); got != want {
+			t.Errorf("%s gave the wrong module.\nwant:\n%s\ngot:\n%s", tt.pipeline, want, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`func.func @main() -> i64 {
+  %0 = arith.constant {value = true} : i1
+  func.return %0
+}`,
+			"'func.return' op returns (i1) from a function returning (i64)",
+		},
+		{
+			`func.func @main() {
+  %0 = arith.constant {value = 1} : i64
+  %1 = arith.addi %0, %2 : i64 loc(1:1)
+  %2 = arith.constant {value = 1} : i64
+  func.return
+}`,
+			"loc(1:1): 'arith.addi' op uses a value before it is defined",
+		},
+		{
+			`func.func @main() {
+  gos.frobnicate
+  func.return
+}`,
+			"'gos.frobnicate' op is not an operation of any dialect",
+		},
+		{
+			`func.func @main() {
+  %0 = arith.constant {value = 1} : i64
+}`,
+			"'arith.constant' op ends a block but is not a terminator",
+		},
+		{
+			`func.func @main() {
+  %0 = arith.constant {value = true} : i1
+  cf.br ^bb1(%0)
+^bb1(%1: i64):
+  func.return
+}`,
+			"'cf.br' op passes (i1) to a block taking (i64)",
+		},
+		{
+			`func.func @main() {
+  %0 = arith.constant {value = true} : i1
+  cf.cond_br %0, ^bb1, ^bb2
+^bb1:
+  %1 = arith.constant {value = 1} : i64
+  cf.br ^bb2
+^bb2:
+  %2 = arith.addi %1, %1 : i64 loc(1:1)
+  func.return
+}`,
+			"loc(1:1): 'arith.addi' op uses a value whose definition does not dominate it",
+		},
+		{
+			`func.func @main() {
+  func.call {callee = @missing}
+  func.return
+}`,
+			"'func.call' op refers to @missing, which is not a function",
+		},
+	}
+	for _, tt := range tests {
+		m, err := Parse("module {\n" + tt.input + "\n}\n")
+		if err != nil {
+			t.Fatalf("%v\n%s", err, tt.input)
+		}
+		err = Verify(m)
+		if err == nil {
+			t.Errorf("expected %q for\n%s", tt.expected, tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"func.func @main() {\n  func.return %0\n}", "3:15: use of undefined value %0"},
+		{"func.func @main() {\n  cf.br ^bb3\n}", "3:9: use of undefined block ^bb3"},
+		{"func.func @main() {\n  %0 = arith.constant {value = 1} : i64\n  %0 = arith.constant {value = 2} : i64\n  func.return\n}", "4:3: redefinition of %0"},
+		{"func.func @main() {\n  %0 = arith.constant {value = 1} : !gos.box<i64>\n  func.return\n}", "3:37: expected a type, found \"!gos.box\""},
+		{"func.func @main( {\n}", "2:18: expected a value, found \"{\""},
+	}
+	for _, tt := range tests {
+		_, err := Parse("module {\n" + tt.input + "\n}\n")
+		if err == nil {
+			t.Errorf("expected %q for\n%s", tt.expected, tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	passes, err := ParsePipeline(DefaultPipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range passes {
+		names = append(names, p.Name)
+	}
+	if got := strings.Join(names, ","); got != DefaultPipeline {
+		t.Errorf("wrong passes. want=%s, got=%s", DefaultPipeline, got)
+	}
+
+	_, err = ParsePipeline("lower,inline")
+	want := `unknown pass "inline", the passes are canonicalize, const-prop, dce, lower`
+	if err == nil || err.Error() != want {
+		t.Errorf("wrong error. want=%q, got=%v", want, err)
+	}
+}
+
+// TestVerifyAfterPass checks that the pass manager names the pass that
+// left a module malformed
+func TestVerifyAfterPass(t *testing.T) {
+	m := NewModule()
+	broken := &Pass{Name: "broken", Run: func(m *Module) error {
+		m.Body.Append(NewOp("func.return", nil))
+		return nil
+	}}
+	err := (&PassManager{Passes: []*Pass{broken}}).Run(m)
+	var verr *VerifyError
+	if !errors.As(err, &verr) || verr.Pass != "broken" {
+		t.Errorf("expected a VerifyError naming broken, got %v", err)
+	}
+}
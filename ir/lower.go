@@ -0,0 +1,328 @@
+package ir
+
+func init() {
+	registerPass(&Pass{
+		Name:    "lower",
+		Summary: "lower gos.if and gos.for to branches, variables to SSA values and integer operations to arith",
+		Run:     Lower,
+	})
+}
+
+// Lower rewrites the functions of m from the gos dialect into the cf and
+// arith dialects. The regions of gos.if and gos.for become blocks of the
+// function joined by cf.br and cf.cond_br, and gos.return becomes
+// func.return.
+//
+// Variables only loaded and stored, rather than captured by closures, are
+// promoted to SSA values: a load becomes the value last stored, and where
+// control flow joins, a value that differs along the incoming branches is
+// passed as an argument of the block. Loop headers take every variable
+// stored before the loop, leaving canonicalize to drop those the loop
+// does not change. Globals are promoted the same way when only @main
+// uses them.
+//
+// Integer and boolean constants and operations become arith's, while
+// those on strings, closures and the variables that stay in memory are
+// left in the gos dialect.
+func Lower(m *Module) error {
+	promotable := map[*Value]bool{}
+	uses := map[string][]*Operation{}
+	for _, fn := range m.Functions() {
+		fn.Walk(func(op *Operation) {
+			if op.Name == "gos.address_of" {
+				sym, _ := op.Attributes["global"].(SymbolAttr)
+				uses[string(sym)] = append(uses[string(sym)], op)
+			}
+		})
+	}
+	for _, addrs := range uses {
+		if len(addrs) == 1 && enclosing(addrs[0], "func.func").Attributes["sym_name"] == SymbolAttr("main") {
+			promotable[addrs[0].Result()] = true
+		}
+	}
+	return forEachFunction(m, func(fn *Operation) error {
+		return lowerFunction(fn, promotable)
+	})
+}
+
+type lowerer struct {
+	region *Region
+	// vars are the variables promoted to values, in the order they are
+	// defined, and replace maps the results of removed loads to the values
+	// they read
+	vars    []*Value
+	promote map[*Value]bool
+	replace map[*Value]*Value
+	block   *Block
+}
+
+// state maps each promoted variable to the value it holds
+type state map[*Value]*Value
+
+func (s state) copy() state {
+	c := make(state, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// exit is where control leaves a region being lowered: the block it
+// leaves from, the variables then and the values yielded. falls is false
+// when control never gets there.
+type exit struct {
+	falls  bool
+	block  *Block
+	state  state
+	values []*Value
+}
+
+func lowerFunction(fn *Operation, globals map[*Value]bool) error {
+	l := &lowerer{region: fn.Regions[0], promote: map[*Value]bool{}, replace: map[*Value]*Value{}}
+	if len(l.region.Blocks) != 1 {
+		// the gos dialect has no branches, so it has been lowered already
+		return nil
+	}
+	fn.Walk(func(op *Operation) {
+		if (op.Name == "gos.var" || op.Name == "gos.address_of" && globals[op.Result()]) && onlyLoadedAndStored(op.Result()) {
+			l.promote[op.Result()] = true
+			l.vars = append(l.vars, op.Result())
+		}
+	})
+
+	entry := l.region.Entry()
+	ops := entry.Ops
+	entry.Ops = nil
+	l.block = entry
+	out, err := l.lower(ops, state{})
+	if err != nil {
+		return err
+	}
+	if out.falls {
+		return opError(fn, "has a body that does not end with a return")
+	}
+	return nil
+}
+
+// onlyLoadedAndStored reports whether a variable is only ever loaded from
+// and stored to, so its value can be tracked through the function
+func onlyLoadedAndStored(ref *Value) bool {
+	for _, op := range Uses(ref) {
+		switch {
+		case op.Name == "gos.load":
+		case op.Name == "gos.store" && op.Operands[0] != ref:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (l *lowerer) newBlock() *Block {
+	return l.region.Append(&Block{})
+}
+
+func (l *lowerer) add(op *Operation) *Operation {
+	return l.block.Append(op)
+}
+
+func (l *lowerer) constant(value Attribute) *Value {
+	op := NewOp("arith.constant", nil, attributeType(value))
+	op.Attributes["value"] = value
+	return l.add(op).Result()
+}
+
+// branch ends the current block with a cf.br to target
+func (l *lowerer) branch(from *Block, target *Block, args []*Value) {
+	br := NewOp("cf.br", nil)
+	br.Successors = []*Successor{{Block: target, Args: args}}
+	from.Append(br)
+}
+
+var arithOps = map[string]string{
+	"gos.add": "arith.addi", "gos.sub": "arith.subi", "gos.mul": "arith.muli",
+	"gos.div": "arith.divsi", "gos.rem": "arith.remsi",
+}
+
+var cmpPredicates = map[string]EnumAttr{"gos.lt": "slt", "gos.gt": "sgt", "gos.eq": "eq", "gos.ne": "ne"}
+
+// lower moves ops, the operations of a block of the gos dialect, into
+// the current block, lowering them as it goes
+func (l *lowerer) lower(ops []*Operation, st state) (exit, error) {
+	for _, op := range ops {
+		for i, o := range op.Operands {
+			if r, ok := l.replace[o]; ok {
+				op.Operands[i] = r
+			}
+		}
+		switch {
+		case l.promote[firstResult(op)]:
+			// the variable is now only in st
+		case op.Name == "gos.load" && l.promote[op.Operands[0]]:
+			v, ok := st[op.Operands[0]]
+			if !ok {
+				return exit{}, opError(op, "reads a variable before it is stored")
+			}
+			l.replace[op.Result()] = v
+		case op.Name == "gos.store" && l.promote[op.Operands[1]]:
+			st[op.Operands[1]] = op.Operands[0]
+
+		case op.Name == "gos.if":
+			out, err := l.ifOp(op, st)
+			if err != nil || !out.falls {
+				return out, err
+			}
+			st = out.state
+		case op.Name == "gos.for":
+			out, err := l.forOp(op, st)
+			if err != nil || !out.falls {
+				return out, err
+			}
+			st = out.state
+		case op.Name == "gos.yield", op.Name == "gos.condition":
+			return exit{falls: true, block: l.block, state: st, values: op.Operands}, nil
+		case op.Name == "gos.return":
+			ret := NewOp("func.return", op.Operands)
+			ret.Loc = op.Loc
+			l.add(ret)
+			return exit{}, nil
+		case op.Name == "gos.unreachable":
+			l.add(op)
+			return exit{}, nil
+
+		case op.Name == "gos.constant" && !SameType(op.Result().Type, Str):
+			op.Name = "arith.constant"
+			l.add(op)
+		case arithOps[op.Name] != "":
+			op.Name = arithOps[op.Name]
+			l.add(op)
+		case op.Name == "gos.neg":
+			zero := l.constant(IntegerAttr(0))
+			op.Name = "arith.subi"
+			op.Operands = []*Value{zero, op.Operands[0]}
+			l.add(op)
+		case op.Name == "gos.not":
+			one := l.constant(BoolAttr(true))
+			op.Name = "arith.xori"
+			op.Operands = append(op.Operands, one)
+			l.add(op)
+		case cmpPredicates[op.Name] != "" && !SameType(op.Operands[0].Type, Str):
+			op.Attributes["predicate"] = cmpPredicates[op.Name]
+			op.Name = "arith.cmpi"
+			l.add(op)
+		default:
+			l.add(op)
+		}
+	}
+	return exit{falls: true, block: l.block, state: st}, nil
+}
+
+func firstResult(op *Operation) *Value {
+	if len(op.Results) == 0 {
+		return nil
+	}
+	return op.Results[0]
+}
+
+func (l *lowerer) ifOp(op *Operation, st state) (exit, error) {
+	cond := NewOp("cf.cond_br", op.Operands)
+	l.add(cond)
+	var exits []exit
+	for _, r := range op.Regions {
+		l.block = l.newBlock()
+		cond.Successors = append(cond.Successors, &Successor{Block: l.block})
+		out, err := l.lower(r.Entry().Ops, st.copy())
+		if err != nil {
+			return exit{}, err
+		}
+		if out.falls {
+			exits = append(exits, out)
+		}
+	}
+	if len(exits) == 0 {
+		return exit{}, nil
+	}
+
+	merge := l.newBlock()
+	args := make([][]*Value, len(exits))
+	for i, r := range op.Results {
+		arg := merge.AddArg(r.Type)
+		l.replace[r] = arg
+		for j, out := range exits {
+			args[j] = append(args[j], out.values[i])
+		}
+	}
+	joined := l.join(merge, exits, args)
+	for j, out := range exits {
+		l.branch(out.block, merge, args[j])
+	}
+	l.block = merge
+	return exit{falls: true, block: merge, state: joined}, nil
+}
+
+// join works out the variables after control flow from exits joins at
+// merge. A variable holding different values along the way is given an
+// argument of merge, whose value each exit appends to its args.
+func (l *lowerer) join(merge *Block, exits []exit, args [][]*Value) state {
+	joined := state{}
+	for _, v := range l.vars {
+		first, ok := exits[0].state[v]
+		same := true
+		for _, out := range exits {
+			val, found := out.state[v]
+			ok = ok && found
+			same = same && val == first
+		}
+		switch {
+		case !ok:
+			// bound along some ways only, so not read after
+		case same:
+			joined[v] = first
+		default:
+			joined[v] = merge.AddArg(first.Type)
+			for j, out := range exits {
+				args[j] = append(args[j], out.state[v])
+			}
+		}
+	}
+	return joined
+}
+
+func (l *lowerer) forOp(op *Operation, st state) (exit, error) {
+	header := l.newBlock()
+	var carried, initial []*Value
+	loop := state{}
+	for _, v := range l.vars {
+		if val, ok := st[v]; ok {
+			carried = append(carried, v)
+			initial = append(initial, val)
+			loop[v] = header.AddArg(val.Type)
+		}
+	}
+	l.branch(l.block, header, initial)
+
+	l.block = header
+	cond, err := l.lower(op.Regions[0].Entry().Ops, loop)
+	if err != nil || !cond.falls {
+		return cond, err
+	}
+	body, after := l.newBlock(), l.newBlock()
+	br := NewOp("cf.cond_br", cond.values)
+	br.Successors = []*Successor{{Block: body}, {Block: after}}
+	cond.block.Append(br)
+
+	l.block = body
+	out, err := l.lower(op.Regions[1].Entry().Ops, cond.state.copy())
+	if err != nil {
+		return exit{}, err
+	}
+	if out.falls {
+		back := make([]*Value, len(carried))
+		for i, v := range carried {
+			back[i] = out.state[v]
+		}
+		l.branch(out.block, header, back)
+	}
+	l.block = after
+	return exit{falls: true, block: after, state: cond.state}, nil
+}
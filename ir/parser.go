@@ -0,0 +1,569 @@
+package ir
+
+import (
+	"fmt"
+	"gosling/token"
+	"strconv"
+	"strings"
+)
+
+// Parse reads the text of a module, as String writes it. The module is
+// not verified.
+func Parse(src string) (m *Module, err error) {
+	p := &reader{src: src}
+	p.next()
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(parseError)
+			if !ok {
+				panic(r)
+			}
+			m, err = nil, perr
+		}
+	}()
+	return p.module(), nil
+}
+
+type parseError struct {
+	line, col int
+	msg       string
+}
+
+func (e parseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.line, e.col, e.msg)
+}
+
+type tokenKind int
+
+const (
+	tEOF    tokenKind = iota
+	tIdent            // a word, such as an operation name or a type
+	tValue            // %name
+	tBlock            // ^name
+	tSymbol           // @name
+	tInt
+	tString
+	tPunct // one of ( ) { } < > , : = or ->
+)
+
+type lexeme struct {
+	kind      tokenKind
+	text      string
+	line, col int
+}
+
+type reader struct {
+	src       string
+	pos       int
+	line, col int
+	tok       lexeme
+
+	// values holds the values of the function being read by name, and
+	// pending those used before their definition was read
+	values  map[string]*Value
+	pending map[string]lexeme
+}
+
+func (p *reader) fail(format string, a ...interface{}) {
+	panic(parseError{line: p.tok.line + 1, col: p.tok.col + 1, msg: fmt.Sprintf(format, a...)})
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *reader) advance() {
+	if p.src[p.pos] == '\n' {
+		p.line++
+		p.col = 0
+	} else {
+		p.col++
+	}
+	p.pos++
+}
+
+// next reads the next token, skipping spaces and // comments
+func (p *reader) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			p.advance()
+		} else if strings.HasPrefix(p.src[p.pos:], "//") {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.advance()
+			}
+		} else {
+			break
+		}
+	}
+	p.tok = lexeme{line: p.line, col: p.col}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tEOF
+		return
+	}
+	start := p.pos
+	word := func() {
+		for p.pos < len(p.src) && isWordByte(p.src[p.pos]) {
+			p.advance()
+		}
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '%' || c == '^' || c == '@' || c == '!':
+		p.advance()
+		word()
+		p.tok.kind = map[byte]tokenKind{'%': tValue, '^': tBlock, '@': tSymbol, '!': tIdent}[c]
+		if p.pos == start+1 {
+			p.fail("expected a name after %c", c)
+		}
+	case c == '-' && strings.HasPrefix(p.src[p.pos:], "->"):
+		p.advance()
+		p.advance()
+		p.tok.kind = tPunct
+	case c == '-' || c >= '0' && c <= '9':
+		p.advance()
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.advance()
+		}
+		p.tok.kind = tInt
+	case c == '"':
+		quoted, err := strconv.QuotedPrefix(p.src[p.pos:])
+		if err != nil {
+			p.fail("unterminated string")
+		}
+		for range len(quoted) {
+			p.advance()
+		}
+		p.tok.kind = tString
+	case isWordByte(c):
+		word()
+		p.tok.kind = tIdent
+	case strings.IndexByte("(){}<>,:=", c) >= 0:
+		p.advance()
+		p.tok.kind = tPunct
+	default:
+		p.fail("unexpected character %q", c)
+	}
+	p.tok.text = p.src[start:p.pos]
+}
+
+func (p *reader) is(text string) bool {
+	return (p.tok.kind == tPunct || p.tok.kind == tIdent) && p.tok.text == text
+}
+
+func (p *reader) expect(text string) {
+	if !p.is(text) {
+		p.fail("expected %s, found %s", text, p.describe())
+	}
+	p.next()
+}
+
+func (p *reader) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *reader) describe() string {
+	if p.tok.kind == tEOF {
+		return "the end of the input"
+	}
+	return strconv.Quote(p.tok.text)
+}
+
+// peek reports the kind and text of the token after the current one
+func (p *reader) peek() lexeme {
+	saved := *p
+	p.next()
+	tok := p.tok
+	*p = saved
+	return tok
+}
+
+func (p *reader) module() *Module {
+	m := NewModule()
+	p.expect("module")
+	p.expect("{")
+	for !p.is("}") {
+		p.values = map[string]*Value{}
+		p.pending = map[string]lexeme{}
+		op := p.op(nil)
+		for name, tok := range p.pending {
+			p.tok = tok
+			p.fail("use of undefined value %s", name)
+		}
+		m.Body.Append(op)
+	}
+	p.expect("}")
+	if p.tok.kind != tEOF {
+		p.fail("expected the end of the input, found %s", p.describe())
+	}
+	return m
+}
+
+// use returns the value named by the current token, which may be
+// defined further on
+func (p *reader) use() *Value {
+	if p.tok.kind != tValue {
+		p.fail("expected a value, found %s", p.describe())
+	}
+	v, ok := p.values[p.tok.text]
+	if !ok {
+		v = &Value{}
+		p.values[p.tok.text] = v
+		p.pending[p.tok.text] = p.tok
+	}
+	p.next()
+	return v
+}
+
+// define returns the value a definition of the current token names,
+// that which earlier uses were given if any
+func (p *reader) define() *Value {
+	if p.tok.kind != tValue {
+		p.fail("expected a value, found %s", p.describe())
+	}
+	name := p.tok.text
+	v, ok := p.values[name]
+	if ok {
+		if _, used := p.pending[name]; !used {
+			p.fail("redefinition of %s", name)
+		}
+		delete(p.pending, name)
+	} else {
+		v = &Value{}
+		p.values[name] = v
+	}
+	p.next()
+	return v
+}
+
+// blocks holds the blocks of the region being read by label
+type blocks struct {
+	region  *Region
+	labels  map[string]*Block
+	defined map[*Block]bool
+	uses    map[*Block]lexeme
+}
+
+func (bs *blocks) get(label string) *Block {
+	b, ok := bs.labels[label]
+	if !ok {
+		b = &Block{region: bs.region}
+		bs.labels[label] = b
+	}
+	return b
+}
+
+func (p *reader) op(bs *blocks) *Operation {
+	start := p.tok
+	var results []*Value
+	if p.tok.kind == tValue {
+		results = append(results, p.define())
+		for p.accept(",") {
+			results = append(results, p.define())
+		}
+		p.expect("=")
+	}
+	if p.tok.kind != tIdent || !strings.Contains(p.tok.text, ".") {
+		p.fail("expected an operation, found %s", p.describe())
+	}
+	if p.tok.text == "func.func" {
+		if len(results) > 0 {
+			p.fail("func.func has no results")
+		}
+		return p.function()
+	}
+
+	op := &Operation{Name: p.tok.text, Attributes: map[string]Attribute{}}
+	line := p.tok.line
+	p.next()
+	// operands and successors, on the line of the name
+	if p.tok.line == line && (p.tok.kind == tValue || p.tok.kind == tBlock) {
+		for {
+			if p.tok.kind == tBlock {
+				if bs == nil {
+					p.fail("a successor outside a region")
+				}
+				succ := &Successor{Block: bs.get(p.tok.text)}
+				bs.uses[succ.Block] = p.tok
+				p.next()
+				if p.accept("(") {
+					succ.Args = append(succ.Args, p.use())
+					for p.accept(",") {
+						succ.Args = append(succ.Args, p.use())
+					}
+					p.expect(")")
+				}
+				op.Successors = append(op.Successors, succ)
+			} else {
+				if len(op.Successors) > 0 {
+					p.fail("operands must come before successors")
+				}
+				op.Operands = append(op.Operands, p.use())
+			}
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.is("{") && p.isAttributes() {
+		p.attributes(op)
+	}
+	var types []Type
+	if p.accept(":") {
+		types = append(types, p.typ())
+		for p.accept(",") {
+			types = append(types, p.typ())
+		}
+	}
+	if len(types) != len(results) {
+		p.tok = start
+		p.fail("%s has %d results but %d result types", op.Name, len(results), len(types))
+	}
+	for i, r := range results {
+		r.Type, r.op = types[i], op
+		op.Results = append(op.Results, r)
+	}
+	for p.is("{") {
+		p.region(op)
+	}
+	p.loc(op)
+	return op
+}
+
+// isAttributes reports whether the brace at the current token opens an
+// attribute dictionary, key = value, rather than a region
+func (p *reader) isAttributes() bool {
+	saved := *p
+	defer func() { *p = saved }()
+	p.next()
+	if p.tok.kind != tIdent {
+		return false
+	}
+	p.next()
+	return p.is("=")
+}
+
+func (p *reader) attributes(op *Operation) {
+	p.expect("{")
+	for {
+		if p.tok.kind != tIdent {
+			p.fail("expected an attribute name, found %s", p.describe())
+		}
+		key := p.tok.text
+		p.next()
+		p.expect("=")
+		op.Attributes[key] = p.attribute()
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect("}")
+}
+
+func (p *reader) attribute() Attribute {
+	tok := p.tok
+	switch {
+	case tok.kind == tInt:
+		p.next()
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			p.tok = tok
+			p.fail("integer %s does not fit in 64 bits", tok.text)
+		}
+		return IntegerAttr(n)
+	case tok.kind == tString:
+		p.next()
+		s, _ := strconv.Unquote(tok.text)
+		return StringAttr(s)
+	case tok.kind == tSymbol:
+		p.next()
+		return SymbolAttr(tok.text[1:])
+	case p.is("true"), p.is("false"):
+		p.next()
+		return BoolAttr(tok.text == "true")
+	case p.is("eq"), p.is("ne"), p.is("slt"), p.is("sgt"):
+		p.next()
+		return EnumAttr(tok.text)
+	}
+	return TypeAttr{Type: p.typ()}
+}
+
+func (p *reader) loc(op *Operation) {
+	if !p.is("loc") || p.peek().text != "(" {
+		return
+	}
+	p.next()
+	p.expect("(")
+	line := p.int()
+	p.expect(":")
+	char := p.int()
+	p.expect(")")
+	op.Loc = &token.TokenLocation{Line: line, LineCh: char}
+}
+
+func (p *reader) int() int {
+	if p.tok.kind != tInt {
+		p.fail("expected an integer, found %s", p.describe())
+	}
+	n, err := strconv.Atoi(p.tok.text)
+	if err != nil {
+		p.fail("bad integer %s", p.tok.text)
+	}
+	p.next()
+	return n
+}
+
+func (p *reader) typ() Type {
+	tok := p.tok
+	switch {
+	case p.accept("i64"):
+		return I64
+	case p.accept("i1"):
+		return I1
+	case p.accept("!gos.str"):
+		return Str
+	case p.accept("!gos.ref"):
+		p.expect("<")
+		elem := p.typ()
+		p.expect(">")
+		return &RefType{Elem: elem}
+	case p.accept("!gos.fn"):
+		p.expect("<")
+		fn := p.functionType()
+		p.expect(">")
+		return &ClosureType{Func: fn}
+	case p.is("("):
+		return p.functionType()
+	}
+	p.tok = tok
+	p.fail("expected a type, found %s", p.describe())
+	return nil
+}
+
+// functionType reads (T, ...) -> T, or -> () for no result
+func (p *reader) functionType() *FunctionType {
+	fn := &FunctionType{}
+	p.expect("(")
+	if !p.is(")") {
+		fn.Params = append(fn.Params, p.typ())
+		for p.accept(",") {
+			fn.Params = append(fn.Params, p.typ())
+		}
+	}
+	p.expect(")")
+	p.expect("->")
+	if p.accept("(") {
+		p.expect(")")
+	} else {
+		fn.Result = p.typ()
+	}
+	return fn
+}
+
+func (p *reader) function() *Operation {
+	op := &Operation{Name: "func.func", Attributes: map[string]Attribute{}}
+	p.next()
+	if p.tok.kind != tSymbol {
+		p.fail("expected the function's name, found %s", p.describe())
+	}
+	op.Attributes["sym_name"] = SymbolAttr(p.tok.text[1:])
+	p.next()
+
+	r := &Region{op: op}
+	op.Regions = append(op.Regions, r)
+	entry := &Block{region: r}
+	fn := &FunctionType{}
+	p.expect("(")
+	for !p.is(")") {
+		if len(entry.Args) > 0 {
+			p.expect(",")
+		}
+		arg := p.define()
+		p.expect(":")
+		arg.Type, arg.block = p.typ(), entry
+		entry.Args = append(entry.Args, arg)
+		fn.Params = append(fn.Params, arg.Type)
+	}
+	p.expect(")")
+	if p.accept("->") {
+		fn.Result = p.typ()
+	}
+	op.Attributes["function_type"] = TypeAttr{Type: fn}
+	if p.accept("attributes") {
+		p.attributes(op)
+	}
+	p.regionBody(r, entry)
+	p.loc(op)
+	return op
+}
+
+// region reads a region of op
+func (p *reader) region(op *Operation) {
+	r := &Region{op: op}
+	op.Regions = append(op.Regions, r)
+	p.regionBody(r, nil)
+}
+
+// regionBody reads the blocks of r between braces. entry is the first
+// block if its arguments were read already.
+func (p *reader) regionBody(r *Region, entry *Block) {
+	p.expect("{")
+	bs := &blocks{region: r, labels: map[string]*Block{}, defined: map[*Block]bool{}, uses: map[*Block]lexeme{}}
+	b := entry
+	if p.tok.kind == tBlock {
+		if entry != nil {
+			bs.labels[p.tok.text] = entry
+		}
+		b = p.label(bs)
+	} else {
+		if b == nil {
+			b = &Block{region: r}
+		}
+		bs.labels["^bb0"] = b
+		bs.defined[b] = true
+	}
+	r.Blocks = append(r.Blocks, b)
+	for !p.is("}") {
+		if p.tok.kind == tBlock {
+			b = p.label(bs)
+			r.Blocks = append(r.Blocks, b)
+			continue
+		}
+		b.Append(p.op(bs))
+	}
+	p.expect("}")
+	for blk, tok := range bs.uses {
+		if !bs.defined[blk] {
+			p.tok = tok
+			p.fail("use of undefined block %s", tok.text)
+		}
+	}
+}
+
+// label reads the label of a block, ^bbN(%arg: type, ...):
+func (p *reader) label(bs *blocks) *Block {
+	b := bs.get(p.tok.text)
+	if bs.defined[b] {
+		p.fail("redefinition of block %s", p.tok.text)
+	}
+	bs.defined[b] = true
+	p.next()
+	if p.accept("(") {
+		for {
+			arg := p.define()
+			p.expect(":")
+			arg.Type, arg.block = p.typ(), b
+			b.Args = append(b.Args, arg)
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect(")")
+	}
+	p.expect(":")
+	return b
+}
@@ -0,0 +1,95 @@
+package ir
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pass transforms a module in place
+type Pass struct {
+	Name    string
+	Summary string
+	Run     func(m *Module) error
+}
+
+var passes = map[string]*Pass{}
+
+func registerPass(p *Pass) {
+	passes[p.Name] = p
+}
+
+// LookupPass returns the pass called name, nil if there is none
+func LookupPass(name string) *Pass {
+	return passes[name]
+}
+
+// Passes returns every pass, sorted by name
+func Passes() []*Pass {
+	all := make([]*Pass, 0, len(passes))
+	for _, p := range passes {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// DefaultPipeline lowers a module and optimises it
+const DefaultPipeline = "lower,const-prop,canonicalize,dce"
+
+// ParsePipeline returns the passes a comma separated list names, in order
+func ParsePipeline(pipeline string) ([]*Pass, error) {
+	var list []*Pass
+	for _, name := range strings.Split(pipeline, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p := LookupPass(name)
+		if p == nil {
+			var names []string
+			for _, p := range Passes() {
+				names = append(names, p.Name)
+			}
+			return nil, fmt.Errorf("unknown pass %q, the passes are %s", name, strings.Join(names, ", "))
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// PassManager runs passes over a module one after another, verifying it
+// after each
+type PassManager struct {
+	Passes []*Pass
+	// AfterPass, if set, is called with the module after each pass has run
+	// and it has been verified
+	AfterPass func(pass *Pass, m *Module)
+}
+
+// Run runs the passes over m, stopping at the first that fails or leaves
+// m malformed
+func (pm *PassManager) Run(m *Module) error {
+	for _, p := range pm.Passes {
+		if err := p.Run(m); err != nil {
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+		if err := Verify(m); err != nil {
+			return &VerifyError{Pass: p.Name, Err: err}
+		}
+		if pm.AfterPass != nil {
+			pm.AfterPass(p, m)
+		}
+	}
+	return nil
+}
+
+// forEachFunction calls f for each function of m
+func forEachFunction(m *Module, f func(fn *Operation) error) error {
+	for _, fn := range m.Functions() {
+		if err := f(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
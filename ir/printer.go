@@ -0,0 +1,246 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The text of a module is a module { ... } holding its operations, one
+// per line. An operation is written
+//
+//	%r = dialect.op %a, %b, ^bb1(%c), ^bb2 {key = value} : type {region} loc(line:char)
+//
+// leaving out whatever it does not have: results, operands, successors,
+// attributes, result types, regions and location. A region is its blocks
+// between braces, each but the first labelled ^bbN(%arg: type): with the
+// arguments it takes. A function is written as in MLIR, its arguments
+// named in its signature:
+//
+//	func.func @name(%arg0: i64) -> i64 { ... }
+//
+// Values are numbered in the order they are defined within each function,
+// and blocks in the order they come within each region.
+
+// String returns the text of the module
+func (m *Module) String() string {
+	p := &printer{}
+	p.line("module {")
+	p.indent++
+	for _, op := range m.Body.Ops {
+		p.names = map[*Value]string{}
+		p.number(op)
+		p.op(op)
+	}
+	p.indent--
+	p.line("}")
+	return p.out.String()
+}
+
+// Dump writes m to w under a header naming the stage it is at: the pass
+// that has just run, or build for the module Build gave
+func Dump(w io.Writer, stage string, m *Module) {
+	fmt.Fprintf(w, "// -----// IR Dump After %s //----- //\n%s\n", stage, m)
+}
+
+type printer struct {
+	out    strings.Builder
+	indent int
+	names  map[*Value]string
+	// blocks holds the labels of the blocks of the regions being printed
+	blocks map[*Block]string
+}
+
+func (p *printer) line(format string, a ...interface{}) {
+	p.out.WriteString(strings.Repeat("  ", p.indent))
+	fmt.Fprintf(&p.out, format, a...)
+	p.out.WriteString("\n")
+}
+
+// number names the values defined in op and the operations nested in it,
+// ahead of printing it, as a branch may pass a value defined in a block
+// printed after it
+func (p *printer) number(op *Operation) {
+	if op.Name == "func.func" && len(op.Regions) == 1 && len(op.Regions[0].Blocks) > 0 {
+		for i, arg := range op.Regions[0].Entry().Args {
+			p.names[arg] = fmt.Sprintf("%%arg%d", i)
+		}
+	}
+	n := 0
+	var visit func(op *Operation)
+	visit = func(op *Operation) {
+		for _, r := range op.Results {
+			p.names[r] = fmt.Sprintf("%%%d", n)
+			n++
+		}
+		for _, r := range op.Regions {
+			for _, b := range r.Blocks {
+				for _, arg := range b.Args {
+					if _, ok := p.names[arg]; !ok {
+						p.names[arg] = fmt.Sprintf("%%%d", n)
+						n++
+					}
+				}
+				for _, inner := range b.Ops {
+					visit(inner)
+				}
+			}
+		}
+	}
+	visit(op)
+}
+
+func (p *printer) value(v *Value) string {
+	if name, ok := p.names[v]; ok {
+		return name
+	}
+	// a value defined outside the function, which the verifier reports
+	return "%<undefined>"
+}
+
+func (p *printer) op(op *Operation) {
+	if op.Name == "func.func" {
+		p.function(op)
+		return
+	}
+	var s strings.Builder
+	for i, r := range op.Results {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(p.value(r))
+	}
+	if len(op.Results) > 0 {
+		s.WriteString(" = ")
+	}
+	s.WriteString(op.Name)
+	var operands []string
+	for _, o := range op.Operands {
+		operands = append(operands, p.value(o))
+	}
+	for _, succ := range op.Successors {
+		operands = append(operands, p.successor(succ))
+	}
+	if len(operands) > 0 {
+		s.WriteString(" " + strings.Join(operands, ", "))
+	}
+	if attrs := attributes(op, nil); attrs != "" {
+		s.WriteString(" " + attrs)
+	}
+	if len(op.Results) > 0 {
+		types := make([]string, len(op.Results))
+		for i, r := range op.Results {
+			types[i] = r.Type.String()
+		}
+		s.WriteString(" : " + strings.Join(types, ", "))
+	}
+	if len(op.Regions) == 0 {
+		p.line("%s%s", s.String(), location(op))
+		return
+	}
+	p.line("%s {", s.String())
+	for i, r := range op.Regions {
+		p.region(r)
+		if i < len(op.Regions)-1 {
+			p.line("} {")
+		}
+	}
+	p.line("}%s", location(op))
+}
+
+func location(op *Operation) string {
+	if op.Loc == nil {
+		return ""
+	}
+	return fmt.Sprintf(" loc(%d:%d)", op.Loc.Line, op.Loc.LineCh)
+}
+
+// attributes writes the attribute dictionary of op, leaving out those
+// in skip
+func attributes(op *Operation, skip map[string]bool) string {
+	var keys []string
+	for k := range op.Attributes {
+		if !skip[k] {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + " = " + op.Attributes[k].String()
+	}
+	return "{" + strings.Join(keys, ", ") + "}"
+}
+
+func (p *printer) successor(s *Successor) string {
+	label := p.blocks[s.Block]
+	if label == "" {
+		label = "^<undefined>"
+	}
+	if len(s.Args) == 0 {
+		return label
+	}
+	args := make([]string, len(s.Args))
+	for i, a := range s.Args {
+		args[i] = p.value(a)
+	}
+	return label + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (p *printer) function(op *Operation) {
+	name, _ := op.Attributes["sym_name"].(SymbolAttr)
+	t, _ := op.Attributes["function_type"].(TypeAttr)
+	fn, _ := t.Type.(*FunctionType)
+	var params []string
+	if len(op.Regions) == 1 && len(op.Regions[0].Blocks) > 0 {
+		for _, arg := range op.Regions[0].Entry().Args {
+			params = append(params, p.value(arg)+": "+arg.Type.String())
+		}
+	}
+	header := fmt.Sprintf("func.func %s(%s)", name, strings.Join(params, ", "))
+	if fn != nil && fn.Result != nil {
+		header += " -> " + fn.Result.String()
+	}
+	if attrs := attributes(op, map[string]bool{"sym_name": true, "function_type": true}); attrs != "" {
+		header += " attributes " + attrs
+	}
+	if len(op.Regions) != 1 {
+		p.line("%s%s", header, location(op))
+		return
+	}
+	p.line("%s {", header)
+	p.region(op.Regions[0])
+	p.line("}%s", location(op))
+}
+
+func (p *printer) region(r *Region) {
+	saved := p.blocks
+	p.blocks = map[*Block]string{}
+	for i, b := range r.Blocks {
+		p.blocks[b] = fmt.Sprintf("^bb%d", i)
+	}
+	p.indent++
+	for i, b := range r.Blocks {
+		if i > 0 || (len(b.Args) > 0 && r.op.Name != "func.func") {
+			args := make([]string, len(b.Args))
+			for j, a := range b.Args {
+				args[j] = p.value(a) + ": " + a.Type.String()
+			}
+			label := p.blocks[b]
+			if len(args) > 0 {
+				label += "(" + strings.Join(args, ", ") + ")"
+			}
+			p.indent--
+			p.line("%s:", label)
+			p.indent++
+		}
+		for _, op := range b.Ops {
+			p.op(op)
+		}
+	}
+	p.indent--
+	p.blocks = saved
+}
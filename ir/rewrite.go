@@ -0,0 +1,113 @@
+package ir
+
+// What the passes share for rewriting functions
+
+// ConstantOf returns the value v is known to have, if it is the result of
+// a constant operation
+func ConstantOf(v *Value) (Attribute, bool) {
+	if v.op == nil || (v.op.Name != "arith.constant" && v.op.Name != "gos.constant") {
+		return nil, false
+	}
+	a, ok := v.op.Attributes["value"]
+	return a, ok
+}
+
+// newConstant makes a constant of the level op belongs to, arith's for
+// integers and booleans outside the gos dialect or in a function that has
+// been lowered, which gos.len and the like outlive
+func newConstant(value Attribute, op *Operation) *Operation {
+	name := "gos.constant"
+	if attributeType(value) != Str && (op.Dialect() != "gos" || lowered(op)) {
+		name = "arith.constant"
+	}
+	c := NewOp(name, nil, attributeType(value))
+	c.Attributes["value"] = value
+	return c
+}
+
+// lowered reports whether the function op is in has been lowered, which
+// its blocks ending in func.return rather than gos.return tell
+func lowered(op *Operation) bool {
+	fn := enclosing(op, "func.func")
+	if fn == nil {
+		return false
+	}
+	for _, b := range fn.Regions[0].Blocks {
+		if t := b.Terminator(); t != nil && t.Name == "func.return" {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceOp replaces the result of op with v and erases op
+func replaceOp(op *Operation, v *Value) {
+	ReplaceAllUses(op.Result(), v)
+	op.Erase()
+}
+
+// replaceWithConstant replaces op with a constant of the given value
+func replaceWithConstant(op *Operation, value Attribute) {
+	c := newConstant(value, op)
+	op.block.Insert(op.Index(), c)
+	replaceOp(op, c.Result())
+}
+
+// edges returns the successors of b's region that branch to b
+func edges(b *Block) []*Successor {
+	var list []*Successor
+	for _, other := range b.region.Blocks {
+		if t := other.Terminator(); t != nil {
+			for _, s := range t.Successors {
+				if s.Block == b {
+					list = append(list, s)
+				}
+			}
+		}
+	}
+	return list
+}
+
+// removeArg removes the ith argument of b and what each branch passes
+// for it
+func removeArg(b *Block, i int) {
+	for _, s := range edges(b) {
+		s.Args = append(s.Args[:i], s.Args[i+1:]...)
+	}
+	b.Args = append(b.Args[:i], b.Args[i+1:]...)
+}
+
+// eraseBlock removes b from its region
+func eraseBlock(b *Block) {
+	r := b.region
+	for i, other := range r.Blocks {
+		if other == b {
+			r.Blocks = append(r.Blocks[:i], r.Blocks[i+1:]...)
+			break
+		}
+	}
+	b.region = nil
+}
+
+// cfgRegions returns the regions of fn with more than one block, those
+// joined by branches, fn's body first
+func cfgRegions(fn *Operation) []*Region {
+	var list []*Region
+	if len(fn.Regions[0].Blocks) > 1 {
+		list = append(list, fn.Regions[0])
+	}
+	fn.Walk(func(op *Operation) {
+		for _, r := range op.Regions {
+			if len(r.Blocks) > 1 {
+				list = append(list, r)
+			}
+		}
+	})
+	return list
+}
+
+// fixpoint runs f until it reports no change
+func fixpoint(f func() bool) {
+	for f() {
+	}
+}
@@ -0,0 +1,124 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of a value. Types are compared by how they print.
+type Type interface {
+	String() string
+}
+
+// IntegerType is a signed integer of a number of bits, i64 for integers
+// and i1 for booleans
+type IntegerType struct {
+	Bits int
+}
+
+func (t *IntegerType) String() string { return fmt.Sprintf("i%d", t.Bits) }
+
+var (
+	I64 Type = &IntegerType{Bits: 64}
+	I1  Type = &IntegerType{Bits: 1}
+)
+
+// StringType is the gos dialect's immutable string
+type StringType struct{}
+
+func (t *StringType) String() string { return "!gos.str" }
+
+var Str Type = &StringType{}
+
+// RefType is the address of a variable holding a T, which loads and stores
+// go through
+type RefType struct {
+	Elem Type
+}
+
+func (t *RefType) String() string { return "!gos.ref<" + t.Elem.String() + ">" }
+
+// FunctionType is the type of a function's symbol: the types of its
+// arguments and of its result, nil if it returns nothing
+type FunctionType struct {
+	Params []Type
+	Result Type
+}
+
+func (t *FunctionType) String() string {
+	params := make([]string, len(t.Params))
+	for i, p := range t.Params {
+		params[i] = p.String()
+	}
+	result := "()"
+	if t.Result != nil {
+		result = t.Result.String()
+	}
+	return "(" + strings.Join(params, ", ") + ") -> " + result
+}
+
+// ClosureType is a function value of the gos dialect, a function together
+// with the variables it captured. Its type is that of the calls made of it,
+// without the captured variables.
+type ClosureType struct {
+	Func *FunctionType
+}
+
+func (t *ClosureType) String() string { return "!gos.fn<" + t.Func.String() + ">" }
+
+// SameType reports whether a and b are the same type, either being nil
+// for no value
+func SameType(a, b Type) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.String() == b.String()
+}
+
+// Attribute is a constant attached to an operation
+type Attribute interface {
+	String() string
+}
+
+type IntegerAttr int64
+
+func (a IntegerAttr) String() string { return strconv.FormatInt(int64(a), 10) }
+
+type BoolAttr bool
+
+func (a BoolAttr) String() string { return strconv.FormatBool(bool(a)) }
+
+type StringAttr string
+
+func (a StringAttr) String() string { return strconv.Quote(string(a)) }
+
+// SymbolAttr names a function or global of the module
+type SymbolAttr string
+
+func (a SymbolAttr) String() string { return "@" + string(a) }
+
+type TypeAttr struct {
+	Type Type
+}
+
+func (a TypeAttr) String() string { return a.Type.String() }
+
+// EnumAttr is one of a fixed set of words, such as the predicate of a
+// comparison
+type EnumAttr string
+
+func (a EnumAttr) String() string { return string(a) }
+
+// attributeType is the type of the value a constant attribute gives
+func attributeType(a Attribute) Type {
+	switch a.(type) {
+	case IntegerAttr:
+		return I64
+	case BoolAttr:
+		return I1
+	case StringAttr:
+		return Str
+	}
+	return nil
+}
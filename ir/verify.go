@@ -0,0 +1,242 @@
+package ir
+
+import "fmt"
+
+// Verify checks that m is well formed: every operation is one a dialect
+// has and follows its rules, every block in a region ends with its only
+// terminator, branches go to blocks of their own region with the
+// arguments those take, and every value is defined where it is used, in a
+// block that dominates the use or one its region is nested in.
+func Verify(m *Module) error {
+	v := &verifier{m: m, dominators: map[*Region]map[*Block][]bool{}}
+	for _, op := range m.Body.Ops {
+		if op.block != m.Body {
+			return opError(op, "is not linked to the module")
+		}
+		if err := v.op(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	m *Module
+	// dominators maps each block of the regions seen so far to the set of
+	// blocks that dominate it, by index in the region
+	dominators map[*Region]map[*Block][]bool
+}
+
+func (v *verifier) op(op *Operation) error {
+	def := Definition(op.Name)
+	if def == nil {
+		return opError(op, "is not an operation of any dialect")
+	}
+	if def.Terminator && op.block.region == nil {
+		return opError(op, "is a terminator outside a region")
+	}
+	if !def.Terminator && len(op.Successors) > 0 {
+		return opError(op, "has successors but is not a terminator")
+	}
+	for _, r := range op.Results {
+		if r.op != op || r.Type == nil {
+			return opError(op, "has a result it does not define")
+		}
+	}
+	for _, o := range op.Operands {
+		if err := v.use(op, o); err != nil {
+			return err
+		}
+	}
+	for _, s := range op.Successors {
+		if s.Block.region != op.block.region || s.Block.index() < 0 {
+			return opError(op, "branches to a block outside its region")
+		}
+		if s.Block == s.Block.region.Entry() {
+			return opError(op, "branches to the entry block of its region")
+		}
+		if got, want := valueTypes(s.Args), valueTypes(s.Block.Args); !sameTypes(got, want) {
+			return opError(op, "passes %s to a block taking %s", typeList(got), typeList(want))
+		}
+		for _, a := range s.Args {
+			if err := v.use(op, a); err != nil {
+				return err
+			}
+		}
+	}
+	if err := def.Verify(op, v.m); err != nil {
+		return err
+	}
+
+	for _, r := range op.Regions {
+		if r.op != op {
+			return opError(op, "has a region it does not own")
+		}
+		if len(r.Blocks) == 0 {
+			return opError(op, "has a region without blocks")
+		}
+		for _, b := range r.Blocks {
+			if b.region != r {
+				return opError(op, "has a block it does not own")
+			}
+			if err := v.block(op, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *verifier) block(parent *Operation, b *Block) error {
+	if len(b.Ops) == 0 {
+		return opError(parent, "has an empty block")
+	}
+	for _, a := range b.Args {
+		if a.block != b || a.op != nil {
+			return opError(parent, "has a block with an argument it does not define")
+		}
+	}
+	for i, op := range b.Ops {
+		if op.block != b {
+			return opError(op, "is not linked to its block")
+		}
+		def := Definition(op.Name)
+		last := i == len(b.Ops)-1
+		if def != nil && def.Terminator && !last {
+			return opError(op, "must be the last operation of its block")
+		}
+		if last && (def == nil || !def.Terminator) {
+			return opError(op, "ends a block but is not a terminator")
+		}
+		if err := v.op(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index returns the position of b in its region, -1 if it is not there
+func (b *Block) index() int {
+	if b.region == nil {
+		return -1
+	}
+	for i, other := range b.region.Blocks {
+		if other == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// use checks that val is defined where op uses it
+func (v *verifier) use(op *Operation, val *Value) error {
+	var def *Block
+	switch {
+	case val.op != nil:
+		def = val.op.block
+	case val.block != nil:
+		def = val.block
+	}
+	if def == nil {
+		return opError(op, "uses a value with no definition")
+	}
+	// find the operation op is nested in, or op itself, that is in a block
+	// of the region the value is defined in
+	user := op
+	for user.block != nil && user.block.region != def.region {
+		if user.block.region == nil {
+			return opError(op, "uses a value defined outside its function")
+		}
+		user = user.block.region.op
+	}
+	if user.block == nil {
+		return opError(op, "uses a value defined outside its function")
+	}
+	if user.block == def {
+		if val.op != nil && val.op.Index() >= user.Index() {
+			return opError(op, "uses a value before it is defined")
+		}
+		return nil
+	}
+	if def.region == nil || !v.dominates(def, user.block) {
+		return opError(op, "uses a value whose definition does not dominate it")
+	}
+	return nil
+}
+
+// dominates reports whether every path from the entry of a's region to b
+// goes through a. Blocks that cannot be reached are dominated by all.
+func (v *verifier) dominates(a, b *Block) bool {
+	r := a.region
+	dom, ok := v.dominators[r]
+	if !ok {
+		dom = Dominators(r)
+		v.dominators[r] = dom
+	}
+	return dom[b][a.index()]
+}
+
+// Dominators returns, for each block of r, which blocks of r dominate it
+// by their index in the region
+func Dominators(r *Region) map[*Block][]bool {
+	n := len(r.Blocks)
+	index := map[*Block]int{}
+	for i, b := range r.Blocks {
+		index[b] = i
+	}
+	preds := make([][]int, n)
+	for i, b := range r.Blocks {
+		if t := b.Terminator(); t != nil {
+			for _, s := range t.Successors {
+				if j, ok := index[s.Block]; ok {
+					preds[j] = append(preds[j], i)
+				}
+			}
+		}
+	}
+	sets := make([][]bool, n)
+	for i := range sets {
+		sets[i] = make([]bool, n)
+		for j := range sets[i] {
+			sets[i][j] = i != 0 || j == 0
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := 1; i < n; i++ {
+			next := make([]bool, n)
+			for j := range next {
+				next[j] = true
+			}
+			for _, p := range preds[i] {
+				for j := range next {
+					next[j] = next[j] && sets[p][j]
+				}
+			}
+			next[i] = true
+			for j := range next {
+				if next[j] != sets[i][j] {
+					changed = true
+				}
+			}
+			sets[i] = next
+		}
+	}
+	dom := make(map[*Block][]bool, n)
+	for i, b := range r.Blocks {
+		dom[b] = sets[i]
+	}
+	return dom
+}
+
+// VerifyError is an error Verify found after a pass had run
+type VerifyError struct {
+	Pass string
+	Err  error
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("after %s: %s", e.Pass, e.Err)
+}
+
+func (e *VerifyError) Unwrap() error { return e.Err }
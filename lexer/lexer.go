@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type Lexer struct {
@@ -13,6 +14,7 @@ type Lexer struct {
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+	comments     []token.Token
 }
 
 // I split LexFile, LexRepl, and New out here,
@@ -81,6 +83,10 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhiteSpace()
+	for l.ch == '/' && l.peekChar() == '/' {
+		l.readComment()
+		l.skipWhiteSpace()
+	}
 
 	switch l.ch {
 	case '=':
@@ -111,16 +117,28 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LBRACE, l.ch, l.Location)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch, l.Location)
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch, l.Location)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch, l.Location)
 	case '-':
-		tok = newToken(token.MINUS, l.ch, l.Location)
+		if l.peekChar() == '>' {
+			loc := l.Location
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "->", Location: loc}
+		} else {
+			tok = newToken(token.MINUS, l.ch, l.Location)
+		}
+	case ':':
+		tok = newToken(token.COLON, l.ch, l.Location)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal, Location: tok.Location}
+			tok = token.Token{Type: token.NOT_EQ, Literal: literal, Location: l.Location}
 		} else {
-			tok = newToken(token.BANG, l.ch, tok.Location)
+			tok = newToken(token.BANG, l.ch, l.Location)
 		}
 	case '/':
 		tok = newToken(token.SLASH, l.ch, l.Location)
@@ -136,20 +154,23 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = ""
 		tok.Type = token.EOF
 	case '"':
+		tok.Location = l.Location
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
 	default:
 		if isLetter(l.ch) {
+			tok.Location = l.Location
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
+			tok.Location = l.Location
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
 			return tok
 		} else {
+			// reported by the parser as diag.IllegalCharacter
 			tok = newToken(token.ILLEGAL, l.ch, l.Location)
-			log.Printf("illegal token at line: %d char: %d\n", l.Location.Line, l.Location.LineCh)
 		}
 	}
 
@@ -168,7 +189,7 @@ func newToken(tokenType token.TokenType, ch byte, loc token.TokenLocation) token
 
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || isDigit(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
@@ -182,6 +203,22 @@ func (l *Lexer) readNumber() string {
 	return l.input[position:l.position]
 }
 
+// readComment records a // comment, up to the end of the line, and moves past it
+func (l *Lexer) readComment() {
+	loc := l.Location
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	text := strings.TrimRight(l.input[position:l.position], "\r")
+	l.comments = append(l.comments, token.Token{Type: token.COMMENT, Literal: text, Location: loc})
+}
+
+// Comments returns the comments skipped so far, in source order
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
 func (l *Lexer) readString() string {
 	position := l.position + 1
 	for {
@@ -161,3 +161,87 @@ func TestIllegalChar(t *testing.T) {
 		}
 	}
 }
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing
+// last`
+
+	expected := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := LexRepl(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - tokenliteral wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	comments := l.Comments()
+	literals := []string{"// leading comment", "// trailing", "// last"}
+	lines := []int{0, 1, 2}
+	if len(comments) != len(literals) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(literals), len(comments))
+	}
+	for i, c := range comments {
+		if c.Literal != literals[i] {
+			t.Errorf("comments[%d] - literal wrong. expected=%q, got=%q", i, literals[i], c.Literal)
+		}
+		if c.Location.Line != lines[i] {
+			t.Errorf("comments[%d] - line wrong. expected=%d, got=%d", i, lines[i], c.Location.Line)
+		}
+	}
+}
+
+func TestTypeAnnotationTokens(t *testing.T) {
+	input := `let x: int = 5; fn(a: fn(int) -> bool) -> string { a - 1 }`
+	expected := []token.TokenType{
+		token.LET, token.IDENT, token.COLON, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.FUNCTION, token.LPAREN, token.IDENT, token.COLON, token.FUNCTION, token.LPAREN,
+		token.IDENT, token.RPAREN, token.ARROW, token.IDENT, token.RPAREN, token.ARROW, token.IDENT,
+		token.LBRACE, token.IDENT, token.MINUS, token.INT, token.RBRACE, token.EOF,
+	}
+
+	l := LexRepl(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q (%q)", i, want, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestIdentifiersWithDigits(t *testing.T) {
+	input := `let x1 = 2x; a_2b`
+	expected := []token.Token{
+		{Type: token.LET, Literal: "let"},
+		{Type: token.IDENT, Literal: "x1"},
+		{Type: token.ASSIGN, Literal: "="},
+		{Type: token.INT, Literal: "2"},
+		{Type: token.IDENT, Literal: "x"},
+		{Type: token.SEMICOLON, Literal: ";"},
+		{Type: token.IDENT, Literal: "a_2b"},
+		{Type: token.EOF, Literal: ""},
+	}
+
+	l := LexRepl(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want.Type || tok.Literal != want.Literal {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q", i, want.Type, want.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+// Package llvm lowers Gosling programs to textual LLVM IR, for
+// gosling build --emit=llvm.
+//
+// It compiles the subset of the language that package infer can type.
+// Integers are i64 and booleans i1. Strings and closures are pointers
+// handled by a small runtime whose functions the module declares; Runtime
+// holds its C source, to be compiled and linked with the module. The
+// program itself becomes main, which prints the value of the last
+// statement as gosling run does.
+//
+// Arithmetic that overflows 64 bits stops the program with an integer
+// overflow error, as gosling run --strict-overflow does, and so do
+// division and modulo by zero. The program's other limits, such as the
+// call depth, are not checked.
+package llvm
+
+import (
+	_ "embed"
+	"fmt"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/infer"
+	"gosling/token"
+	"gosling/types"
+	"strings"
+)
+
+// Runtime is the C source of the runtime a module links against
+//
+//go:embed runtime/gosling_runtime.c
+var Runtime string
+
+// RuntimeFile is the name gosling build gives the runtime it writes
+const RuntimeFile = "gosling_runtime.c"
+
+// the runtime's functions, declared by every module
+const declarations = `declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+`
+
+// Compile lowers program to an LLVM IR module. filename names the source
+// in the module and in the locations of run time errors.
+func Compile(program *ast.Program, filename string) (string, []diag.Diagnostic) {
+	info, diagnostics := infer.Check(program)
+	if len(diagnostics) > 0 {
+		return "", diagnostics
+	}
+
+	c := &compiler{
+		info:      info,
+		strings:   make(map[string]string),
+		fails:     make(map[string]string),
+		symbols:   make(map[*infer.Function]string),
+		usedNames: make(map[string]int),
+	}
+	main := c.main(program)
+	for len(c.pending) > 0 {
+		fn := c.pending[0]
+		c.pending = c.pending[1:]
+		c.functions = append(c.functions, c.function(fn))
+	}
+	if len(c.diagnostics) > 0 {
+		return "", c.diagnostics
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "; ModuleID = '%s'\n", filename)
+	fmt.Fprintf(&out, "source_filename = \"%s\"\n", escape(filename))
+	if len(c.constants) > 0 {
+		out.WriteString("\n")
+		for _, constant := range c.constants {
+			out.WriteString(constant + "\n")
+		}
+	}
+	if len(info.Globals) > 0 {
+		out.WriteString("\n")
+		for _, g := range info.Globals {
+			fmt.Fprintf(&out, "@g.%s = internal global %s %s\n", g.Name, llvmType(g.Type), zero(g.Type))
+		}
+	}
+	out.WriteString("\n" + declarations)
+	out.WriteString("\n" + main)
+	for _, fn := range c.functions {
+		out.WriteString("\n" + fn)
+	}
+	return out.String(), nil
+}
+
+type compiler struct {
+	info        *infer.Info
+	diagnostics []diag.Diagnostic
+
+	constants []string
+	// strings and fails map the text of string literals and of run time
+	// errors to the constants holding them
+	strings map[string]string
+	fails   map[string]string
+
+	functions []string
+	// symbols holds the names given to functions, pending those whose
+	// bodies are still to be lowered
+	symbols   map[*infer.Function]string
+	usedNames map[string]int
+	pending   []*infer.Function
+}
+
+func (c *compiler) unsupported(node ast.Node, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diag.New(diag.UnsupportedConstruct, node.Location(), format, a...))
+}
+
+// symbol returns the name of fn's LLVM function, queueing its body
+func (c *compiler) symbol(fn *infer.Function) string {
+	if name, ok := c.symbols[fn]; ok {
+		return name
+	}
+	base := "fn"
+	if fn.Name != "" {
+		base = "fn." + fn.Name
+	}
+	n := c.usedNames[base]
+	c.usedNames[base]++
+	name := "@" + base
+	if fn.Name == "" || n > 0 {
+		name = fmt.Sprintf("@%s.%d", base, n)
+	}
+	c.symbols[fn] = name
+	c.pending = append(c.pending, fn)
+	return name
+}
+
+// stringConstant returns the constant holding the bytes of a literal
+func (c *compiler) stringConstant(s string) string {
+	if name, ok := c.strings[s]; ok {
+		return name
+	}
+	name := fmt.Sprintf("@.str.%d", len(c.strings))
+	c.strings[s] = name
+	c.constants = append(c.constants, fmt.Sprintf("%s = private unnamed_addr constant [%d x i8] c\"%s\"", name, len(s), escape(s)))
+	return name
+}
+
+// failConstant returns the constant holding the message of a run time
+// error, formatted as gosling run prints it
+func (c *compiler) failConstant(code diag.Code, loc token.TokenLocation, message string) string {
+	text := diag.Format(code, loc, message)
+	if name, ok := c.fails[text]; ok {
+		return name
+	}
+	name := fmt.Sprintf("@.fail.%d", len(c.fails))
+	c.fails[text] = name
+	c.constants = append(c.constants, fmt.Sprintf("%s = private unnamed_addr constant [%d x i8] c\"%s\\00\"", name, len(text)+1, escape(text)))
+	return name
+}
+
+// escape writes s as the contents of an LLVM string constant
+func escape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b < ' ' || b > '~' || b == '"' || b == '\\' {
+			fmt.Fprintf(&out, "\\%02X", b)
+		} else {
+			out.WriteByte(b)
+		}
+	}
+	return out.String()
+}
+
+func llvmType(t types.Type) string {
+	switch t.(type) {
+	case *types.IntegerType:
+		return "i64"
+	case *types.BooleanType:
+		return "i1"
+	case *types.StringType, *types.FunctionType:
+		return "ptr"
+	}
+	return "void"
+}
+
+func zero(t types.Type) string {
+	switch t.(type) {
+	case *types.IntegerType:
+		return "0"
+	case *types.BooleanType:
+		return "false"
+	}
+	return "null"
+}
+
+// value is an operand together with its LLVM type, void for none
+type value struct {
+	ref string
+	typ string
+}
+
+var void = value{typ: "void"}
+
+// emitter lowers the body of one function, or of main
+type emitter struct {
+	c  *compiler
+	fn *infer.Function // nil for main
+
+	allocas strings.Builder
+	body    strings.Builder
+	temps   int
+	labels  int
+	// block is the label of the block being written, terminated is set
+	// once it has ended with a branch, return or unreachable
+	block      string
+	terminated bool
+}
+
+func (c *compiler) newEmitter(fn *infer.Function) *emitter {
+	return &emitter{c: c, fn: fn, block: "entry"}
+}
+
+func (e *emitter) temp() string {
+	e.temps++
+	return fmt.Sprintf("%%t%d", e.temps)
+}
+
+func (e *emitter) label(kind string) string {
+	e.labels++
+	return fmt.Sprintf("%s.%d", kind, e.labels)
+}
+
+// emit writes an instruction, opening an unreachable block for it if the
+// current one has already ended, as after a return
+func (e *emitter) emit(format string, a ...interface{}) {
+	if e.terminated {
+		e.start(e.label("dead"))
+	}
+	fmt.Fprintf(&e.body, "  "+format+"\n", a...)
+}
+
+// terminate writes the instruction that ends the current block
+func (e *emitter) terminate(format string, a ...interface{}) {
+	e.emit(format, a...)
+	e.terminated = true
+}
+
+func (e *emitter) start(label string) {
+	fmt.Fprintf(&e.body, "%s:\n", label)
+	e.block = label
+	e.terminated = false
+}
+
+// fail stops the program with a run time error when cond is true
+func (e *emitter) fail(cond string, code diag.Code, loc token.TokenLocation, message string) {
+	failed, ok := e.label("fail"), e.label("ok")
+	e.terminate("br i1 %s, label %%%s, label %%%s", cond, failed, ok)
+	e.start(failed)
+	e.emit("call void @gosling_fail(ptr %s)", e.c.failConstant(code, loc, message))
+	e.terminate("unreachable")
+	e.start(ok)
+}
+
+// address returns the pointer a binding is stored at
+func (e *emitter) address(b *infer.Binding) string {
+	switch {
+	case b.Owner == nil:
+		return "@g." + b.Name
+	case b.Owner != e.fn || b.Captured:
+		return "%" + b.Name + ".cell"
+	}
+	return "%" + b.Name + ".addr"
+}
+
+func (c *compiler) main(program *ast.Program) string {
+	e := c.newEmitter(nil)
+	last := value{}
+	for i, stmt := range program.Statements {
+		v := e.statement(stmt)
+		if i == len(program.Statements)-1 {
+			last = v
+		}
+	}
+	if es, ok := lastExpression(program.Statements); ok && last.typ != "void" {
+		switch c.info.Types[es.Expression].(type) {
+		case *types.IntegerType:
+			e.emit("call void @gosling_print_int(i64 %s)", last.ref)
+		case *types.BooleanType:
+			e.emit("call void @gosling_print_bool(i1 zeroext %s)", last.ref)
+		case *types.StringType:
+			e.emit("call void @gosling_print_string(ptr %s)", last.ref)
+		default:
+			c.unsupported(es, "cannot compile %s: printing a function is not supported", es.String())
+		}
+	}
+	e.terminate("ret i32 0")
+	return e.finish("define i32 @main()")
+}
+
+func lastExpression(statements []ast.Statement) (*ast.ExpressionStatement, bool) {
+	if len(statements) == 0 {
+		return nil, false
+	}
+	es, ok := statements[len(statements)-1].(*ast.ExpressionStatement)
+	return es, ok
+}
+
+func (c *compiler) function(fn *infer.Function) string {
+	e := c.newEmitter(fn)
+	params := []string{"ptr %closure"}
+	for i, p := range fn.Params {
+		typ := llvmType(fn.Type.Parameters[i])
+		params = append(params, fmt.Sprintf("%s %%%s.arg", typ, p.Name))
+	}
+	for i, b := range fn.Free {
+		fmt.Fprintf(&e.allocas, "  %%%s.cell = call ptr @gosling_closure_cell(ptr %%closure, i64 %d)\n", b.Name, i)
+	}
+	for _, b := range append(append([]*infer.Binding{}, fn.Params...), fn.Locals...) {
+		if b.Captured {
+			fmt.Fprintf(&e.allocas, "  %%%s.cell = call ptr @gosling_cell_new()\n", b.Name)
+		} else {
+			fmt.Fprintf(&e.allocas, "  %%%s.addr = alloca %s\n", b.Name, llvmType(b.Type))
+		}
+	}
+	for _, p := range fn.Params {
+		e.emit("store %s %%%s.arg, ptr %s", llvmType(p.Type), p.Name, e.address(p))
+	}
+
+	result := e.block_(fn.Literal.Body)
+	if !e.terminated {
+		if result.typ == "void" || llvmType(fn.Type.Result) == "void" {
+			e.terminate("ret void")
+		} else {
+			e.terminate("ret %s %s", result.typ, result.ref)
+		}
+	}
+	header := fmt.Sprintf("define internal %s %s(%s)", llvmType(fn.Type.Result), c.symbol(fn), strings.Join(params, ", "))
+	return e.finish(header)
+}
+
+// finish assembles the function, its allocas going first in the entry block
+func (e *emitter) finish(header string) string {
+	return header + " {\nentry:\n" + e.allocas.String() + e.body.String() + "}\n"
+}
+
+// block_ lowers the statements of a block and returns the value of the
+// last one
+func (e *emitter) block_(b *ast.BlockStatement) value {
+	result := void
+	if b == nil {
+		return result
+	}
+	for _, stmt := range b.Statements {
+		result = e.statement(stmt)
+	}
+	return result
+}
+
+func (e *emitter) statement(stmt ast.Statement) value {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		v := e.expression(stmt.Value)
+		b := e.c.info.Bindings[stmt.Name]
+		e.emit("store %s %s, ptr %s", v.typ, v.ref, e.address(b))
+	case *ast.ReturnStatement:
+		if stmt.ReturnValue == nil || llvmType(e.fn.Type.Result) == "void" {
+			if stmt.ReturnValue != nil {
+				e.expression(stmt.ReturnValue)
+			}
+			e.terminate("ret void")
+			return void
+		}
+		v := e.expression(stmt.ReturnValue)
+		e.terminate("ret %s %s", v.typ, v.ref)
+	case *ast.ExpressionStatement:
+		return e.expression(stmt.Expression)
+	}
+	return void
+}
+
+func (e *emitter) expression(exp ast.Expression) value {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return value{fmt.Sprintf("%d", exp.Value), "i64"}
+	case *ast.Boolean:
+		return value{fmt.Sprintf("%t", exp.Value), "i1"}
+	case *ast.StringLiteral:
+		t := e.temp()
+		e.emit("%s = call ptr @gosling_string_new(ptr %s, i64 %d)", t, e.c.stringConstant(exp.Value), len(exp.Value))
+		return value{t, "ptr"}
+	case *ast.Identifier:
+		b := e.c.info.Bindings[exp]
+		if b == nil {
+			e.c.unsupported(exp, "cannot compile the builtin %s as a value, only calls of it", exp.Value)
+			return value{"undef", "ptr"}
+		}
+		t := e.temp()
+		typ := llvmType(b.Type)
+		e.emit("%s = load %s, ptr %s", t, typ, e.address(b))
+		return value{t, typ}
+	case *ast.AssignExpression:
+		v := e.expression(exp.Value)
+		e.emit("store %s %s, ptr %s", v.typ, v.ref, e.address(e.c.info.Bindings[exp.Name]))
+		return v
+	case *ast.PrefixExpression:
+		right := e.expression(exp.Right)
+		t := e.temp()
+		if exp.Operator == "!" {
+			e.emit("%s = xor i1 %s, true", t, right.ref)
+			return value{t, "i1"}
+		}
+		return e.checked("ssub", value{"0", "i64"}, right, exp.Token.Location)
+	case *ast.InfixExpression:
+		return e.infix(exp)
+	case *ast.IfExpression:
+		return e.ifExpression(exp)
+	case *ast.ForExpression:
+		e.forExpression(exp)
+		return void
+	case *ast.FunctionLiteral:
+		return e.closure(e.c.info.Functions[exp])
+	case *ast.CallExpression:
+		return e.call(exp)
+	}
+	e.c.unsupported(exp, "cannot compile %s", exp.String())
+	return void
+}
+
+// checked applies an overflow checking intrinsic
+func (e *emitter) checked(op string, left, right value, loc token.TokenLocation) value {
+	pair, result, overflow := e.temp(), e.temp(), e.temp()
+	e.emit("%s = call { i64, i1 } @llvm.%s.with.overflow.i64(i64 %s, i64 %s)", pair, op, left.ref, right.ref)
+	e.emit("%s = extractvalue { i64, i1 } %s, 0", result, pair)
+	e.emit("%s = extractvalue { i64, i1 } %s, 1", overflow, pair)
+	e.fail(overflow, diag.IntegerOverflow, loc, "integer overflow: result does not fit in 64 bits")
+	return value{result, "i64"}
+}
+
+var intrinsics = map[string]string{"+": "sadd", "-": "ssub", "*": "smul"}
+
+var comparisons = map[string]string{"<": "slt", ">": "sgt", "==": "eq", "!=": "ne"}
+
+func (e *emitter) infix(exp *ast.InfixExpression) value {
+	left := e.expression(exp.Left)
+	right := e.expression(exp.Right)
+	loc := exp.Token.Location
+
+	if _, ok := e.c.info.Types[exp.Left].(*types.StringType); ok {
+		t := e.temp()
+		switch exp.Operator {
+		case "+":
+			e.emit("%s = call ptr @gosling_string_concat(ptr %s, ptr %s)", t, left.ref, right.ref)
+			return value{t, "ptr"}
+		case "==":
+			e.emit("%s = call zeroext i1 @gosling_string_equal(ptr %s, ptr %s)", t, left.ref, right.ref)
+			return value{t, "i1"}
+		}
+		equal := t
+		e.emit("%s = call zeroext i1 @gosling_string_equal(ptr %s, ptr %s)", equal, left.ref, right.ref)
+		t = e.temp()
+		e.emit("%s = xor i1 %s, true", t, equal)
+		return value{t, "i1"}
+	}
+
+	switch exp.Operator {
+	case "+", "-", "*":
+		return e.checked(intrinsics[exp.Operator], left, right, loc)
+	case "/":
+		isZero := e.temp()
+		e.emit("%s = icmp eq i64 %s, 0", isZero, right.ref)
+		e.fail(isZero, diag.DivisionByZero, loc, "division by zero")
+		isMin, isMinusOne, overflow := e.temp(), e.temp(), e.temp()
+		e.emit("%s = icmp eq i64 %s, -9223372036854775808", isMin, left.ref)
+		e.emit("%s = icmp eq i64 %s, -1", isMinusOne, right.ref)
+		e.emit("%s = and i1 %s, %s", overflow, isMin, isMinusOne)
+		e.fail(overflow, diag.IntegerOverflow, loc, "integer overflow: result does not fit in 64 bits")
+		t := e.temp()
+		e.emit("%s = sdiv i64 %s, %s", t, left.ref, right.ref)
+		return value{t, "i64"}
+	case "%":
+		isZero := e.temp()
+		e.emit("%s = icmp eq i64 %s, 0", isZero, right.ref)
+		e.fail(isZero, diag.ModuloByZero, loc, "modulo by zero")
+		// x % -1 is 0 like x % 1, which srem cannot overflow on
+		isMinusOne, divisor, t := e.temp(), e.temp(), e.temp()
+		e.emit("%s = icmp eq i64 %s, -1", isMinusOne, right.ref)
+		e.emit("%s = select i1 %s, i64 1, i64 %s", divisor, isMinusOne, right.ref)
+		e.emit("%s = srem i64 %s, %s", t, left.ref, divisor)
+		return value{t, "i64"}
+	}
+	t := e.temp()
+	e.emit("%s = icmp %s %s %s, %s", t, comparisons[exp.Operator], left.typ, left.ref, right.ref)
+	return value{t, "i1"}
+}
+
+func (e *emitter) ifExpression(ie *ast.IfExpression) value {
+	cond := e.expression(ie.Condition)
+	then, end := e.label("if.then"), e.label("if.end")
+	otherwise := end
+	if ie.Alternative != nil {
+		otherwise = e.label("if.else")
+	}
+	e.terminate("br i1 %s, label %%%s, label %%%s", cond.ref, then, otherwise)
+
+	typ := llvmType(e.c.info.Types[ie])
+	var incoming []string
+	branch := func(label string, block *ast.BlockStatement) {
+		e.start(label)
+		v := e.block_(block)
+		if e.terminated {
+			return
+		}
+		if typ != "void" {
+			incoming = append(incoming, fmt.Sprintf("[ %s, %%%s ]", v.ref, e.block))
+		}
+		e.terminate("br label %%%s", end)
+	}
+	branch(then, ie.Consequence)
+	if ie.Alternative != nil {
+		branch(otherwise, ie.Alternative)
+	}
+
+	e.start(end)
+	if typ == "void" {
+		return void
+	}
+	if len(incoming) == 0 {
+		e.terminate("unreachable")
+		return value{"undef", typ}
+	}
+	t := e.temp()
+	e.emit("%s = phi %s %s", t, typ, strings.Join(incoming, ", "))
+	return value{t, typ}
+}
+
+func (e *emitter) forExpression(fe *ast.ForExpression) {
+	cond, body, end := e.label("for.cond"), e.label("for.body"), e.label("for.end")
+	e.terminate("br label %%%s", cond)
+	e.start(cond)
+	v := e.expression(fe.Condition)
+	e.terminate("br i1 %s, label %%%s, label %%%s", v.ref, body, end)
+	e.start(body)
+	e.block_(fe.Body)
+	if !e.terminated {
+		e.terminate("br label %%%s", cond)
+	}
+	e.start(end)
+}
+
+// closure makes a closure of fn holding the cells of its free bindings,
+// which the current function owns or has itself captured
+func (e *emitter) closure(fn *infer.Function) value {
+	t := e.temp()
+	e.emit("%s = call ptr @gosling_closure_new(ptr %s, i64 %d)", t, e.c.symbol(fn), len(fn.Free))
+	for i, b := range fn.Free {
+		e.emit("call void @gosling_closure_set(ptr %s, i64 %d, ptr %s)", t, i, e.address(b))
+	}
+	return value{t, "ptr"}
+}
+
+func (e *emitter) call(call *ast.CallExpression) value {
+	typ := llvmType(e.c.info.Types[call])
+	callee := ""
+	closure := "null"
+	if ident, ok := call.Function.(*ast.Identifier); ok {
+		b := e.c.info.Bindings[ident]
+		switch {
+		case b == nil && ident.Value == "len":
+			arg := e.expression(call.Arguments[0])
+			t := e.temp()
+			e.emit("%s = call i64 @gosling_string_len(ptr %s)", t, arg.ref)
+			return value{t, "i64"}
+		case b != nil && b.Function != nil && len(b.Function.Free) == 0:
+			// the function needs no closure, call it directly
+			callee = e.c.symbol(b.Function)
+		}
+	}
+	if callee == "" {
+		closure = e.expression(call.Function).ref
+		callee = e.temp()
+		e.emit("%s = call ptr @gosling_closure_code(ptr %s)", callee, closure)
+	}
+
+	args := []string{"ptr " + closure}
+	for _, a := range call.Arguments {
+		v := e.expression(a)
+		args = append(args, v.typ+" "+v.ref)
+	}
+	if typ == "void" {
+		e.emit("call void %s(%s)", callee, strings.Join(args, ", "))
+		return void
+	}
+	t := e.temp()
+	e.emit("%s = call %s %s(%s)", t, typ, callee, strings.Join(args, ", "))
+	return value{t, typ}
+}
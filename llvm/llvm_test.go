@@ -0,0 +1,116 @@
+package llvm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/internal/golden"
+	"gosling/object"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestGolden compiles each program and compares the module with its .ll
+// file in testdata
+func TestGolden(t *testing.T) {
+	for _, path := range golden.Programs(t) {
+		module, diagnostics := Compile(golden.ParseFile(t, path), filepath.Base(path))
+		if len(diagnostics) > 0 {
+			t.Errorf("%s: unexpected diagnostics: %v", path, diagnostics)
+			continue
+		}
+		golden.Compare(t, path, ".ll", []byte(module), *update)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []struct {
+		input   string
+		code    diag.Code
+		message string
+	}{
+		{"let x = none;", diag.UnsupportedConstruct, "cannot compile none: options are not supported"},
+		{"if let x = some(1) { x }", diag.UnsupportedConstruct, "cannot compile if let x: options are not supported"},
+		{"let x = 99999999999999999999;", diag.UnsupportedConstruct, "cannot compile the integer literal 99999999999999999999: it does not fit in 64 bits"},
+		{"return 1;", diag.UnsupportedConstruct, "cannot compile a return statement outside a function"},
+		{"if (1) { 2 }", diag.UnsupportedConstruct, "cannot compile the condition 1: it has type int, only booleans are supported"},
+		{"let f = fn(a: int) { a }; f(1, 2)", diag.UnsupportedConstruct, "cannot compile f(1, 2): the function takes 1 argument, not 2"},
+		{"let f = fn(a: int, b: int) { a }; f(1)", diag.UnsupportedConstruct, "cannot compile f(1): the function takes 2 arguments, not 1"},
+		{"let f = fn() { 1 }; f(1)", diag.UnsupportedConstruct, "cannot compile f(1): the function takes 0 arguments, not 1"},
+		{"let f = fn(a: int) { a }; f(true)", diag.UnsupportedConstruct, "cannot compile f(true): cannot use bool as int"},
+		{"let f = fn() { let g = fn() { y }; let r = g(); let y = 2; r }; f()", diag.UnsupportedConstruct, "cannot compile fn(): it captures y before y is bound"},
+		{"let f = fn(x) { x };", diag.NoStaticType, "cannot work out the type of f, annotate it"},
+		{"q + 1", diag.IdentifierNotFound, "identifier not found: q"},
+		{"let y = len;", diag.UnsupportedConstruct, "cannot compile the builtin len as a value, only calls of it"},
+		{"let f = fn(x: int) { x }; f", diag.UnsupportedConstruct, "cannot compile f: printing a function is not supported"},
+	}
+
+	for _, tt := range tests {
+		_, diagnostics := Compile(golden.Parse(t, tt.input), "test.gos")
+		golden.Diagnostic(t, tt.input, diagnostics, tt.code, tt.message)
+	}
+}
+
+// TestRun builds each program with clang and checks that it prints what
+// the evaluator does. It is skipped when clang is not on PATH.
+func TestRun(t *testing.T) {
+	clang, err := exec.LookPath("clang")
+	if err != nil {
+		t.Skip("clang is not on PATH")
+	}
+	dir := t.TempDir()
+	runtime := filepath.Join(dir, RuntimeFile)
+	if err := os.WriteFile(runtime, []byte(Runtime), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range golden.Programs(t) {
+		module, diagnostics := Compile(golden.ParseFile(t, path), filepath.Base(path))
+		if len(diagnostics) > 0 {
+			t.Errorf("%s: unexpected diagnostics: %v", path, diagnostics)
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".gos")
+		ll := filepath.Join(dir, name+".ll")
+		if err := os.WriteFile(ll, []byte(module), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		binary := filepath.Join(dir, name)
+		if out, err := exec.Command(clang, "-Wno-override-module", "-o", binary, ll, runtime).CombinedOutput(); err != nil {
+			t.Errorf("%s: clang failed: %v\n%s", path, err, out)
+			continue
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(binary)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		err = cmd.Run()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			t.Fatal(err)
+		}
+		got := stdout.String() + stderr.String()
+		if want := interpret(t, path); got != want {
+			t.Errorf("%s: output differs from the evaluator.\nwant=%q\ngot=%q", path, want, got)
+		}
+	}
+}
+
+// interpret returns what gosling run --strict-overflow prints for the
+// program at path
+func interpret(t *testing.T, path string) string {
+	t.Helper()
+	result := evaluator.Eval(context.Background(), golden.ParseFile(t, path), object.NewEnvironment(), evaluator.Options{StrictOverflow: true})
+	if result == nil || result == evaluator.NULL {
+		return ""
+	}
+	return result.Inspect() + "\n"
+}
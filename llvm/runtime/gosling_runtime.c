@@ -0,0 +1,94 @@
+// The runtime that modules from gosling build --emit=llvm link against.
+// Compile it with the module, e.g. clang -o prog prog.ll gosling_runtime.c
+//
+// Values are never freed: compiled programs are expected to be short lived.
+
+#include <stdbool.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef struct {
+	int64_t len;
+	char data[];
+} gosling_string;
+
+typedef struct {
+	void *code;
+	int64_t nfree;
+	void *cells[];
+} gosling_closure;
+
+void gosling_fail(const char *message) {
+	fflush(stdout);
+	fprintf(stderr, "%s\n", message);
+	exit(1);
+}
+
+static void *gosling_alloc(size_t size) {
+	void *p = calloc(1, size);
+	if (p == NULL) {
+		gosling_fail("out of memory");
+	}
+	return p;
+}
+
+gosling_string *gosling_string_new(const char *bytes, int64_t len) {
+	gosling_string *s = gosling_alloc(sizeof(gosling_string) + len);
+	s->len = len;
+	memcpy(s->data, bytes, len);
+	return s;
+}
+
+gosling_string *gosling_string_concat(gosling_string *a, gosling_string *b) {
+	gosling_string *s = gosling_alloc(sizeof(gosling_string) + a->len + b->len);
+	s->len = a->len + b->len;
+	memcpy(s->data, a->data, a->len);
+	memcpy(s->data + a->len, b->data, b->len);
+	return s;
+}
+
+bool gosling_string_equal(gosling_string *a, gosling_string *b) {
+	return a->len == b->len && memcmp(a->data, b->data, a->len) == 0;
+}
+
+int64_t gosling_string_len(gosling_string *s) {
+	return s->len;
+}
+
+void *gosling_cell_new(void) {
+	return gosling_alloc(sizeof(int64_t));
+}
+
+void *gosling_closure_new(void *code, int64_t nfree) {
+	gosling_closure *c = gosling_alloc(sizeof(gosling_closure) + nfree * sizeof(void *));
+	c->code = code;
+	c->nfree = nfree;
+	return c;
+}
+
+void *gosling_closure_code(gosling_closure *c) {
+	return c->code;
+}
+
+void gosling_closure_set(gosling_closure *c, int64_t i, void *cell) {
+	c->cells[i] = cell;
+}
+
+void *gosling_closure_cell(gosling_closure *c, int64_t i) {
+	return c->cells[i];
+}
+
+void gosling_print_int(int64_t v) {
+	printf("%lld\n", (long long)v);
+}
+
+void gosling_print_bool(bool v) {
+	printf("%s\n", v ? "true" : "false");
+}
+
+void gosling_print_string(gosling_string *s) {
+	fwrite(s->data, 1, s->len, stdout);
+	putchar('\n');
+}
@@ -0,0 +1,100 @@
+; ModuleID = 'arithmetic.gos'
+source_filename = "arithmetic.gos"
+
+@.fail.0 = private unnamed_addr constant [122 x i8] c"file: ../internal/golden/testdata/arithmetic.gos line: 1 char: 9 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.1 = private unnamed_addr constant [122 x i8] c"file: ../internal/golden/testdata/arithmetic.gos line: 2 char: 3 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.2 = private unnamed_addr constant [91 x i8] c"file: ../internal/golden/testdata/arithmetic.gos line: 2 char: 11 [E0103] division by zero\00"
+@.fail.3 = private unnamed_addr constant [123 x i8] c"file: ../internal/golden/testdata/arithmetic.gos line: 2 char: 11 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.4 = private unnamed_addr constant [122 x i8] c"file: ../internal/golden/testdata/arithmetic.gos line: 2 char: 7 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.5 = private unnamed_addr constant [89 x i8] c"file: ../internal/golden/testdata/arithmetic.gos line: 2 char: 19 [E0104] modulo by zero\00"
+@.fail.6 = private unnamed_addr constant [123 x i8] c"file: ../internal/golden/testdata/arithmetic.gos line: 2 char: 15 [E0116] integer overflow: result does not fit in 64 bits\00"
+
+@g.a = internal global i64 0
+@g.b = internal global i64 0
+
+declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+
+define i32 @main() {
+entry:
+  store i64 7, ptr @g.a
+  %t2 = call { i64, i1 } @llvm.ssub.with.overflow.i64(i64 0, i64 3)
+  %t3 = extractvalue { i64, i1 } %t2, 0
+  %t4 = extractvalue { i64, i1 } %t2, 1
+  br i1 %t4, label %fail.1, label %ok.2
+fail.1:
+  call void @gosling_fail(ptr @.fail.0)
+  unreachable
+ok.2:
+  store i64 %t3, ptr @g.b
+  %t5 = load i64, ptr @g.a
+  %t6 = load i64, ptr @g.b
+  %t7 = call { i64, i1 } @llvm.smul.with.overflow.i64(i64 %t5, i64 %t6)
+  %t8 = extractvalue { i64, i1 } %t7, 0
+  %t9 = extractvalue { i64, i1 } %t7, 1
+  br i1 %t9, label %fail.3, label %ok.4
+fail.3:
+  call void @gosling_fail(ptr @.fail.1)
+  unreachable
+ok.4:
+  %t10 = load i64, ptr @g.a
+  %t11 = load i64, ptr @g.b
+  %t12 = icmp eq i64 %t11, 0
+  br i1 %t12, label %fail.5, label %ok.6
+fail.5:
+  call void @gosling_fail(ptr @.fail.2)
+  unreachable
+ok.6:
+  %t13 = icmp eq i64 %t10, -9223372036854775808
+  %t14 = icmp eq i64 %t11, -1
+  %t15 = and i1 %t13, %t14
+  br i1 %t15, label %fail.7, label %ok.8
+fail.7:
+  call void @gosling_fail(ptr @.fail.3)
+  unreachable
+ok.8:
+  %t16 = sdiv i64 %t10, %t11
+  %t17 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t8, i64 %t16)
+  %t18 = extractvalue { i64, i1 } %t17, 0
+  %t19 = extractvalue { i64, i1 } %t17, 1
+  br i1 %t19, label %fail.9, label %ok.10
+fail.9:
+  call void @gosling_fail(ptr @.fail.4)
+  unreachable
+ok.10:
+  %t20 = load i64, ptr @g.a
+  %t21 = load i64, ptr @g.b
+  %t22 = icmp eq i64 %t21, 0
+  br i1 %t22, label %fail.11, label %ok.12
+fail.11:
+  call void @gosling_fail(ptr @.fail.5)
+  unreachable
+ok.12:
+  %t23 = icmp eq i64 %t21, -1
+  %t24 = select i1 %t23, i64 1, i64 %t21
+  %t25 = srem i64 %t20, %t24
+  %t26 = call { i64, i1 } @llvm.ssub.with.overflow.i64(i64 %t18, i64 %t25)
+  %t27 = extractvalue { i64, i1 } %t26, 0
+  %t28 = extractvalue { i64, i1 } %t26, 1
+  br i1 %t28, label %fail.13, label %ok.14
+fail.13:
+  call void @gosling_fail(ptr @.fail.6)
+  unreachable
+ok.14:
+  call void @gosling_print_int(i64 %t27)
+  ret i32 0
+}
@@ -0,0 +1,150 @@
+; ModuleID = 'closures.gos'
+source_filename = "closures.gos"
+
+@.fail.0 = private unnamed_addr constant [122 x i8] c"file: ../internal/golden/testdata/closures.gos line: 10 char: 29 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.1 = private unnamed_addr constant [122 x i8] c"file: ../internal/golden/testdata/closures.gos line: 11 char: 26 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.2 = private unnamed_addr constant [121 x i8] c"file: ../internal/golden/testdata/closures.gos line: 2 char: 15 [E0116] integer overflow: result does not fit in 64 bits\00"
+
+@g.counter = internal global ptr null
+@g.compose = internal global ptr null
+@g.c = internal global ptr null
+@g.double = internal global ptr null
+@g.inc = internal global ptr null
+
+declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+
+define i32 @main() {
+entry:
+  %t1 = call ptr @gosling_closure_new(ptr @fn.counter, i64 0)
+  store ptr %t1, ptr @g.counter
+  %t2 = call ptr @gosling_closure_new(ptr @fn.compose, i64 0)
+  store ptr %t2, ptr @g.compose
+  %t3 = call ptr @fn.counter(ptr null, i64 5)
+  store ptr %t3, ptr @g.c
+  %t4 = load ptr, ptr @g.c
+  %t5 = call ptr @gosling_closure_code(ptr %t4)
+  %t6 = call i64 %t5(ptr %t4)
+  %t7 = load ptr, ptr @g.c
+  %t8 = call ptr @gosling_closure_code(ptr %t7)
+  %t9 = call i64 %t8(ptr %t7)
+  %t10 = call ptr @gosling_closure_new(ptr @fn.double, i64 0)
+  store ptr %t10, ptr @g.double
+  %t11 = call ptr @gosling_closure_new(ptr @fn.inc, i64 0)
+  store ptr %t11, ptr @g.inc
+  %t12 = load ptr, ptr @g.double
+  %t13 = load ptr, ptr @g.inc
+  %t14 = call ptr @fn.compose(ptr null, ptr %t12, ptr %t13)
+  %t15 = call ptr @gosling_closure_code(ptr %t14)
+  %t16 = load ptr, ptr @g.c
+  %t17 = call ptr @gosling_closure_code(ptr %t16)
+  %t18 = call i64 %t17(ptr %t16)
+  %t19 = call i64 %t15(ptr %t14, i64 %t18)
+  call void @gosling_print_int(i64 %t19)
+  ret i32 0
+}
+
+define internal ptr @fn.counter(ptr %closure, i64 %step.arg) {
+entry:
+  %step.cell = call ptr @gosling_cell_new()
+  %n.cell = call ptr @gosling_cell_new()
+  store i64 %step.arg, ptr %step.cell
+  store i64 0, ptr %n.cell
+  %t1 = call ptr @gosling_closure_new(ptr @fn.0, i64 2)
+  call void @gosling_closure_set(ptr %t1, i64 0, ptr %n.cell)
+  call void @gosling_closure_set(ptr %t1, i64 1, ptr %step.cell)
+  ret ptr %t1
+}
+
+define internal ptr @fn.compose(ptr %closure, ptr %f.arg, ptr %g.arg) {
+entry:
+  %f.cell = call ptr @gosling_cell_new()
+  %g.cell = call ptr @gosling_cell_new()
+  store ptr %f.arg, ptr %f.cell
+  store ptr %g.arg, ptr %g.cell
+  %t1 = call ptr @gosling_closure_new(ptr @fn.1, i64 2)
+  call void @gosling_closure_set(ptr %t1, i64 0, ptr %f.cell)
+  call void @gosling_closure_set(ptr %t1, i64 1, ptr %g.cell)
+  ret ptr %t1
+}
+
+define internal i64 @fn.double(ptr %closure, i64 %x.arg) {
+entry:
+  %x.addr = alloca i64
+  store i64 %x.arg, ptr %x.addr
+  %t1 = load i64, ptr %x.addr
+  %t2 = call { i64, i1 } @llvm.smul.with.overflow.i64(i64 %t1, i64 2)
+  %t3 = extractvalue { i64, i1 } %t2, 0
+  %t4 = extractvalue { i64, i1 } %t2, 1
+  br i1 %t4, label %fail.1, label %ok.2
+fail.1:
+  call void @gosling_fail(ptr @.fail.0)
+  unreachable
+ok.2:
+  ret i64 %t3
+}
+
+define internal i64 @fn.inc(ptr %closure, i64 %x.arg) {
+entry:
+  %x.addr = alloca i64
+  store i64 %x.arg, ptr %x.addr
+  %t1 = load i64, ptr %x.addr
+  %t2 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t1, i64 1)
+  %t3 = extractvalue { i64, i1 } %t2, 0
+  %t4 = extractvalue { i64, i1 } %t2, 1
+  br i1 %t4, label %fail.1, label %ok.2
+fail.1:
+  call void @gosling_fail(ptr @.fail.1)
+  unreachable
+ok.2:
+  ret i64 %t3
+}
+
+define internal i64 @fn.0(ptr %closure) {
+entry:
+  %n.cell = call ptr @gosling_closure_cell(ptr %closure, i64 0)
+  %step.cell = call ptr @gosling_closure_cell(ptr %closure, i64 1)
+  %t1 = load i64, ptr %n.cell
+  %t2 = load i64, ptr %step.cell
+  %t3 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t1, i64 %t2)
+  %t4 = extractvalue { i64, i1 } %t3, 0
+  %t5 = extractvalue { i64, i1 } %t3, 1
+  br i1 %t5, label %fail.1, label %ok.2
+fail.1:
+  call void @gosling_fail(ptr @.fail.2)
+  unreachable
+ok.2:
+  store i64 %t4, ptr %n.cell
+  %t6 = load i64, ptr %n.cell
+  ret i64 %t6
+}
+
+define internal i64 @fn.1(ptr %closure, i64 %x.arg) {
+entry:
+  %f.cell = call ptr @gosling_closure_cell(ptr %closure, i64 0)
+  %g.cell = call ptr @gosling_closure_cell(ptr %closure, i64 1)
+  %x.addr = alloca i64
+  store i64 %x.arg, ptr %x.addr
+  %t1 = load ptr, ptr %f.cell
+  %t2 = call ptr @gosling_closure_code(ptr %t1)
+  %t3 = load ptr, ptr %g.cell
+  %t4 = call ptr @gosling_closure_code(ptr %t3)
+  %t5 = load i64, ptr %x.addr
+  %t6 = call i64 %t4(ptr %t3, i64 %t5)
+  %t7 = call i64 %t2(ptr %t1, i64 %t6)
+  ret i64 %t7
+}
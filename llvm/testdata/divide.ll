@@ -0,0 +1,91 @@
+; ModuleID = 'divide.gos'
+source_filename = "divide.gos"
+
+@.fail.0 = private unnamed_addr constant [119 x i8] c"file: ../internal/golden/testdata/divide.gos line: 4 char: 16 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.1 = private unnamed_addr constant [118 x i8] c"file: ../internal/golden/testdata/divide.gos line: 5 char: 8 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.2 = private unnamed_addr constant [87 x i8] c"file: ../internal/golden/testdata/divide.gos line: 0 char: 45 [E0103] division by zero\00"
+@.fail.3 = private unnamed_addr constant [119 x i8] c"file: ../internal/golden/testdata/divide.gos line: 0 char: 45 [E0116] integer overflow: result does not fit in 64 bits\00"
+
+@g.divide = internal global ptr null
+@g.steps = internal global i64 0
+@g.n = internal global i64 0
+
+declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+
+define i32 @main() {
+entry:
+  %t1 = call ptr @gosling_closure_new(ptr @fn.divide, i64 0)
+  store ptr %t1, ptr @g.divide
+  store i64 0, ptr @g.steps
+  store i64 3, ptr @g.n
+  br label %for.cond.1
+for.cond.1:
+  br i1 true, label %for.body.2, label %for.end.3
+for.body.2:
+  %t2 = load i64, ptr @g.steps
+  %t3 = load i64, ptr @g.n
+  %t4 = call i64 @fn.divide(ptr null, i64 12, i64 %t3)
+  %t5 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t2, i64 %t4)
+  %t6 = extractvalue { i64, i1 } %t5, 0
+  %t7 = extractvalue { i64, i1 } %t5, 1
+  br i1 %t7, label %fail.4, label %ok.5
+fail.4:
+  call void @gosling_fail(ptr @.fail.0)
+  unreachable
+ok.5:
+  store i64 %t6, ptr @g.steps
+  %t8 = load i64, ptr @g.n
+  %t9 = call { i64, i1 } @llvm.ssub.with.overflow.i64(i64 %t8, i64 1)
+  %t10 = extractvalue { i64, i1 } %t9, 0
+  %t11 = extractvalue { i64, i1 } %t9, 1
+  br i1 %t11, label %fail.6, label %ok.7
+fail.6:
+  call void @gosling_fail(ptr @.fail.1)
+  unreachable
+ok.7:
+  store i64 %t10, ptr @g.n
+  br label %for.cond.1
+for.end.3:
+  ret i32 0
+}
+
+define internal i64 @fn.divide(ptr %closure, i64 %a.arg, i64 %b.arg) {
+entry:
+  %a.addr = alloca i64
+  %b.addr = alloca i64
+  store i64 %a.arg, ptr %a.addr
+  store i64 %b.arg, ptr %b.addr
+  %t1 = load i64, ptr %a.addr
+  %t2 = load i64, ptr %b.addr
+  %t3 = icmp eq i64 %t2, 0
+  br i1 %t3, label %fail.1, label %ok.2
+fail.1:
+  call void @gosling_fail(ptr @.fail.2)
+  unreachable
+ok.2:
+  %t4 = icmp eq i64 %t1, -9223372036854775808
+  %t5 = icmp eq i64 %t2, -1
+  %t6 = and i1 %t4, %t5
+  br i1 %t6, label %fail.3, label %ok.4
+fail.3:
+  call void @gosling_fail(ptr @.fail.3)
+  unreachable
+ok.4:
+  %t7 = sdiv i64 %t1, %t2
+  ret i64 %t7
+}
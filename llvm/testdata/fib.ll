@@ -0,0 +1,76 @@
+; ModuleID = 'fib.gos'
+source_filename = "fib.gos"
+
+@.fail.0 = private unnamed_addr constant [115 x i8] c"file: ../internal/golden/testdata/fib.gos line: 2 char: 8 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.1 = private unnamed_addr constant [116 x i8] c"file: ../internal/golden/testdata/fib.gos line: 2 char: 21 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.2 = private unnamed_addr constant [116 x i8] c"file: ../internal/golden/testdata/fib.gos line: 2 char: 13 [E0116] integer overflow: result does not fit in 64 bits\00"
+
+@g.fib = internal global ptr null
+
+declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+
+define i32 @main() {
+entry:
+  %t1 = call ptr @gosling_closure_new(ptr @fn.fib, i64 0)
+  store ptr %t1, ptr @g.fib
+  %t2 = call i64 @fn.fib(ptr null, i64 20)
+  call void @gosling_print_int(i64 %t2)
+  ret i32 0
+}
+
+define internal i64 @fn.fib(ptr %closure, i64 %n.arg) {
+entry:
+  %n.addr = alloca i64
+  store i64 %n.arg, ptr %n.addr
+  %t1 = load i64, ptr %n.addr
+  %t2 = icmp slt i64 %t1, 2
+  br i1 %t2, label %if.then.1, label %if.end.2
+if.then.1:
+  %t3 = load i64, ptr %n.addr
+  ret i64 %t3
+if.end.2:
+  %t4 = load i64, ptr %n.addr
+  %t5 = call { i64, i1 } @llvm.ssub.with.overflow.i64(i64 %t4, i64 1)
+  %t6 = extractvalue { i64, i1 } %t5, 0
+  %t7 = extractvalue { i64, i1 } %t5, 1
+  br i1 %t7, label %fail.3, label %ok.4
+fail.3:
+  call void @gosling_fail(ptr @.fail.0)
+  unreachable
+ok.4:
+  %t8 = call i64 @fn.fib(ptr null, i64 %t6)
+  %t9 = load i64, ptr %n.addr
+  %t10 = call { i64, i1 } @llvm.ssub.with.overflow.i64(i64 %t9, i64 2)
+  %t11 = extractvalue { i64, i1 } %t10, 0
+  %t12 = extractvalue { i64, i1 } %t10, 1
+  br i1 %t12, label %fail.5, label %ok.6
+fail.5:
+  call void @gosling_fail(ptr @.fail.1)
+  unreachable
+ok.6:
+  %t13 = call i64 @fn.fib(ptr null, i64 %t11)
+  %t14 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t8, i64 %t13)
+  %t15 = extractvalue { i64, i1 } %t14, 0
+  %t16 = extractvalue { i64, i1 } %t14, 1
+  br i1 %t16, label %fail.7, label %ok.8
+fail.7:
+  call void @gosling_fail(ptr @.fail.2)
+  unreachable
+ok.8:
+  ret i64 %t15
+}
@@ -0,0 +1,92 @@
+; ModuleID = 'loop.gos'
+source_filename = "loop.gos"
+
+@.fail.0 = private unnamed_addr constant [82 x i8] c"file: ../internal/golden/testdata/loop.gos line: 4 char: 9 [E0104] modulo by zero\00"
+@.fail.1 = private unnamed_addr constant [117 x i8] c"file: ../internal/golden/testdata/loop.gos line: 4 char: 35 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.2 = private unnamed_addr constant [116 x i8] c"file: ../internal/golden/testdata/loop.gos line: 5 char: 9 [E0116] integer overflow: result does not fit in 64 bits\00"
+
+@g.sum = internal global ptr null
+
+declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+
+define i32 @main() {
+entry:
+  %t1 = call ptr @gosling_closure_new(ptr @fn.sum, i64 0)
+  store ptr %t1, ptr @g.sum
+  %t2 = call i64 @fn.sum(ptr null, i64 100)
+  %t3 = icmp sgt i64 %t2, 2000
+  call void @gosling_print_bool(i1 zeroext %t3)
+  ret i32 0
+}
+
+define internal i64 @fn.sum(ptr %closure, i64 %n.arg) {
+entry:
+  %n.addr = alloca i64
+  %i.addr = alloca i64
+  %total.addr = alloca i64
+  store i64 %n.arg, ptr %n.addr
+  store i64 0, ptr %i.addr
+  store i64 0, ptr %total.addr
+  br label %for.cond.1
+for.cond.1:
+  %t1 = load i64, ptr %i.addr
+  %t2 = load i64, ptr %n.addr
+  %t3 = icmp slt i64 %t1, %t2
+  br i1 %t3, label %for.body.2, label %for.end.3
+for.body.2:
+  %t4 = load i64, ptr %i.addr
+  %t5 = icmp eq i64 2, 0
+  br i1 %t5, label %fail.4, label %ok.5
+fail.4:
+  call void @gosling_fail(ptr @.fail.0)
+  unreachable
+ok.5:
+  %t6 = icmp eq i64 2, -1
+  %t7 = select i1 %t6, i64 1, i64 2
+  %t8 = srem i64 %t4, %t7
+  %t9 = icmp eq i64 %t8, 0
+  br i1 %t9, label %if.then.6, label %if.end.7
+if.then.6:
+  %t10 = load i64, ptr %total.addr
+  %t11 = load i64, ptr %i.addr
+  %t12 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t10, i64 %t11)
+  %t13 = extractvalue { i64, i1 } %t12, 0
+  %t14 = extractvalue { i64, i1 } %t12, 1
+  br i1 %t14, label %fail.8, label %ok.9
+fail.8:
+  call void @gosling_fail(ptr @.fail.1)
+  unreachable
+ok.9:
+  store i64 %t13, ptr %total.addr
+  br label %if.end.7
+if.end.7:
+  %t15 = load i64, ptr %i.addr
+  %t16 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t15, i64 1)
+  %t17 = extractvalue { i64, i1 } %t16, 0
+  %t18 = extractvalue { i64, i1 } %t16, 1
+  br i1 %t18, label %fail.10, label %ok.11
+fail.10:
+  call void @gosling_fail(ptr @.fail.2)
+  unreachable
+ok.11:
+  store i64 %t17, ptr %i.addr
+  br label %for.cond.1
+for.end.3:
+  %t19 = load i64, ptr %total.addr
+  ret i64 %t19
+}
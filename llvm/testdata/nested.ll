@@ -0,0 +1,88 @@
+; ModuleID = 'nested.gos'
+source_filename = "nested.gos"
+
+@.fail.0 = private unnamed_addr constant [119 x i8] c"file: ../internal/golden/testdata/nested.gos line: 2 char: 18 [E0116] integer overflow: result does not fit in 64 bits\00"
+@.fail.1 = private unnamed_addr constant [119 x i8] c"file: ../internal/golden/testdata/nested.gos line: 2 char: 22 [E0116] integer overflow: result does not fit in 64 bits\00"
+
+@g.outer = internal global ptr null
+
+declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+
+define i32 @main() {
+entry:
+  %t1 = call ptr @gosling_closure_new(ptr @fn.outer, i64 0)
+  store ptr %t1, ptr @g.outer
+  %t2 = call ptr @fn.outer(ptr null, i64 100)
+  %t3 = call ptr @gosling_closure_code(ptr %t2)
+  %t4 = call i64 %t3(ptr %t2, i64 1000)
+  call void @gosling_print_int(i64 %t4)
+  ret i32 0
+}
+
+define internal ptr @fn.outer(ptr %closure, i64 %a.arg) {
+entry:
+  %a.cell = call ptr @gosling_cell_new()
+  %middle.addr = alloca ptr
+  store i64 %a.arg, ptr %a.cell
+  %t1 = call ptr @gosling_closure_new(ptr @fn.middle, i64 1)
+  call void @gosling_closure_set(ptr %t1, i64 0, ptr %a.cell)
+  store ptr %t1, ptr %middle.addr
+  %t2 = load ptr, ptr %middle.addr
+  %t3 = call ptr @gosling_closure_code(ptr %t2)
+  %t4 = call ptr %t3(ptr %t2, i64 10)
+  ret ptr %t4
+}
+
+define internal ptr @fn.middle(ptr %closure, i64 %b.arg) {
+entry:
+  %a.cell = call ptr @gosling_closure_cell(ptr %closure, i64 0)
+  %b.cell = call ptr @gosling_cell_new()
+  store i64 %b.arg, ptr %b.cell
+  %t1 = call ptr @gosling_closure_new(ptr @fn.0, i64 2)
+  call void @gosling_closure_set(ptr %t1, i64 0, ptr %a.cell)
+  call void @gosling_closure_set(ptr %t1, i64 1, ptr %b.cell)
+  ret ptr %t1
+}
+
+define internal i64 @fn.0(ptr %closure, i64 %c.arg) {
+entry:
+  %a.cell = call ptr @gosling_closure_cell(ptr %closure, i64 0)
+  %b.cell = call ptr @gosling_closure_cell(ptr %closure, i64 1)
+  %c.addr = alloca i64
+  store i64 %c.arg, ptr %c.addr
+  %t1 = load i64, ptr %a.cell
+  %t2 = load i64, ptr %b.cell
+  %t3 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t1, i64 %t2)
+  %t4 = extractvalue { i64, i1 } %t3, 0
+  %t5 = extractvalue { i64, i1 } %t3, 1
+  br i1 %t5, label %fail.1, label %ok.2
+fail.1:
+  call void @gosling_fail(ptr @.fail.0)
+  unreachable
+ok.2:
+  %t6 = load i64, ptr %c.addr
+  %t7 = call { i64, i1 } @llvm.sadd.with.overflow.i64(i64 %t4, i64 %t6)
+  %t8 = extractvalue { i64, i1 } %t7, 0
+  %t9 = extractvalue { i64, i1 } %t7, 1
+  br i1 %t9, label %fail.3, label %ok.4
+fail.3:
+  call void @gosling_fail(ptr @.fail.1)
+  unreachable
+ok.4:
+  ret i64 %t8
+}
@@ -0,0 +1,72 @@
+; ModuleID = 'strings.gos'
+source_filename = "strings.gos"
+
+@.str.0 = private unnamed_addr constant [5 x i8] c"world"
+@.str.1 = private unnamed_addr constant [0 x i8] c""
+@.str.2 = private unnamed_addr constant [12 x i8] c"hello, caf\C3\A9"
+@.str.3 = private unnamed_addr constant [7 x i8] c"hello, "
+
+@g.greet = internal global ptr null
+@g.s = internal global ptr null
+
+declare ptr @gosling_string_new(ptr, i64)
+declare ptr @gosling_string_concat(ptr, ptr)
+declare zeroext i1 @gosling_string_equal(ptr, ptr)
+declare i64 @gosling_string_len(ptr)
+declare ptr @gosling_cell_new()
+declare ptr @gosling_closure_new(ptr, i64)
+declare ptr @gosling_closure_code(ptr)
+declare void @gosling_closure_set(ptr, i64, ptr)
+declare ptr @gosling_closure_cell(ptr, i64)
+declare void @gosling_print_int(i64)
+declare void @gosling_print_bool(i1 zeroext)
+declare void @gosling_print_string(ptr)
+declare void @gosling_fail(ptr) noreturn
+declare { i64, i1 } @llvm.sadd.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.ssub.with.overflow.i64(i64, i64)
+declare { i64, i1 } @llvm.smul.with.overflow.i64(i64, i64)
+
+define i32 @main() {
+entry:
+  %t1 = call ptr @gosling_closure_new(ptr @fn.greet, i64 0)
+  store ptr %t1, ptr @g.greet
+  %t2 = call ptr @gosling_string_new(ptr @.str.0, i64 5)
+  %t3 = call ptr @fn.greet(ptr null, ptr %t2)
+  store ptr %t3, ptr @g.s
+  %t4 = load ptr, ptr @g.s
+  %t5 = call i64 @gosling_string_len(ptr %t4)
+  %t6 = icmp ne i64 %t5, 12
+  br i1 %t6, label %if.then.1, label %if.else.3
+if.then.1:
+  %t7 = call ptr @gosling_string_new(ptr @.str.1, i64 0)
+  %t8 = call ptr @fn.greet(ptr null, ptr %t7)
+  br label %if.end.2
+if.else.3:
+  %t9 = load ptr, ptr @g.s
+  br label %if.end.2
+if.end.2:
+  %t10 = phi ptr [ %t8, %if.then.1 ], [ %t9, %if.else.3 ]
+  call void @gosling_print_string(ptr %t10)
+  ret i32 0
+}
+
+define internal ptr @fn.greet(ptr %closure, ptr %name.arg) {
+entry:
+  %name.addr = alloca ptr
+  store ptr %name.arg, ptr %name.addr
+  %t1 = load ptr, ptr %name.addr
+  %t2 = call ptr @gosling_string_new(ptr @.str.1, i64 0)
+  %t3 = call zeroext i1 @gosling_string_equal(ptr %t1, ptr %t2)
+  br i1 %t3, label %if.then.1, label %if.else.3
+if.then.1:
+  %t4 = call ptr @gosling_string_new(ptr @.str.2, i64 12)
+  br label %if.end.2
+if.else.3:
+  %t5 = call ptr @gosling_string_new(ptr @.str.3, i64 7)
+  %t6 = load ptr, ptr %name.addr
+  %t7 = call ptr @gosling_string_concat(ptr %t5, ptr %t6)
+  br label %if.end.2
+if.end.2:
+  %t8 = phi ptr [ %t4, %if.then.1 ], [ %t7, %if.else.3 ]
+  ret ptr %t8
+}
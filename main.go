@@ -1,17 +1,63 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"gosling/repl"
 	"os"
 	"os/user"
+	"strings"
 )
 
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	// flags before any command are for the REPL
+	fs := flag.NewFlagSet("gosling", flag.ExitOnError)
+	engine := fs.String("engine", repl.EngineTree, "how to run each line: tree to walk the syntax tree, vm to compile it to bytecode")
+	fs.Parse(os.Args[1:])
+	if *engine != repl.EngineTree && *engine != repl.EngineVM {
+		fmt.Fprintf(os.Stderr, "unknown engine %q, want tree or vm\n", *engine)
+		os.Exit(2)
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 
 	// Pass the user info to the REPL to handle printing
-	repl.StartWithWelcome(os.Stdin, os.Stdout, user.Username)
+	repl.StartWithWelcome(os.Stdin, os.Stdout, user.Username, *engine)
+}
+
+// runCommand dispatches `gosling <command> [args]` and returns the exit code
+func runCommand(command string, args []string) int {
+	switch command {
+	case "run":
+		return run(args, os.Stdout, os.Stderr)
+	case "vet":
+		return vetFiles(args, os.Stdout, os.Stderr)
+	case "check":
+		return checkFiles(args, os.Stdout, os.Stderr)
+	case "compile":
+		return compileCmd(args, os.Stdout, os.Stderr)
+	case "build":
+		return build(args, os.Stdout, os.Stderr)
+	case "disasm":
+		return disasm(args, os.Stdout, os.Stderr)
+	case "ir":
+		return irCmd(args, os.Stdout, os.Stderr)
+	case "difftest":
+		return difftestCmd(args, os.Stdout, os.Stderr)
+	case "bench":
+		return benchCmd(args, os.Stdout, os.Stderr)
+	case "explain":
+		return explain(args, os.Stdout, os.Stderr)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+		fmt.Fprintf(os.Stderr, "usage: gosling [--engine=tree|vm] [run [--engine=tree|vm] file.gos | vet file.gos... | check file.gos... | compile file.gos | build (--emit=llvm|go | --target=wasm) file.gos | disasm file.gos | ir [--dump-after=pass] file.gos | difftest [file.gos|dir...] | bench [file.gos|dir...] | explain <code>]\n")
+		return 2
+	}
 }
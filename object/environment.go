@@ -11,15 +11,120 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s, outer: nil}
 }
 
+// NewSlotEnvironment returns an environment inside outer with a slot for
+// each of names, the layout a resolver gave a scope. Names bound in it are
+// read and written by slot, without a map.
+func NewSlotEnvironment(outer *Environment, names []string) *Environment {
+	return &Environment{slots: make([]Object, len(names)), names: names, outer: outer}
+}
+
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+	for env := e; env != nil; env = env.outer {
+		if obj, ok := env.local(name); ok {
+			return obj, true
+		}
+	}
+	return nil, false
+}
+
+// local returns name if it is bound in this environment itself
+func (e *Environment) local(name string) (Object, bool) {
+	for i, n := range e.names {
+		if n == name && e.slots[i] != nil {
+			return e.slots[i], true
+		}
 	}
+	obj, ok := e.store[name]
 	return obj, ok
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+	for i, n := range e.names {
+		if n == name {
+			e.slots[i] = val
+			return val
+		}
+	}
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
+
+// Assign rebinds name in the nearest environment that already holds it.
+// It reports false, without binding anything, if no environment does.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		for i, n := range env.names {
+			if n == name && env.slots[i] != nil {
+				env.slots[i] = val
+				return true
+			}
+		}
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup finds a name a resolver placed depth environments out, in slot
+// of that environment. A slot not bound yet, or a slot of -1, means the
+// name is looked up from there by name, as Get would have found it.
+func (e *Environment) Lookup(depth, slot int, name string) (Object, bool) {
+	env := e.up(depth)
+	if slot < 0 {
+		return env.Get(name)
+	}
+	if obj := env.slots[slot]; obj != nil {
+		return obj, true
+	}
+	if env.outer == nil {
+		return nil, false
+	}
+	return env.outer.Get(name)
+}
+
+// SetSlot binds the name in slot of this environment
+func (e *Environment) SetSlot(slot int, val Object) {
+	e.slots[slot] = val
+}
+
+// AssignAt is Assign for a name a resolver placed as for Lookup
+func (e *Environment) AssignAt(depth, slot int, name string, val Object) bool {
+	env := e.up(depth)
+	if slot < 0 {
+		return env.Assign(name, val)
+	}
+	if env.slots[slot] != nil {
+		env.slots[slot] = val
+		return true
+	}
+	return env.outer != nil && env.outer.Assign(name, val)
+}
+
+func (e *Environment) up(depth int) *Environment {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	return env
+}
+
+// Names returns every name bound in this environment and the ones enclosing it
+func (e *Environment) Names() []string {
+	names := []string{}
+	for env := e; env != nil; env = env.outer {
+		for i, name := range env.names {
+			if env.slots[i] != nil {
+				names = append(names, name)
+			}
+		}
+		for name := range env.store {
+			names = append(names, name)
+		}
+	}
+	return names
+}
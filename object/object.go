@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"gosling/ast"
+	"gosling/code"
+	"gosling/diag"
 	"gosling/token"
+	"hash/fnv"
+	"math/big"
 	"strings"
 )
 
@@ -19,6 +23,11 @@ const (
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
+	OPTION_OBJ       = "OPTION"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	ANNOTATION_OBJ        = "ANNOTATION"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -30,11 +39,43 @@ type Integer struct {
 	Value int64
 }
 
+// The integers from smallIntMin to smallIntMax, which counters, indices and
+// small sums keep to, are made once and shared, as the booleans are. No
+// object is changed once made, so sharing them cannot be seen.
+const (
+	smallIntMin = -128
+	smallIntMax = 1024
+)
+
+var smallInts = func() *[smallIntMax - smallIntMin + 1]Integer {
+	ints := new([smallIntMax - smallIntMin + 1]Integer)
+	for i := range ints {
+		ints[i].Value = int64(i + smallIntMin)
+	}
+	return ints
+}()
+
+// NewInteger returns an Integer of v, the shared one if v is small
+func NewInteger(v int64) *Integer {
+	if v >= smallIntMin && v <= smallIntMax {
+		return &smallInts[v-smallIntMin]
+	}
+	return &Integer{Value: v}
+}
+
+// BigInt is an integer too large for an Integer. Arithmetic promotes its
+// result to one on overflow and demotes it again once it fits, so the two
+// never hold the same value and a program only ever sees integers.
+type BigInt struct {
+	Value *big.Int
+}
+
 type Boolean struct {
 	Value bool
 }
 
 type Error struct {
+	Code     diag.Code
 	Message  string
 	Location token.TokenLocation
 }
@@ -49,38 +90,106 @@ type ReturnValue struct {
 
 type Environment struct {
 	store map[string]Object
+	// slots hold the names a resolver laid out, named by names. An
+	// environment with slots makes store only for names bound by name.
+	slots []Object
+	names []string
 	outer *Environment
 }
 
 type Function struct {
 	Parameters []*ast.Identifier
+	ReturnType ast.TypeExpression
 	Body       *ast.BlockStatement
 	Env        *Environment
+	// Scope is the layout of the environment of a call, nil if the
+	// function was not resolved and binds by name
+	Scope *ast.Scope
 }
 
 type String struct {
 	Value string
 }
 
+// emptyString is the one empty string, which every literal "" and every
+// concatenation of empty strings evaluates to
+var emptyString = &String{Value: ""}
+
+// NewString returns a String of s, the shared one if s is empty
+func NewString(s string) *String {
+	if s == "" {
+		return emptyString
+	}
+	return &String{Value: s}
+}
+
 type Builtin struct {
 	Fn BuiltinFunction
 }
 
 type BuiltinFunction func(args ...Object) Object
 
+// Option is either some(Value) or, with a nil Value, none
+type Option struct {
+	Value Object
+}
+
+// CompiledFunction is a function literal compiled to bytecode. Parameters
+// and ReturnType keep the annotations checked when it is called.
+type CompiledFunction struct {
+	Instructions code.Instructions
+	Positions    code.Positions
+	NumLocals    int
+	Parameters   []*ast.Identifier
+	ReturnType   ast.TypeExpression
+	// Names holds the name bound to each local slot, for error messages
+	Names []string
+	// Name is the name a let binds the literal to, empty if there is none
+	Name string
+	// FreeNames holds the name of each variable the function captures
+	FreeNames []string
+}
+
+// Closure is a compiled function as a value, with the cells of the
+// variables it captured when it was made
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+// Cell holds a variable that closures capture, so they and the function
+// declaring it share every assignment. A nil Value is not yet bound.
+type Cell struct {
+	Value Object
+}
+
+// Annotation is a type annotation kept in a constant pool for OpCheckType.
+// What names the checked value in error messages, e.g. "let x".
+type Annotation struct {
+	Annotation ast.TypeExpression
+	What       string
+}
+
 // Integer Methods
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+// BigInt Methods
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+func (b *BigInt) Type() ObjectType { return INTEGER_OBJ }
+
 // Boolean Methods
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 
 // Error Methods
 func (e *Error) Inspect() string {
-	return fmt.Sprintf("file: %s line: %d char: %d %s", e.Location.Filename, e.Location.Line, e.Location.LineCh, e.Message)
+	return diag.Format(e.Code, e.Location, e.Message)
 }
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
+func (e *Error) Diagnostic() diag.Diagnostic {
+	return diag.Diagnostic{Code: e.Code, Severity: diag.Error, Message: e.Message, Location: e.Location}
+}
 
 // Null Methods
 func (n *Null) Inspect() string  { return "null" }
@@ -91,8 +200,8 @@ func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 // Helper function to create new errors
-func NewError(message string, location token.TokenLocation) *Error {
-	return &Error{Message: message, Location: location}
+func NewError(code diag.Code, location token.TokenLocation, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...), Location: location}
 }
 
 // Environment Methods
@@ -109,7 +218,11 @@ func (f *Function) Inspect() string {
 	out.WriteString("fn")
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") {\n")
+	out.WriteString(")")
+	if f.ReturnType != nil {
+		out.WriteString(" -> " + f.ReturnType.String())
+	}
+	out.WriteString(" {\n")
 	out.WriteString(f.Body.String())
 	out.WriteString("\n}")
 
@@ -123,3 +236,86 @@ func (s *String) Type() ObjectType { return STRING_OBJ }
 // Builtin Methods
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
+
+// Option Methods
+func (o *Option) Type() ObjectType { return OPTION_OBJ }
+func (o *Option) Inspect() string {
+	if o.Value == nil {
+		return "none"
+	}
+	return "some(" + o.Value.Inspect() + ")"
+}
+
+// CompiledFunction Methods
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	params := []string{}
+	for _, p := range cf.Parameters {
+		params = append(params, p.String())
+	}
+	out := "fn(" + strings.Join(params, ", ") + ")"
+	if cf.ReturnType != nil {
+		out += " -> " + cf.ReturnType.String()
+	}
+	return fmt.Sprintf("%s [compiled %p]", out, cf)
+}
+
+// Closure Methods
+// A closure is the compiled form of a function value, so it reports the
+// same type as one in error messages
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
+// Cell Methods
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return "cell()"
+	}
+	return "cell(" + c.Value.Inspect() + ")"
+}
+
+// Annotation Methods
+func (a *Annotation) Type() ObjectType { return ANNOTATION_OBJ }
+func (a *Annotation) Inspect() string  { return a.What + ": " + a.Annotation.String() }
+
+// HashKey identifies a value among the keys of a map. Equal values have
+// equal keys, whichever representation of an integer they use.
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by the values that can be map keys
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+func (b *BigInt) HashKey() HashKey {
+	if b.Value.IsInt64() {
+		return HashKey{Type: b.Type(), Value: uint64(b.Value.Int64())}
+	}
+	h := fnv.New64a()
+	h.Write([]byte{byte(b.Value.Sign() + 1)})
+	h.Write(b.Value.Bytes())
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
@@ -0,0 +1,461 @@
+// Package optimize rewrites a parsed program into one that computes the
+// same results with less work, before it is evaluated or compiled.
+//
+// Every rewrite keeps what the program does, including the errors it
+// reports and where: an expression that would fail, such as 1 / 0, is
+// left for the engine to run. Only the number of evaluation steps and
+// the memory charged for them can change.
+package optimize
+
+import (
+	"gosling/ast"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/token"
+	"strconv"
+)
+
+// Options selects the passes to run
+type Options struct {
+	// Fold replaces operators on constants with their result, e.g.
+	// 1 + 2 * 3 with 7 and "a" + "b" with "ab"
+	Fold bool
+	// DeadCode drops the branch of an if that a constant condition never
+	// takes, loops that never run and statements after a return
+	DeadCode bool
+	// Inline replaces calls of small functions given constant arguments
+	// with the function's body, for literals called where they are
+	// written and for top level functions bound once with let
+	Inline bool
+}
+
+// Enabled reports whether any pass is selected
+func (o Options) Enabled() bool {
+	return o.Fold || o.DeadCode || o.Inline
+}
+
+// maxInlineNodes bounds the size of a body that is inlined, so a call is
+// only replaced by something about as cheap to run
+const maxInlineNodes = 16
+
+type optimizer struct {
+	opts Options
+	// functions holds the top level functions calls may be inlined from,
+	// each added once its let has run
+	functions map[string]*ast.FunctionLiteral
+	// rebound holds the names bound more than once anywhere in the
+	// program, which a call cannot be sure refers to the top level let
+	rebound map[string]bool
+}
+
+// Program rewrites program in place with the passes opts selects and
+// returns it
+func Program(program *ast.Program, opts Options) *ast.Program {
+	if !opts.Enabled() {
+		return program
+	}
+	o := &optimizer{opts: opts, functions: make(map[string]*ast.FunctionLiteral)}
+	if opts.Inline {
+		o.rebound = reboundNames(program)
+	}
+
+	program.Statements = o.statements(program.Statements, func(stmt ast.Statement) {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || !opts.Inline || o.rebound[let.Name.Value] {
+			return
+		}
+		if fn, ok := let.Value.(*ast.FunctionLiteral); ok {
+			o.functions[let.Name.Value] = fn
+		}
+	})
+	return program
+}
+
+// statements rewrites a list of statements, calling done after each one.
+// A program and a block share an environment with the blocks of their
+// ifs, so the statements of a branch that is always taken can replace
+// the if.
+func (o *optimizer) statements(stmts []ast.Statement, done func(ast.Statement)) []ast.Statement {
+	out := make([]ast.Statement, 0, len(stmts))
+	for i, stmt := range stmts {
+		stmt = o.statement(stmt)
+		if done != nil {
+			done(stmt)
+		}
+		last := i == len(stmts)-1
+
+		if o.opts.DeadCode {
+			if spliced, ok := o.splice(stmt, last); ok {
+				out = append(out, spliced...)
+				if endsWithReturn(spliced) {
+					break
+				}
+				continue
+			}
+		}
+		out = append(out, stmt)
+		if _, ok := stmt.(*ast.ReturnStatement); ok && o.opts.DeadCode {
+			break
+		}
+	}
+	return out
+}
+
+// splice returns what stmt can be replaced with in its list: the branch
+// an if always takes, or nothing for a loop that never runs. As the last
+// statement its value is the list's, so an empty branch, whose value is
+// no value at all, is kept.
+func (o *optimizer) splice(stmt ast.Statement, last bool) ([]ast.Statement, bool) {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return nil, false
+	}
+	switch exp := es.Expression.(type) {
+	case *ast.IfExpression:
+		truthy, ok := constantCondition(exp)
+		if !ok {
+			return nil, false
+		}
+		taken := exp.Consequence
+		if !truthy {
+			taken = exp.Alternative
+		}
+		switch {
+		case taken != nil && len(taken.Statements) > 0:
+			return taken.Statements, true
+		case !last:
+			return nil, true
+		}
+	case *ast.ForExpression:
+		if truthy, ok := constant(exp.Condition); ok && !evaluator.IsTruthy(truthy) && !last {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func endsWithReturn(stmts []ast.Statement) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+	_, ok := stmts[len(stmts)-1].(*ast.ReturnStatement)
+	return ok
+}
+
+func (o *optimizer) statement(stmt ast.Statement) ast.Statement {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		stmt.Value = o.expression(stmt.Value)
+	case *ast.ReturnStatement:
+		if stmt.ReturnValue != nil {
+			stmt.ReturnValue = o.expression(stmt.ReturnValue)
+		}
+	case *ast.ExpressionStatement:
+		if stmt.Expression != nil {
+			stmt.Expression = o.expression(stmt.Expression)
+		}
+	case *ast.BlockStatement:
+		o.block(stmt)
+	}
+	return stmt
+}
+
+func (o *optimizer) block(block *ast.BlockStatement) {
+	if block != nil {
+		block.Statements = o.statements(block.Statements, nil)
+	}
+}
+
+// expression rewrites exp after its operands, so that folding works from
+// the leaves up
+func (o *optimizer) expression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		exp.Right = o.expression(exp.Right)
+		if o.opts.Fold {
+			return fold(exp, func() object.Object {
+				right, ok := constant(exp.Right)
+				if !ok {
+					return nil
+				}
+				return evaluator.EvalPrefixExpression(exp.Operator, right, exp.Token.Location)
+			})
+		}
+	case *ast.InfixExpression:
+		exp.Left = o.expression(exp.Left)
+		exp.Right = o.expression(exp.Right)
+		if o.opts.Fold {
+			return fold(exp, func() object.Object {
+				left, ok := constant(exp.Left)
+				if !ok {
+					return nil
+				}
+				right, ok := constant(exp.Right)
+				if !ok {
+					return nil
+				}
+				return evaluator.EvalInfixExpression(exp.Operator, left, right, exp.Token.Location)
+			})
+		}
+	case *ast.IfExpression:
+		exp.Condition = o.expression(exp.Condition)
+		o.block(exp.Consequence)
+		o.block(exp.Alternative)
+		if o.opts.DeadCode {
+			pruneBranch(exp)
+		}
+	case *ast.ForExpression:
+		exp.Condition = o.expression(exp.Condition)
+		o.block(exp.Body)
+		if truthy, ok := constant(exp.Condition); ok && o.opts.DeadCode && !evaluator.IsTruthy(truthy) {
+			exp.Body.Statements = nil
+		}
+	case *ast.AssignExpression:
+		exp.Value = o.expression(exp.Value)
+	case *ast.FunctionLiteral:
+		o.block(exp.Body)
+	case *ast.CallExpression:
+		exp.Function = o.expression(exp.Function)
+		for i, a := range exp.Arguments {
+			exp.Arguments[i] = o.expression(a)
+		}
+		if o.opts.Inline {
+			if inlined, ok := o.inline(exp); ok {
+				return o.expression(inlined)
+			}
+		}
+	}
+	return exp
+}
+
+// pruneBranch drops the branch of an if its constant condition never
+// takes. A false condition moves the alternative into the consequence,
+// so the if keeps a value of its own wherever it is used.
+func pruneBranch(ie *ast.IfExpression) {
+	truthy, ok := constantCondition(ie)
+	if !ok {
+		return
+	}
+	if truthy {
+		ie.Alternative = nil
+		return
+	}
+	if ie.Alternative == nil {
+		ie.Consequence = &ast.BlockStatement{Token: ie.Consequence.Token}
+		return
+	}
+	ie.Condition = &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true", Location: ie.Condition.Location()}, Value: true}
+	ie.Consequence, ie.Alternative = ie.Alternative, nil
+}
+
+// constantCondition reports whether a plain if always takes its
+// consequence. An if let is left alone, its condition being an option.
+func constantCondition(ie *ast.IfExpression) (bool, bool) {
+	if ie.Binding != nil {
+		return false, false
+	}
+	condition, ok := constant(ie.Condition)
+	if !ok {
+		return false, false
+	}
+	return evaluator.IsTruthy(condition), true
+}
+
+// fold replaces exp with a literal for the value apply computes, unless
+// apply has no constant operands or its result is an error, which is
+// left for the engine to report
+func fold(exp ast.Expression, apply func() object.Object) ast.Expression {
+	value := apply()
+	if value == nil {
+		return exp
+	}
+	if literal, ok := literalFor(value, exp.Location()); ok {
+		return literal
+	}
+	return exp
+}
+
+// constant returns the value of a literal
+func constant(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		// left alone like any value that needs a big integer, for the
+		// engine to check against StrictOverflow
+		if exp.Big != nil {
+			return nil, false
+		}
+		return &object.Integer{Value: exp.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}, true
+	case *ast.Boolean:
+		if exp.Value {
+			return evaluator.TRUE, true
+		}
+		return evaluator.FALSE, true
+	case *ast.NoneLiteral:
+		return evaluator.NONE, true
+	}
+	return nil, false
+}
+
+// literalFor returns the literal for a value, located at loc so that an
+// error about it is reported where the folded expression was
+func literalFor(value object.Object, loc token.TokenLocation) (ast.Expression, bool) {
+	switch value := value.(type) {
+	case *object.Integer:
+		literal := strconv.FormatInt(value.Value, 10)
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal, Location: loc}, Value: value.Value}, true
+	case *object.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value.Value, Location: loc}, Value: value.Value}, true
+	case *object.Boolean:
+		if value.Value {
+			return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true", Location: loc}, Value: true}, true
+		}
+		return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false", Location: loc}, Value: false}, true
+	}
+	if value == evaluator.NONE {
+		return &ast.NoneLiteral{Token: token.Token{Type: token.NONE, Literal: "none", Location: loc}}, true
+	}
+	return nil, false
+}
+
+// inline returns the body of the function call calls, with its
+// parameters replaced by the arguments. Only constant arguments are
+// substituted, so evaluating them where the parameters were used rather
+// than before the body changes nothing.
+func (o *optimizer) inline(call *ast.CallExpression) (ast.Expression, bool) {
+	var fn *ast.FunctionLiteral
+	// a literal called where it is written runs in the environment of the
+	// call, so its body may use any name; a top level function may only
+	// use its parameters, as the call may be where a local hides a global
+	ownNamesOnly := false
+	switch callee := call.Function.(type) {
+	case *ast.FunctionLiteral:
+		fn = callee
+	case *ast.Identifier:
+		fn = o.functions[callee.Value]
+		ownNamesOnly = true
+	}
+	if fn == nil || len(fn.TypeParameters) > 0 || fn.ReturnType != nil || len(fn.Parameters) != len(call.Arguments) {
+		return nil, false
+	}
+
+	args := make(map[string]ast.Expression, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		if _, ok := constant(call.Arguments[i]); !ok || p.Type != nil {
+			return nil, false
+		}
+		if _, ok := args[p.Value]; ok {
+			return nil, false
+		}
+		args[p.Value] = call.Arguments[i]
+	}
+
+	body, ok := inlineBody(fn, args, ownNamesOnly)
+	if !ok {
+		return nil, false
+	}
+	return substitute(body, args), true
+}
+
+// inlineBody returns the expression a function's body consists of, if it
+// is small and made only of operators, calls, literals and names
+func inlineBody(fn *ast.FunctionLiteral, params map[string]ast.Expression, ownNamesOnly bool) (ast.Expression, bool) {
+	if len(fn.Body.Statements) != 1 {
+		return nil, false
+	}
+	var body ast.Expression
+	switch stmt := fn.Body.Statements[0].(type) {
+	case *ast.ExpressionStatement:
+		body = stmt.Expression
+	case *ast.ReturnStatement:
+		body = stmt.ReturnValue
+	}
+	if body == nil {
+		return nil, false
+	}
+
+	nodes, ok := 0, true
+	ast.Inspect(body, func(node ast.Node) bool {
+		nodes++
+		switch node := node.(type) {
+		case *ast.Identifier:
+			if _, isParam := params[node.Value]; ownNamesOnly && !isParam {
+				ok = false
+			}
+		case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.NoneLiteral,
+			*ast.PrefixExpression, *ast.InfixExpression, *ast.CallExpression:
+		default:
+			ok = false
+		}
+		return ok
+	})
+	return body, ok && nodes <= maxInlineNodes
+}
+
+// substitute copies exp, which inlineBody accepted, replacing the names
+// in args. Every node is new, since the compiler tells names apart by
+// their node.
+func substitute(exp ast.Expression, args map[string]ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if arg, ok := args[exp.Value]; ok {
+			return substitute(arg, nil)
+		}
+		return &ast.Identifier{Token: exp.Token, Value: exp.Value}
+	case *ast.IntegerLiteral:
+		copied := *exp
+		return &copied
+	case *ast.StringLiteral:
+		copied := *exp
+		return &copied
+	case *ast.Boolean:
+		copied := *exp
+		return &copied
+	case *ast.NoneLiteral:
+		copied := *exp
+		return &copied
+	case *ast.PrefixExpression:
+		return &ast.PrefixExpression{Token: exp.Token, Operator: exp.Operator, Right: substitute(exp.Right, args)}
+	case *ast.InfixExpression:
+		return &ast.InfixExpression{Token: exp.Token, Left: substitute(exp.Left, args), Operator: exp.Operator, Right: substitute(exp.Right, args)}
+	case *ast.CallExpression:
+		call := &ast.CallExpression{Token: exp.Token, Function: substitute(exp.Function, args)}
+		for _, a := range exp.Arguments {
+			call.Arguments = append(call.Arguments, substitute(a, args))
+		}
+		return call
+	}
+	return exp
+}
+
+// reboundNames returns the names bound by more than one let, parameter or
+// if let anywhere in program, or assigned to at all
+func reboundNames(program *ast.Program) map[string]bool {
+	bindings := make(map[string]int)
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			bindings[node.Name.Value]++
+		case *ast.AssignExpression:
+			bindings[node.Name.Value] += 2
+		case *ast.IfExpression:
+			if node.Binding != nil {
+				bindings[node.Binding.Value]++
+			}
+		case *ast.FunctionLiteral:
+			for _, p := range node.Parameters {
+				bindings[p.Value]++
+			}
+		}
+		return true
+	})
+
+	rebound := make(map[string]bool)
+	for name, n := range bindings {
+		if n > 1 {
+			rebound[name] = true
+		}
+	}
+	return rebound
+}
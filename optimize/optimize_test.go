@@ -0,0 +1,132 @@
+package optimize
+
+import (
+	"context"
+	"gosling/ast"
+	"gosling/evaluator"
+	"gosling/lexer"
+	"gosling/object"
+	"gosling/parser"
+	"testing"
+)
+
+var (
+	foldOnly = Options{Fold: true}
+	deadCode = Options{DeadCode: true}
+	inline   = Options{Inline: true}
+	all      = Options{Fold: true, DeadCode: true, Inline: true}
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		input  string
+		opts   Options
+		before string
+		after  string
+	}{
+		{"1 + 2 * 3", foldOnly, "(1 + (2 * 3))", "7"},
+		{`"a" + "b" + "c"`, foldOnly, "((a + b) + c)", "abc"},
+		{"-(2 - 5)", foldOnly, "(-(2 - 5))", "3"},
+		{"!true == false", foldOnly, "((!true) == false)", "true"},
+		{"x + 1 * 2", foldOnly, "(x + (1 * 2))", "(x + 2)"},
+		// errors are left for the engine to report where they happen
+		{"1 / 0", foldOnly, "(1 / 0)", "(1 / 0)"},
+		{"(1 + 1) / (2 - 2)", foldOnly, "((1 + 1) / (2 - 2))", "(2 / 0)"},
+		{"1 + true", foldOnly, "(1 + true)", "(1 + true)"},
+		{"if (1 > 2) { 1 }", foldOnly, "if(1 > 2) 1", "iffalse 1"},
+		// results that need a big integer are left for the engine to check
+		{"9223372036854775807 + 1", foldOnly, "(9223372036854775807 + 1)", "(9223372036854775807 + 1)"},
+		{"99999999999999999999 - 1", foldOnly, "(99999999999999999999 - 1)", "(99999999999999999999 - 1)"},
+
+		{"if (true) { 1 } else { 2 }", deadCode, "iftrue 1else 2", "1"},
+		{"if (false) { 1 } else { 2 }", deadCode, "iffalse 1else 2", "2"},
+		{"if (false) { 1 }; 5", deadCode, "iffalse 15", "5"},
+		{"let a = if (false) { 1 } else { 2 };", deadCode, "let a = iffalse 1else 2;", "let a = iftrue 2;"},
+		{"let a = if (true) { 1 } else { 2 };", deadCode, "let a = iftrue 1else 2;", "let a = iftrue 1;"},
+		// the value of the program is that of the if, which has none to give
+		{"if (false) { 1 }", deadCode, "iffalse 1", "iffalse "},
+		{"if (x) { 1 } else { 2 }", deadCode, "ifx 1else 2", "ifx 1else 2"},
+		{"if let v = true { v }", deadCode, "if let v = true v", "if let v = true v"},
+		{"let f = fn() { return 1; 2; 3 };", deadCode, "let f = fn()return 1;23;", "let f = fn()return 1;;"},
+		{"if (true) { return 1; } 2", deadCode, "iftrue return 1;2", "return 1;"},
+		{"for (false) { 1 }; 2", deadCode, "forfalse 12", "2"},
+		{"if (1 > 2) { 1 } else { 2 }", deadCode, "if(1 > 2) 1else 2", "if(1 > 2) 1else 2"},
+
+		{"fn(x) { x * 2 }(21)", inline, "fn(x)(x * 2)(21)", "(21 * 2)"},
+		{"fn(x) { len(x) + y }(\"ab\")", inline, "fn(x)(len(x) + y)(ab)", "(len(ab) + y)"},
+		{"let sq = fn(x) { x * x }; sq(7)", inline, "let sq = fn(x)(x * x);sq(7)", "let sq = fn(x)(x * x);(7 * 7)"},
+		{"let sq = fn(x) { return x * x; }; sq(7)", inline, "let sq = fn(x)return (x * x);;sq(7)", "let sq = fn(x)return (x * x);;(7 * 7)"},
+		// arguments that are not constants are evaluated before the body
+		{"let sq = fn(x) { x * x }; sq(y)", inline, "let sq = fn(x)(x * x);sq(y)", "let sq = fn(x)(x * x);sq(y)"},
+		// calls before the let would not find the function
+		{"sq(7); let sq = fn(x) { x * x };", inline, "sq(7)let sq = fn(x)(x * x);", "sq(7)let sq = fn(x)(x * x);"},
+		{"let sq = fn(x) { x * x }; let sq = 1; sq(7)", inline, "let sq = fn(x)(x * x);let sq = 1;sq(7)", "let sq = fn(x)(x * x);let sq = 1;sq(7)"},
+		{"let f = fn(n) { f(n) }; f(1)", inline, "let f = fn(n)f(n);f(1)", "let f = fn(n)f(n);f(1)"},
+		// a top level function using a global might find a local instead
+		{"let y = 1; let f = fn(x) { x + y }; f(1)", inline, "let y = 1;let f = fn(x)(x + y);f(1)", "let y = 1;let f = fn(x)(x + y);f(1)"},
+		{"let f = fn(a, b) { a }; f(1)", inline, "let f = fn(a, b)a;f(1)", "let f = fn(a, b)a;f(1)"},
+		{"let f = fn(a: int) { a }; f(1)", inline, "let f = fn(a: int)a;f(1)", "let f = fn(a: int)a;f(1)"},
+		{"let f = fn(a) { let b = a; b }; f(1)", inline, "let f = fn(a)let b = a;b;f(1)", "let f = fn(a)let b = a;b;f(1)"},
+
+		{"let add = fn(a, b) { a + b }; let g = fn(y) { add(1, 2) + y }; g(3)", all,
+			"let add = fn(a, b)(a + b);let g = fn(y)(add(1, 2) + y);g(3)", "let add = fn(a, b)(a + b);let g = fn(y)(3 + y);6"},
+		{"if (fn(x) { x > 1 }(2)) { 10 } else { 20 }", all, "iffn(x)(x > 1)(2) 10else 20", "10"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		if got := program.String(); got != tt.before {
+			t.Errorf("wrong program before for %q. want=%q, got=%q", tt.input, tt.before, got)
+		}
+		if got := Program(program, tt.opts).String(); got != tt.after {
+			t.Errorf("wrong program after for %q. want=%q, got=%q", tt.input, tt.after, got)
+		}
+	}
+}
+
+// TestSameResults evaluates programs before and after every pass,
+// expecting the same values and the same errors at the same locations
+func TestSameResults(t *testing.T) {
+	inputs := []string{
+		"1 / 0",
+		"let a = 1;\n2 * 3 + a / (4 - 4)",
+		`"a" + "b" == "ab"`,
+		"if (1 < 2) { 10 } else { 20 }",
+		"if (1 > 2) { 10 }",
+		"if (true) { }",
+		"let a = 5; if (false) { let a = 6; } a",
+		"let a = 5; if (true) { let a = 6; } a",
+		"let f = fn(n) { if (true) { return n * 2; } n }; f(4)",
+		"let f = fn(x) {\n  x / 0\n};\nf(1);",
+		"fn(x) { x + y }(1)",
+		"let sq = fn(x) { x * x }; let g = fn(sq) { sq(2) }; g(fn(n) { n + 1 })",
+		"let x = 1; for (false) { x = 2 }; x",
+		"fn(f) { f(1) }(5)",
+		"if (5) { -true }",
+	}
+
+	for _, input := range inputs {
+		want := evaluator.Eval(context.Background(), parse(t, input), object.NewEnvironment(), evaluator.Options{})
+		got := evaluator.Eval(context.Background(), Program(parse(t, input), all), object.NewEnvironment(), evaluator.Options{})
+		if describe(got) != describe(want) {
+			t.Errorf("results differ for %q.\nbefore=%s\nafter=%s", input, describe(want), describe(got))
+		}
+	}
+}
+
+func describe(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
+}
@@ -1,10 +1,11 @@
 package parser
 
 import (
-	"fmt"
 	"gosling/ast"
+	"gosling/diag"
 	"gosling/lexer"
 	"gosling/token"
+	"math/big"
 	"strconv"
 )
 
@@ -12,7 +13,10 @@ type Parser struct {
 	l         *lexer.Lexer
 	curToken  token.Token
 	peekToken token.Token
-	errors    []string
+	errors    []diag.Diagnostic
+
+	// type parameters of the generic functions being parsed, innermost last
+	typeParameters []string
 
 	prefixParseFns map[token.TokenType]prefixParseFns
 	infixParseFns  map[token.TokenType]infixParseFns
@@ -27,6 +31,7 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
 	EQUALS      // ==
 	LESSGREATER // < or >
 	SUM         // + or -
@@ -36,6 +41,7 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -58,6 +64,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NONE, p.parseNoneLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FOR, p.parseForExpression)
@@ -75,6 +82,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -90,17 +98,33 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFns) {
 }
 
 func (p *Parser) Errors() []string {
+	msgs := make([]string, 0, len(p.errors))
+	for _, d := range p.errors {
+		msgs = append(msgs, d.String())
+	}
+	return msgs
+}
+
+// Diagnostics returns the same errors as Errors, keeping their codes and locations
+func (p *Parser) Diagnostics() []diag.Diagnostic {
 	return p.errors
 }
 
+func (p *Parser) addError(code diag.Code, loc token.TokenLocation, format string, a ...interface{}) {
+	p.errors = append(p.errors, diag.New(code, loc, format, a...))
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(diag.UnexpectedToken, p.peekToken.Location, "expected next token to be %s, got %s", t, p.peekToken.Type)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	// the lexer hands illegal characters through as tokens, report them as such
+	if t == token.ILLEGAL {
+		p.addError(diag.IllegalCharacter, p.curToken.Location, "illegal character %q", p.curToken.Literal)
+		return
+	}
+	p.addError(diag.NoPrefixParse, p.curToken.Location, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) nextToken() {
@@ -162,6 +186,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Name.Type = p.parseTypeExpression()
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -181,6 +210,13 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
+	// return; and a return closing a block give null
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
+		for p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	}
 	p.nextToken()
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
@@ -238,13 +274,16 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+	if err == nil {
+		lit.Value = value
+		return lit
+	}
+	n, ok := new(big.Int).SetString(p.curToken.Literal, 0)
+	if !ok {
+		p.addError(diag.InvalidInteger, p.curToken.Location, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
-
-	lit.Value = value
+	lit.Big = n
 	return lit
 }
 
@@ -279,6 +318,10 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseNoneLiteral() ast.Expression {
+	return &ast.NoneLiteral{Token: p.curToken}
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	bool := &ast.Boolean{
 		Token: p.curToken,
@@ -300,15 +343,30 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 
 func (p *Parser) parseIfExpression() ast.Expression {
 	exp := &ast.IfExpression{Token: p.curToken}
-	if !p.expectPeek(token.LPAREN) {
-		return nil
-	}
+	if p.peekTokenIs(token.LET) {
+		// if let v = x { ... } runs the block with v bound to the value of
+		// the option x
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		exp.Binding = p.parseParameter()
+		if !p.expectPeek(token.ASSIGN) {
+			return nil
+		}
+		p.nextToken()
+		exp.Condition = p.parseExpression(LOWEST)
+	} else {
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
 
-	p.nextToken()
-	exp.Condition = p.parseExpression(LOWEST)
+		p.nextToken()
+		exp.Condition = p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
 	}
 
 	if !p.expectPeek(token.LBRACE) {
@@ -347,12 +405,31 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	function := &ast.FunctionLiteral{Token: p.curToken}
 
+	if p.peekTokenIs(token.LT) {
+		p.nextToken()
+		function.TypeParameters = p.parseTypeParameters()
+		if function.TypeParameters == nil {
+			return nil
+		}
+		outer := len(p.typeParameters)
+		for _, tp := range function.TypeParameters {
+			p.typeParameters = append(p.typeParameters, tp.Value)
+		}
+		defer func() { p.typeParameters = p.typeParameters[:outer] }()
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
 	function.Parameters = p.parseFunctionParameters()
 
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		p.nextToken()
+		function.ReturnType = p.parseTypeExpression()
+	}
+
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
@@ -372,15 +449,13 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 	p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+	identifiers = append(identifiers, p.parseParameter())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
 
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
+		identifiers = append(identifiers, p.parseParameter())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -390,6 +465,114 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	return identifiers
 }
 
+func (p *Parser) parseParameter() *ast.Identifier {
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		ident.Type = p.parseTypeExpression()
+	}
+	return ident
+}
+
+// parseTypeParameters parses the <T, U> of a generic function, starting
+// at the token.LT
+func (p *Parser) parseTypeParameters() []*ast.Identifier {
+	params := []*ast.Identifier{}
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		params = append(params, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.GT) {
+		return nil
+	}
+	return params
+}
+
+func (p *Parser) isTypeParameter(name string) bool {
+	for _, tp := range p.typeParameters {
+		if tp == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTypeExpression parses an annotation starting at the current token:
+// a type name with optional <arguments>, [T], {K: V} or fn(params) -> result
+func (p *Parser) parseTypeExpression() ast.TypeExpression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		named := &ast.NamedType{Token: p.curToken, Name: p.curToken.Literal, Parameter: p.isTypeParameter(p.curToken.Literal)}
+		if p.peekTokenIs(token.LT) {
+			p.nextToken()
+			for {
+				p.nextToken()
+				named.Arguments = append(named.Arguments, p.parseTypeExpression())
+				if !p.peekTokenIs(token.COMMA) {
+					break
+				}
+				p.nextToken()
+			}
+			if !p.expectPeek(token.GT) {
+				return nil
+			}
+		}
+		return named
+	case token.LBRACKET:
+		array := &ast.ArrayType{Token: p.curToken}
+		p.nextToken()
+		array.Element = p.parseTypeExpression()
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return array
+	case token.LBRACE:
+		m := &ast.MapType{Token: p.curToken}
+		p.nextToken()
+		m.Key = p.parseTypeExpression()
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		m.Value = p.parseTypeExpression()
+		if !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		return m
+	case token.FUNCTION:
+		fnType := &ast.FunctionType{Token: p.curToken, Parameters: []ast.TypeExpression{}}
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		for !p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+			fnType.Parameters = append(fnType.Parameters, p.parseTypeExpression())
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if p.peekTokenIs(token.ARROW) {
+			p.nextToken()
+			p.nextToken()
+			fnType.Result = p.parseTypeExpression()
+		}
+		return fnType
+	}
+	p.addError(diag.UnexpectedToken, p.curToken.Location, "expected a type, got %s", p.curToken.Type)
+	return nil
+}
+
 func (p *Parser) parseForExpression() ast.Expression {
 	exp := &ast.ForExpression{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
@@ -437,6 +620,25 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	return args
 }
 
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	name, ok := left.(*ast.Identifier)
+	if left == nil || (!ok && len(p.errors) > 0) {
+		// the target may not have parsed, which is reported already, and
+		// may be missing parts to print
+		return nil
+	}
+	if !ok {
+		p.addError(diag.InvalidAssignment, p.curToken.Location, "cannot assign to %s", left.String())
+		return nil
+	}
+
+	exp := &ast.AssignExpression{Token: p.curToken, Name: name}
+	p.nextToken()
+	// one less than ASSIGN so that a = b = c groups to the right
+	exp.Value = p.parseExpression(ASSIGN - 1)
+	return exp
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -456,6 +658,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 		p.nextToken()
 	}
+	program.Comments = p.l.Comments()
 
 	return program
 }
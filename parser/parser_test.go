@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"gosling/ast"
+	"gosling/diag"
 	"gosling/lexer"
 	"testing"
 )
@@ -90,6 +91,28 @@ func TestReturnStatements(t *testing.T) {
 	}
 }
 
+func TestEmptyReturnStatements(t *testing.T) {
+	for _, input := range []string{"return;", "return", "fn() { return }"} {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		var stmt *ast.ReturnStatement
+		ast.Inspect(program, func(n ast.Node) bool {
+			if r, ok := n.(*ast.ReturnStatement); ok {
+				stmt = r
+			}
+			return true
+		})
+		if stmt == nil {
+			t.Fatalf("no return statement in %q", input)
+		}
+		if stmt.ReturnValue != nil {
+			t.Errorf("return in %q has a value: %s", input, stmt.ReturnValue)
+		}
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	boolTests := []struct {
 		input string
@@ -258,6 +281,34 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiteral(t *testing.T) {
+	tests := []struct {
+		input string
+		big   string // empty if the literal fits in Value
+	}{
+		{"9223372036854775807", ""},
+		{"9223372036854775808", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("not an ast.IntegerLiteral for %q", tt.input)
+		}
+		switch {
+		case tt.big == "" && literal.Big != nil:
+			t.Errorf("literal %q should fit in Value. got Big=%s", tt.input, literal.Big)
+		case tt.big != "" && (literal.Big == nil || literal.Big.String() != tt.big):
+			t.Errorf("wrong Big for %q. got=%v", tt.input, literal.Big)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input        string
@@ -427,6 +478,41 @@ func TestIfElseExpression(t *testing.T) {
 
 }
 
+func TestIfLetExpression(t *testing.T) {
+	input := `if let v: int = find(x) { v } else { 0 }`
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not an *ast.IfExpression, got=%T", stmt.Expression)
+	}
+	if exp.Binding == nil || exp.Binding.Value != "v" || exp.Binding.Type.String() != "int" {
+		t.Fatalf("wrong binding. got=%+v", exp.Binding)
+	}
+	if _, ok := exp.Condition.(*ast.CallExpression); !ok {
+		t.Errorf("exp.Condition is not an *ast.CallExpression. got=%T", exp.Condition)
+	}
+	if exp.Alternative == nil {
+		t.Errorf("alternative missing")
+	}
+	if got, want := program.String(), "if let v: int = find(x) velse 0"; got != want {
+		t.Errorf("wrong program. want=%q, got=%q", want, got)
+	}
+
+	l = lexer.New("let x = none;")
+	p = New(l)
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	if _, ok := program.Statements[0].(*ast.LetStatement).Value.(*ast.NoneLiteral); !ok {
+		t.Errorf("value is not an *ast.NoneLiteral. got=%T", program.Statements[0].(*ast.LetStatement).Value)
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -692,6 +778,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a = b + c",
+			"a = (b + c)",
+		},
+		{
+			"a = b = c == d",
+			"a = b = (c == d)",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -705,6 +799,38 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestAssignExpressionParsing(t *testing.T) {
+	input := "x = x + 1;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T",
+			stmt.Expression)
+	}
+
+	if !testIdentifier(t, exp.Name, "x") {
+		return
+	}
+
+	testInfixExpression(t, exp.Value, "x", "+", 1)
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -803,3 +929,99 @@ func TestStringLiteralExpressions(t *testing.T) {
 		t.Errorf("literal.Value not %q. got=%q", "hello world", literal.Value)
 	}
 }
+
+func TestParserErrorCodes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected diag.Code
+	}{
+		{"let = 5;", diag.UnexpectedToken},
+		{"let x = * 5;", diag.NoPrefixParse},
+		{"09", diag.InvalidInteger},
+		{"5 = 6;", diag.InvalidAssignment},
+		{"() = 1;", diag.NoPrefixParse},
+		{"1 + ) = 2;", diag.NoPrefixParse},
+		{"let x = 5 \\ 2;", diag.IllegalCharacter},
+		{"let x: 5 = 1;", diag.UnexpectedToken},
+		{"fn(a: int, b:) {}", diag.UnexpectedToken},
+		{"fn<>(x) {}", diag.UnexpectedToken},
+		{"if let 5 = x { 1 }", diag.UnexpectedToken},
+		{"if let v x { 1 }", diag.UnexpectedToken},
+		{"if let v = x; { 1 }", diag.UnexpectedToken},
+		{"let x: [int = 1;", diag.UnexpectedToken},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+		if diagnostics[0].Code != tt.expected {
+			t.Errorf("wrong error code for %q, expected=%s, got=%s (%s)",
+				tt.input, tt.expected, diagnostics[0].Code, diagnostics[0])
+		}
+	}
+}
+
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let x = 5;", "let x = 5;"},
+		{"fn(a: int, b) { a }", "fn(a: int, b)a"},
+		{"fn(a) -> string { a }", "fn(a) -> string a"},
+		{"let f: fn(int, string) -> bool = g;", "let f: fn(int, string) -> bool = g;"},
+		{"let f: fn() = g;", "let f: fn() = g;"},
+		{"fn(f: fn(int) -> int) -> fn(int) -> int { f }", "fn(f: fn(int) -> int) -> fn(int) -> int f"},
+		{"fn<T>(xs: [T]) -> T { xs }", "fn<T>(xs: [T]) -> T xs"},
+		{"let m: {string: [int]} = n;", "let m: {string: [int]} = n;"},
+		{"let r: Result<int> = n;", "let r: Result<int> = n;"},
+		{"fn<K, V>(m: {K: V}, f: fn(K) -> V) { m }", "fn<K, V>(m: {K: V}, f: fn(K) -> V)m"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("let f = fn(a: int) -> bool { true };")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	function := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	param, ok := function.Parameters[0].Type.(*ast.NamedType)
+	if !ok || param.Name != "int" {
+		t.Errorf("parameter type wrong. got=%#v", function.Parameters[0].Type)
+	}
+	result, ok := function.ReturnType.(*ast.NamedType)
+	if !ok || result.Name != "bool" {
+		t.Errorf("return type wrong. got=%#v", function.ReturnType)
+	}
+
+	l = lexer.New("fn<T>(x: T, y: U) { x }")
+	p = New(l)
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+
+	function = program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if x := function.Parameters[0].Type.(*ast.NamedType); !x.Parameter {
+		t.Errorf("T not marked as a type parameter")
+	}
+	if y := function.Parameters[1].Type.(*ast.NamedType); y.Parameter {
+		t.Errorf("U wrongly marked as a type parameter")
+	}
+}
@@ -0,0 +1,85 @@
+package repl
+
+import (
+	"context"
+	"gosling/ast"
+	"gosling/compiler"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/resolve"
+	"gosling/vm"
+)
+
+// The engines a session can run lines with
+const (
+	EngineTree = "tree" // walk the syntax tree with the evaluator
+	EngineVM   = "vm"   // compile to bytecode and run it on the vm
+)
+
+// engine runs the lines of a session, keeping what each line binds for
+// the ones after it
+type engine interface {
+	// run returns the value of the line or the *object.Error it failed
+	// with, and an error if the line could not be run at all
+	run(program *ast.Program) (object.Object, error)
+	// globals returns the values bound by the lines run so far
+	globals() map[string]object.Object
+}
+
+func newEngine(name string) engine {
+	if name == EngineVM {
+		return &vmEngine{symbolTable: compiler.New().SymbolTable(), constants: []object.Object{}}
+	}
+	return &treeEngine{env: object.NewEnvironment()}
+}
+
+type treeEngine struct {
+	env *object.Environment
+}
+
+func (e *treeEngine) run(program *ast.Program) (object.Object, error) {
+	resolve.Program(program)
+	return evaluator.Eval(context.Background(), program, e.env, evaluator.Options{}), nil
+}
+
+func (e *treeEngine) globals() map[string]object.Object {
+	globals := map[string]object.Object{}
+	for _, name := range e.env.Names() {
+		if val, ok := e.env.Get(name); ok {
+			globals[name] = val
+		}
+	}
+	return globals
+}
+
+// vmEngine compiles each line against the symbols and constants of the
+// earlier ones and runs it on their globals
+type vmEngine struct {
+	symbolTable  *compiler.SymbolTable
+	constants    []object.Object
+	globalsStore []object.Object
+}
+
+func (e *vmEngine) run(program *ast.Program) (object.Object, error) {
+	comp := compiler.NewWithState(e.symbolTable, e.constants)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	bytecode := comp.Bytecode()
+	e.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalsStore(bytecode, e.globalsStore, evaluator.Options{})
+	result := machine.Run(context.Background())
+	e.globalsStore = machine.Globals()
+	return result, nil
+}
+
+func (e *vmEngine) globals() map[string]object.Object {
+	globals := map[string]object.Object{}
+	for _, symbol := range e.symbolTable.Symbols() {
+		if symbol.Index < len(e.globalsStore) && e.globalsStore[symbol.Index] != nil {
+			globals[symbol.Name] = e.globalsStore[symbol.Index]
+		}
+	}
+	return globals
+}
@@ -3,10 +3,12 @@ package repl
 import (
 	"bufio"
 	"fmt"
-	"gosling/evaluator"
+	"gosling/ast"
 	"gosling/lexer"
-	"gosling/object"
 	"gosling/parser"
+	"gosling/typecheck"
+	"gosling/types"
+	"gosling/vet"
 	"io"
 	"os"
 	"strings"
@@ -16,6 +18,9 @@ import (
 
 const PROMPT = "$ "
 
+// CHECK_COMMAND toggles the static type checker for the rest of the session
+const CHECK_COMMAND = "\\check"
+
 type CommandHistory struct {
 	commands []string
 	index    int
@@ -80,7 +85,7 @@ func (h *CommandHistory) Next() string {
 }
 
 // New function that handles the welcome message
-func StartWithWelcome(in io.Reader, out io.Writer, username string) {
+func StartWithWelcome(in io.Reader, out io.Writer, username string, engineName string) {
 
 	// Print welcome messages with forced line positioning
 	fmt.Printf("\rWelcome to my language, Gosling\n")
@@ -88,13 +93,19 @@ func StartWithWelcome(in io.Reader, out io.Writer, username string) {
 	fmt.Printf("\rEnter Valid Gosling commands and see what happens\n")
 
 	// Now start the REPL
-	Start(in, out)
+	StartEngine(in, out, engineName)
 }
 
 // Keep the original Start function for backward compatibility
 func Start(in io.Reader, out io.Writer) {
-	env := object.NewEnvironment()
+	StartEngine(in, out, EngineTree)
+}
+
+// StartEngine runs the REPL with the named engine, EngineTree or EngineVM
+func StartEngine(in io.Reader, out io.Writer, engineName string) {
+	env := newEngine(engineName)
 	history := NewCommandHistory(100) // Keep last 100 commands
+	typeCheck := false
 
 	// Check if we're in a terminal
 	if !term.IsTerminal(int(os.Stdin.Fd())) {
@@ -104,6 +115,7 @@ func Start(in io.Reader, out io.Writer) {
 
 	// Print REPL-specific message BEFORE entering raw mode
 	fmt.Printf("\rGosling REPL - Use Up/Down arrows for history, Ctrl+C to exit\n")
+	fmt.Printf("\rType %s to toggle static type checking\n", CHECK_COMMAND)
 
 	// Set terminal to raw mode
 	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
@@ -140,6 +152,12 @@ func Start(in io.Reader, out io.Writer) {
 		// Parse and evaluate - force new line before output
 		fmt.Printf("\n")
 
+		if line == CHECK_COMMAND {
+			typeCheck = !typeCheck
+			printCheckState(os.Stdout, typeCheck)
+			continue
+		}
+
 		l := lexer.New(line)
 		p := parser.New(l)
 
@@ -148,8 +166,16 @@ func Start(in io.Reader, out io.Writer) {
 			printParseErrors(p.Errors())
 			continue
 		}
+		printWarnings(os.Stdout, program, env)
+		if typeCheck && !checkTypes(os.Stdout, program, env) {
+			continue
+		}
 
-		evaluated := evaluator.Eval(program, env)
+		evaluated, err := env.run(program)
+		if err != nil {
+			fmt.Printf("\t%s\n", err)
+			continue
+		}
 
 		if evaluated != nil {
 			fmt.Printf("%s\n", evaluated.Inspect())
@@ -227,9 +253,10 @@ func clearLineAndRedraw(newText string) {
 	fmt.Printf("\r\033[2K%s%s", PROMPT, newText)
 }
 
-func startBasicREPL(in io.Reader, out io.Writer, env *object.Environment) {
+func startBasicREPL(in io.Reader, out io.Writer, env engine) {
 	fmt.Fprintf(out, "Gosling REPL (basic mode)\n")
 	scanner := bufio.NewScanner(in)
+	typeCheck := false
 
 	for {
 		fmt.Fprint(out, PROMPT)
@@ -239,6 +266,12 @@ func startBasicREPL(in io.Reader, out io.Writer, env *object.Environment) {
 		}
 
 		line := scanner.Text()
+		if line == CHECK_COMMAND {
+			typeCheck = !typeCheck
+			printCheckState(out, typeCheck)
+			continue
+		}
+
 		l := lexer.New(line)
 		p := parser.New(l)
 
@@ -247,8 +280,16 @@ func startBasicREPL(in io.Reader, out io.Writer, env *object.Environment) {
 			printParseErrors(p.Errors())
 			continue
 		}
+		printWarnings(out, program, env)
+		if typeCheck && !checkTypes(out, program, env) {
+			continue
+		}
 
-		evaluated := evaluator.Eval(program, env)
+		evaluated, err := env.run(program)
+		if err != nil {
+			fmt.Fprintf(out, "\t%s\n", err)
+			continue
+		}
 
 		if evaluated != nil {
 			fmt.Fprintf(out, "%s\n", evaluated.Inspect())
@@ -256,6 +297,42 @@ func startBasicREPL(in io.Reader, out io.Writer, env *object.Environment) {
 	}
 }
 
+// printWarnings shows vet findings for the line about to run. Bindings from
+// earlier lines count as known, and top level lets may be used later on.
+func printWarnings(out io.Writer, program *ast.Program, env engine) {
+	names := []string{}
+	for name := range env.globals() {
+		names = append(names, name)
+	}
+	config := vet.Config{Globals: names, IgnoreUnusedGlobals: true}
+	for _, finding := range vet.Check(program, config) {
+		fmt.Fprintf(out, "\twarning: %s\n", finding)
+	}
+}
+
+// checkTypes runs the static checker over the line, using the values bound
+// by earlier lines, and reports whether the line may run
+func checkTypes(out io.Writer, program *ast.Program, env engine) bool {
+	globals := map[string]types.Type{}
+	for name, val := range env.globals() {
+		globals[name] = typecheck.TypeOf(val)
+	}
+
+	_, diagnostics := typecheck.Check(program, typecheck.Config{Globals: globals})
+	for _, d := range diagnostics {
+		fmt.Fprintf(out, "\t%s\n", d)
+	}
+	return len(diagnostics) == 0
+}
+
+func printCheckState(out io.Writer, on bool) {
+	if on {
+		fmt.Fprintf(out, "type checking on\n")
+	} else {
+		fmt.Fprintf(out, "type checking off\n")
+	}
+}
+
 func printParseErrors(errors []string) {
 	for _, msg := range errors {
 		fmt.Printf("\t%s\n", msg)
@@ -0,0 +1,173 @@
+// Package resolve works out, before a program runs, where each of its
+// names is bound, so the evaluator can reach a binding by position rather
+// than by looking its name up in every enclosing environment.
+//
+// The evaluator makes an environment for the program, for each call and
+// for the consequence of each if let, and the blocks of plain ifs and
+// loops share the environment they are in. Each of those scopes but the
+// program's gets a slot for every name bound anywhere in it. The program's
+// names stay in the map of the environment Eval is given, which the REPL
+// and host programs fill and read by name.
+//
+// A slot can be read before the let that fills it has run, as in
+// let x = x or a closure called before a name it uses is bound. The
+// evaluator then looks the name up by name from the enclosing
+// environment, as it would have without a resolver.
+//
+// Each integer and string literal also gets its value here, once, so the
+// evaluator returns the same object every time it reaches the literal and
+// never writes to the tree it runs.
+package resolve
+
+import (
+	"gosling/ast"
+	"gosling/object"
+)
+
+// Program annotates every identifier of program with where it is bound,
+// every function literal and if let with the layout of its environment,
+// and every integer and string literal with its value. It may be run again
+// on a program it has already resolved, though not while it runs.
+func Program(program *ast.Program) {
+	top := &scope{}
+	for _, s := range program.Statements {
+		resolve(s, top)
+	}
+}
+
+// scope is the environment of the program, a call or an if let
+type scope struct {
+	outer *scope
+	// slots holds the slot of each name bound in the scope, nil for the
+	// program whose names are bound by name
+	slots  map[string]int
+	layout *ast.Scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, slots: make(map[string]int), layout: &ast.Scope{}}
+}
+
+func (s *scope) bind(name string) {
+	if _, ok := s.slots[name]; !ok {
+		s.slots[name] = len(s.layout.Names)
+		s.layout.Names = append(s.layout.Names, name)
+	}
+}
+
+// lookup returns how many scopes out name is bound and its slot there,
+// reaching the program's scope, where it is found by name, if no scope in
+// between binds it
+func (s *scope) lookup(name string) (depth, slot int) {
+	for ; s.slots != nil; s = s.outer {
+		if slot, ok := s.slots[name]; ok {
+			return depth, slot
+		}
+		depth++
+	}
+	return depth, -1
+}
+
+// declare gives a slot to every name a let binds in node, leaving out the
+// scopes nested in it
+func declare(node ast.Node, s *scope) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			s.bind(n.Name.Value)
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.IfExpression:
+			if n.Binding != nil {
+				declare(n.Condition, s)
+				declare(n.Alternative, s)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func resolve(node ast.Node, s *scope) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, stmt := range node.Statements {
+			resolve(stmt, s)
+		}
+	case *ast.ExpressionStatement:
+		resolve(node.Expression, s)
+	case *ast.LetStatement:
+		resolve(node.Value, s)
+		bindHere(node.Name, s)
+	case *ast.ReturnStatement:
+		resolve(node.ReturnValue, s)
+	case *ast.Identifier:
+		use(node, s)
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			node.Object = &object.BigInt{Value: node.Big}
+		} else {
+			node.Object = object.NewInteger(node.Value)
+		}
+	case *ast.StringLiteral:
+		node.Object = object.NewString(node.Value)
+	case *ast.AssignExpression:
+		resolve(node.Value, s)
+		use(node.Name, s)
+	case *ast.PrefixExpression:
+		resolve(node.Right, s)
+	case *ast.InfixExpression:
+		resolve(node.Left, s)
+		resolve(node.Right, s)
+	case *ast.IfExpression:
+		resolve(node.Condition, s)
+		if node.Binding != nil {
+			inner := newScope(s)
+			inner.bind(node.Binding.Value)
+			declare(node.Consequence, inner)
+			bindHere(node.Binding, inner)
+			resolve(node.Consequence, inner)
+			node.Scope = inner.layout
+		} else {
+			resolve(node.Consequence, s)
+		}
+		resolve(node.Alternative, s)
+	case *ast.ForExpression:
+		resolve(node.Condition, s)
+		resolve(node.Body, s)
+	case *ast.FunctionLiteral:
+		inner := newScope(s)
+		for _, p := range node.Parameters {
+			inner.bind(p.Value)
+		}
+		declare(node.Body, inner)
+		for _, p := range node.Parameters {
+			bindHere(p, inner)
+		}
+		resolve(node.Body, inner)
+		node.Scope = inner.layout
+	case *ast.CallExpression:
+		resolve(node.Function, s)
+		for _, a := range node.Arguments {
+			resolve(a, s)
+		}
+	}
+}
+
+// bindHere resolves a name that a let, parameter or if let binds in s
+// itself
+func bindHere(ident *ast.Identifier, s *scope) {
+	slot := -1
+	if s.slots != nil {
+		slot = s.slots[ident.Value]
+	}
+	ident.Resolved, ident.Depth, ident.Slot = true, 0, slot
+}
+
+func use(ident *ast.Identifier, s *scope) {
+	ident.Depth, ident.Slot = s.lookup(ident.Value)
+	ident.Resolved = true
+}
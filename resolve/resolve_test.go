@@ -0,0 +1,175 @@
+package resolve
+
+import (
+	"context"
+	"gosling/ast"
+	"gosling/evaluator"
+	"gosling/lexer"
+	"gosling/object"
+	"gosling/parser"
+	"testing"
+)
+
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestProgram(t *testing.T) {
+	program := parse(t, "let a = 1; let f = fn(x) { let y = x; fn() { if (true) { let z = a; } y + z } }; f(2)")
+	Program(program)
+
+	var idents []*ast.Identifier
+	ast.Inspect(program, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			idents = append(idents, ident)
+		}
+		return true
+	})
+	tests := []struct {
+		name  string
+		depth int
+		slot  int
+	}{
+		{"a", 0, -1},
+		{"f", 0, -1},
+		{"x", 0, 0},
+		{"y", 0, 1},
+		{"x", 0, 0},
+		{"z", 0, 0},
+		{"a", 2, -1},
+		{"y", 1, 1},
+		{"z", 0, 0},
+		{"f", 0, -1},
+	}
+	if len(idents) != len(tests) {
+		t.Fatalf("wrong number of identifiers. want=%d, got=%d", len(tests), len(idents))
+	}
+	for i, tt := range tests {
+		got := idents[i]
+		if got.Value != tt.name || !got.Resolved || got.Depth != tt.depth || got.Slot != tt.slot {
+			t.Errorf("wrong place for identifier %d. want=%s %d %d, got=%s %d %d (resolved %t)",
+				i, tt.name, tt.depth, tt.slot, got.Value, got.Depth, got.Slot, got.Resolved)
+		}
+	}
+}
+
+// TestSameResults evaluates programs with and without resolving them,
+// expecting the same values and the same errors at the same locations
+func TestSameResults(t *testing.T) {
+	inputs := []string{
+		"let x = 1; let f = fn() { let x = x + 1; x }; f()",
+		"let f = fn() { let g = fn() { y }; let y = 2; g() }; f()",
+		"let f = fn() { let g = fn() { y }; let r = g(); let y = 2; r }; f()",
+		"let y = 7; let f = fn() { let g = fn() { y }; let r = g(); let y = 2; r + g() }; f()",
+		"let f = fn(c) { if (c) { let v = 1; } v }; f(true)",
+		"let v = 3; let f = fn(c) { if (c) { let v = 1; } v }; f(false)",
+		"let f = fn(o) { if let v = o { v * 2 } else { 0 } }; f(some(4)) + f(none)",
+		"let v = 1; let f = fn(o) { if let w = o { let v = w; v } else { v } }; f(none)",
+		"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c()",
+		"let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n",
+		"let f = fn() { m = 1 }; f()",
+		"let f = fn(n) { let i = 0; let s = 0; for (i < n) { s = s + i; i = i + 1; } s }; f(10)",
+		"let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)",
+		"let f = fn(a, a) { a }; f(1, 2)",
+		"let len = fn(x) { 0 }; len(\"abc\")",
+		"let f = fn() { len(\"abc\") }; f()",
+		"let f = fn() { g() }; let g = fn() { 5 }; f()",
+		"let f = fn(x: int) -> int { if let y = some(x) { return y + 1; } 0 }; f(1)",
+		"let f = fn() { q }; f()",
+		"if let x = some(1) { let x = 2; x }",
+	}
+
+	for _, input := range inputs {
+		want := evaluator.Eval(context.Background(), parse(t, input), object.NewEnvironment(), evaluator.Options{})
+		resolved := parse(t, input)
+		Program(resolved)
+		got := evaluator.Eval(context.Background(), resolved, object.NewEnvironment(), evaluator.Options{})
+		if describe(got) != describe(want) {
+			t.Errorf("results differ for %q.\nby name=%s\nresolved=%s", input, describe(want), describe(got))
+		}
+	}
+}
+
+// TestConcurrentEval evaluates one program from several goroutines at
+// once, resolved and not, which go test -race checks share it safely: the
+// evaluator never writes to the tree, and Program makes the values of the
+// literals before any evaluation starts
+func TestConcurrentEval(t *testing.T) {
+	const input = `let f = fn(n, s) { if (n == 0) { s } else { f(n - 1, s + "ab") } }; len(f(20, "")) + 99999999999999999999 - 1000000`
+	resolved := parse(t, input)
+	Program(resolved)
+
+	var literals int
+	ast.Inspect(resolved, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IntegerLiteral:
+			literals++
+			if n.Object == nil {
+				t.Errorf("no value for the literal %s", n.String())
+			}
+		case *ast.StringLiteral:
+			literals++
+			if n.Object == nil {
+				t.Errorf("no value for the literal %q", n.Value)
+			}
+		}
+		return true
+	})
+	if literals != 7 {
+		t.Errorf("wrong number of literals. want=7, got=%d", literals)
+	}
+
+	for _, program := range []*ast.Program{resolved, parse(t, input)} {
+		results := make(chan string)
+		for i := 0; i < 8; i++ {
+			go func() {
+				results <- describe(evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{}))
+			}()
+		}
+		for i := 0; i < 8; i++ {
+			if got := <-results; got != "99999999999999000039" {
+				t.Errorf("wrong result. want=99999999999999000039, got=%s", got)
+			}
+		}
+	}
+}
+
+func describe(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
+}
+
+const closureProgram = `
+let compose = fn(f, g) { fn(x) { f(g(x)) } };
+let adder = fn(n) { fn(x) { x + n } };
+let loop = fn(i, acc) {
+	let step = compose(adder(i), adder(1));
+	let j = i + 1;
+	if (i < 2000) { loop(j, step(acc)) } else { acc }
+};
+loop(0, 0);
+`
+
+func BenchmarkClosures(b *testing.B) {
+	b.Run("by name", func(b *testing.B) {
+		program := parse(b, closureProgram)
+		for i := 0; i < b.N; i++ {
+			evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{})
+		}
+	})
+	b.Run("resolved", func(b *testing.B) {
+		program := parse(b, closureProgram)
+		Program(program)
+		for i := 0; i < b.N; i++ {
+			evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{})
+		}
+	})
+}
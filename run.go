@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gosling/ast"
+	"gosling/compiler"
+	"gosling/evaluator"
+	"gosling/gosc"
+	"gosling/lexer"
+	"gosling/object"
+	"gosling/optimize"
+	"gosling/parser"
+	"gosling/repl"
+	"gosling/resolve"
+	"gosling/vm"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+)
+
+// run evaluates a .gos file, or runs a compiled .gosc one on the vm, and
+// prints the value of its last statement. Both engines go through the
+// cache, the vm for bytecode and the tree walker for the parsed program.
+func run(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	maxDepth := fs.Int("max-depth", 0, "maximum call depth, 0 for the default, negative for no limit")
+	maxSteps := fs.Int("max-steps", 0, "maximum number of evaluation steps, 0 for no limit")
+	timeout := fs.Duration("timeout", 0, "wall-clock limit such as 500ms or 2s, 0 for none")
+	maxMemory := fs.Int64("max-memory", 0, "approximate memory budget in bytes, 0 for no limit")
+	strictOverflow := fs.Bool("strict-overflow", false, "report integer overflow as an error instead of promoting to a big integer")
+	engine := fs.String("engine", repl.EngineTree, "how to run the program: tree to walk the syntax tree, vm to compile it to bytecode")
+	noCache := fs.Bool("no-cache", false, "parse and compile the file even if the cache holds it")
+	passes := optimizeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *engine != repl.EngineTree && *engine != repl.EngineVM {
+		fmt.Fprintf(errOut, "unknown engine %q, want tree or vm\n", *engine)
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(errOut, "usage: gosling run [flags] file.gos|file.gosc\n")
+		return 2
+	}
+	path := fs.Arg(0)
+
+	// Ctrl+C stops the evaluation with a located error instead of killing it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := evaluator.Options{
+		MaxDepth:  *maxDepth,
+		MaxSteps:  *maxSteps,
+		Timeout:   *timeout,
+		MaxMemory: *maxMemory,
+
+		StrictOverflow: *strictOverflow,
+	}
+	var evaluated object.Object
+	switch {
+	case filepath.Ext(path) == gosc.Ext:
+		bytecode, ok := readCompiled(path, errOut)
+		if !ok {
+			return 1
+		}
+		evaluated = vm.New(bytecode, opts).Run(ctx)
+	case *engine == repl.EngineVM:
+		bytecode, ok := compileFile(path, *passes, !*noCache, errOut)
+		if !ok {
+			return 1
+		}
+		evaluated = vm.New(bytecode, opts).Run(ctx)
+	default:
+		program, ok := parseCachedFile(path, !*noCache, errOut)
+		if !ok {
+			return 1
+		}
+		optimize.Program(program, *passes)
+		resolve.Program(program)
+		evaluated = evaluator.Eval(ctx, program, object.NewEnvironment(), opts)
+	}
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintf(errOut, "%s\n", errObj.Inspect())
+		return 1
+	}
+	if evaluated != nil && evaluated != evaluator.NULL {
+		fmt.Fprintf(out, "%s\n", evaluated.Inspect())
+	}
+	return 0
+}
+
+// compileFile compiles a .gos file, going through the compile cache when
+// useCache is set. A cache that cannot be written only costs the next run
+// a compile, so failing to store is not reported. The cache only holds
+// programs compiled as written, so optimising passes bypass it.
+func compileFile(path string, passes optimize.Options, useCache bool, errOut io.Writer) (*compiler.Bytecode, bool) {
+	cache, source := openCache(path, useCache && !passes.Enabled())
+	if cache != nil {
+		if bytecode, ok := cache.Load(path, source); ok {
+			return bytecode, true
+		}
+	}
+
+	program, ok := parseFile(path, errOut)
+	if !ok {
+		return nil, false
+	}
+	optimize.Program(program, passes)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return nil, false
+	}
+	bytecode := comp.Bytecode()
+	if cache != nil {
+		cache.Store(path, source, bytecode)
+	}
+	return bytecode, true
+}
+
+// parseCachedFile is parseFile going through the cache when useCache is
+// set, for the tree walker. The cache holds the program as parsed, before
+// any pass changes it, so unlike compileFile it serves optimised runs too.
+func parseCachedFile(path string, useCache bool, errOut io.Writer) (*ast.Program, bool) {
+	cache, source := openCache(path, useCache)
+	if cache != nil {
+		if program, ok := cache.LoadProgram(path, source); ok {
+			return program, true
+		}
+	}
+
+	program, ok := parseFile(path, errOut)
+	if !ok {
+		return nil, false
+	}
+	if cache != nil {
+		cache.StoreProgram(path, source, program)
+	}
+	return program, true
+}
+
+// openCache returns the user's cache and the source of path, with a nil
+// cache when useCache is not set or either cannot be had
+func openCache(path string, useCache bool) (*gosc.Cache, []byte) {
+	if !useCache {
+		return nil, nil
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil
+	}
+	cache, err := gosc.DefaultCache()
+	if err != nil {
+		return nil, nil
+	}
+	return cache, source
+}
+
+// optimizeFlags adds a flag for each pass of the optimize package to fs
+func optimizeFlags(fs *flag.FlagSet) *optimize.Options {
+	opts := &optimize.Options{}
+	fs.BoolVar(&opts.Fold, "fold", false, "replace operators on constants with their results")
+	fs.BoolVar(&opts.DeadCode, "dead-code", false, "drop branches with constant conditions and statements after a return")
+	fs.BoolVar(&opts.Inline, "inline", false, "replace calls of small functions with constant arguments by their bodies")
+	return opts
+}
+
+// readCompiled reads a .gosc file
+func readCompiled(path string, errOut io.Writer) (*compiler.Bytecode, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return nil, false
+	}
+	defer f.Close()
+
+	bytecode, err := gosc.Read(f)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s: %s\n", path, err)
+		return nil, false
+	}
+	return bytecode, true
+}
+
+// parseFile lexes and parses a .gos file, printing any parse errors
+func parseFile(path string, errOut io.Writer) (*ast.Program, bool) {
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		return nil, false
+	}
+	l := lexer.LexFile(path)
+	if l == nil {
+		fmt.Fprintf(errOut, "%s is not a .gos file\n", path)
+		return nil, false
+	}
+
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(errOut, "\t%s\n", msg)
+		}
+		return nil, false
+	}
+	return program, true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"gosling/compiler"
+	"gosling/gosc"
+	"gosling/lexer"
+	"gosling/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A second run of a file that has not changed takes it from the cache,
+// which is seen here by swapping the entry for another program
+func TestRunCache(t *testing.T) {
+	// os.UserCacheDir reads XDG_CACHE_HOME on Linux and HOME elsewhere
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	cache, err := gosc.DefaultCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := parser.New(lexer.New(`"from the cache"`)).ParseProgram()
+	swapProgram := func(path string, source []byte) bool {
+		if _, ok := cache.LoadProgram(path, source); !ok {
+			return false
+		}
+		return cache.StoreProgram(path, source, cached) == nil
+	}
+
+	tests := []struct {
+		args []string
+		// swap replaces the entry the first run stored
+		swap func(path string, source []byte) bool
+	}{
+		{nil, swapProgram},
+		{[]string{"--fold"}, swapProgram},
+		{[]string{"--engine=vm"}, func(path string, source []byte) bool {
+			if _, ok := cache.Load(path, source); !ok {
+				return false
+			}
+			comp := compiler.New()
+			if err := comp.Compile(cached); err != nil {
+				t.Fatal(err)
+			}
+			return cache.Store(path, source, comp.Bytecode()) == nil
+		}},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(t.TempDir(), "a.gos")
+		source := []byte("1 + 2")
+		if err := os.WriteFile(path, source, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		runArgs := func(extra ...string) string {
+			var out, errOut bytes.Buffer
+			args := append(append(append([]string{}, tt.args...), extra...), path)
+			if code := run(args, &out, &errOut); code != 0 {
+				t.Fatalf("test %d: run %v exited with %d: %s", i, args, code, errOut.String())
+			}
+			return out.String()
+		}
+
+		if got := runArgs(); got != "3\n" {
+			t.Errorf("test %d: first run printed %q", i, got)
+		}
+		if !tt.swap(path, source) {
+			t.Errorf("test %d: the first run did not fill the cache", i)
+			continue
+		}
+		if got := runArgs(); got != "from the cache\n" {
+			t.Errorf("test %d: second run did not use the cache, printed %q", i, got)
+		}
+		if got := runArgs("--no-cache"); got != "3\n" {
+			t.Errorf("test %d: --no-cache printed %q", i, got)
+		}
+	}
+}
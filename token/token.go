@@ -9,14 +9,15 @@ type Token struct {
 }
 
 type TokenLocation struct {
-	Line     int
-	LineCh   int
-	Filename string
+	Line     int    `json:"line"`
+	LineCh   int    `json:"char"`
+	Filename string `json:"file"`
 }
 
 const (
 	ILLEGAL = "ILLEGAL" //any unknown
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // never returned by NextToken, see Lexer.Comments
 
 	// Identifiers + literals
 	IDENT  = "IDENT"  // add, foobar, x, y, ...
@@ -37,15 +38,21 @@ const (
 	LT = "<"
 	GT = ">"
 
+	ARROW = "->"
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
 
 	LPAREN = "("
 	RPAREN = ")"
 	LBRACE = "{"
 	RBRACE = "}"
 
+	LBRACKET = "["
+	RBRACKET = "]"
+
 	// Keywords
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
@@ -55,6 +62,7 @@ const (
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
 	FOR      = "FOR"
+	NONE     = "NONE"
 )
 
 var keywords = map[string]TokenType{
@@ -66,6 +74,7 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"for":    FOR,
+	"none":   NONE,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,920 @@
+package typecheck
+
+import (
+	"fmt"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/object"
+	"gosling/token"
+	"gosling/types"
+)
+
+// builtins gives the types of the evaluator's builtin functions
+var builtins = map[string]*types.BuiltinFunctionType{
+	"len":    {Name: "len", Signature: &types.FunctionType{Parameters: []types.Type{String}, Result: Integer}},
+	"some":   {Name: "some", Signature: &types.FunctionType{Parameters: []types.Type{Dynamic}, Result: &types.OptionType{ValueType: Dynamic}}},
+	"exists": {Name: "exists", Signature: &types.FunctionType{Parameters: []types.Type{Dynamic}, Result: Boolean}},
+}
+
+var (
+	Integer = &types.IntegerType{}
+	String  = &types.StringType{}
+	Boolean = &types.BooleanType{}
+	Dynamic = &types.DynamicType{}
+)
+
+type Config struct {
+	// Globals are names bound before the program runs, such as the
+	// bindings from earlier lines of a REPL session
+	Globals map[string]types.Type
+}
+
+// Info holds what the checker learned about a program
+type Info struct {
+	// Types maps every expression to its inferred type
+	Types map[ast.Expression]types.Type
+}
+
+type binding struct {
+	typ types.Type
+	// scheme is set for fixed lets, each use gets a fresh copy of its
+	// variables so a function like fn(x) { x } can be used at any type
+	scheme *types.Scheme
+	// fixed bindings are let once at the top of their scope and never
+	// assigned, so their type cannot change behind the checker's back
+	fixed bool
+}
+
+// scope is a program or a function body, matching the environments the
+// evaluator creates
+type scope struct {
+	outer    *scope
+	bindings map[string]*binding
+}
+
+// The checker infers function types Hindley-Milner style: each parameter
+// starts as a type variable and is unified with what the function does to
+// it. Since a program that runs without errors must never be rejected, a
+// parameter is only constrained by operations that run on every call of
+// its own function: not inside if or for blocks, not after a statement
+// that may return and not from a nested function, which might never be
+// called. Everything else sees the parameter as Dynamic.
+type checker struct {
+	info        *Info
+	diagnostics []diag.Diagnostic
+
+	// level is the let nesting depth, used to generalise let bound types
+	level int
+	// function identifies the function literal being checked, owner
+	// records which one introduced each parameter's variable
+	function  int
+	functions int
+	owner     map[*types.Variable]int
+	// called holds the function types inferred for parameters from the
+	// first call made of them
+	called map[*types.FunctionType]bool
+	// definite is set while the code being checked runs on every call of
+	// the current function
+	definite bool
+	// typeParameters maps the type parameters in scope to their types
+	typeParameters map[string]types.Type
+	// results collects the types of the current function's returns
+	results []result
+}
+
+type result struct {
+	typ  types.Type
+	node ast.Node
+}
+
+// Check infers a type for every expression in program and reports the
+// operations that are certain to fail when it runs. Anything that cannot
+// be inferred is Dynamic, so a program that can run without errors is
+// never rejected.
+func Check(program *ast.Program, config Config) (*Info, []diag.Diagnostic) {
+	c := &checker{
+		info:     &Info{Types: make(map[ast.Expression]types.Type)},
+		owner:    make(map[*types.Variable]int),
+		called:   make(map[*types.FunctionType]bool),
+		definite: true,
+	}
+
+	top := newScope(nil)
+	for name, typ := range config.Globals {
+		top.bindings[name] = &binding{typ: typ, fixed: true}
+	}
+	c.declare(top, program.Statements)
+	for _, stmt := range program.Statements {
+		c.checkStatement(top, stmt)
+	}
+
+	return c.info, c.diagnostics
+}
+
+// TypeOf returns the static type matching a run time value. Functions
+// take the type of their annotations, with Dynamic for the missing ones.
+func TypeOf(obj object.Object) types.Type {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.BigInt:
+		return Integer
+	case *object.String:
+		return String
+	case *object.Boolean:
+		return Boolean
+	case *object.Function:
+		fn := &types.FunctionType{Parameters: make([]types.Type, len(obj.Parameters)), Result: resolveOrDynamic(obj.ReturnType)}
+		for i, param := range obj.Parameters {
+			fn.Parameters[i] = resolveOrDynamic(param.Type)
+		}
+		return fn
+	case *object.CompiledFunction:
+		fn := &types.FunctionType{Parameters: make([]types.Type, len(obj.Parameters)), Result: resolveOrDynamic(obj.ReturnType)}
+		for i, param := range obj.Parameters {
+			fn.Parameters[i] = resolveOrDynamic(param.Type)
+		}
+		return fn
+	case *object.Closure:
+		return TypeOf(obj.Fn)
+	case *object.Builtin:
+		return &types.FunctionType{Parameters: []types.Type{Dynamic}, Result: Dynamic, Variadic: true}
+	case *object.Option:
+		if obj.Value == nil {
+			return &types.OptionType{ValueType: Dynamic}
+		}
+		return &types.OptionType{ValueType: TypeOf(obj.Value)}
+	default:
+		return Dynamic
+	}
+}
+
+// Resolve returns the type named by an annotation. Type parameters of
+// generic functions are Dynamic, as they are when the program runs.
+func Resolve(texp ast.TypeExpression) (types.Type, error) {
+	return resolve(texp, nil)
+}
+
+// resolve looks the type parameters in texp up in params
+func resolve(texp ast.TypeExpression, params map[string]types.Type) (types.Type, error) {
+	switch texp := texp.(type) {
+	case *ast.NamedType:
+		if texp.Parameter {
+			if t, ok := params[texp.Name]; ok {
+				return t, nil
+			}
+			return Dynamic, nil
+		}
+		args := make([]types.Type, len(texp.Arguments))
+		for i, arg := range texp.Arguments {
+			t, err := resolve(arg, params)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = t
+		}
+		var t types.Type
+		want := 0
+		switch texp.Name {
+		case "int":
+			t = Integer
+		case "string":
+			t = String
+		case "bool":
+			t = Boolean
+		case "dynamic":
+			t = Dynamic
+		case "Result":
+			want = 1
+			if len(args) == want {
+				t = &types.ResultType{ValueType: args[0]}
+			}
+		case "Option":
+			want = 1
+			if len(args) == want {
+				t = &types.OptionType{ValueType: args[0]}
+			}
+		default:
+			return nil, fmt.Errorf("unknown type: %s", texp.Name)
+		}
+		if len(args) != want {
+			return nil, fmt.Errorf("wrong number of type arguments for %s. got=%d, want=%d", texp.Name, len(args), want)
+		}
+		return t, nil
+	case *ast.ArrayType:
+		elem, err := resolve(texp.Element, params)
+		if err != nil {
+			return nil, err
+		}
+		return &types.ArrayType{ElementType: elem}, nil
+	case *ast.MapType:
+		key, err := resolve(texp.Key, params)
+		if err != nil {
+			return nil, err
+		}
+		value, err := resolve(texp.Value, params)
+		if err != nil {
+			return nil, err
+		}
+		return &types.MapType{KeyType: key, ValueType: value}, nil
+	case *ast.FunctionType:
+		fn := &types.FunctionType{Parameters: make([]types.Type, len(texp.Parameters)), Result: Dynamic}
+		for i, param := range texp.Parameters {
+			t, err := resolve(param, params)
+			if err != nil {
+				return nil, err
+			}
+			fn.Parameters[i] = t
+		}
+		if texp.Result != nil {
+			t, err := resolve(texp.Result, params)
+			if err != nil {
+				return nil, err
+			}
+			fn.Result = t
+		}
+		return fn, nil
+	}
+	return nil, fmt.Errorf("unknown type: %s", texp)
+}
+
+func resolveOrDynamic(texp ast.TypeExpression) types.Type {
+	if texp == nil {
+		return Dynamic
+	}
+	if t, err := Resolve(texp); err == nil {
+		return t
+	}
+	return Dynamic
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, bindings: make(map[string]*binding)}
+}
+
+func (s *scope) lookup(name string) (*binding, bool) {
+	for sc := s; sc != nil; sc = sc.outer {
+		if b, ok := sc.bindings[name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func (c *checker) report(code diag.Code, loc token.TokenLocation, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diag.New(code, loc, format, a...))
+}
+
+// declare binds every let of the scope as Dynamic before checking starts.
+// Only a name let once, directly in the scope's statements and never
+// assigned, may later take the type of its value. A let inside an if or
+// for block might not run, leaving an outer binding visible instead.
+func (c *checker) declare(s *scope, statements []ast.Statement) {
+	lets := map[string]int{}
+	unstable := map[string]bool{}
+	for _, stmt := range statements {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FunctionLiteral:
+				// assignments inside closures still change this scope
+				ast.Inspect(n.Body, func(inner ast.Node) bool {
+					if assign, ok := inner.(*ast.AssignExpression); ok {
+						unstable[assign.Name.Value] = true
+					}
+					return true
+				})
+				return false
+			case *ast.LetStatement:
+				lets[n.Name.Value]++
+				if ast.Statement(n) != stmt {
+					unstable[n.Name.Value] = true
+				}
+			case *ast.AssignExpression:
+				unstable[n.Name.Value] = true
+			}
+			return true
+		})
+	}
+
+	for name, count := range lets {
+		s.bindings[name] = &binding{typ: Dynamic, fixed: count == 1 && !unstable[name]}
+	}
+	// parameters and globals that are assigned can hold anything
+	for name := range unstable {
+		if b, ok := s.bindings[name]; ok && lets[name] == 0 {
+			b.typ, b.fixed = Dynamic, false
+		}
+	}
+}
+
+func (c *checker) checkStatement(s *scope, stmt ast.Statement) types.Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.level++
+		typ := c.checkExpression(s, stmt.Value)
+		c.level--
+		if stmt.Name.Type != nil {
+			annotated := c.resolve(stmt.Name.Type)
+			c.checkAnnotation(annotated, typ, stmt.Name.Location(), "let "+stmt.Name.Value)
+			typ = annotated
+		}
+		if b, ok := s.bindings[stmt.Name.Value]; ok && b.fixed {
+			// values never change once bound, so unlike ML every let can
+			// be generalised, not just function literals
+			b.typ = typ
+			b.scheme = types.Generalize(typ, c.level)
+		}
+		return Dynamic
+	case *ast.ReturnStatement:
+		typ := c.checkExpression(s, stmt.ReturnValue)
+		c.results = append(c.results, result{typ: typ, node: stmt})
+		return typ
+	case *ast.ExpressionStatement:
+		return c.checkExpression(s, stmt.Expression)
+	case *ast.BlockStatement:
+		return c.checkBlock(s, stmt)
+	}
+	return Dynamic
+}
+
+// checkBlock returns the type of the block's last expression
+func (c *checker) checkBlock(s *scope, block *ast.BlockStatement) types.Type {
+	definite := c.definite
+	var typ types.Type = Dynamic
+	for _, stmt := range block.Statements {
+		typ = c.checkStatement(s, stmt)
+		if mayReturn(stmt) {
+			c.definite = false
+		}
+	}
+	c.definite = definite
+	return typ
+}
+
+// checkBranch checks a block that may not run
+func (c *checker) checkBranch(s *scope, block *ast.BlockStatement) types.Type {
+	definite := c.definite
+	c.definite = false
+	typ := c.checkBlock(s, block)
+	c.definite = definite
+	return typ
+}
+
+func (c *checker) checkExpression(s *scope, exp ast.Expression) types.Type {
+	if exp == nil {
+		return Dynamic
+	}
+	typ := c.infer(s, exp)
+	c.info.Types[exp] = typ
+	return typ
+}
+
+func (c *checker) infer(s *scope, exp ast.Expression) types.Type {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return Integer
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Boolean
+	case *ast.NoneLiteral:
+		return &types.OptionType{ValueType: types.NewVariable(c.level)}
+	case *ast.Identifier:
+		if b, ok := s.lookup(exp.Value); ok {
+			if b.scheme != nil {
+				return b.scheme.Instantiate(c.level)
+			}
+			return b.typ
+		}
+		if builtin, ok := builtins[exp.Value]; ok {
+			return builtin
+		}
+		return Dynamic
+	case *ast.PrefixExpression:
+		right := c.checkExpression(s, exp.Right)
+		return c.checkPrefix(exp, right)
+	case *ast.InfixExpression:
+		left := c.checkExpression(s, exp.Left)
+		right := c.checkExpression(s, exp.Right)
+		return c.checkInfix(exp, left, right)
+	case *ast.AssignExpression:
+		return c.checkExpression(s, exp.Value)
+	case *ast.IfExpression:
+		condition := c.checkExpression(s, exp.Condition)
+		var consequence types.Type
+		if exp.Binding != nil {
+			consequence = c.checkIfLet(s, exp, condition)
+		} else {
+			consequence = c.checkBranch(s, exp.Consequence)
+		}
+		if exp.Alternative == nil {
+			return Dynamic
+		}
+		alternative := c.checkBranch(s, exp.Alternative)
+		return c.join([]types.Type{consequence, alternative})
+	case *ast.ForExpression:
+		c.checkExpression(s, exp.Condition)
+		c.checkBranch(s, exp.Body)
+		return Dynamic
+	case *ast.FunctionLiteral:
+		return c.checkFunction(s, exp)
+	case *ast.CallExpression:
+		return c.checkCall(s, exp)
+	}
+	return Dynamic
+}
+
+// checkIfLet checks the consequence of an if let with the binding narrowed
+// to the value inside the option
+func (c *checker) checkIfLet(s *scope, ie *ast.IfExpression, condition types.Type) types.Type {
+	// the value's variable belongs to this function like a parameter's, so
+	// the consequence, which may not run, cannot constrain it
+	value := types.NewVariable(c.level)
+	c.owner[value] = c.function
+	option := c.expect(condition, &types.OptionType{ValueType: value})
+
+	var typ types.Type = Dynamic
+	switch option := option.(type) {
+	case *types.OptionType:
+		typ = option.ValueType
+	case *types.DynamicType, *types.Variable, *types.TypeParameter:
+	default:
+		c.report(diag.TypeMismatch, ie.Condition.Location(), "cannot use %s as an option for if let %s", option.Kind(), ie.Binding.Value)
+	}
+	if ie.Binding.Type != nil {
+		annotated := c.resolve(ie.Binding.Type)
+		c.checkAnnotation(annotated, typ, ie.Binding.Location(), "if let "+ie.Binding.Value)
+		typ = annotated
+	}
+	if assigns(ie.Consequence, ie.Binding.Value) {
+		typ = Dynamic
+	}
+
+	inner := newScope(s)
+	inner.bindings[ie.Binding.Value] = &binding{typ: typ}
+	return c.checkBranch(inner, ie.Consequence)
+}
+
+// assigns reports whether node, or a function inside it, assigns to name
+func assigns(node ast.Node, name string) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if assign, ok := n.(*ast.AssignExpression); ok && assign.Name.Value == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func (c *checker) checkFunction(s *scope, fn *ast.FunctionLiteral) types.Type {
+	function, definite, results, typeParameters := c.function, c.definite, c.results, c.typeParameters
+	c.functions++
+	c.function, c.definite, c.results = c.functions, true, nil
+
+	// inside the body a type parameter only matches itself
+	declared := make([]*types.TypeParameter, len(fn.TypeParameters))
+	if len(fn.TypeParameters) > 0 {
+		c.typeParameters = make(map[string]types.Type, len(typeParameters)+len(fn.TypeParameters))
+		for name, t := range typeParameters {
+			c.typeParameters[name] = t
+		}
+		for i, tp := range fn.TypeParameters {
+			for _, other := range fn.TypeParameters[:i] {
+				if other.Value == tp.Value {
+					c.report(diag.DuplicateTypeParameter, tp.Location(), "type parameter %s is declared twice", tp.Value)
+					break
+				}
+			}
+			declared[i] = &types.TypeParameter{Name: tp.Value}
+			c.typeParameters[tp.Value] = declared[i]
+		}
+	}
+
+	fnScope := newScope(s)
+	params := make([]types.Type, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		if param.Type != nil {
+			params[i] = c.resolve(param.Type)
+		} else {
+			v := types.NewVariable(c.level)
+			c.owner[v] = c.function
+			params[i] = v
+		}
+		fnScope.bindings[param.Value] = &binding{typ: params[i]}
+	}
+	c.declare(fnScope, fn.Body.Statements)
+	last := c.checkBlock(fnScope, fn.Body)
+	if len(fn.Body.Statements) > 0 {
+		lastStmt := fn.Body.Statements[len(fn.Body.Statements)-1]
+		if _, ok := lastStmt.(*ast.ReturnStatement); !ok {
+			c.results = append(c.results, result{typ: last, node: lastStmt})
+		}
+	}
+
+	var typ types.Type
+	if fn.ReturnType != nil {
+		typ = c.resolve(fn.ReturnType)
+		for _, r := range c.results {
+			c.checkAnnotation(typ, r.typ, r.node.Location(), "the result")
+		}
+	} else {
+		ts := []types.Type{Dynamic}
+		if len(c.results) > 0 {
+			ts = ts[:0]
+			for _, r := range c.results {
+				ts = append(ts, r.typ)
+			}
+		}
+		typ = c.join(ts)
+	}
+
+	c.function, c.definite, c.results, c.typeParameters = function, definite, results, typeParameters
+
+	var fnType types.Type = &types.FunctionType{Parameters: params, Result: typ}
+	if len(declared) > 0 {
+		// callers see variables instead, so every call can infer its own
+		subst := make(map[types.Type]types.Type, len(declared))
+		for _, tp := range declared {
+			v := types.NewVariable(c.level + 1)
+			v.Name = tp.Name
+			subst[tp] = v
+		}
+		fnType = types.Substitute(fnType, subst)
+	}
+	return fnType
+}
+
+// resolve returns the type of an annotation, reporting unknown names
+func (c *checker) resolve(texp ast.TypeExpression) types.Type {
+	t, err := resolve(texp, c.typeParameters)
+	if err != nil {
+		c.report(diag.UnknownType, texp.Location(), "%s", err)
+		return Dynamic
+	}
+	return t
+}
+
+// checkAnnotation reports a value whose type cannot be the annotated one.
+// A parameter used as the value takes the annotated type, as the run time
+// check would fail for anything else.
+func (c *checker) checkAnnotation(want, got types.Type, loc token.TokenLocation, what string) {
+	if types.IsDynamic(want) {
+		return
+	}
+	got = c.expect(got, want)
+	if tp, ok := types.Prune(got).(*types.TypeParameter); ok {
+		// whatever a call makes it, a type parameter only matches itself
+		if !tp.IsAssignableTo(want) {
+			c.report(diag.TypeMismatch, loc, "cannot use %s as %s for %s", tp, want, what)
+		}
+		return
+	}
+	if !unknown(got) && !got.IsAssignableTo(want) {
+		c.report(diag.TypeMismatch, loc, "cannot use %s as %s for %s", got.Kind(), want, what)
+	}
+}
+
+// join is the type shared by all of ts, or Dynamic if they differ. Types
+// with free variables, such as the Option<t> of a none, take the shape of
+// the others, parameters' variables are left alone.
+func (c *checker) join(ts []types.Type) types.Type {
+	for _, t := range ts[1:] {
+		if t.IsType(ts[0]) {
+			continue
+		}
+		if !c.free(t) || !c.free(ts[0]) || types.Unify(t, ts[0]) != nil {
+			return Dynamic
+		}
+	}
+	return types.Prune(ts[0])
+}
+
+// free reports whether every unbound variable in t may be bound by join
+func (c *checker) free(t types.Type) bool {
+	free := true
+	types.Walk(t, func(t types.Type) {
+		if v, ok := t.(*types.Variable); ok {
+			if _, owned := c.owner[v]; owned || v.Name != "" {
+				free = false
+			}
+		}
+	})
+	return free
+}
+
+// mayReturn reports whether stmt contains a return of the current function
+func mayReturn(stmt ast.Statement) bool {
+	found := false
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.ReturnStatement:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// unknown reports whether t may still turn out to be any type. A type
+// parameter counts, its operations are only known at the call.
+func unknown(t types.Type) bool {
+	switch types.Prune(t).(type) {
+	case *types.DynamicType, *types.Variable, *types.TypeParameter:
+		return true
+	}
+	return false
+}
+
+// constrainable reports whether the checker may decide what v stands for.
+// Variables of the current function's parameters are only constrained by
+// code that runs on every call, fresh copies made for a single use of a
+// let bound name can always be.
+func (c *checker) constrainable(v *types.Variable) bool {
+	owner, ok := c.owner[v]
+	if !ok {
+		return true
+	}
+	return owner == c.function && c.definite
+}
+
+// expect records that t must be want for the operation being checked to
+// succeed, and returns what t is now known to be
+func (c *checker) expect(t, want types.Type) types.Type {
+	if v, ok := types.Prune(t).(*types.Variable); ok && c.constrainable(v) {
+		types.Unify(v, want)
+	}
+	return types.Prune(t)
+}
+
+// shield hides the variables the checker may not constrain behind Dynamic
+// before t is unified with a function's parameter
+func (c *checker) shield(t types.Type) types.Type {
+	switch t := types.Prune(t).(type) {
+	case *types.Variable:
+		if c.constrainable(t) {
+			return t
+		}
+		return Dynamic
+	case *types.ArrayType:
+		return &types.ArrayType{ElementType: c.shield(t.ElementType)}
+	case *types.OptionType:
+		return &types.OptionType{ValueType: c.shield(t.ValueType)}
+	case *types.FunctionType:
+		params := make([]types.Type, len(t.Parameters))
+		for i, p := range t.Parameters {
+			params[i] = c.shield(p)
+		}
+		return &types.FunctionType{Parameters: params, Result: c.shield(t.Result), Variadic: t.Variadic}
+	default:
+		return t
+	}
+}
+
+func (c *checker) checkPrefix(pe *ast.PrefixExpression, right types.Type) types.Type {
+	switch pe.Operator {
+	case "!":
+		if right.IsType(Integer) || right.IsType(String) {
+			c.report(diag.UnknownOperator, pe.Location(), "unknown operator: !%s", right.Kind())
+		}
+		return Boolean
+	case "-":
+		if option, ok := types.Prune(right).(*types.OptionType); ok {
+			c.reportOption(pe.Location(), option, pe.Operator)
+			return Integer
+		}
+		right = c.expect(right, Integer)
+		if !unknown(right) && !right.IsType(Integer) {
+			c.report(diag.UnknownOperator, pe.Location(), "unknown operator: -%s", right.Kind())
+		}
+		return Integer
+	}
+	return Dynamic
+}
+
+// checkInfix follows the same rules as the evaluator's evalInfixExpression
+func (c *checker) checkInfix(ie *ast.InfixExpression, left, right types.Type) types.Type {
+	op := ie.Operator
+	if op == "==" || op == "!=" {
+		return Boolean
+	}
+	for _, operand := range []types.Type{left, right} {
+		if option, ok := types.Prune(operand).(*types.OptionType); ok {
+			c.reportOption(ie.Location(), option, op)
+			return Dynamic
+		}
+	}
+
+	switch op {
+	case "-", "*", "/", "%", "<", ">":
+		left, right = c.expect(left, Integer), c.expect(right, Integer)
+	case "+":
+		// both sides must be integers or both strings
+		switch {
+		case unknown(left) && (right.IsType(Integer) || right.IsType(String)):
+			left = c.expect(left, right)
+		case unknown(right) && (left.IsType(Integer) || left.IsType(String)):
+			right = c.expect(right, left)
+		case unknown(left) && unknown(right):
+			left, right = c.expect(left, right), c.expect(right, left)
+			if left.IsType(right) {
+				return left
+			}
+		}
+	}
+
+	if unknown(left) || unknown(right) {
+		switch op {
+		case "-", "*", "/", "%":
+			return Integer
+		case "<", ">":
+			return Boolean
+		}
+		return Dynamic
+	}
+
+	switch {
+	case left.IsType(Integer) && right.IsType(Integer):
+		switch op {
+		case "+", "-", "*", "/", "%":
+			return Integer
+		case "<", ">":
+			return Boolean
+		}
+	case left.IsType(String) && right.IsType(String):
+		if op == "+" {
+			return String
+		}
+	}
+
+	c.report(diag.UnknownOperator, ie.Location(), "unknown operator: %s %s %s", left.Kind(), op, right.Kind())
+	return Dynamic
+}
+
+func (c *checker) reportOption(loc token.TokenLocation, option *types.OptionType, op string) {
+	what := "an option"
+	if !unknown(option.ValueType) {
+		what = option.String()
+	}
+	c.report(diag.UnwrappedOption, loc, "cannot use %s as an operand of %s, unwrap it with if let first", what, op)
+}
+
+func (c *checker) checkCall(s *scope, ce *ast.CallExpression) types.Type {
+	callee := c.checkExpression(s, ce.Function)
+	args := make([]types.Type, len(ce.Arguments))
+	for i, arg := range ce.Arguments {
+		args[i] = c.checkExpression(s, arg)
+	}
+
+	switch fn := types.Prune(callee).(type) {
+	case *types.IntegerType, *types.StringType, *types.BooleanType:
+		c.report(diag.NotAFunction, ce.Location(), "not a function: %s", callee.Kind())
+	case *types.BuiltinFunctionType:
+		return c.checkBuiltinCall(ce, fn, args)
+	case *types.FunctionType:
+		if c.called[fn] {
+			return c.callAgain(fn, args)
+		}
+		return c.checkArguments(ce, fn, args)
+	case *types.Variable:
+		// a parameter that is called is a function taking arguments like
+		// these, whose result is for the rest of the body to find out
+		want := &types.FunctionType{Parameters: make([]types.Type, len(args)), Result: types.NewVariable(c.level)}
+		for i, arg := range args {
+			want.Parameters[i] = c.shield(arg)
+		}
+		c.owner[want.Result.(*types.Variable)] = c.function
+		if fn, ok := c.expect(fn, want).(*types.FunctionType); ok {
+			c.called[fn] = true
+			return fn.Result
+		}
+	}
+	return Dynamic
+}
+
+// callAgain checks another call of a parameter whose type its first call
+// inferred. The argument may be generic, as fn(v) { v } is, so arguments
+// unlike those of the first call make the parameters they are passed to
+// dynamic rather than being reported, and the call's result dynamic.
+func (c *checker) callAgain(fn *types.FunctionType, args []types.Type) types.Type {
+	same := len(args) == len(fn.Parameters)
+	for i, arg := range args {
+		if i == len(fn.Parameters) {
+			break
+		}
+		if !unknown(fn.Parameters[i]) && types.Unify(fn.Parameters[i], c.shield(arg)) != nil {
+			fn.Parameters[i] = Dynamic
+			same = false
+		}
+	}
+	if !same {
+		return Dynamic
+	}
+	return fn.Result
+}
+
+// checkArguments unifies each argument with its parameter. Extra arguments
+// are ignored when a function runs, missing ones are an error.
+func (c *checker) checkArguments(ce *ast.CallExpression, fn *types.FunctionType, args []types.Type) types.Type {
+	if len(args) < fn.Required() {
+		c.report(diag.WrongArgumentCount, ce.Location(), "wrong number of arguments. got=%d, want=%d", len(args), fn.Required())
+		return Dynamic
+	}
+	// the type parameters of a generic function, by the arguments they
+	// appear in, collected before unification binds them
+	typeParameters := make([][]*types.Variable, len(args))
+	inArguments := map[*types.Variable]bool{}
+	for i := range args {
+		if param := fn.Parameter(i); param != nil {
+			typeParameters[i] = typeParametersIn(param)
+			for _, v := range typeParameters[i] {
+				inArguments[v] = true
+			}
+		}
+	}
+
+	inferredFrom := map[*types.Variable]int{}
+	for i, arg := range args {
+		param := fn.Parameter(i)
+		if param == nil {
+			break
+		}
+		if err := types.Unify(ignoringExtra(param, arg), c.shield(arg)); err != nil {
+			c.reportArgument(ce, i, arg, param, typeParameters[i], inferredFrom)
+			continue
+		}
+		for _, v := range typeParameters[i] {
+			if _, ok := inferredFrom[v]; !ok && v.Instance != nil {
+				inferredFrom[v] = i
+			}
+		}
+	}
+
+	for _, v := range typeParametersIn(fn.Result) {
+		if !inArguments[v] {
+			c.report(diag.CannotInfer, ce.Location(), "cannot infer %s: it does not appear in the parameters", v.Name)
+			inArguments[v] = true
+		}
+	}
+	return fn.Result
+}
+
+// reportArgument explains why argument i does not fit its parameter. If a
+// type parameter was already inferred from an earlier argument the two
+// arguments disagree, which is reported as such.
+func (c *checker) reportArgument(ce *ast.CallExpression, i int, arg, param types.Type, typeParameters []*types.Variable, inferredFrom map[*types.Variable]int) {
+	for _, v := range typeParameters {
+		if j, ok := inferredFrom[v]; ok {
+			c.report(diag.CannotInfer, ce.Arguments[i].Location(), "cannot infer %s: argument %d is %s but argument %d makes it %s", v.Name, i+1, arg, j+1, types.Prune(v))
+			return
+		}
+	}
+	c.report(diag.ArgumentTypeMismatch, ce.Arguments[i].Location(), "cannot use %s as %s in argument %d", arg, param, i+1)
+}
+
+// ignoringExtra returns param without the parameters that arg, a function
+// passed for it, does not have: a function ignores the arguments it is
+// called with beyond its parameters
+func ignoringExtra(param, arg types.Type) types.Type {
+	p, ok := types.Prune(param).(*types.FunctionType)
+	a, isFn := types.Prune(arg).(*types.FunctionType)
+	if !ok || !isFn || p.Variadic || a.Variadic || len(a.Parameters) >= len(p.Parameters) {
+		return param
+	}
+	return &types.FunctionType{Parameters: p.Parameters[:len(a.Parameters)], Result: p.Result}
+}
+
+// typeParametersIn returns the unbound variables standing for the type
+// parameters of a generic function
+func typeParametersIn(t types.Type) []*types.Variable {
+	found := []*types.Variable{}
+	types.Walk(t, func(t types.Type) {
+		if v, ok := t.(*types.Variable); ok && v.Name != "" {
+			found = append(found, v)
+		}
+	})
+	return found
+}
+
+// checkBuiltinCall reports the errors the builtin itself would return
+func (c *checker) checkBuiltinCall(ce *ast.CallExpression, fn *types.BuiltinFunctionType, args []types.Type) types.Type {
+	switch fn.Name {
+	case "len":
+		if len(args) != 1 {
+			c.report(diag.WrongArgumentCount, ce.Location(), "wrong number of arguments. got=%d, want=1", len(args))
+		} else if arg := c.expect(args[0], String); !unknown(arg) && !arg.IsType(String) {
+			c.report(diag.UnsupportedArgument, ce.Arguments[0].Location(), "argument to `len` not supported, got %s", arg.Kind())
+		}
+	case "some":
+		if len(args) != 1 {
+			c.report(diag.WrongArgumentCount, ce.Location(), "wrong number of arguments. got=%d, want=1", len(args))
+			break
+		}
+		return &types.OptionType{ValueType: c.shield(args[0])}
+	case "exists":
+		if len(args) != 1 {
+			c.report(diag.WrongArgumentCount, ce.Location(), "wrong number of arguments. got=%d, want=1", len(args))
+		}
+	}
+	return fn.Signature.Result
+}
@@ -0,0 +1,262 @@
+package typecheck
+
+import (
+	"fmt"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/lexer"
+	"gosling/parser"
+	"gosling/types"
+	"regexp"
+	"testing"
+)
+
+func TestInference(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5", "int"},
+		{`"a"`, "string"},
+		{"true", "bool"},
+		{"-5", "int"},
+		{"!true", "bool"},
+		{"1 + 2 * 3", "int"},
+		{`"a" + "b"`, "string"},
+		{"1 < 2", "bool"},
+		{`"a" == 1`, "bool"},
+		{"let x = 5; x", "int"},
+		{"let x = 5; x = 6; x", "dynamic"},
+		{"if (true) { 1 } else { 2 }", "int"},
+		{`if (true) { 1 } else { "a" }`, "dynamic"},
+		{"if (true) { 1 }", "dynamic"},
+		{`len("abc")`, "int"},
+		{"len", "builtin len fn(string) -> int"},
+		{"fn(x) { x }", "fn(a) -> a"},
+		{"fn(x) { x }(1)", "int"},
+		{"let f = fn(x) { x }; f(1) * 2", "int"},
+		{"fn(x) { x - 1 }", "fn(int) -> int"},
+		{`fn(x) { x + "a" }`, "fn(string) -> string"},
+		{"fn(x, y) { x + y }", "fn(a, a) -> a"},
+		{"fn(x) { len(x) }", "fn(string) -> int"},
+		{"fn(x) { if (x > 0) { return 1; } 2 }", "fn(int) -> int"},
+		{`fn(x) { if (x > 0) { return 1; } "a" }`, "fn(int) -> dynamic"},
+		{"fn(c, x) { if (c) { x - 1 } else { 0 } }", "fn(a, b) -> int"},
+		{"fn(x) { x = 1; x }", "fn(a) -> dynamic"},
+		{"fn(x) { fn(y) { x + y } }", "fn(a) -> fn(a) -> a"},
+		{"fn(x) { fn() { x - 1 } }", "fn(a) -> fn() -> int"},
+		{"let newAdder = fn(x) { fn(y) { x + y } }; newAdder(1)", "fn(int) -> int"},
+		{`let id = fn(x) { x }; id(1); id("a")`, "string"},
+		{"let apply = fn(f, x) { f(x) }; apply", "fn(fn(a) -> b, a) -> b"},
+		{"fn(f) { f(1) + 1 }", "fn(fn(int) -> int) -> int"},
+		{"let twice = fn(f, x) { f(f(x)) }; twice(fn(n) { n * 2 }, 1)", "int"},
+		{"fn(a: int, b) -> string { b }", "fn(int, string) -> string"},
+		{"let x: dynamic = 5; x", "dynamic"},
+		{"fn(f: fn(int) -> bool) { f(1) }", "fn(fn(int) -> bool) -> bool"},
+		{"fn(x) { let y: int = x; y }", "fn(int) -> int"},
+		{"fn<T>(x: T) -> T { x }", "fn(T) -> T"},
+		{"some(1)", "Option<int>"},
+		{"exists(none)", "bool"},
+		{"if (true) { some(1) } else { none }", "Option<int>"},
+		{`if (true) { none } else { some("a") }`, "Option<string>"},
+		{"let find = fn(n) { if (n > 0) { some(n) } else { none } }; find", "fn(int) -> Option<int>"},
+		{"if let v = some(1) { v * 2 } else { 0 }", "int"},
+		{"fn(o) { if let v = o { v } else { 0 } }", "fn(Option<a>) -> dynamic"},
+		{"fn(o) { if let v = o { v + 1 } }(some(1))", "dynamic"},
+		{"let x: Option<int> = none; x", "Option<int>"},
+		{"let f = fn(o) { if let v = o { v } }; f", "fn(Option<a>) -> dynamic"},
+		{"let id = fn<T>(x: T) -> T { x }; id(1) * 2", "int"},
+		{`let id = fn<T>(x: T) -> T { x }; id(1); id("a")`, "string"},
+		{"let apply = fn<A, B>(f: fn(A) -> B, x: A) -> B { f(x) }; apply(fn(n) { n * 2 }, 3)", "int"},
+		{"fn<T>(x: T) { fn<U>(y: U) -> fn(T) -> U { fn(z: T) -> U { y } } }", "fn(T) -> fn(U) -> fn(T) -> U"},
+		{"fn<K, V>(m: {K: V}, k: K) -> {K: V} { m }", "fn({K: V}, K) -> {K: V}"},
+	}
+
+	for _, tt := range tests {
+		program, info, diagnostics := testCheck(t, tt.input, Config{})
+		if len(diagnostics) != 0 {
+			t.Errorf("unexpected diagnostics for %q: %v", tt.input, diagnostics)
+			continue
+		}
+
+		last := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		typ, ok := info.Types[last.Expression]
+		if !ok {
+			t.Errorf("no type recorded for %q", tt.input)
+			continue
+		}
+		if got := normalize(typ.String()); got != tt.expected {
+			t.Errorf("wrong type for %q. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected diag.Code
+		message  string
+	}{
+		{`1 + "a"`, diag.UnknownOperator, "unknown operator: INTEGER + STRING"},
+		{`let x = "a"; x - 1`, diag.UnknownOperator, "unknown operator: STRING - INTEGER"},
+		{"true + false", diag.UnknownOperator, "unknown operator: BOOLEAN + BOOLEAN"},
+		{"-true", diag.UnknownOperator, "unknown operator: -BOOLEAN"},
+		{"!5", diag.UnknownOperator, "unknown operator: !INTEGER"},
+		{"let x = 5; x(1)", diag.NotAFunction, "not a function: INTEGER"},
+		{`"a"()`, diag.NotAFunction, "not a function: STRING"},
+		{"len(1)", diag.UnsupportedArgument, "argument to `len` not supported, got INTEGER"},
+		{`len("a", "b")`, diag.WrongArgumentCount, "wrong number of arguments. got=2, want=1"},
+		{`let f = fn() { 1 + "a" }`, diag.UnknownOperator, "unknown operator: INTEGER + STRING"},
+		{`let dec = fn(x) { x - 1 }; dec("a")`, diag.ArgumentTypeMismatch, "cannot use string as int in argument 1"},
+		{`let f = fn(x) { len(x) }; f(1)`, diag.ArgumentTypeMismatch, "cannot use int as string in argument 1"},
+		{`let newAdder = fn(x) { fn(y) { x + y } }; newAdder(1)("a")`, diag.ArgumentTypeMismatch, "cannot use string as int in argument 1"},
+		{`let f = fn(x) { x - 1 }; f(1) + "a"`, diag.UnknownOperator, "unknown operator: INTEGER + STRING"},
+		{"let f = fn(a, b) { a }; f(1)", diag.WrongArgumentCount, "wrong number of arguments. got=1, want=2"},
+		{`let x: int = "a"`, diag.TypeMismatch, "cannot use STRING as int for let x"},
+		{`fn(a) -> string { 1 }`, diag.TypeMismatch, "cannot use INTEGER as string for the result"},
+		{`fn(a) -> int { if (a) { return "a"; } 1 }`, diag.TypeMismatch, "cannot use STRING as int for the result"},
+		{`fn(a: string) { a - 1 }`, diag.UnknownOperator, "unknown operator: STRING - INTEGER"},
+		{`let f = fn(a: int) { a }; f("a")`, diag.ArgumentTypeMismatch, "cannot use string as int in argument 1"},
+		{"let f: fn(int) -> int = 5", diag.TypeMismatch, "cannot use INTEGER as fn(int) -> int for let f"},
+		{"let x: integer = 5", diag.UnknownType, "unknown type: integer"},
+		{"none + 1", diag.UnwrappedOption, "cannot use an option as an operand of +, unwrap it with if let first"},
+		{"some(1) * 2", diag.UnwrappedOption, "cannot use Option<int> as an operand of *, unwrap it with if let first"},
+		{"-some(1)", diag.UnwrappedOption, "cannot use Option<int> as an operand of -, unwrap it with if let first"},
+		{`if let v = some("a") { v - 1 }`, diag.UnknownOperator, "unknown operator: STRING - INTEGER"},
+		{"if let v = 5 { v }", diag.TypeMismatch, "cannot use INTEGER as an option for if let v"},
+		{`if let v: int = some("a") { v }`, diag.TypeMismatch, "cannot use STRING as int for if let v"},
+		{"let f = fn(o: Option<int>) { o }; f(1)", diag.ArgumentTypeMismatch, "cannot use int as Option<int> in argument 1"},
+		{"some()", diag.WrongArgumentCount, "wrong number of arguments. got=0, want=1"},
+		{`let pick = fn<T>(a: T, b: T) -> T { a }; pick(1, "a")`, diag.CannotInfer, "cannot infer T: argument 2 is string but argument 1 makes it int"},
+		{"let make = fn<T>() -> T { make() }; make()", diag.CannotInfer, "cannot infer T: it does not appear in the parameters"},
+		{"fn<T>(x: T) -> T { 1 }", diag.TypeMismatch, "cannot use INTEGER as T for the result"},
+		{"fn<T>(x: T) -> int { x }", diag.TypeMismatch, "cannot use T as int for the result"},
+		{"fn<T>(x: T) -> int { if (true) { return x; } 1 }", diag.TypeMismatch, "cannot use T as int for the result"},
+		{"fn<T, U>(x: T) -> U { x }", diag.TypeMismatch, "cannot use T as U for the result"},
+		{"fn<T>(x: T) { let y: string = x; y }", diag.TypeMismatch, "cannot use T as string for let y"},
+		{"fn<T, T>(x: T) -> T { x }", diag.DuplicateTypeParameter, "type parameter T is declared twice"},
+		{"fn<A, B, A>(x: A) -> A { x }", diag.DuplicateTypeParameter, "type parameter A is declared twice"},
+		{"let f = fn<T>(xs: [T]) { xs }; f(1)", diag.ArgumentTypeMismatch, "cannot use int as [T] in argument 1"},
+		{"let x: Result<int, int> = 1", diag.UnknownType, "wrong number of type arguments for Result. got=2, want=1"},
+		{"let x: int<string> = 1", diag.UnknownType, "wrong number of type arguments for int. got=1, want=0"},
+		{"let k = fn(x) { x(1) }; k(2);", diag.ArgumentTypeMismatch, "cannot use int as fn(int) -> a in argument 1"},
+		{`let c = fn(f) { f(1) + 1 }; c(fn(s) { s + "a" });`, diag.ArgumentTypeMismatch, "cannot use fn(string) -> string as fn(int) -> int in argument 1"},
+	}
+
+	for _, tt := range tests {
+		_, _, diagnostics := testCheck(t, tt.input, Config{})
+		if len(diagnostics) != 1 {
+			t.Errorf("expected one diagnostic for %q, got=%v", tt.input, diagnostics)
+			continue
+		}
+		if diagnostics[0].Code != tt.expected || normalize(diagnostics[0].Message) != tt.message {
+			t.Errorf("wrong diagnostic for %q. want=[%s] %s, got=%s", tt.input, tt.expected, tt.message, diagnostics[0])
+		}
+	}
+}
+
+// Programs that run without errors must never be rejected
+func TestValidPrograms(t *testing.T) {
+	tests := []string{
+		"let x = 5; x = \"a\"; x + \"b\"",
+		"let x = 5; let f = fn() { x = \"a\" }; f(); x + \"b\"",
+		"let y = \"a\"; let f = fn(c) { if (c) { let y = 1; } y + \"b\" }; f(false)",
+		"let x = 1; let x = \"a\"; x + \"b\"",
+		"let f = fn() { g() + 1 }; let g = fn() { 1 }; f()",
+		"let newAdder = fn(x) { fn(y) { x + y } }; newAdder(\"a\")(\"b\")",
+		"let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(3)",
+		"let f = fn(c, x) { if (c) { return 0; } x - 1 }; f(true, \"a\")",
+		"let f = fn(c, x) { if (c) { x - 1 } else { 0 } }; f(false, \"a\")",
+		"let f = fn(x) { let g = fn() { x - 1 }; x + \"a\" }; f(\"b\")",
+		"let f = fn(x) { x = \"a\"; x + \"b\" }; f(1)",
+		"let id = fn(x) { x }; id(1); id(\"a\")",
+		"let pick = fn(x) { x }; let g = pick(fn(y) { y }); g(1); g(\"a\")",
+		"let f = fn(h) { h(1); h(\"a\") }; f(fn(v) { v })",
+		"let f = fn(h) { h(1) }; f(fn() { 1 })",
+		"let twice = fn(f, x) { f(f(x)) }; twice(fn(s) { s + \"a\" }, \"b\")",
+		"let f = fn(x) { x }; f(1, 2)",
+		"let f = fn(x: dynamic) -> dynamic { x }; f(1); f(\"a\")",
+		"let f = fn() -> int { let x = 1; x }; f()",
+		"let f = fn<T>(x: T) { x + 1 }; f(1)",
+		"none == none",
+		"let f = fn(o) { if let v = o { if (true) { v + 1 } else { len(v) } } }; f(some(1)); f(none)",
+		`let f = fn(o) { if let v = o { v = "a"; len(v) } }; f(some(1))`,
+		"let f = fn(o) { if let v = o { v } }; f(if (false) { 1 })",
+		"let id = fn<T>(x: T) -> T { x }; id(fn(n) { n })(1)",
+	}
+
+	for _, input := range tests {
+		_, _, diagnostics := testCheck(t, input, Config{})
+		if len(diagnostics) != 0 {
+			t.Errorf("valid program %q rejected: %v", input, diagnostics)
+		}
+	}
+}
+
+// Generic calls infer their type parameters from container arguments
+func TestGenericContainers(t *testing.T) {
+	globals := map[string]types.Type{
+		"xs": &types.ArrayType{ElementType: Integer},
+		"m":  &types.MapType{KeyType: String, ValueType: Boolean},
+		"r":  &types.ResultType{ValueType: String},
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let first = fn<T>(xs: [T], fallback: T) -> T { fallback }; first(xs, 0)", "int"},
+		{"let get = fn<K, V>(m: {K: V}, k: K, fallback: V) -> V { fallback }; get(m, \"a\", true)", "bool"},
+		{"let zip = fn<A, B>(a: [A], b: [B]) -> {A: B} { zip(a, b) }; zip(xs, xs)", "{int: int}"},
+		{"let unwrap = fn<T>(r: Result<T>, fallback: T) -> T { fallback }; unwrap(r, \"\")", "string"},
+	}
+
+	for _, tt := range tests {
+		program, info, diagnostics := testCheck(t, tt.input, Config{Globals: globals})
+		if len(diagnostics) != 0 {
+			t.Errorf("unexpected diagnostics for %q: %v", tt.input, diagnostics)
+			continue
+		}
+		last := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		if got := info.Types[last.Expression].String(); got != tt.expected {
+			t.Errorf("wrong type for %q. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+
+	_, _, diagnostics := testCheck(t, "let get = fn<K, V>(m: {K: V}, k: K) { k }; get(m, 1)", Config{Globals: globals})
+	if len(diagnostics) != 1 || diagnostics[0].Message != "cannot infer K: argument 2 is int but argument 1 makes it string" {
+		t.Errorf("wrong diagnostics for map key mismatch: %v", diagnostics)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	config := Config{Globals: map[string]types.Type{"x": String}}
+	_, _, diagnostics := testCheck(t, "x * 2", config)
+	if len(diagnostics) != 1 || diagnostics[0].Code != diag.UnknownOperator {
+		t.Errorf("expected unknown operator for global, got=%v", diagnostics)
+	}
+}
+
+func testCheck(t *testing.T, input string, config Config) (*ast.Program, *Info, []diag.Diagnostic) {
+	t.Helper()
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	info, diagnostics := Check(program, config)
+	return program, info, diagnostics
+}
+
+var variableName = regexp.MustCompile(`\bt[0-9]+\b`)
+
+// normalize renames type variables a, b, ... in order of appearance
+func normalize(typ string) string {
+	names := map[string]string{}
+	return variableName.ReplaceAllStringFunc(typ, func(v string) string {
+		if _, ok := names[v]; !ok {
+			names[v] = fmt.Sprintf("%c", 'a'+len(names))
+		}
+		return names[v]
+	})
+}
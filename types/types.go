@@ -1,67 +1,86 @@
 package types
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Type interface {
 	IsType(Type) bool
 	IsAssignableTo(Type) bool
-	Kind() string // "INTEGER", "STRING", "BOOLEAN", "ARRAY", "STRUCT", "FUNCTION", "BUILTIN_FUNCTION", "ERROR"
+	Kind() string   // "INTEGER", "STRING", "BOOLEAN", "ARRAY", "MAP", "RESULT", "OPTION", "STRUCT", "FUNCTION", "BUILTIN_FUNCTION", "ERROR", "DYNAMIC", "TYPE_PARAMETER", "VARIABLE"
+	String() string // the type as written in messages, e.g. "fn(int) -> string"
 }
 
 // IntegerType represents the type of an integer value.
 type IntegerType struct{}
 
 func (t *IntegerType) IsType(other Type) bool {
-	_, ok := other.(*IntegerType)
+	_, ok := Prune(other).(*IntegerType)
 	return ok
 }
 func (t *IntegerType) IsAssignableTo(other Type) bool {
-	return t.IsType(other)
+	return t.IsType(other) || isUnknown(other)
 }
 func (t *IntegerType) Kind() string {
 	return "INTEGER"
 }
+func (t *IntegerType) String() string {
+	return "int"
+}
 
 // StringType represents the type of a string value.
 type StringType struct{}
 
 func (t *StringType) IsType(other Type) bool {
-	_, ok := other.(*StringType)
+	_, ok := Prune(other).(*StringType)
 	return ok
 }
 func (t *StringType) IsAssignableTo(other Type) bool {
-	return t.IsType(other)
+	return t.IsType(other) || isUnknown(other)
 }
 func (t *StringType) Kind() string {
 	return "STRING"
 }
+func (t *StringType) String() string {
+	return "string"
+}
 
 // BooleanType represents the type of a boolean value.
 type BooleanType struct{}
 
 func (t *BooleanType) IsType(other Type) bool {
-	_, ok := other.(*BooleanType)
+	_, ok := Prune(other).(*BooleanType)
 	return ok
 }
 func (t *BooleanType) IsAssignableTo(other Type) bool {
-	return t.IsType(other)
+	return t.IsType(other) || isUnknown(other)
 }
 func (t *BooleanType) Kind() string {
 	return "BOOLEAN"
 }
+func (t *BooleanType) String() string {
+	return "bool"
+}
 
 // ArrayType represents the type of an array value.
+// Arrays are covariant in their element type.
 type ArrayType struct {
 	ElementType Type
 }
 
 func (t *ArrayType) IsType(other Type) bool {
-	otherArray, ok := other.(*ArrayType)
+	otherArray, ok := Prune(other).(*ArrayType)
 	if !ok {
 		return false
 	}
 	return t.ElementType.IsType(otherArray.ElementType)
 }
 func (t *ArrayType) IsAssignableTo(other Type) bool {
-	otherArray, ok := other.(*ArrayType)
+	if isUnknown(other) {
+		return true
+	}
+	otherArray, ok := Prune(other).(*ArrayType)
 	if !ok {
 		return false
 	}
@@ -70,3 +89,378 @@ func (t *ArrayType) IsAssignableTo(other Type) bool {
 func (t *ArrayType) Kind() string {
 	return "ARRAY"
 }
+func (t *ArrayType) String() string {
+	return "[" + t.ElementType.String() + "]"
+}
+
+// MapType represents the type of a map value. Keys are invariant,
+// values covariant like the elements of an array.
+type MapType struct {
+	KeyType   Type
+	ValueType Type
+}
+
+func (t *MapType) IsType(other Type) bool {
+	otherMap, ok := Prune(other).(*MapType)
+	return ok && t.KeyType.IsType(otherMap.KeyType) && t.ValueType.IsType(otherMap.ValueType)
+}
+func (t *MapType) IsAssignableTo(other Type) bool {
+	if isUnknown(other) {
+		return true
+	}
+	otherMap, ok := Prune(other).(*MapType)
+	if !ok {
+		return false
+	}
+	return (t.KeyType.IsType(otherMap.KeyType) || isUnknown(otherMap.KeyType)) && t.ValueType.IsAssignableTo(otherMap.ValueType)
+}
+func (t *MapType) Kind() string {
+	return "MAP"
+}
+func (t *MapType) String() string {
+	return "{" + t.KeyType.String() + ": " + t.ValueType.String() + "}"
+}
+
+// ResultType represents either a value of ValueType or an error.
+// Results are covariant in their value type.
+type ResultType struct {
+	ValueType Type
+}
+
+func (t *ResultType) IsType(other Type) bool {
+	otherResult, ok := Prune(other).(*ResultType)
+	return ok && t.ValueType.IsType(otherResult.ValueType)
+}
+func (t *ResultType) IsAssignableTo(other Type) bool {
+	if isUnknown(other) {
+		return true
+	}
+	otherResult, ok := Prune(other).(*ResultType)
+	return ok && t.ValueType.IsAssignableTo(otherResult.ValueType)
+}
+func (t *ResultType) Kind() string {
+	return "RESULT"
+}
+func (t *ResultType) String() string {
+	return "Result<" + t.ValueType.String() + ">"
+}
+
+// OptionType represents either some value of ValueType or none.
+// Options are covariant in their value type.
+type OptionType struct {
+	ValueType Type
+}
+
+func (t *OptionType) IsType(other Type) bool {
+	otherOption, ok := Prune(other).(*OptionType)
+	return ok && t.ValueType.IsType(otherOption.ValueType)
+}
+func (t *OptionType) IsAssignableTo(other Type) bool {
+	if isUnknown(other) {
+		return true
+	}
+	otherOption, ok := Prune(other).(*OptionType)
+	return ok && t.ValueType.IsAssignableTo(otherOption.ValueType)
+}
+func (t *OptionType) Kind() string {
+	return "OPTION"
+}
+func (t *OptionType) String() string {
+	return "Option<" + t.ValueType.String() + ">"
+}
+
+// TypeParameter is a type variable declared by a generic function, such
+// as T in fn<T>(xs: [T]) -> T. Inside the function it only matches itself,
+// each call replaces it with the type inferred from the arguments.
+type TypeParameter struct {
+	Name string
+}
+
+func (t *TypeParameter) IsType(other Type) bool {
+	otherParam, ok := Prune(other).(*TypeParameter)
+	return ok && otherParam == t
+}
+func (t *TypeParameter) IsAssignableTo(other Type) bool {
+	return t.IsType(other) || isUnknown(other)
+}
+func (t *TypeParameter) Kind() string {
+	return "TYPE_PARAMETER"
+}
+func (t *TypeParameter) String() string {
+	return t.Name
+}
+
+// FunctionType represents the type of a function literal. When Variadic is
+// set the last parameter type applies to every remaining argument.
+type FunctionType struct {
+	Parameters []Type
+	Result     Type
+	Variadic   bool
+}
+
+// Parameter returns the type expected for argument i, or nil if the
+// function takes fewer arguments
+func (t *FunctionType) Parameter(i int) Type {
+	if i < len(t.Parameters) {
+		return t.Parameters[i]
+	}
+	if t.Variadic && len(t.Parameters) > 0 {
+		return t.Parameters[len(t.Parameters)-1]
+	}
+	return nil
+}
+
+// Required returns how many arguments a call must pass at least
+func (t *FunctionType) Required() int {
+	if t.Variadic {
+		return len(t.Parameters) - 1
+	}
+	return len(t.Parameters)
+}
+
+func (t *FunctionType) IsType(other Type) bool {
+	otherFn, ok := Prune(other).(*FunctionType)
+	if !ok || t.Variadic != otherFn.Variadic || len(t.Parameters) != len(otherFn.Parameters) {
+		return false
+	}
+	for i, p := range t.Parameters {
+		if !p.IsType(otherFn.Parameters[i]) {
+			return false
+		}
+	}
+	return t.Result.IsType(otherFn.Result)
+}
+
+// IsAssignableTo follows the usual variance rules: a function can stand in
+// for another if it accepts at least the arguments the other accepts
+// (contravariant parameters) and returns something the other's callers can
+// use (covariant result). Callers of a variadic type may pass any number of
+// arguments, so only a variadic function can stand in for one.
+func (t *FunctionType) IsAssignableTo(other Type) bool {
+	if isUnknown(other) {
+		return true
+	}
+	target, ok := Prune(other).(*FunctionType)
+	if !ok {
+		return false
+	}
+	if target.Variadic && !t.Variadic {
+		return false
+	}
+	if t.Required() > target.Required() {
+		return false
+	}
+	for i := range target.Parameters {
+		mine := t.Parameter(i)
+		if mine == nil {
+			// extra arguments are ignored when the function is called
+			continue
+		}
+		if !target.Parameters[i].IsAssignableTo(mine) {
+			return false
+		}
+	}
+	return t.Result.IsAssignableTo(target.Result)
+}
+func (t *FunctionType) Kind() string {
+	return "FUNCTION"
+}
+func (t *FunctionType) String() string {
+	params := make([]string, len(t.Parameters))
+	for i, p := range t.Parameters {
+		params[i] = p.String()
+		if t.Variadic && i == len(t.Parameters)-1 {
+			params[i] = "..." + params[i]
+		}
+	}
+	return fmt.Sprintf("fn(%s) -> %s", strings.Join(params, ", "), t.Result.String())
+}
+
+// BuiltinFunctionType represents a function provided by the interpreter.
+// It can be used anywhere a function with the same signature can.
+type BuiltinFunctionType struct {
+	Name      string
+	Signature *FunctionType
+}
+
+func (t *BuiltinFunctionType) IsType(other Type) bool {
+	otherBuiltin, ok := Prune(other).(*BuiltinFunctionType)
+	return ok && t.Name == otherBuiltin.Name && t.Signature.IsType(otherBuiltin.Signature)
+}
+func (t *BuiltinFunctionType) IsAssignableTo(other Type) bool {
+	if t.IsType(other) {
+		return true
+	}
+	return t.Signature.IsAssignableTo(other)
+}
+func (t *BuiltinFunctionType) Kind() string {
+	return "BUILTIN_FUNCTION"
+}
+func (t *BuiltinFunctionType) String() string {
+	return "builtin " + t.Name + " " + t.Signature.String()
+}
+
+// Field is one named member of a StructType
+type Field struct {
+	Name string
+	Type Type
+}
+
+// StructType represents a record of named fields. Named structs only match
+// structs of the same name and type arguments, anonymous ones match by
+// shape. A struct can be used where one with a subset of its fields is
+// expected. A generic struct is written with TypeParameters in its fields
+// and instantiated with InstantiateStruct.
+type StructType struct {
+	Name          string
+	TypeArguments []Type
+	Fields        []Field
+}
+
+// InstantiateStruct returns a copy of t with params replaced by args
+func InstantiateStruct(t *StructType, params []*TypeParameter, args []Type) *StructType {
+	subst := make(map[Type]Type, len(params))
+	for i, p := range params {
+		subst[p] = args[i]
+	}
+	instance := Substitute(t, subst).(*StructType)
+	instance.TypeArguments = args
+	return instance
+}
+
+// Field returns the type of the named field, or nil
+func (t *StructType) Field(name string) Type {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f.Type
+		}
+	}
+	return nil
+}
+
+func (t *StructType) IsType(other Type) bool {
+	otherStruct, ok := Prune(other).(*StructType)
+	if !ok || t.Name != otherStruct.Name || len(t.Fields) != len(otherStruct.Fields) {
+		return false
+	}
+	if !sameTypes(t.TypeArguments, otherStruct.TypeArguments) {
+		return false
+	}
+	for _, f := range otherStruct.Fields {
+		mine := t.Field(f.Name)
+		if mine == nil || !mine.IsType(f.Type) {
+			return false
+		}
+	}
+	return true
+}
+func (t *StructType) IsAssignableTo(other Type) bool {
+	if isUnknown(other) {
+		return true
+	}
+	otherStruct, ok := Prune(other).(*StructType)
+	if !ok || (otherStruct.Name != "" && t.Name != otherStruct.Name) {
+		return false
+	}
+	if otherStruct.Name != "" && !sameTypes(t.TypeArguments, otherStruct.TypeArguments) {
+		return false
+	}
+	for _, f := range otherStruct.Fields {
+		mine := t.Field(f.Name)
+		if mine == nil || !mine.IsAssignableTo(f.Type) {
+			return false
+		}
+	}
+	return true
+}
+func (t *StructType) Kind() string {
+	return "STRUCT"
+}
+func (t *StructType) String() string {
+	fields := make([]string, len(t.Fields))
+	for i, f := range t.Fields {
+		fields[i] = f.Name + ": " + f.Type.String()
+	}
+	body := "struct { " + strings.Join(fields, ", ") + " }"
+	if len(t.Fields) == 0 {
+		body = "struct {}"
+	}
+	if t.Name == "" {
+		return body
+	}
+	name := t.Name
+	if len(t.TypeArguments) > 0 {
+		args := make([]string, len(t.TypeArguments))
+		for i, a := range t.TypeArguments {
+			args[i] = a.String()
+		}
+		name += "<" + strings.Join(args, ", ") + ">"
+	}
+	return name + " " + body
+}
+
+func sameTypes(a, b []Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].IsType(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrorType represents the type of an error value
+type ErrorType struct{}
+
+func (t *ErrorType) IsType(other Type) bool {
+	_, ok := Prune(other).(*ErrorType)
+	return ok
+}
+func (t *ErrorType) IsAssignableTo(other Type) bool {
+	return t.IsType(other) || isUnknown(other)
+}
+func (t *ErrorType) Kind() string {
+	return "ERROR"
+}
+func (t *ErrorType) String() string {
+	return "error"
+}
+
+// DynamicType stands for a value whose type is only known at run time.
+// It is the top type: every type is assignable to it and, since the value
+// is checked when the program runs, it is assignable to every type.
+type DynamicType struct{}
+
+func (t *DynamicType) IsType(other Type) bool {
+	_, ok := Prune(other).(*DynamicType)
+	return ok
+}
+func (t *DynamicType) IsAssignableTo(other Type) bool {
+	return true
+}
+func (t *DynamicType) Kind() string {
+	return "DYNAMIC"
+}
+func (t *DynamicType) String() string {
+	return "dynamic"
+}
+
+// IsDynamic reports whether t is unknown until run time
+func IsDynamic(t Type) bool {
+	_, ok := Prune(t).(*DynamicType)
+	return ok
+}
+
+// isUnknown is true for the dynamic type and for unbound variables,
+// either of which may turn out to be anything
+func isUnknown(t Type) bool {
+	switch t := Prune(t).(type) {
+	case *DynamicType:
+		return true
+	case *Variable:
+		return t.Instance == nil
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package types
+
+import "testing"
+
+var (
+	integer = &IntegerType{}
+	str     = &StringType{}
+	boolean = &BooleanType{}
+	dynamic = &DynamicType{}
+	errType = &ErrorType{}
+)
+
+func fn(result Type, params ...Type) *FunctionType {
+	return &FunctionType{Parameters: params, Result: result}
+}
+
+func variadic(result Type, params ...Type) *FunctionType {
+	return &FunctionType{Parameters: params, Result: result, Variadic: true}
+}
+
+func TestIsAssignableTo(t *testing.T) {
+	point := &StructType{Name: "Point", Fields: []Field{{"x", integer}, {"y", integer}}}
+	namedX := &StructType{Name: "X", Fields: []Field{{"x", integer}}}
+	anonX := &StructType{Fields: []Field{{"x", integer}}}
+	anonXY := &StructType{Fields: []Field{{"x", integer}, {"y", integer}}}
+	anonXDynamic := &StructType{Fields: []Field{{"x", dynamic}}}
+	length := &BuiltinFunctionType{Name: "len", Signature: fn(integer, str)}
+	bound := NewVariable(0)
+	bound.Instance = integer
+
+	tests := []struct {
+		name     string
+		from, to Type
+		expected bool
+	}{
+		{"int to int", integer, integer, true},
+		{"int to string", integer, str, false},
+		{"string to bool", str, boolean, false},
+		{"bool to bool", boolean, boolean, true},
+		{"int to dynamic", integer, dynamic, true},
+		{"dynamic to int", dynamic, integer, true},
+		{"dynamic to function", dynamic, fn(integer), true},
+		{"error to error", errType, errType, true},
+		{"error to string", errType, str, false},
+		{"int to error", integer, errType, false},
+
+		{"array of int to array of int", &ArrayType{integer}, &ArrayType{integer}, true},
+		{"array of int to array of string", &ArrayType{integer}, &ArrayType{str}, false},
+		{"array covariance", &ArrayType{integer}, &ArrayType{dynamic}, true},
+		{"array to its element", &ArrayType{integer}, integer, false},
+
+		{"same function", fn(integer, str), fn(integer, str), true},
+		{"different parameter", fn(integer, str), fn(integer, integer), false},
+		{"different result", fn(integer, str), fn(str, str), false},
+		{"covariant result", fn(anonXY), fn(anonX), true},
+		{"result is not contravariant", fn(anonX), fn(anonXY), false},
+		{"contravariant parameter", fn(integer, anonX), fn(integer, anonXY), true},
+		{"parameter is not covariant", fn(integer, anonXY), fn(integer, anonX), false},
+		{"parameter of a parameter", fn(integer, fn(integer, anonXY)), fn(integer, fn(integer, anonX)), true},
+		{"parameter of a parameter reversed", fn(integer, fn(integer, anonX)), fn(integer, fn(integer, anonXY)), false},
+		{"dynamic parameter", fn(integer, dynamic), fn(integer, str), true},
+		{"fewer parameters", fn(integer), fn(integer, str), true},
+		{"more parameters", fn(integer, str, str), fn(integer, str), false},
+		{"variadic to fixed", variadic(integer, str), fn(integer, str, str), true},
+		{"variadic element type", variadic(integer, str), fn(integer, str, integer), false},
+		{"fixed to variadic", fn(integer, str), variadic(integer, str), false},
+		{"variadic to variadic", variadic(integer, integer, str), variadic(integer, integer, str), true},
+		{"function to int", fn(integer), integer, false},
+
+		{"builtin to its signature", length, fn(integer, str), true},
+		{"builtin to other signature", length, fn(integer, integer), false},
+		{"builtin to itself", length, length, true},
+		{"function to builtin", fn(integer, str), length, false},
+
+		{"same named struct", point, point, true},
+		{"named struct to other name", namedX, &StructType{Name: "Y", Fields: []Field{{"x", integer}}}, false},
+		{"named struct to anonymous subset", point, anonX, true},
+		{"anonymous struct to named", anonX, namedX, false},
+		{"width subtyping", anonXY, anonX, true},
+		{"missing field", anonX, anonXY, false},
+		{"depth subtyping", anonX, anonXDynamic, true},
+		{"field of other type", anonX, &StructType{Fields: []Field{{"x", str}}}, false},
+
+		{"unbound variable to int", NewVariable(0), integer, true},
+		{"int to unbound variable", integer, NewVariable(0), true},
+		{"bound variable to int", bound, integer, true},
+		{"bound variable to string", bound, str, false},
+		{"string to bound variable", str, bound, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.IsAssignableTo(tt.to); got != tt.expected {
+			t.Errorf("%s: %s.IsAssignableTo(%s) = %t, want %t", tt.name, tt.from, tt.to, got, tt.expected)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		typ      Type
+		expected string
+	}{
+		{integer, "int"},
+		{str, "string"},
+		{boolean, "bool"},
+		{dynamic, "dynamic"},
+		{errType, "error"},
+		{&ArrayType{integer}, "[int]"},
+		{fn(str), "fn() -> string"},
+		{fn(boolean, integer, str), "fn(int, string) -> bool"},
+		{variadic(integer, str, integer), "fn(string, ...int) -> int"},
+		{fn(fn(integer, integer), integer), "fn(int) -> fn(int) -> int"},
+		{&BuiltinFunctionType{Name: "len", Signature: fn(integer, str)}, "builtin len fn(string) -> int"},
+		{&StructType{Fields: []Field{{"x", integer}, {"y", str}}}, "struct { x: int, y: string }"},
+		{&StructType{Name: "Empty"}, "Empty struct {}"},
+		{&Variable{ID: 7}, "t7"},
+		{&Variable{ID: 7, Instance: str}, "string"},
+		{&Variable{ID: 7, Name: "T"}, "T"},
+		{&TypeParameter{Name: "T"}, "T"},
+		{&MapType{str, &ArrayType{integer}}, "{string: [int]}"},
+		{&ResultType{integer}, "Result<int>"},
+		{&OptionType{&ArrayType{str}}, "Option<[string]>"},
+		{&StructType{Name: "Pair", TypeArguments: []Type{integer, str}}, "Pair<int, string> struct {}"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.typ.String(); got != tt.expected {
+			t.Errorf("wrong string. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestUnify(t *testing.T) {
+	a, b := NewVariable(0), NewVariable(0)
+	if err := Unify(fn(a, a), fn(b, integer)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !Prune(a).IsType(integer) || !Prune(b).IsType(integer) {
+		t.Errorf("variables not bound to int. a=%s, b=%s", a, b)
+	}
+
+	tests := []struct {
+		a, b    Type
+		wantErr bool
+	}{
+		{integer, integer, false},
+		{integer, str, true},
+		{dynamic, str, false},
+		{&ArrayType{NewVariable(0)}, &ArrayType{str}, false},
+		{&ArrayType{integer}, &ArrayType{str}, true},
+		{fn(integer, str), fn(integer, str, str), true},
+		{fn(integer), variadic(integer), true},
+		{&StructType{Fields: []Field{{"x", NewVariable(0)}}}, &StructType{Fields: []Field{{"x", str}}}, false},
+		{&StructType{Fields: []Field{{"x", integer}}}, &StructType{Fields: []Field{{"y", integer}}}, true},
+		{&MapType{str, NewVariable(0)}, &MapType{str, integer}, false},
+		{&MapType{str, integer}, &MapType{integer, integer}, true},
+		{&ResultType{NewVariable(0)}, &ResultType{boolean}, false},
+		{&ResultType{integer}, &ArrayType{integer}, true},
+		{&OptionType{NewVariable(0)}, &OptionType{str}, false},
+		{&OptionType{integer}, &ResultType{integer}, true},
+		{&TypeParameter{Name: "T"}, integer, true},
+	}
+	for _, tt := range tests {
+		err := Unify(tt.a, tt.b)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unify(%s, %s) error = %v, wantErr %t", tt.a, tt.b, err, tt.wantErr)
+		}
+	}
+
+	v := NewVariable(0)
+	if err := Unify(v, fn(integer, v)); err == nil {
+		t.Errorf("expected occurs check to fail for %s", v)
+	}
+}
+
+func TestGeneralize(t *testing.T) {
+	outer := NewVariable(0)
+	inner := NewVariable(1)
+	scheme := Generalize(fn(inner, inner, outer), 0)
+	if len(scheme.Variables) != 1 || scheme.Variables[0] != inner {
+		t.Fatalf("wrong generalised variables. got=%v", scheme.Variables)
+	}
+
+	first := scheme.Instantiate(0).(*FunctionType)
+	second := scheme.Instantiate(0).(*FunctionType)
+	if err := Unify(first.Parameters[0], integer); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unify(second.Parameters[0], str); err != nil {
+		t.Fatal(err)
+	}
+	if !first.Result.IsType(integer) || !second.Result.IsType(str) {
+		t.Errorf("instances share variables. first=%s, second=%s", first, second)
+	}
+	if first.Parameters[1] != outer {
+		t.Errorf("free variable was copied")
+	}
+}
+
+func TestInstantiateStruct(t *testing.T) {
+	a, b := &TypeParameter{Name: "A"}, &TypeParameter{Name: "B"}
+	pair := &StructType{Name: "Pair", Fields: []Field{{"first", a}, {"second", &ArrayType{b}}}}
+
+	instance := InstantiateStruct(pair, []*TypeParameter{a, b}, []Type{integer, str})
+	if got, want := instance.String(), "Pair<int, string> struct { first: int, second: [string] }"; got != want {
+		t.Errorf("wrong instance. want=%q, got=%q", want, got)
+	}
+	if pair.Field("first") != Type(a) {
+		t.Errorf("generic struct was modified. got=%s", pair)
+	}
+	other := InstantiateStruct(pair, []*TypeParameter{a, b}, []Type{str, str})
+	if instance.IsAssignableTo(other) {
+		t.Errorf("%s should not be assignable to %s", instance, other)
+	}
+}
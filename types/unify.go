@@ -0,0 +1,264 @@
+package types
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+var nextVariable atomic.Int64
+
+// Variable is a placeholder for a type that inference has not worked out
+// yet. Once unified with another type, Instance points at it.
+type Variable struct {
+	ID       int64
+	Level    int    // nesting depth of the function that introduced the variable
+	Name     string // the type parameter it stands for, if any
+	Instance Type
+}
+
+func NewVariable(level int) *Variable {
+	return &Variable{ID: nextVariable.Add(1), Level: level}
+}
+
+func (t *Variable) IsType(other Type) bool {
+	if t.Instance != nil {
+		return t.Instance.IsType(other)
+	}
+	otherVar, ok := Prune(other).(*Variable)
+	return ok && otherVar == t
+}
+func (t *Variable) IsAssignableTo(other Type) bool {
+	if t.Instance != nil {
+		return t.Instance.IsAssignableTo(other)
+	}
+	return true
+}
+func (t *Variable) Kind() string {
+	if t.Instance != nil {
+		return t.Instance.Kind()
+	}
+	return "VARIABLE"
+}
+func (t *Variable) String() string {
+	if t.Instance != nil {
+		return t.Instance.String()
+	}
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("t%d", t.ID)
+}
+
+// Prune follows bound variables to the type they stand for
+func Prune(t Type) Type {
+	for {
+		v, ok := t.(*Variable)
+		if !ok || v.Instance == nil {
+			return t
+		}
+		t = v.Instance
+	}
+}
+
+// Unify makes a and b the same type by binding the variables in them.
+// Dynamic unifies with anything without binding. When unification fails
+// some variables may already have been bound.
+func Unify(a, b Type) error {
+	a, b = Prune(a), Prune(b)
+
+	if va, ok := a.(*Variable); ok {
+		return bind(va, b)
+	}
+	if vb, ok := b.(*Variable); ok {
+		return bind(vb, a)
+	}
+	if IsDynamic(a) || IsDynamic(b) {
+		return nil
+	}
+
+	switch a := a.(type) {
+	case *ArrayType:
+		if b, ok := b.(*ArrayType); ok {
+			return Unify(a.ElementType, b.ElementType)
+		}
+	case *MapType:
+		if b, ok := b.(*MapType); ok {
+			if err := Unify(a.KeyType, b.KeyType); err != nil {
+				return err
+			}
+			return Unify(a.ValueType, b.ValueType)
+		}
+	case *ResultType:
+		if b, ok := b.(*ResultType); ok {
+			return Unify(a.ValueType, b.ValueType)
+		}
+	case *OptionType:
+		if b, ok := b.(*OptionType); ok {
+			return Unify(a.ValueType, b.ValueType)
+		}
+	case *FunctionType:
+		if b, ok := b.(*FunctionType); ok && len(a.Parameters) == len(b.Parameters) && a.Variadic == b.Variadic {
+			for i := range a.Parameters {
+				if err := Unify(a.Parameters[i], b.Parameters[i]); err != nil {
+					return err
+				}
+			}
+			return Unify(a.Result, b.Result)
+		}
+	case *BuiltinFunctionType:
+		if b, ok := b.(*BuiltinFunctionType); ok && a.Name == b.Name {
+			return nil
+		}
+		return Unify(a.Signature, b)
+	case *StructType:
+		if b, ok := b.(*StructType); ok && a.Name == b.Name && len(a.Fields) == len(b.Fields) {
+			for _, f := range a.Fields {
+				other := b.Field(f.Name)
+				if other == nil {
+					return mismatch(a, b)
+				}
+				if err := Unify(f.Type, other); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		if a.IsType(b) {
+			return nil
+		}
+	}
+	return mismatch(a, b)
+}
+
+func bind(v *Variable, t Type) error {
+	if other, ok := t.(*Variable); ok && other == v {
+		return nil
+	}
+	if Occurs(v, t) {
+		return fmt.Errorf("recursive type: %s occurs in %s", v, t)
+	}
+	// a variable from an outer function may escape into the bound type
+	Walk(t, func(t Type) {
+		if other, ok := t.(*Variable); ok && other.Level > v.Level {
+			other.Level = v.Level
+		}
+	})
+	v.Instance = t
+	return nil
+}
+
+// Occurs reports whether v appears in t
+func Occurs(v *Variable, t Type) bool {
+	found := false
+	Walk(t, func(t Type) {
+		if t == Type(v) {
+			found = true
+		}
+	})
+	return found
+}
+
+func mismatch(a, b Type) error {
+	return fmt.Errorf("cannot use %s as %s", b, a)
+}
+
+// Walk calls f for t and every type inside it, following bound variables
+func Walk(t Type, f func(Type)) {
+	t = Prune(t)
+	f(t)
+	switch t := t.(type) {
+	case *ArrayType:
+		Walk(t.ElementType, f)
+	case *MapType:
+		Walk(t.KeyType, f)
+		Walk(t.ValueType, f)
+	case *ResultType:
+		Walk(t.ValueType, f)
+	case *OptionType:
+		Walk(t.ValueType, f)
+	case *FunctionType:
+		for _, p := range t.Parameters {
+			Walk(p, f)
+		}
+		Walk(t.Result, f)
+	case *StructType:
+		for _, a := range t.TypeArguments {
+			Walk(a, f)
+		}
+		for _, field := range t.Fields {
+			Walk(field.Type, f)
+		}
+	}
+}
+
+// Substitute copies t, replacing the variables and type parameters that
+// are keys of subst
+func Substitute(t Type, subst map[Type]Type) Type {
+	t = Prune(t)
+	if replacement, ok := subst[t]; ok {
+		return replacement
+	}
+	switch t := t.(type) {
+	case *ArrayType:
+		return &ArrayType{ElementType: Substitute(t.ElementType, subst)}
+	case *MapType:
+		return &MapType{KeyType: Substitute(t.KeyType, subst), ValueType: Substitute(t.ValueType, subst)}
+	case *ResultType:
+		return &ResultType{ValueType: Substitute(t.ValueType, subst)}
+	case *OptionType:
+		return &OptionType{ValueType: Substitute(t.ValueType, subst)}
+	case *FunctionType:
+		params := make([]Type, len(t.Parameters))
+		for i, p := range t.Parameters {
+			params[i] = Substitute(p, subst)
+		}
+		return &FunctionType{Parameters: params, Result: Substitute(t.Result, subst), Variadic: t.Variadic}
+	case *StructType:
+		s := &StructType{Name: t.Name, Fields: make([]Field, len(t.Fields))}
+		for _, a := range t.TypeArguments {
+			s.TypeArguments = append(s.TypeArguments, Substitute(a, subst))
+		}
+		for i, f := range t.Fields {
+			s.Fields[i] = Field{Name: f.Name, Type: Substitute(f.Type, subst)}
+		}
+		return s
+	default:
+		return t
+	}
+}
+
+// Scheme is a type generalised over some of its variables, the type of a
+// let bound function that can be used at several types
+type Scheme struct {
+	Variables []*Variable
+	Type      Type
+}
+
+// Generalize quantifies over every unbound variable in t that was
+// introduced deeper than level, i.e. inside the function being bound
+func Generalize(t Type, level int) *Scheme {
+	s := &Scheme{Type: t}
+	seen := map[*Variable]bool{}
+	Walk(t, func(t Type) {
+		if v, ok := t.(*Variable); ok && v.Level > level && !seen[v] {
+			seen[v] = true
+			s.Variables = append(s.Variables, v)
+		}
+	})
+	return s
+}
+
+// Instantiate copies the scheme's type with fresh variables at level
+func (s *Scheme) Instantiate(level int) Type {
+	if len(s.Variables) == 0 {
+		return s.Type
+	}
+	fresh := make(map[Type]Type, len(s.Variables))
+	for _, v := range s.Variables {
+		nv := NewVariable(level)
+		nv.Name = v.Name
+		fresh[v] = nv
+	}
+	return Substitute(s.Type, fresh)
+}
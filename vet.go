@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gosling/diag"
+	"gosling/vet"
+	"io"
+)
+
+// vetFiles reports likely mistakes in .gos files without running them
+func vetFiles(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("vet", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	asJSON := fs.Bool("json", false, "print findings as a JSON array")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintf(errOut, "usage: gosling vet [--json] file.gos...\n")
+		return 2
+	}
+
+	status := 0
+	findings := []diag.Diagnostic{}
+	for _, path := range fs.Args() {
+		program, ok := parseFile(path, errOut)
+		if !ok {
+			status = 1
+			continue
+		}
+		findings = append(findings, vet.Check(program, vet.Config{})...)
+	}
+	if len(findings) > 0 {
+		status = 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			fmt.Fprintf(errOut, "%s\n", err)
+			return 1
+		}
+		return status
+	}
+	for _, f := range findings {
+		fmt.Fprintf(out, "%s\n", f)
+	}
+	return status
+}
@@ -0,0 +1,316 @@
+package vet
+
+import (
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/token"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Directive is the comment that silences findings on its own line and the
+// line below it. It may be followed by codes or names to silence only
+// those, e.g. "//vet:ignore unused-binding W0004".
+const Directive = "vet:ignore"
+
+type Config struct {
+	// Globals are names bound before the program runs, such as the
+	// bindings from earlier lines of a REPL session
+	Globals []string
+	// IgnoreUnusedGlobals skips unused-binding for top level lets,
+	// which a REPL user may still read on a later line
+	IgnoreUnusedGlobals bool
+}
+
+type binding struct {
+	name     string
+	location token.TokenLocation
+	isLet    bool
+	used     bool
+}
+
+// scope is a program or a function body. Blocks of if and for share the
+// environment of the function they are in, so they do not get a scope,
+// except for the consequence of an if let which holds its binding.
+type scope struct {
+	outer    *scope
+	bindings map[string]*binding
+}
+
+type checker struct {
+	config      Config
+	diagnostics []diag.Diagnostic
+}
+
+// Check looks for likely mistakes in program without running it.
+// Findings are warnings ordered by position.
+func Check(program *ast.Program, config Config) []diag.Diagnostic {
+	c := &checker{config: config}
+
+	top := newScope(nil)
+	for _, name := range config.Globals {
+		top.bindings[name] = &binding{name: name, used: true}
+	}
+	c.declare(top, program)
+	c.checkStatements(top, program.Statements)
+	if !config.IgnoreUnusedGlobals {
+		c.reportUnused(top)
+	}
+
+	findings := suppress(c.diagnostics, program.Comments)
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i].Location, findings[j].Location
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.LineCh < b.LineCh
+	})
+	return findings
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, bindings: make(map[string]*binding)}
+}
+
+func (s *scope) lookup(name string) (*binding, bool) {
+	for sc := s; sc != nil; sc = sc.outer {
+		if b, ok := sc.bindings[name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func (c *checker) report(code diag.Code, loc token.TokenLocation, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diag.New(code, loc, format, a...))
+}
+
+// declare binds every let in the scope up front, so that functions can
+// refer to names bound later on, as they can when they run
+func (c *checker) declare(s *scope, node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			if _, ok := s.bindings[n.Name.Value]; !ok {
+				s.bindings[n.Name.Value] = &binding{name: n.Name.Value, location: n.Name.Location(), isLet: true}
+			}
+		}
+		return true
+	})
+}
+
+func (c *checker) reportUnused(s *scope) {
+	for _, b := range s.bindings {
+		if b.isLet && !b.used && !strings.HasPrefix(b.name, "_") {
+			c.report(diag.UnusedBinding, b.location, "%s is bound but never used", b.name)
+		}
+	}
+}
+
+func (c *checker) checkStatements(s *scope, statements []ast.Statement) {
+	for i, stmt := range statements {
+		c.check(s, stmt)
+		if _, ok := stmt.(*ast.ReturnStatement); ok && i < len(statements)-1 {
+			c.report(diag.UnreachableCode, statements[i+1].Location(), "unreachable code after return")
+			for _, rest := range statements[i+1:] {
+				c.check(s, rest)
+			}
+			return
+		}
+	}
+}
+
+func (c *checker) check(s *scope, node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		c.check(s, node.Value)
+	case *ast.ReturnStatement:
+		c.check(s, node.ReturnValue)
+	case *ast.ExpressionStatement:
+		c.check(s, node.Expression)
+	case *ast.BlockStatement:
+		c.checkStatements(s, node.Statements)
+	case *ast.Identifier:
+		if b, ok := s.lookup(node.Value); ok {
+			b.used = true
+		}
+	case *ast.PrefixExpression:
+		c.check(s, node.Right)
+	case *ast.InfixExpression:
+		c.checkComparison(node)
+		c.check(s, node.Left)
+		c.check(s, node.Right)
+	case *ast.AssignExpression:
+		// assigning is not reading, so only the value is checked
+		c.check(s, node.Value)
+	case *ast.IfExpression:
+		if value, ok := constantBool(node.Condition); ok {
+			c.report(diag.ConstantCondition, node.Condition.Location(), "condition is always %t", value)
+		}
+		c.check(s, node.Condition)
+		if node.Binding != nil {
+			// the value of an if let is only bound inside the consequence
+			inner := newScope(s)
+			inner.bindings[node.Binding.Value] = &binding{name: node.Binding.Value, location: node.Binding.Location(), isLet: true}
+			c.check(inner, node.Consequence)
+			c.reportUnused(inner)
+		} else {
+			c.check(s, node.Consequence)
+		}
+		if node.Alternative != nil {
+			c.check(s, node.Alternative)
+		}
+	case *ast.ForExpression:
+		if value, ok := constantBool(node.Condition); ok && !value {
+			c.report(diag.ConstantCondition, node.Condition.Location(), "condition is always false, the loop never runs")
+		}
+		c.check(s, node.Condition)
+		c.check(s, node.Body)
+	case *ast.CallExpression:
+		if ident, ok := node.Function.(*ast.Identifier); ok {
+			if _, bound := s.lookup(ident.Value); !bound && !evaluator.IsBuiltin(ident.Value) {
+				c.report(diag.UndefinedCall, ident.Location(), "call to undefined function %s", ident.Value)
+			}
+		}
+		c.check(s, node.Function)
+		for _, arg := range node.Arguments {
+			c.check(s, arg)
+		}
+	case *ast.FunctionLiteral:
+		c.checkFunction(s, node)
+	}
+}
+
+func (c *checker) checkFunction(outer *scope, fn *ast.FunctionLiteral) {
+	s := newScope(outer)
+	for _, param := range fn.Parameters {
+		if _, ok := outer.lookup(param.Value); ok {
+			c.report(diag.ShadowedName, param.Location(), "parameter %s shadows a name from an enclosing scope", param.Value)
+		} else if evaluator.IsBuiltin(param.Value) {
+			c.report(diag.ShadowedName, param.Location(), "parameter %s shadows the builtin %s", param.Value, param.Value)
+		}
+		// parameters are part of the function's signature, so unused ones are fine
+		s.bindings[param.Value] = &binding{name: param.Value, location: param.Location()}
+	}
+
+	c.declare(s, fn.Body)
+	c.check(s, fn.Body)
+	c.reportUnused(s)
+}
+
+func (c *checker) checkComparison(ie *ast.InfixExpression) {
+	switch ie.Operator {
+	case "==", "!=", "<", ">":
+	default:
+		return
+	}
+	left, right := literalType(ie.Left), literalType(ie.Right)
+	if left != "" && right != "" && left != right {
+		c.report(diag.MismatchedComparison, ie.Location(), "comparison of %s with %s", left, right)
+	}
+}
+
+func literalType(exp ast.Expression) string {
+	switch exp.(type) {
+	case *ast.IntegerLiteral:
+		return "INTEGER"
+	case *ast.StringLiteral:
+		return "STRING"
+	case *ast.Boolean:
+		return "BOOLEAN"
+	}
+	return ""
+}
+
+// constantBool works out the value of conditions built only from literals
+func constantBool(exp ast.Expression) (bool, bool) {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.PrefixExpression:
+		if exp.Operator == "!" {
+			value, ok := constantBool(exp.Right)
+			return !value, ok
+		}
+	case *ast.InfixExpression:
+		if l, ok := exp.Left.(*ast.IntegerLiteral); ok {
+			if r, ok := exp.Right.(*ast.IntegerLiteral); ok {
+				cmp := integerValue(l).Cmp(integerValue(r))
+				switch exp.Operator {
+				case "<":
+					return cmp < 0, true
+				case ">":
+					return cmp > 0, true
+				case "==":
+					return cmp == 0, true
+				case "!=":
+					return cmp != 0, true
+				}
+			}
+		}
+		if l, ok := exp.Left.(*ast.Boolean); ok {
+			if r, ok := exp.Right.(*ast.Boolean); ok {
+				switch exp.Operator {
+				case "==":
+					return l.Value == r.Value, true
+				case "!=":
+					return l.Value != r.Value, true
+				}
+			}
+		}
+	}
+	return false, false
+}
+
+// suppress drops findings silenced by a directive on the same line or
+// the line above
+func suppress(findings []diag.Diagnostic, comments []token.Token) []diag.Diagnostic {
+	type directive struct {
+		line  int
+		codes []string
+	}
+	directives := []directive{}
+	for _, c := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Literal, "//"))
+		if !strings.HasPrefix(text, Directive) {
+			continue
+		}
+		directives = append(directives, directive{
+			line:  c.Location.Line,
+			codes: strings.Fields(strings.TrimPrefix(text, Directive)),
+		})
+	}
+
+	kept := []diag.Diagnostic{}
+	for _, f := range findings {
+		silenced := false
+		for _, d := range directives {
+			if d.line != f.Location.Line && d.line != f.Location.Line-1 {
+				continue
+			}
+			if len(d.codes) == 0 {
+				silenced = true
+			}
+			for _, code := range d.codes {
+				if code == string(f.Code) || code == f.Code.Name() {
+					silenced = true
+				}
+			}
+		}
+		if !silenced {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+func integerValue(lit *ast.IntegerLiteral) *big.Int {
+	if lit.Big != nil {
+		return lit.Big
+	}
+	return big.NewInt(lit.Value)
+}
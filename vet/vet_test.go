@@ -0,0 +1,125 @@
+package vet
+
+import (
+	"gosling/diag"
+	"gosling/lexer"
+	"gosling/parser"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []diag.Code
+	}{
+		{"let x = 5; x;", []diag.Code{}},
+		{"let x = 5;", []diag.Code{diag.UnusedBinding}},
+		{"let _x = 5;", []diag.Code{}},
+		{"let x = 5; x = 6;", []diag.Code{diag.UnusedBinding}},
+		{"let f = fn() { let y = 1; 2 }; f();", []diag.Code{diag.UnusedBinding}},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(3);", []diag.Code{}},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f();", []diag.Code{}},
+		{"let x = 1; let f = fn(x) { x }; f(x);", []diag.Code{diag.ShadowedName}},
+		{"let f = fn(len) { len }; f(1);", []diag.Code{diag.ShadowedName}},
+		{"let f = fn() { return 1; 2; 3; }; f();", []diag.Code{diag.UnreachableCode}},
+		{"return 1; 2;", []diag.Code{diag.UnreachableCode}},
+		{"if (true) { 1 }", []diag.Code{diag.ConstantCondition}},
+		{"if (1 > 2) { 1 } else { 2 }", []diag.Code{diag.ConstantCondition}},
+		{"if (!false) { 1 }", []diag.Code{diag.ConstantCondition}},
+		{"for (false) { 1 }", []diag.Code{diag.ConstantCondition}},
+		{"for (true) { 1 }", []diag.Code{}},
+		{"for (99999999999999999999 > 1) { 1 }", []diag.Code{}},
+		{`let a = 5 == "5"; a;`, []diag.Code{diag.MismatchedComparison}},
+		{`let a = true != 1; a;`, []diag.Code{diag.MismatchedComparison}},
+		{`let a = 1 == 1; a;`, []diag.Code{}},
+		{`lenght("abc");`, []diag.Code{diag.UndefinedCall}},
+		{`len("abc");`, []diag.Code{}},
+		{"let f = fn(g) { g(1) }; f(fn(x) { x });", []diag.Code{}},
+		{"if let v = some(1) { v }", []diag.Code{}},
+		{"if let v = some(1) { 2 }", []diag.Code{diag.UnusedBinding}},
+		{"if let _v = some(1) { 2 }", []diag.Code{}},
+		{"let f = fn() { if let v = none { v } }; f(); v;", []diag.Code{}},
+		{"exists(none);", []diag.Code{}},
+	}
+
+	for _, tt := range tests {
+		findings := testCheck(t, tt.input, Config{})
+		if len(findings) != len(tt.expected) {
+			t.Errorf("wrong number of findings for %q. want=%v, got=%v", tt.input, tt.expected, findings)
+			continue
+		}
+		for i, code := range tt.expected {
+			if findings[i].Code != code {
+				t.Errorf("wrong finding for %q. want=%s, got=%s", tt.input, code, findings[i])
+			}
+			if findings[i].Severity != diag.Warning {
+				t.Errorf("finding for %q is not a warning: %s", tt.input, findings[i].Severity)
+			}
+		}
+	}
+}
+
+func TestCheckLocations(t *testing.T) {
+	input := `let f = fn() {
+	return 1;
+	let y = 2;
+};
+f();`
+
+	findings := testCheck(t, input, Config{})
+	if len(findings) != 2 {
+		t.Fatalf("wrong number of findings. got=%v", findings)
+	}
+	// both findings point at the let on the third line
+	if findings[0].Code != diag.UnreachableCode || findings[0].Location.Line != 2 {
+		t.Errorf("wrong first finding. got=%s", findings[0])
+	}
+	if findings[1].Code != diag.UnusedBinding || findings[1].Location.Line != 2 {
+		t.Errorf("wrong second finding. got=%s", findings[1])
+	}
+}
+
+func TestSuppression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"let x = 5; //vet:ignore", 0},
+		{"//vet:ignore\nlet x = 5;", 0},
+		{"// vet:ignore unused-binding\nlet x = 5;", 0},
+		{"let x = 5; // vet:ignore W0001", 0},
+		{"let x = 5; // vet:ignore constant-condition", 1},
+		{"//vet:ignore\n\nlet x = 5;", 1},
+		{"let x = 5; // not a directive", 1},
+	}
+
+	for _, tt := range tests {
+		findings := testCheck(t, tt.input, Config{})
+		if len(findings) != tt.expected {
+			t.Errorf("wrong number of findings for %q. want=%d, got=%v", tt.input, tt.expected, findings)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	findings := testCheck(t, "let x = 1; double(x);", Config{Globals: []string{"double"}, IgnoreUnusedGlobals: true})
+	if len(findings) != 0 {
+		t.Errorf("expected no findings, got=%v", findings)
+	}
+
+	findings = testCheck(t, "let y = 1;", Config{IgnoreUnusedGlobals: true})
+	if len(findings) != 0 {
+		t.Errorf("expected no findings, got=%v", findings)
+	}
+}
+
+func testCheck(t *testing.T, input string, config Config) []diag.Diagnostic {
+	t.Helper()
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Check(program, config)
+}
@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"gosling/code"
+	"gosling/object"
+	"gosling/token"
+)
+
+// Frame is one call of a closure. Its locals are the stack slots from
+// basePointer on, starting with the arguments.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+
+	// call is where the function was called from, which errors about its
+	// arguments and result point at, as they do in the evaluator
+	call token.TokenLocation
+	// frames is the memory accounted for calls when this one began
+	frames int64
+}
+
+func NewFrame(cl *object.Closure, basePointer int) Frame {
+	return Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
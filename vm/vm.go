@@ -0,0 +1,606 @@
+package vm
+
+import (
+	"context"
+	"gosling/code"
+	"gosling/compiler"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/object"
+	"gosling/token"
+)
+
+// StackSize is the number of slots the stack starts with. It grows when a
+// program needs more.
+const StackSize = 2048
+
+// how many instructions to run between checks of the context
+const cancelCheckInterval = 1024
+
+// The sizes the evaluator charges for the same values, so a memory budget
+// means the same thing with either engine
+const (
+	stringSize      = 16
+	bigIntSize      = 32
+	environmentSize = 64
+	bindingSize     = 32
+)
+
+// builtins holds the builtin functions in the order OpGetBuiltin indexes
+var builtins = func() []*object.Builtin {
+	names := evaluator.BuiltinNames()
+	fns := make([]*object.Builtin, len(names))
+	for i, name := range names {
+		fns[i] = evaluator.LookupBuiltin(name)
+	}
+	return fns
+}()
+
+// the operators of the instructions the evaluator's infix rules handle
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpMod:         "%",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+// VM runs compiled bytecode. A program gives the same result, and fails
+// with the same errors at the same locations, as it does in evaluator.Eval,
+// and the same Options bound it. A VM is not safe for concurrent use.
+type VM struct {
+	constants []object.Object
+
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int // the next free slot, the top of the stack is stack[sp-1]
+
+	frames      []Frame
+	framesIndex int
+
+	opts  evaluator.Options
+	ctx   context.Context
+	steps int
+
+	// memory accounting, split as in the evaluator
+	strings   int64
+	callBytes int64
+	peak      int64
+
+	// result is the value of the last top level statement
+	result object.Object
+}
+
+func New(bytecode *compiler.Bytecode, opts evaluator.Options) *VM {
+	return NewWithGlobalsStore(bytecode, nil, opts)
+}
+
+// NewWithGlobalsStore returns a VM using the globals left by an earlier
+// one, as the REPL does between lines
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object, opts evaluator.Options) *VM {
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = evaluator.DefaultMaxDepth
+	}
+	for len(globals) < len(bytecode.Globals) {
+		globals = append(globals, nil)
+	}
+
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Positions: bytecode.Positions}
+	frames := make([]Frame, 1, 64)
+	frames[0] = NewFrame(&object.Closure{Fn: mainFn}, 0)
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     globals,
+		globalNames: bytecode.Globals,
+		stack:       make([]object.Object, StackSize),
+		frames:      frames,
+		opts:        opts,
+	}
+}
+
+// Globals returns the global slots, to pass to NewWithGlobalsStore
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// Steps returns how many instructions the last call to Run executed
+func (vm *VM) Steps() int {
+	return vm.steps
+}
+
+// PeakMemory returns the highest number of bytes accounted for during the
+// last call to Run
+func (vm *VM) PeakMemory() int64 {
+	return vm.peak
+}
+
+// Run executes the program, returning the value of its last statement or
+// the *object.Error that stopped it
+func (vm *VM) Run(ctx context.Context) object.Object {
+	if vm.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, vm.opts.Timeout)
+		defer cancel()
+	}
+	vm.ctx = ctx
+	if err := vm.run(); err != nil {
+		return err
+	}
+	return vm.result
+}
+
+func (vm *VM) run() *object.Error {
+	for {
+		frame := &vm.frames[vm.framesIndex]
+		frame.ip++
+		ins := frame.cl.Fn.Instructions
+		if frame.ip >= len(ins) {
+			// only the main program runs off its end, functions return
+			return nil
+		}
+		if err := vm.step(); err != nil {
+			return err
+		}
+
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+		switch op {
+		case code.OpConstant:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.push(vm.constants[index])
+
+		case code.OpPop:
+			value := vm.pop()
+			if vm.framesIndex == 0 {
+				vm.result = value
+			}
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			if err := vm.executeBinaryOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpMinus, code.OpBang:
+			operator := "-"
+			if op == code.OpBang {
+				operator = "!"
+			}
+			result := evaluator.EvalPrefixExpression(operator, vm.pop(), vm.location())
+			switch result := result.(type) {
+			case *object.Error:
+				return result
+			case *object.BigInt:
+				if err := vm.bigInt(result); err != nil {
+					return err
+				}
+			}
+			vm.push(result)
+
+		case code.OpTrue:
+			vm.push(evaluator.TRUE)
+		case code.OpFalse:
+			vm.push(evaluator.FALSE)
+		case code.OpNull:
+			vm.push(evaluator.NULL)
+		case code.OpNone:
+			vm.push(evaluator.NONE)
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+
+		case code.OpJumpNotTruthy:
+			frame.ip += 2
+			if !evaluator.IsTruthy(vm.pop()) {
+				frame.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+			}
+
+		case code.OpUnwrap:
+			if err := vm.executeUnwrap(frame, ins[ip+1:]); err != nil {
+				return err
+			}
+
+		case code.OpGetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			value := vm.globals[index]
+			if value == nil {
+				return vm.notFound(vm.globalNames[index])
+			}
+			vm.push(value)
+
+		case code.OpSetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if err := vm.charge(&vm.callBytes, bindingSize); err != nil {
+				return err
+			}
+			vm.globals[index] = vm.pop()
+			if vm.framesIndex == 0 {
+				// a top level let has no value, as in the evaluator
+				vm.result = nil
+			}
+
+		case code.OpAssignGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if vm.globals[index] == nil {
+				return vm.notFound(vm.globalNames[index])
+			}
+			vm.globals[index] = vm.pop()
+
+		case code.OpGetLocal:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			value := vm.stack[frame.basePointer+index]
+			if value == nil {
+				return vm.notFound(frame.cl.Fn.Names[index])
+			}
+			vm.push(value)
+
+		case code.OpSetLocal:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.stack[frame.basePointer+index] = vm.pop()
+
+		case code.OpGetBuiltin:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.push(builtins[index])
+
+		case code.OpGetFree:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			value := frame.cl.Free[index].Value
+			if value == nil {
+				return vm.notFound(frame.cl.Fn.FreeNames[index])
+			}
+			vm.push(value)
+
+		case code.OpSetFree:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			frame.cl.Free[index].Value = vm.pop()
+
+		case code.OpGetCell:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			cell, _ := vm.stack[frame.basePointer+index].(*object.Cell)
+			if cell == nil || cell.Value == nil {
+				return vm.notFound(frame.cl.Fn.Names[index])
+			}
+			vm.push(cell.Value)
+
+		case code.OpSetCell:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.cellAt(frame, index).Value = vm.pop()
+
+		case code.OpNewCell:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.stack[frame.basePointer+index] = &object.Cell{Value: vm.pop()}
+
+		case code.OpCellRef:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.push(vm.cellAt(frame, index))
+
+		case code.OpFreeRef:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.push(frame.cl.Free[index])
+
+		case code.OpClosure:
+			index := code.ReadUint16(ins[ip+1:])
+			numFree := int(code.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+			vm.pushClosure(vm.constants[index].(*object.CompiledFunction), numFree)
+
+		case code.OpCall:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			if err := vm.executeCall(numArgs); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue, code.OpReturn:
+			value := object.Object(evaluator.NULL)
+			if op == code.OpReturnValue {
+				value = vm.pop()
+			}
+			if vm.framesIndex == 0 {
+				// a top level return ends the program
+				vm.result = value
+				return nil
+			}
+			if err := vm.returnFrom(value); err != nil {
+				return err
+			}
+
+		case code.OpCheckType:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			annotation := vm.constants[index].(*object.Annotation)
+			if err := evaluator.CheckAnnotation(annotation.Annotation, vm.stack[vm.sp-1], annotation.What, vm.location()); err != nil {
+				return err
+			}
+
+		default:
+			def, err := code.Lookup(byte(op))
+			if err != nil {
+				return vm.errorf(diag.UnknownNode, "%s", err)
+			}
+			return vm.errorf(diag.UnknownNode, "cannot execute %s", def.Name)
+		}
+	}
+}
+
+// executeBinaryOperation handles integers itself, as most arithmetic is on
+// them, and leaves everything else to the evaluator's rules
+func (vm *VM) executeBinaryOperation(op code.Opcode) *object.Error {
+	right := vm.pop()
+	left := vm.pop()
+
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		switch op {
+		case code.OpAdd:
+			if sum, ok := evaluator.AddInt(l.Value, r.Value); ok {
+				vm.push(&object.Integer{Value: sum})
+				return nil
+			}
+		case code.OpSub:
+			if diff, ok := evaluator.SubInt(l.Value, r.Value); ok {
+				vm.push(&object.Integer{Value: diff})
+				return nil
+			}
+		case code.OpMul:
+			if product, ok := evaluator.MulInt(l.Value, r.Value); ok {
+				vm.push(&object.Integer{Value: product})
+				return nil
+			}
+		case code.OpEqual:
+			vm.push(nativeBoolToBooleanObject(l.Value == r.Value))
+			return nil
+		case code.OpNotEqual:
+			vm.push(nativeBoolToBooleanObject(l.Value != r.Value))
+			return nil
+		case code.OpGreaterThan:
+			vm.push(nativeBoolToBooleanObject(l.Value > r.Value))
+			return nil
+		case code.OpLessThan:
+			vm.push(nativeBoolToBooleanObject(l.Value < r.Value))
+			return nil
+		}
+	}
+
+	result := evaluator.EvalInfixExpression(infixOperators[op], left, right, vm.location())
+	switch result := result.(type) {
+	case *object.Error:
+		return result
+	case *object.String:
+		if err := vm.charge(&vm.strings, stringSize+int64(len(result.Value))); err != nil {
+			return err
+		}
+	case *object.BigInt:
+		if err := vm.bigInt(result); err != nil {
+			return err
+		}
+	}
+	vm.push(result)
+	return nil
+}
+
+// bigInt checks a result that needed a big integer against StrictOverflow
+// and charges it like a string
+func (vm *VM) bigInt(result *object.BigInt) *object.Error {
+	if vm.opts.StrictOverflow {
+		return evaluator.CheckOverflow(result, vm.location())
+	}
+	return vm.charge(&vm.strings, bigIntSize+int64(len(result.Value.Bits()))*8)
+}
+
+// executeUnwrap leaves the value of some(v) on the stack, or jumps to the
+// alternative for none and null
+func (vm *VM) executeUnwrap(frame *Frame, operands code.Instructions) *object.Error {
+	frame.ip += 4
+	condition := vm.pop()
+	switch condition := condition.(type) {
+	case *object.Option:
+		if condition.Value == nil {
+			break
+		}
+		if err := vm.charge(&vm.callBytes, environmentSize); err != nil {
+			return err
+		}
+		vm.push(condition.Value)
+		return nil
+	case *object.Null:
+	default:
+		name := vm.constants[code.ReadUint16(operands[2:])].(*object.String)
+		return vm.errorf(diag.TypeMismatch, "cannot use %s as an option for if let %s", condition.Type(), name.Value)
+	}
+	frame.ip = int(code.ReadUint16(operands)) - 1
+	return nil
+}
+
+func (vm *VM) executeCall(numArgs int) *object.Error {
+	switch callee := vm.stack[vm.sp-1-numArgs].(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		result := evaluator.CallBuiltin(callee, vm.stack[vm.sp-numArgs:vm.sp], vm.location())
+		vm.sp = vm.sp - numArgs - 1
+		if err, ok := result.(*object.Error); ok {
+			return err
+		}
+		vm.push(result)
+		return nil
+	default:
+		return vm.errorf(diag.NotAFunction, "not a function: %s", callee.Type())
+	}
+}
+
+// callClosure pushes a frame for cl, whose arguments are the top numArgs
+// values on the stack. The checks are those of the evaluator's calls.
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) *object.Error {
+	fn := cl.Fn
+	loc := vm.location()
+	if vm.opts.MaxDepth > 0 && vm.framesIndex >= vm.opts.MaxDepth {
+		return object.NewError(diag.CallDepthExceeded, loc, "maximum call depth of %d exceeded", vm.opts.MaxDepth)
+	}
+	basePointer := vm.sp - numArgs
+	for i, param := range fn.Parameters {
+		if param.Type != nil && i < numArgs {
+			if err := evaluator.CheckAnnotation(param.Type, vm.stack[basePointer+i], "parameter "+param.Value, loc); err != nil {
+				return err
+			}
+		}
+	}
+	if numArgs < len(fn.Parameters) {
+		return object.NewError(diag.WrongArgumentCount, loc, "wrong number of arguments. got=%d, want=%d", numArgs, len(fn.Parameters))
+	}
+
+	// a call pays for all of its slots up front
+	callBytes := vm.callBytes
+	slots := max(numArgs, fn.NumLocals)
+	if err := vm.charge(&vm.callBytes, environmentSize+bindingSize*int64(slots)); err != nil {
+		return err
+	}
+
+	for basePointer+fn.NumLocals+StackSize > len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+	// extra arguments are ignored, and locals start out unbound
+	for i := len(fn.Parameters); i < fn.NumLocals; i++ {
+		vm.stack[basePointer+i] = nil
+	}
+
+	frame := NewFrame(cl, basePointer)
+	frame.call = loc
+	frame.frames = callBytes
+	vm.framesIndex++
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, frame)
+	} else {
+		vm.frames[vm.framesIndex] = frame
+	}
+	vm.sp = basePointer + fn.NumLocals
+	return nil
+}
+
+// returnFrom pops the current frame, replacing the function and its
+// arguments on the stack with value
+func (vm *VM) returnFrom(value object.Object) *object.Error {
+	frame := &vm.frames[vm.framesIndex]
+	vm.framesIndex--
+	vm.sp = frame.basePointer - 1
+
+	// a returned function may hold on to the call, anything else frees it
+	if _, ok := value.(*object.Closure); !ok {
+		vm.callBytes = frame.frames
+	}
+	if frame.cl.Fn.ReturnType != nil {
+		if err := evaluator.CheckAnnotation(frame.cl.Fn.ReturnType, value, "the result", frame.call); err != nil {
+			return err
+		}
+	}
+	vm.push(value)
+	return nil
+}
+
+// cellAt returns the cell in a local slot of frame, putting an empty one
+// there if the variable has not been bound yet
+func (vm *VM) cellAt(frame *Frame, index int) *object.Cell {
+	slot := &vm.stack[frame.basePointer+index]
+	cell, ok := (*slot).(*object.Cell)
+	if !ok {
+		cell = &object.Cell{}
+		*slot = cell
+	}
+	return cell
+}
+
+// pushClosure replaces the numFree cells on top of the stack with a
+// closure of fn capturing them
+func (vm *VM) pushClosure(fn *object.CompiledFunction, numFree int) {
+	free := make([]*object.Cell, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i].(*object.Cell)
+	}
+	vm.sp -= numFree
+	vm.push(&object.Closure{Fn: fn, Free: free})
+}
+
+// step counts an instruction against the step budget and, every so often,
+// checks whether the context has been cancelled
+func (vm *VM) step() *object.Error {
+	vm.steps++
+	if vm.opts.MaxSteps > 0 && vm.steps > vm.opts.MaxSteps {
+		return vm.errorf(diag.StepBudgetExceeded, "evaluation step budget of %d exhausted", vm.opts.MaxSteps)
+	}
+	if vm.ctx != nil && vm.steps%cancelCheckInterval == 0 {
+		if err := vm.ctx.Err(); err != nil {
+			return vm.errorf(diag.EvaluationCancelled, "evaluation cancelled: %s", err)
+		}
+	}
+	return nil
+}
+
+// charge adds size bytes to counter, failing once the total passes MaxMemory
+func (vm *VM) charge(counter *int64, size int64) *object.Error {
+	*counter += size
+	total := vm.strings + vm.callBytes
+	if total > vm.peak {
+		vm.peak = total
+	}
+	if vm.opts.MaxMemory > 0 && total > vm.opts.MaxMemory {
+		return vm.errorf(diag.MemoryLimitExceeded, "memory budget of %d bytes exceeded", vm.opts.MaxMemory)
+	}
+	return nil
+}
+
+// location returns the source location of the instruction being executed
+func (vm *VM) location() token.TokenLocation {
+	frame := &vm.frames[vm.framesIndex]
+	return frame.cl.Fn.Positions.Lookup(frame.ip)
+}
+
+func (vm *VM) errorf(code diag.Code, format string, a ...interface{}) *object.Error {
+	return object.NewError(code, vm.location(), format, a...)
+}
+
+func (vm *VM) notFound(name string) *object.Error {
+	return vm.errorf(diag.IdentifierNotFound, "identifier not found: %s", name)
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
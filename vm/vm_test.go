@@ -0,0 +1,274 @@
+package vm
+
+import (
+	"context"
+	"gosling/compiler"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/lexer"
+	"gosling/object"
+	"gosling/parser"
+	"testing"
+	"time"
+)
+
+func parse(t testing.TB, input string) *compiler.Bytecode {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	return comp.Bytecode()
+}
+
+func run(t testing.TB, input string, opts evaluator.Options) object.Object {
+	return New(parse(t, input), opts).Run(context.Background())
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // what the result inspects as, or the error message
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", "50"},
+		{"7 % 3", "1"},
+		{`"foo" + "bar"`, "foobar"},
+		{"1 < 2 == true", "true"},
+		{"!5", "unknown operator: !INTEGER"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"if (false) { 10 }", "null"},
+		{"let a = 1; let b = a + 1; b", "2"},
+		{"let a = 1;", "<nil>"},
+		{"let a = 1; a = a + 1; a", "2"},
+		{"let i = 0; let sum = 0; for (i < 10) { i = i + 1; sum = sum + i; } sum;", "55"},
+		{"let f = fn(a, b) { a + b }; f(1, 2)", "3"},
+		{"let f = fn(a) { let b = a * 2; b }; f(4)", "8"},
+		{"let f = fn() { return 1; 2 }; f()", "1"},
+		{"let f = fn() { let x = 1; }; f()", "null"},
+		{"let f = fn(a) { a }; f(1, 2)", "1"},
+		{"return 5; 10", "5"},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)", "610"},
+		{`len("four")`, "4"},
+		{"let len = 1; len", "1"},
+		{"if let x = some(5) { x * 2 }", "10"},
+		{"if let x = none { x } else { 0 }", "0"},
+		{"let x = 1; if let x = some(2) { x }; x", "1"},
+		{"let f = fn(o) { if let v = o { v } else { -1 } }; f(some(3)) + f(none)", "2"},
+		{"foobar", "identifier not found: foobar"},
+		{"let f = fn() { let x = x; x }; f()", "identifier not found: x"},
+		{"b = 5;", "identifier not found: b"},
+		{"10 / 0", "division by zero"},
+		{"let x = 5; x(1);", "not a function: INTEGER"},
+		{"let f = fn(a, b) { a }; f(1);", "wrong number of arguments. got=1, want=2"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{"none + 1", "cannot use none as an operand of +, check it with if let first"},
+		{"if let x = 5 { x }", "cannot use INTEGER as an option for if let x"},
+		{`let x: int = "a";`, "cannot use STRING as int for let x"},
+		{`let f = fn(a: int) { a }; f("a");`, "cannot use STRING as int for parameter a"},
+		{`let f = fn(a) -> string { a }; f(1);`, "cannot use INTEGER as string for the result"},
+		{`if let x: int = some("a") { x }`, "cannot use STRING as int for if let x"},
+		{"let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) }; apply(fn(n) { n * 2 }, 4);", "8"},
+		{"let adder = fn(a) { fn(b) { a + b } }; let addTwo = adder(2); addTwo(3)", "5"},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", "6"},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c(); c()", "3"},
+		{"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()", "2"},
+		{"let f = fn() { let even = fn(n) { if (n == 0) { true } else { !even(n - 1) } }; even(4) }; f()", "true"},
+		{"let f = fn(o) { if let v = o { fn() { v } } else { fn() { 0 } } }; f(some(7))()", "7"},
+		{"let f = fn() { let g = fn() { y }; let y = 1; g() }; f()", "1"},
+		{"let f = fn() { let g = fn() { y }; g() }; f()", "identifier not found: y"},
+		{"let f: fn(int) -> int = fn(a: int) -> int { a }; f(1)", "1"},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input, evaluator.Options{})
+		got := inspect(result)
+		if errObj, ok := result.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// TestMatchesEvaluator runs programs with both engines, expecting the same
+// results and the same errors at the same locations
+func TestMatchesEvaluator(t *testing.T) {
+	inputs := []string{
+		"5 + true;",
+		"5; true + false; 5",
+		"if (10 > 1) { true + false; }",
+		"-true",
+		`"Hello" - "World"`,
+		"let f = fn(x) {\n  x / 0\n};\nf(1);",
+		"let f = fn(a: int) { a };\nf(true);",
+		"let f = fn() -> int { if (false) { 1 } };\nf();",
+		"let f = fn(a, b) { a };\n  f(1);",
+		"let f = fn() { y };\nf();",
+		"1 * some(2)",
+		"-none",
+		"if let v = 1 { v }",
+		"let x: Option<int> = some(1); x",
+		"let find = fn(n) { if (n > 0) { some(n) } else { none } }; if let v = find(3) { v } else { 0 }",
+		"some(1) != some(2)",
+		"!none",
+		"exists(if (false) { 1 })",
+		"for (false) { 10 }",
+		"let f = fn() { for (true) { return 5; } }; f();",
+		"let a = 1; let f = fn() { a = 10; }; f(); a;",
+		"let a = 1; let f = fn(a) { a = 10; }; f(2); a;",
+		"let id = fn<T>(x: T) -> T { x }; id(5);",
+		"let f = fn(n) { f(n) + 1 }; f(1);",
+		"let adder = fn(a) { fn(b) { a + b } }; adder(1)(true);",
+		"let f = fn() { let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n }; f();",
+		"let f = fn() { let g = fn() {\n y }; g() }; f();",
+		"let f = fn() { let g = fn() { z = 1 }; g() }; f();",
+		"let f = fn(a) { fn() { a } }; f(1) == f(1);",
+		"9223372036854775807 + 1",
+		"-9223372036854775807 - 2 - 1 + 2",
+		"-9223372036854775808 / -1",
+		"-(-9223372036854775808)",
+		"99999999999999999999 * 99999999999999999999 % 7",
+		"99999999999999999999 / 0",
+		"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25) / f(23)",
+		"len(1)",
+		`len("one", "two")`,
+		"let x = 1;\nsome(1, 2)",
+		"1 + exists()",
+	}
+
+	for _, input := range inputs {
+		l := lexer.New(input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		want := inspect(evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{}))
+
+		got := inspect(run(t, input, evaluator.Options{}))
+		if got != want {
+			t.Errorf("engines differ for %q.\nevaluator=%q\nvm=%q", input, want, got)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	countdown := "let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(10);"
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		opts     evaluator.Options
+		expected diag.Code
+	}{
+		{countdown, context.Background(), evaluator.Options{MaxDepth: 5}, diag.CallDepthExceeded},
+		{countdown, context.Background(), evaluator.Options{MaxDepth: 11}, ""},
+		{countdown, context.Background(), evaluator.Options{MaxDepth: -1}, ""},
+		{"for (true) { }", context.Background(), evaluator.Options{MaxSteps: 1000}, diag.StepBudgetExceeded},
+		{"for (true) { }", context.Background(), evaluator.Options{Timeout: 10 * time.Millisecond}, diag.EvaluationCancelled},
+		{"for (true) { }", cancelled, evaluator.Options{}, diag.EvaluationCancelled},
+		{`let s = "ab"; for (true) { s = s + s; }`, context.Background(), evaluator.Options{MaxMemory: 1 << 20}, diag.MemoryLimitExceeded},
+		{"let n = 2; for (true) { n = n * n; }", context.Background(), evaluator.Options{MaxMemory: 1 << 20}, diag.MemoryLimitExceeded},
+		{"let n = 2; for (true) { n = n * n; }", context.Background(), evaluator.Options{StrictOverflow: true}, diag.IntegerOverflow},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(9000);", context.Background(), evaluator.Options{}, ""},
+	}
+
+	for _, tt := range tests {
+		result := New(parse(t, tt.input), tt.opts).Run(tt.ctx)
+		if tt.expected == "" {
+			if inspect(result) != "0" {
+				t.Errorf("wrong result for %q with %+v. got=%s", tt.input, tt.opts, inspect(result))
+			}
+			continue
+		}
+
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q with %+v, got=%T(%+v)", tt.input, tt.opts, result, result)
+			continue
+		}
+		if errObj.Code != tt.expected {
+			t.Errorf("wrong error code for %q with %+v, expected=%s, got=%s", tt.input, tt.opts, tt.expected, errObj.Code)
+		}
+		if errObj.Location.LineCh == 0 {
+			t.Errorf("error for %q is not located: %s", tt.input, errObj.Inspect())
+		}
+	}
+}
+
+// TestGlobalsStore runs lines one after another, as the REPL does
+func TestGlobalsStore(t *testing.T) {
+	lines := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;", "<nil>"},
+		{"let f = fn(x) { x + a };", "<nil>"},
+		{"a = 10; f(1)", "11"},
+		{"b", "identifier not found: b"},
+		{"let b = f(a); b", "20"},
+	}
+
+	symbolTable := compiler.New().SymbolTable()
+	constants := []object.Object{}
+	var globals []object.Object
+
+	for _, line := range lines {
+		l := lexer.New(line.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error for %q: %s", line.input, err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := NewWithGlobalsStore(bytecode, globals, evaluator.Options{})
+		result := machine.Run(context.Background())
+		globals = machine.Globals()
+
+		got := inspect(result)
+		if errObj, ok := result.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != line.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", line.input, line.expected, got)
+		}
+	}
+}
+
+const fibProgram = "let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(25);"
+
+func BenchmarkFib(b *testing.B) {
+	l := lexer.New(fibProgram)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	b.Run("tree", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{})
+		}
+	})
+	b.Run("vm", func(b *testing.B) {
+		bytecode := parse(b, fibProgram)
+		for i := 0; i < b.N; i++ {
+			New(bytecode, evaluator.Options{}).Run(context.Background())
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package wasm
+
+import "fmt"
+
+// opcodes of the instructions without immediates
+var opcodes = map[string][]byte{
+	"unreachable": {0x00},
+	"return":      {0x0F},
+	"drop":        {0x1A},
+	"select":      {0x1B},
+
+	"i32.eqz":  {0x45},
+	"i32.eq":   {0x46},
+	"i32.ne":   {0x47},
+	"i32.ge_u": {0x4F},
+	"i32.le_u": {0x4D},
+	"i64.eqz":  {0x50},
+	"i64.eq":   {0x51},
+	"i64.ne":   {0x52},
+	"i64.lt_s": {0x53},
+	"i64.gt_s": {0x55},
+
+	"i32.add":   {0x6A},
+	"i32.sub":   {0x6B},
+	"i32.and":   {0x71},
+	"i32.shl":   {0x74},
+	"i32.shr_u": {0x76},
+	"i64.add":   {0x7C},
+	"i64.sub":   {0x7D},
+	"i64.mul":   {0x7E},
+	"i64.div_s": {0x7F},
+	"i64.rem_s": {0x81},
+	"i64.and":   {0x83},
+	"i64.xor":   {0x85},
+
+	"i32.wrap_i64":     {0xA7},
+	"i64.extend_i32_u": {0xAD},
+
+	"memory.size": {0x3F, 0x00},
+	"memory.grow": {0x40, 0x00},
+	"memory.copy": {0xFC, 0x0A, 0x00, 0x00},
+}
+
+// memory instructions with their opcodes and natural alignment
+var memoryOps = map[string]struct {
+	code  byte
+	align uint64
+}{
+	"i32.load":    {0x28, 2},
+	"i64.load":    {0x29, 3},
+	"i32.load8_u": {0x2D, 0},
+	"i32.store":   {0x36, 2},
+	"i64.store":   {0x37, 3},
+	"i32.store8":  {0x3A, 0},
+}
+
+func constant(t valType, v int64) instr {
+	if t == i64 {
+		return instr{text: fmt.Sprintf("i64.const %d", v), code: append([]byte{0x42}, sleb(v)...)}
+	}
+	return instr{text: fmt.Sprintf("i32.const %d", int32(v)), code: append([]byte{0x41}, sleb(int64(int32(v)))...)}
+}
+
+// builder appends instructions to the body of a function
+type builder struct {
+	c *compiler
+	f *function
+}
+
+func (b *builder) add(in instr) {
+	b.f.body = append(b.f.body, in)
+}
+
+func (b *builder) op(name string) {
+	code, ok := opcodes[name]
+	if !ok {
+		panic("wasm: unknown instruction " + name)
+	}
+	b.add(instr{text: name, code: code})
+}
+
+func (b *builder) i32(v int32) { b.add(constant(i32, int64(v))) }
+
+func (b *builder) i64(v int64) { b.add(constant(i64, v)) }
+
+// index returns the index of a parameter or local declared before
+func (b *builder) index(name string) int {
+	for i, p := range b.f.params {
+		if p.name == name {
+			return i
+		}
+	}
+	for i, l := range b.f.locals {
+		if l.name == name {
+			return len(b.f.params) + i
+		}
+	}
+	panic("wasm: undeclared local " + name)
+}
+
+func (b *builder) declare(name string, t valType) {
+	b.f.locals = append(b.f.locals, local{name, t})
+}
+
+func (b *builder) localOp(op string, code byte, name string) {
+	b.add(instr{text: op + " " + name, code: append([]byte{code}, uleb(uint64(b.index(name)))...)})
+}
+
+func (b *builder) get(name string) { b.localOp("local.get", 0x20, name) }
+
+func (b *builder) set(name string) { b.localOp("local.set", 0x21, name) }
+
+func (b *builder) tee(name string) { b.localOp("local.tee", 0x22, name) }
+
+func (b *builder) globalGet(index int) {
+	b.add(instr{text: "global.get " + b.c.m.globals[index].name, code: append([]byte{0x23}, uleb(uint64(index))...)})
+}
+
+func (b *builder) globalSet(index int) {
+	b.add(instr{text: "global.set " + b.c.m.globals[index].name, code: append([]byte{0x24}, uleb(uint64(index))...)})
+}
+
+func (b *builder) call(index int) {
+	b.add(instr{text: "call " + b.c.m.functionName(index), code: append([]byte{0x10}, uleb(uint64(index))...)})
+}
+
+// callHelper calls a function of the runtime by name
+func (b *builder) callHelper(name string) {
+	b.call(b.c.helpers[name])
+}
+
+func (b *builder) callIndirect(sig signature) {
+	typ := b.c.m.typeIndex(sig)
+	code := append([]byte{0x11}, uleb(uint64(typ))...)
+	b.add(instr{text: fmt.Sprintf("call_indirect (type %d)", typ), code: append(code, 0x00)})
+}
+
+// memory writes a load or store at the given offset from the address on
+// the stack
+func (b *builder) memory(op string, offset int) {
+	m := memoryOps[op]
+	text := op
+	if offset != 0 {
+		text = fmt.Sprintf("%s offset=%d", op, offset)
+	}
+	code := append([]byte{m.code}, uleb(m.align)...)
+	b.add(instr{text: text, code: append(code, uleb(uint64(offset))...)})
+}
+
+func load(t valType) string {
+	if t == i64 {
+		return "i64.load"
+	}
+	return "i32.load"
+}
+
+func store(t valType) string {
+	if t == i64 {
+		return "i64.store"
+	}
+	return "i32.store"
+}
+
+// open starts a block, loop or if whose value, if any, has type result
+func (b *builder) open(kind string, result valType) {
+	codes := map[string]byte{"block": 0x02, "loop": 0x03, "if": 0x04}
+	if result == 0 {
+		b.add(instr{text: kind, code: []byte{codes[kind], 0x40}, nesting: opens})
+		return
+	}
+	b.add(instr{text: fmt.Sprintf("%s (result %s)", kind, result), code: []byte{codes[kind], byte(result)}, nesting: opens})
+}
+
+func (b *builder) else_() { b.add(instr{text: "else", code: []byte{0x05}, nesting: reopens}) }
+
+func (b *builder) end() { b.add(instr{text: "end", code: []byte{0x0B}, nesting: closes}) }
+
+func (b *builder) br(depth int) {
+	b.add(instr{text: fmt.Sprintf("br %d", depth), code: append([]byte{0x0C}, uleb(uint64(depth))...)})
+}
+
+func (b *builder) brIf(depth int) {
+	b.add(instr{text: fmt.Sprintf("br_if %d", depth), code: append([]byte{0x0D}, uleb(uint64(depth))...)})
+}
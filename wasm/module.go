@@ -0,0 +1,324 @@
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// valType is a WebAssembly value type, 0 standing for no value
+type valType byte
+
+const (
+	i32 valType = 0x7F
+	i64 valType = 0x7E
+)
+
+func (t valType) String() string {
+	switch t {
+	case i32:
+		return "i32"
+	case i64:
+		return "i64"
+	}
+	return ""
+}
+
+type signature struct {
+	params []valType
+	result valType
+}
+
+func (s signature) key() string {
+	return fmt.Sprint(s.params, s.result)
+}
+
+func (s signature) text() string {
+	var out strings.Builder
+	out.WriteString("(func")
+	if len(s.params) > 0 {
+		out.WriteString(" (param")
+		for _, p := range s.params {
+			out.WriteString(" " + p.String())
+		}
+		out.WriteString(")")
+	}
+	if s.result != 0 {
+		out.WriteString(" (result " + s.result.String() + ")")
+	}
+	return out.String() + ")"
+}
+
+type local struct {
+	name string
+	typ  valType
+}
+
+// function is a function defined by the module
+type function struct {
+	name   string
+	typ    int
+	params []local
+	result valType
+	locals []local
+	body   []instr
+}
+
+// nesting says how an instruction changes the depth of blocks, for
+// indenting the text
+type nesting int
+
+const (
+	flat nesting = iota
+	opens
+	closes
+	reopens // else
+)
+
+// instr is an instruction in both its forms
+type instr struct {
+	text    string
+	code    []byte
+	nesting nesting
+}
+
+type global struct {
+	name string
+	typ  valType
+	init int64
+}
+
+type import_ struct {
+	module, name string
+	funcName     string
+	typ          int
+}
+
+// Module is a compiled program
+type Module struct {
+	types     []signature
+	imports   []import_
+	functions []*function
+	globals   []global
+	// table holds the functions that closures can call, by their index in
+	// the function space
+	table []int
+	start int
+	pages int
+	// data is placed in memory at dataOffset
+	data []byte
+}
+
+// typeIndex returns the index of sig in the type section, adding it if
+// it is new
+func (m *Module) typeIndex(sig signature) int {
+	for i, t := range m.types {
+		if t.key() == sig.key() {
+			return i
+		}
+	}
+	m.types = append(m.types, sig)
+	return len(m.types) - 1
+}
+
+func (m *Module) functionName(index int) string {
+	if index < len(m.imports) {
+		return m.imports[index].funcName
+	}
+	return m.functions[index-len(m.imports)].name
+}
+
+// Binary encodes the module in the WebAssembly binary format
+func (m *Module) Binary() []byte {
+	var out bytes.Buffer
+	out.Write([]byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00})
+
+	section := func(id byte, count int, write func(b *bytes.Buffer)) {
+		var b bytes.Buffer
+		b.Write(uleb(uint64(count)))
+		write(&b)
+		out.WriteByte(id)
+		out.Write(uleb(uint64(b.Len())))
+		out.Write(b.Bytes())
+	}
+
+	section(1, len(m.types), func(b *bytes.Buffer) {
+		for _, t := range m.types {
+			b.WriteByte(0x60)
+			b.Write(uleb(uint64(len(t.params))))
+			for _, p := range t.params {
+				b.WriteByte(byte(p))
+			}
+			if t.result == 0 {
+				b.WriteByte(0)
+			} else {
+				b.Write([]byte{1, byte(t.result)})
+			}
+		}
+	})
+	section(2, len(m.imports), func(b *bytes.Buffer) {
+		for _, im := range m.imports {
+			b.Write(name(im.module))
+			b.Write(name(im.name))
+			b.WriteByte(0x00)
+			b.Write(uleb(uint64(im.typ)))
+		}
+	})
+	section(3, len(m.functions), func(b *bytes.Buffer) {
+		for _, f := range m.functions {
+			b.Write(uleb(uint64(f.typ)))
+		}
+	})
+	section(4, 1, func(b *bytes.Buffer) {
+		b.Write([]byte{0x70, 0x00})
+		b.Write(uleb(uint64(len(m.table))))
+	})
+	section(5, 1, func(b *bytes.Buffer) {
+		b.WriteByte(0x00)
+		b.Write(uleb(uint64(m.pages)))
+	})
+	section(6, len(m.globals), func(b *bytes.Buffer) {
+		for _, g := range m.globals {
+			b.Write([]byte{byte(g.typ), 0x01})
+			b.Write(constant(g.typ, g.init).code)
+			b.WriteByte(0x0B)
+		}
+	})
+	section(7, 2, func(b *bytes.Buffer) {
+		b.Write(name("memory"))
+		b.Write([]byte{0x02, 0x00})
+		b.Write(name("_start"))
+		b.WriteByte(0x00)
+		b.Write(uleb(uint64(m.start)))
+	})
+	if len(m.table) > 0 {
+		section(9, 1, func(b *bytes.Buffer) {
+			b.WriteByte(0x00)
+			b.Write(constant(i32, 0).code)
+			b.WriteByte(0x0B)
+			b.Write(uleb(uint64(len(m.table))))
+			for _, f := range m.table {
+				b.Write(uleb(uint64(f)))
+			}
+		})
+	}
+	section(10, len(m.functions), func(b *bytes.Buffer) {
+		for _, f := range m.functions {
+			var code bytes.Buffer
+			code.Write(uleb(uint64(len(f.locals))))
+			for _, l := range f.locals {
+				code.WriteByte(1)
+				code.WriteByte(byte(l.typ))
+			}
+			for _, in := range f.body {
+				code.Write(in.code)
+			}
+			code.WriteByte(0x0B)
+			b.Write(uleb(uint64(code.Len())))
+			b.Write(code.Bytes())
+		}
+	})
+	section(11, 1, func(b *bytes.Buffer) {
+		b.WriteByte(0x00)
+		b.Write(constant(i32, dataOffset).code)
+		b.WriteByte(0x0B)
+		b.Write(uleb(uint64(len(m.data))))
+		b.Write(m.data)
+	})
+	return out.Bytes()
+}
+
+// Text writes the module in the WebAssembly text format
+func (m *Module) Text() string {
+	var out strings.Builder
+	out.WriteString("(module\n")
+	for i, t := range m.types {
+		fmt.Fprintf(&out, "  (type (;%d;) %s)\n", i, t.text())
+	}
+	for _, im := range m.imports {
+		fmt.Fprintf(&out, "  (import %q %q (func %s (type %d)))\n", im.module, im.name, im.funcName, im.typ)
+	}
+	for _, f := range m.functions {
+		fmt.Fprintf(&out, "  (func %s (type %d)", f.name, f.typ)
+		for _, p := range f.params {
+			fmt.Fprintf(&out, " (param %s %s)", p.name, p.typ)
+		}
+		if f.result != 0 {
+			fmt.Fprintf(&out, " (result %s)", f.result)
+		}
+		out.WriteString("\n")
+		for _, l := range f.locals {
+			fmt.Fprintf(&out, "    (local %s %s)\n", l.name, l.typ)
+		}
+		depth := 2
+		for _, in := range f.body {
+			if in.nesting == closes || in.nesting == reopens {
+				depth--
+			}
+			fmt.Fprintf(&out, "%s%s\n", strings.Repeat("  ", depth), in.text)
+			if in.nesting == opens || in.nesting == reopens {
+				depth++
+			}
+		}
+		out.WriteString("  )\n")
+	}
+	fmt.Fprintf(&out, "  (table %d funcref)\n", len(m.table))
+	fmt.Fprintf(&out, "  (memory %d)\n", m.pages)
+	for _, g := range m.globals {
+		fmt.Fprintf(&out, "  (global %s (mut %s) (%s))\n", g.name, g.typ, constant(g.typ, g.init).text)
+	}
+	out.WriteString("  (export \"memory\" (memory 0))\n")
+	fmt.Fprintf(&out, "  (export \"_start\" (func %s))\n", m.functionName(m.start))
+	if len(m.table) > 0 {
+		out.WriteString("  (elem (i32.const 0) func")
+		for _, f := range m.table {
+			out.WriteString(" " + m.functionName(f))
+		}
+		out.WriteString(")\n")
+	}
+	fmt.Fprintf(&out, "  (data (i32.const %d) \"%s\")\n", dataOffset, escape(m.data))
+	out.WriteString(")\n")
+	return out.String()
+}
+
+// escape writes b as the contents of a string in the text format
+func escape(b []byte) string {
+	var out strings.Builder
+	for _, c := range b {
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&out, "\\%02x", c)
+		} else {
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+func uleb(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7F)
+		v >>= 7
+		if v != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+func sleb(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func name(s string) []byte {
+	return append(uleb(uint64(len(s))), s...)
+}
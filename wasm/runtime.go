@@ -0,0 +1,420 @@
+package wasm
+
+import (
+	"gosling/diag"
+	"gosling/token"
+	"math"
+)
+
+// The low addresses of memory are scratch space for the runtime: the two
+// iovecs and the count fd_write takes, the newline printed after every
+// value and the digits of an integer being printed, which are written
+// backwards from dataOffset.
+const (
+	iovecs     = 0
+	written    = 16
+	newline    = 20
+	dataOffset = 64
+)
+
+// helper is a function of the runtime every module carries
+type helper struct {
+	name   string
+	params []local
+	result valType
+	locals []local
+	body   func(b *builder)
+}
+
+// runtime adds the imports and the runtime's functions to the module
+func (c *compiler) runtime() {
+	wasi := []struct {
+		name string
+		sig  signature
+	}{
+		{"fd_write", signature{params: []valType{i32, i32, i32, i32}, result: i32}},
+		{"proc_exit", signature{params: []valType{i32}}},
+	}
+	for _, w := range wasi {
+		c.helpers[w.name] = len(c.m.imports)
+		c.m.imports = append(c.m.imports, import_{module: "wasi_snapshot_preview1", name: w.name, funcName: "$" + w.name, typ: c.m.typeIndex(w.sig)})
+	}
+
+	helpers := []helper{
+		{"write", []local{{"$fd", i32}, {"$ptr", i32}, {"$len", i32}}, 0, nil, writeBody},
+		{"fail", []local{{"$message", i32}}, 0, nil, failBody},
+		{"alloc", []local{{"$size", i32}}, i32, []local{{"$p", i32}, {"$end", i32}}, c.allocBody},
+		{"print_string", []local{{"$s", i32}}, 0, nil, printStringBody},
+		{"print_bool", []local{{"$b", i32}}, 0, nil, c.printBoolBody},
+		{"print_int", []local{{"$v", i64}}, 0, []local{{"$pos", i32}, {"$neg", i32}}, printIntBody},
+		{"concat", []local{{"$a", i32}, {"$b", i32}}, i32, []local{{"$la", i32}, {"$lb", i32}, {"$p", i32}}, concatBody},
+		{"string_equal", []local{{"$a", i32}, {"$b", i32}}, i32, []local{{"$n", i32}, {"$i", i32}}, stringEqualBody},
+		{"add", []local{{"$a", i64}, {"$b", i64}, {"$message", i32}}, i64, []local{{"$r", i64}}, addBody},
+		{"sub", []local{{"$a", i64}, {"$b", i64}, {"$message", i32}}, i64, []local{{"$r", i64}}, subBody},
+		{"mul", []local{{"$a", i64}, {"$b", i64}, {"$message", i32}}, i64, []local{{"$r", i64}}, mulBody},
+		{"div", []local{{"$a", i64}, {"$b", i64}, {"$zero", i32}, {"$overflow", i32}}, i64, nil, divBody},
+		{"rem", []local{{"$a", i64}, {"$b", i64}, {"$zero", i32}}, i64, nil, remBody},
+		{"neg", []local{{"$a", i64}, {"$message", i32}}, i64, nil, negBody},
+	}
+	// declare them all first, as they call each other
+	functions := make([]*function, len(helpers))
+	for i, h := range helpers {
+		sig := signature{result: h.result}
+		for _, p := range h.params {
+			sig.params = append(sig.params, p.typ)
+		}
+		functions[i] = c.function("$"+h.name, h.params, h.result, sig)
+		c.helpers[h.name] = len(c.m.imports) + len(c.m.functions) - 1
+	}
+	for i, h := range helpers {
+		functions[i].locals = append(functions[i].locals, h.locals...)
+		h.body(&builder{c: c, f: functions[i]})
+	}
+}
+
+// writeBody writes len bytes at ptr and a newline to the file descriptor
+func writeBody(b *builder) {
+	b.i32(iovecs)
+	b.get("$ptr")
+	b.memory("i32.store", 0)
+	b.i32(iovecs + 4)
+	b.get("$len")
+	b.memory("i32.store", 0)
+	b.i32(iovecs + 8)
+	b.i32(newline)
+	b.memory("i32.store", 0)
+	b.i32(iovecs + 12)
+	b.i32(1)
+	b.memory("i32.store", 0)
+	b.i32(newline)
+	b.i32('\n')
+	b.memory("i32.store8", 0)
+	b.get("$fd")
+	b.i32(iovecs)
+	b.i32(2)
+	b.i32(written)
+	b.callHelper("fd_write")
+	b.op("drop")
+}
+
+// failBody prints the message of a run time error and exits with status 1
+func failBody(b *builder) {
+	b.i32(2)
+	b.get("$message")
+	b.i32(4)
+	b.op("i32.add")
+	b.get("$message")
+	b.memory("i32.load", 0)
+	b.callHelper("write")
+	b.i32(1)
+	b.callHelper("proc_exit")
+	b.op("unreachable")
+}
+
+// allocBody returns size bytes of zeroed memory, growing the memory when
+// the heap reaches its end. Nothing is ever freed.
+func (c *compiler) allocBody(b *builder) {
+	message := c.message(diag.MemoryLimitExceeded, token.TokenLocation{Filename: c.filename}, "memory limit exceeded: out of linear memory")
+	b.globalGet(heap)
+	b.set("$p")
+	b.get("$p")
+	b.get("$size")
+	b.op("i32.add")
+	b.i32(7)
+	b.op("i32.add")
+	b.i32(-8)
+	b.op("i32.and")
+	b.tee("$end")
+	b.globalSet(heap)
+	b.open("block", 0)
+	b.get("$end")
+	b.op("memory.size")
+	b.i32(16)
+	b.op("i32.shl")
+	b.op("i32.le_u")
+	b.brIf(0)
+	b.get("$end")
+	b.op("memory.size")
+	b.i32(16)
+	b.op("i32.shl")
+	b.op("i32.sub")
+	b.i32(0xFFFF)
+	b.op("i32.add")
+	b.i32(16)
+	b.op("i32.shr_u")
+	b.op("memory.grow")
+	b.i32(-1)
+	b.op("i32.ne")
+	b.brIf(0)
+	b.i32(message)
+	b.callHelper("fail")
+	b.end()
+	b.get("$p")
+}
+
+func printStringBody(b *builder) {
+	b.i32(1)
+	b.get("$s")
+	b.i32(4)
+	b.op("i32.add")
+	b.get("$s")
+	b.memory("i32.load", 0)
+	b.callHelper("write")
+}
+
+func (c *compiler) printBoolBody(b *builder) {
+	b.i32(c.stringData("true"))
+	b.i32(c.stringData("false"))
+	b.get("$b")
+	b.op("select")
+	b.callHelper("print_string")
+}
+
+// printIntBody writes the digits backwards from dataOffset, working on
+// the negated value so that the smallest integer needs no special case
+func printIntBody(b *builder) {
+	b.i32(dataOffset)
+	b.set("$pos")
+	b.get("$v")
+	b.i64(0)
+	b.op("i64.lt_s")
+	b.tee("$neg")
+	b.op("i32.eqz")
+	b.open("if", 0)
+	b.i64(0)
+	b.get("$v")
+	b.op("i64.sub")
+	b.set("$v")
+	b.end()
+	b.open("loop", 0)
+	b.get("$pos")
+	b.i32(1)
+	b.op("i32.sub")
+	b.tee("$pos")
+	b.i32('0')
+	b.i64(0)
+	b.get("$v")
+	b.i64(10)
+	b.op("i64.rem_s")
+	b.op("i64.sub")
+	b.op("i32.wrap_i64")
+	b.op("i32.add")
+	b.memory("i32.store8", 0)
+	b.get("$v")
+	b.i64(10)
+	b.op("i64.div_s")
+	b.tee("$v")
+	b.op("i64.eqz")
+	b.op("i32.eqz")
+	b.brIf(0)
+	b.end()
+	b.get("$neg")
+	b.open("if", 0)
+	b.get("$pos")
+	b.i32(1)
+	b.op("i32.sub")
+	b.tee("$pos")
+	b.i32('-')
+	b.memory("i32.store8", 0)
+	b.end()
+	b.i32(1)
+	b.get("$pos")
+	b.i32(dataOffset)
+	b.get("$pos")
+	b.op("i32.sub")
+	b.callHelper("write")
+}
+
+func concatBody(b *builder) {
+	b.get("$a")
+	b.memory("i32.load", 0)
+	b.set("$la")
+	b.get("$b")
+	b.memory("i32.load", 0)
+	b.set("$lb")
+	b.get("$la")
+	b.get("$lb")
+	b.op("i32.add")
+	b.i32(4)
+	b.op("i32.add")
+	b.callHelper("alloc")
+	b.tee("$p")
+	b.get("$la")
+	b.get("$lb")
+	b.op("i32.add")
+	b.memory("i32.store", 0)
+	b.get("$p")
+	b.i32(4)
+	b.op("i32.add")
+	b.get("$a")
+	b.i32(4)
+	b.op("i32.add")
+	b.get("$la")
+	b.op("memory.copy")
+	b.get("$p")
+	b.i32(4)
+	b.op("i32.add")
+	b.get("$la")
+	b.op("i32.add")
+	b.get("$b")
+	b.i32(4)
+	b.op("i32.add")
+	b.get("$lb")
+	b.op("memory.copy")
+	b.get("$p")
+}
+
+func stringEqualBody(b *builder) {
+	b.get("$a")
+	b.memory("i32.load", 0)
+	b.tee("$n")
+	b.get("$b")
+	b.memory("i32.load", 0)
+	b.op("i32.ne")
+	b.open("if", 0)
+	b.i32(0)
+	b.op("return")
+	b.end()
+	b.open("block", 0)
+	b.open("loop", 0)
+	b.get("$i")
+	b.get("$n")
+	b.op("i32.ge_u")
+	b.brIf(1)
+	b.get("$a")
+	b.get("$i")
+	b.op("i32.add")
+	b.memory("i32.load8_u", 4)
+	b.get("$b")
+	b.get("$i")
+	b.op("i32.add")
+	b.memory("i32.load8_u", 4)
+	b.op("i32.ne")
+	b.open("if", 0)
+	b.i32(0)
+	b.op("return")
+	b.end()
+	b.get("$i")
+	b.i32(1)
+	b.op("i32.add")
+	b.set("$i")
+	b.br(0)
+	b.end()
+	b.end()
+	b.i32(1)
+}
+
+// failIf calls fail with the message in local when the condition on the
+// stack is true
+func failIf(b *builder, message string) {
+	b.open("if", 0)
+	b.get(message)
+	b.callHelper("fail")
+	b.end()
+}
+
+// addBody adds with the overflow check of evaluator.AddInt
+func addBody(b *builder) {
+	b.get("$a")
+	b.get("$b")
+	b.op("i64.add")
+	b.set("$r")
+	b.get("$a")
+	b.get("$r")
+	b.op("i64.xor")
+	b.get("$b")
+	b.get("$r")
+	b.op("i64.xor")
+	b.op("i64.and")
+	b.i64(0)
+	b.op("i64.lt_s")
+	failIf(b, "$message")
+	b.get("$r")
+}
+
+// subBody subtracts with the overflow check of evaluator.SubInt
+func subBody(b *builder) {
+	b.get("$a")
+	b.get("$b")
+	b.op("i64.sub")
+	b.set("$r")
+	b.get("$a")
+	b.get("$b")
+	b.op("i64.xor")
+	b.get("$a")
+	b.get("$r")
+	b.op("i64.xor")
+	b.op("i64.and")
+	b.i64(0)
+	b.op("i64.lt_s")
+	failIf(b, "$message")
+	b.get("$r")
+}
+
+// mulBody multiplies, checking that dividing the product by a gives back
+// b. a = -1 is checked apart as the division itself would trap.
+func mulBody(b *builder) {
+	b.get("$a")
+	b.get("$b")
+	b.op("i64.mul")
+	b.set("$r")
+	b.open("block", 0)
+	b.get("$a")
+	b.op("i64.eqz")
+	b.brIf(0)
+	b.get("$a")
+	b.i64(-1)
+	b.op("i64.eq")
+	b.open("if", i32)
+	b.get("$b")
+	b.i64(math.MinInt64)
+	b.op("i64.eq")
+	b.else_()
+	b.get("$r")
+	b.get("$a")
+	b.op("i64.div_s")
+	b.get("$b")
+	b.op("i64.ne")
+	b.end()
+	failIf(b, "$message")
+	b.end()
+	b.get("$r")
+}
+
+func divBody(b *builder) {
+	b.get("$b")
+	b.op("i64.eqz")
+	failIf(b, "$zero")
+	b.get("$a")
+	b.i64(math.MinInt64)
+	b.op("i64.eq")
+	b.get("$b")
+	b.i64(-1)
+	b.op("i64.eq")
+	b.op("i32.and")
+	failIf(b, "$overflow")
+	b.get("$a")
+	b.get("$b")
+	b.op("i64.div_s")
+}
+
+// remBody needs no overflow check, as rem_s gives 0 for the smallest
+// integer and -1 rather than trapping
+func remBody(b *builder) {
+	b.get("$b")
+	b.op("i64.eqz")
+	failIf(b, "$zero")
+	b.get("$a")
+	b.get("$b")
+	b.op("i64.rem_s")
+}
+
+func negBody(b *builder) {
+	b.get("$a")
+	b.i64(math.MinInt64)
+	b.op("i64.eq")
+	failIf(b, "$message")
+	b.i64(0)
+	b.get("$a")
+	b.op("i64.sub")
+}
@@ -0,0 +1,561 @@
+(module
+  (type (;0;) (func (param i32 i32 i32 i32) (result i32)))
+  (type (;1;) (func (param i32)))
+  (type (;2;) (func (param i32 i32 i32)))
+  (type (;3;) (func (param i32) (result i32)))
+  (type (;4;) (func (param i64)))
+  (type (;5;) (func (param i32 i32) (result i32)))
+  (type (;6;) (func (param i64 i64 i32) (result i64)))
+  (type (;7;) (func (param i64 i64 i32 i32) (result i64)))
+  (type (;8;) (func (param i64 i32) (result i64)))
+  (type (;9;) (func))
+  (type (;10;) (func (param i32 i64) (result i32)))
+  (type (;11;) (func (param i32 i32 i32) (result i32)))
+  (type (;12;) (func (param i32) (result i64)))
+  (type (;13;) (func (param i32 i64) (result i64)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (type 0)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (type 1)))
+  (func $write (type 2) (param $fd i32) (param $ptr i32) (param $len i32)
+    i32.const 0
+    local.get $ptr
+    i32.store
+    i32.const 4
+    local.get $len
+    i32.store
+    i32.const 8
+    i32.const 20
+    i32.store
+    i32.const 12
+    i32.const 1
+    i32.store
+    i32.const 20
+    i32.const 10
+    i32.store8
+    local.get $fd
+    i32.const 0
+    i32.const 2
+    i32.const 16
+    call $fd_write
+    drop
+  )
+  (func $fail (type 1) (param $message i32)
+    i32.const 2
+    local.get $message
+    i32.const 4
+    i32.add
+    local.get $message
+    i32.load
+    call $write
+    i32.const 1
+    call $proc_exit
+    unreachable
+  )
+  (func $alloc (type 3) (param $size i32) (result i32)
+    (local $p i32)
+    (local $end i32)
+    global.get $heap
+    local.set $p
+    local.get $p
+    local.get $size
+    i32.add
+    i32.const 7
+    i32.add
+    i32.const -8
+    i32.and
+    local.tee $end
+    global.set $heap
+    block
+      local.get $end
+      memory.size
+      i32.const 16
+      i32.shl
+      i32.le_u
+      br_if 0
+      local.get $end
+      memory.size
+      i32.const 16
+      i32.shl
+      i32.sub
+      i32.const 65535
+      i32.add
+      i32.const 16
+      i32.shr_u
+      memory.grow
+      i32.const -1
+      i32.ne
+      br_if 0
+      i32.const 64
+      call $fail
+    end
+    local.get $p
+  )
+  (func $print_string (type 1) (param $s i32)
+    i32.const 1
+    local.get $s
+    i32.const 4
+    i32.add
+    local.get $s
+    i32.load
+    call $write
+  )
+  (func $print_bool (type 1) (param $b i32)
+    i32.const 156
+    i32.const 164
+    local.get $b
+    select
+    call $print_string
+  )
+  (func $print_int (type 4) (param $v i64)
+    (local $pos i32)
+    (local $neg i32)
+    i32.const 64
+    local.set $pos
+    local.get $v
+    i64.const 0
+    i64.lt_s
+    local.tee $neg
+    i32.eqz
+    if
+      i64.const 0
+      local.get $v
+      i64.sub
+      local.set $v
+    end
+    loop
+      local.get $pos
+      i32.const 1
+      i32.sub
+      local.tee $pos
+      i32.const 48
+      i64.const 0
+      local.get $v
+      i64.const 10
+      i64.rem_s
+      i64.sub
+      i32.wrap_i64
+      i32.add
+      i32.store8
+      local.get $v
+      i64.const 10
+      i64.div_s
+      local.tee $v
+      i64.eqz
+      i32.eqz
+      br_if 0
+    end
+    local.get $neg
+    if
+      local.get $pos
+      i32.const 1
+      i32.sub
+      local.tee $pos
+      i32.const 45
+      i32.store8
+    end
+    i32.const 1
+    local.get $pos
+    i32.const 64
+    local.get $pos
+    i32.sub
+    call $write
+  )
+  (func $concat (type 5) (param $a i32) (param $b i32) (result i32)
+    (local $la i32)
+    (local $lb i32)
+    (local $p i32)
+    local.get $a
+    i32.load
+    local.set $la
+    local.get $b
+    i32.load
+    local.set $lb
+    local.get $la
+    local.get $lb
+    i32.add
+    i32.const 4
+    i32.add
+    call $alloc
+    local.tee $p
+    local.get $la
+    local.get $lb
+    i32.add
+    i32.store
+    local.get $p
+    i32.const 4
+    i32.add
+    local.get $a
+    i32.const 4
+    i32.add
+    local.get $la
+    memory.copy
+    local.get $p
+    i32.const 4
+    i32.add
+    local.get $la
+    i32.add
+    local.get $b
+    i32.const 4
+    i32.add
+    local.get $lb
+    memory.copy
+    local.get $p
+  )
+  (func $string_equal (type 5) (param $a i32) (param $b i32) (result i32)
+    (local $n i32)
+    (local $i i32)
+    local.get $a
+    i32.load
+    local.tee $n
+    local.get $b
+    i32.load
+    i32.ne
+    if
+      i32.const 0
+      return
+    end
+    block
+      loop
+        local.get $i
+        local.get $n
+        i32.ge_u
+        br_if 1
+        local.get $a
+        local.get $i
+        i32.add
+        i32.load8_u offset=4
+        local.get $b
+        local.get $i
+        i32.add
+        i32.load8_u offset=4
+        i32.ne
+        if
+          i32.const 0
+          return
+        end
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br 0
+      end
+    end
+    i32.const 1
+  )
+  (func $add (type 6) (param $a i64) (param $b i64) (param $message i32) (result i64)
+    (local $r i64)
+    local.get $a
+    local.get $b
+    i64.add
+    local.set $r
+    local.get $a
+    local.get $r
+    i64.xor
+    local.get $b
+    local.get $r
+    i64.xor
+    i64.and
+    i64.const 0
+    i64.lt_s
+    if
+      local.get $message
+      call $fail
+    end
+    local.get $r
+  )
+  (func $sub (type 6) (param $a i64) (param $b i64) (param $message i32) (result i64)
+    (local $r i64)
+    local.get $a
+    local.get $b
+    i64.sub
+    local.set $r
+    local.get $a
+    local.get $b
+    i64.xor
+    local.get $a
+    local.get $r
+    i64.xor
+    i64.and
+    i64.const 0
+    i64.lt_s
+    if
+      local.get $message
+      call $fail
+    end
+    local.get $r
+  )
+  (func $mul (type 6) (param $a i64) (param $b i64) (param $message i32) (result i64)
+    (local $r i64)
+    local.get $a
+    local.get $b
+    i64.mul
+    local.set $r
+    block
+      local.get $a
+      i64.eqz
+      br_if 0
+      local.get $a
+      i64.const -1
+      i64.eq
+      if (result i32)
+        local.get $b
+        i64.const -9223372036854775808
+        i64.eq
+      else
+        local.get $r
+        local.get $a
+        i64.div_s
+        local.get $b
+        i64.ne
+      end
+      if
+        local.get $message
+        call $fail
+      end
+    end
+    local.get $r
+  )
+  (func $div (type 7) (param $a i64) (param $b i64) (param $zero i32) (param $overflow i32) (result i64)
+    local.get $b
+    i64.eqz
+    if
+      local.get $zero
+      call $fail
+    end
+    local.get $a
+    i64.const -9223372036854775808
+    i64.eq
+    local.get $b
+    i64.const -1
+    i64.eq
+    i32.and
+    if
+      local.get $overflow
+      call $fail
+    end
+    local.get $a
+    local.get $b
+    i64.div_s
+  )
+  (func $rem (type 6) (param $a i64) (param $b i64) (param $zero i32) (result i64)
+    local.get $b
+    i64.eqz
+    if
+      local.get $zero
+      call $fail
+    end
+    local.get $a
+    local.get $b
+    i64.rem_s
+  )
+  (func $neg (type 8) (param $a i64) (param $message i32) (result i64)
+    local.get $a
+    i64.const -9223372036854775808
+    i64.eq
+    if
+      local.get $message
+      call $fail
+    end
+    i64.const 0
+    local.get $a
+    i64.sub
+  )
+  (func $_start (type 9)
+    (local $tmp.1 i32)
+    (local $tmp.2 i32)
+    (local $tmp.3 i32)
+    (local $tmp.4 i32)
+    (local $tmp.5 i32)
+    (local $tmp.6 i32)
+    (local $tmp.7 i32)
+    (local $tmp.8 i32)
+    i32.const 4
+    call $alloc
+    local.tee $tmp.1
+    i32.const 0
+    i32.store
+    local.get $tmp.1
+    global.set $g.counter
+    i32.const 4
+    call $alloc
+    local.tee $tmp.2
+    i32.const 1
+    i32.store
+    local.get $tmp.2
+    global.set $g.compose
+    i32.const 0
+    i64.const 5
+    call $fn.counter
+    global.set $g.c
+    global.get $g.c
+    local.tee $tmp.3
+    local.get $tmp.3
+    i32.load
+    call_indirect (type 12)
+    drop
+    global.get $g.c
+    local.tee $tmp.4
+    local.get $tmp.4
+    i32.load
+    call_indirect (type 12)
+    drop
+    i32.const 4
+    call $alloc
+    local.tee $tmp.5
+    i32.const 2
+    i32.store
+    local.get $tmp.5
+    global.set $g.double
+    i32.const 4
+    call $alloc
+    local.tee $tmp.6
+    i32.const 3
+    i32.store
+    local.get $tmp.6
+    global.set $g.inc
+    i32.const 0
+    global.get $g.double
+    global.get $g.inc
+    call $fn.compose
+    local.tee $tmp.7
+    global.get $g.c
+    local.tee $tmp.8
+    local.get $tmp.8
+    i32.load
+    call_indirect (type 12)
+    local.get $tmp.7
+    i32.load
+    call_indirect (type 13)
+    call $print_int
+  )
+  (func $fn.counter (type 10) (param $closure.ptr i32) (param $step i64) (result i32)
+    (local $step.cell i32)
+    (local $n.cell i32)
+    (local $tmp.1 i32)
+    i32.const 8
+    call $alloc
+    local.set $step.cell
+    i32.const 8
+    call $alloc
+    local.set $n.cell
+    local.get $step.cell
+    local.get $step
+    i64.store
+    local.get $n.cell
+    i64.const 0
+    i64.store
+    i32.const 12
+    call $alloc
+    local.tee $tmp.1
+    i32.const 4
+    i32.store
+    local.get $tmp.1
+    local.get $n.cell
+    i32.store offset=4
+    local.get $tmp.1
+    local.get $step.cell
+    i32.store offset=8
+    local.get $tmp.1
+  )
+  (func $fn.compose (type 11) (param $closure.ptr i32) (param $f i32) (param $g i32) (result i32)
+    (local $f.cell i32)
+    (local $g.cell i32)
+    (local $tmp.1 i32)
+    i32.const 8
+    call $alloc
+    local.set $f.cell
+    i32.const 8
+    call $alloc
+    local.set $g.cell
+    local.get $f.cell
+    local.get $f
+    i32.store
+    local.get $g.cell
+    local.get $g
+    i32.store
+    i32.const 12
+    call $alloc
+    local.tee $tmp.1
+    i32.const 5
+    i32.store
+    local.get $tmp.1
+    local.get $f.cell
+    i32.store offset=4
+    local.get $tmp.1
+    local.get $g.cell
+    i32.store offset=8
+    local.get $tmp.1
+  )
+  (func $fn.double (type 13) (param $closure.ptr i32) (param $x i64) (result i64)
+    local.get $x
+    i64.const 2
+    i32.const 176
+    call $mul
+  )
+  (func $fn.inc (type 13) (param $closure.ptr i32) (param $x i64) (result i64)
+    local.get $x
+    i64.const 1
+    i32.const 304
+    call $add
+  )
+  (func $fn.0 (type 12) (param $closure.ptr i32) (result i64)
+    (local $n.cell i32)
+    (local $step.cell i32)
+    (local $tmp.1 i64)
+    local.get $closure.ptr
+    i32.load offset=4
+    local.set $n.cell
+    local.get $closure.ptr
+    i32.load offset=8
+    local.set $step.cell
+    local.get $n.cell
+    local.get $n.cell
+    i64.load
+    local.get $step.cell
+    i64.load
+    i32.const 432
+    call $add
+    local.tee $tmp.1
+    i64.store
+    local.get $tmp.1
+    drop
+    local.get $n.cell
+    i64.load
+  )
+  (func $fn.1 (type 13) (param $closure.ptr i32) (param $x i64) (result i64)
+    (local $f.cell i32)
+    (local $g.cell i32)
+    (local $tmp.1 i32)
+    (local $tmp.2 i32)
+    local.get $closure.ptr
+    i32.load offset=4
+    local.set $f.cell
+    local.get $closure.ptr
+    i32.load offset=8
+    local.set $g.cell
+    local.get $f.cell
+    i32.load
+    local.tee $tmp.1
+    local.get $g.cell
+    i32.load
+    local.tee $tmp.2
+    local.get $x
+    local.get $tmp.2
+    i32.load
+    call_indirect (type 13)
+    local.get $tmp.1
+    i32.load
+    call_indirect (type 13)
+  )
+  (table 6 funcref)
+  (memory 1)
+  (global $heap (mut i32) (i32.const 560))
+  (global $g.counter (mut i32) (i32.const 0))
+  (global $g.compose (mut i32) (i32.const 0))
+  (global $g.c (mut i32) (i32.const 0))
+  (global $g.double (mut i32) (i32.const 0))
+  (global $g.inc (mut i32) (i32.const 0))
+  (export "memory" (memory 0))
+  (export "_start" (func $_start))
+  (elem (i32.const 0) func $fn.counter $fn.compose $fn.double $fn.inc $fn.0 $fn.1)
+  (data (i32.const 64) "V\00\00\00file: closures.gos line: 0 char: 0 [E0112] memory limit exceeded: out of linear memory\00\00\04\00\00\00true\05\00\00\00false\00\00\00y\00\00\00file: ../internal/golden/testdata/closures.gos line: 10 char: 29 [E0116] integer overflow: result does not fit in 64 bits\00\00\00y\00\00\00file: ../internal/golden/testdata/closures.gos line: 11 char: 26 [E0116] integer overflow: result does not fit in 64 bits\00\00\00x\00\00\00file: ../internal/golden/testdata/closures.gos line: 2 char: 15 [E0116] integer overflow: result does not fit in 64 bits")
+)
@@ -0,0 +1,522 @@
+// Package wasm compiles Gosling programs to WebAssembly modules that run
+// under WASI, for gosling build --target=wasm.
+//
+// It compiles the subset of the language that package infer can type.
+// Integers are i64 and booleans i32. Strings live in linear memory as a
+// 32-bit length followed by the bytes, and closures as the table index of
+// their function followed by the cells of the variables they capture.
+// Memory is allocated from a heap that only grows. The module exports
+// _start, which prints the value of the last statement through fd_write
+// as gosling run does, and its memory.
+//
+// As in package llvm, arithmetic that overflows 64 bits stops the program
+// with an integer overflow error, as gosling run --strict-overflow does.
+// Run time errors are printed to stderr and exit with status 1.
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/infer"
+	"gosling/token"
+	"gosling/types"
+)
+
+// heap is the index of the global holding the address of the next
+// allocation
+const heap = 0
+
+// Compile compiles program to a module. filename names the source in the
+// locations of run time errors.
+func Compile(program *ast.Program, filename string) (*Module, []diag.Diagnostic) {
+	info, diagnostics := infer.Check(program)
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+
+	c := &compiler{
+		m:        &Module{},
+		info:     info,
+		filename: filename,
+		helpers:  make(map[string]int),
+		strings:  make(map[string]int32),
+		globals:  make(map[*infer.Binding]int),
+		symbols:  make(map[*infer.Function]int),
+		bodies:   make(map[*infer.Function]*function),
+	}
+	c.m.globals = append(c.m.globals, global{name: "$heap", typ: i32})
+	for _, g := range info.Globals {
+		c.globals[g] = len(c.m.globals)
+		c.m.globals = append(c.m.globals, global{name: "$g." + g.Name, typ: valueType(g.Type)})
+	}
+	c.runtime()
+
+	start := c.function("$_start", nil, 0, signature{})
+	c.m.start = len(c.m.imports) + len(c.m.functions) - 1
+	c.main(start, program)
+	for len(c.pending) > 0 {
+		fn := c.pending[0]
+		c.pending = c.pending[1:]
+		c.lower(fn)
+	}
+	if len(c.diagnostics) > 0 {
+		return nil, c.diagnostics
+	}
+
+	top := (dataOffset + len(c.m.data) + 7) &^ 7
+	c.m.globals[heap].init = int64(top)
+	c.m.pages = (top + 0xFFFF) >> 16
+	return c.m, nil
+}
+
+type compiler struct {
+	m           *Module
+	info        *infer.Info
+	filename    string
+	diagnostics []diag.Diagnostic
+
+	// helpers maps the names of the runtime's functions and imports to
+	// their indices
+	helpers map[string]int
+	// strings maps the contents of string literals and error messages to
+	// their addresses
+	strings map[string]int32
+	globals map[*infer.Binding]int
+	// symbols maps functions to their index in the function space,
+	// pending those whose bodies are still to be lowered
+	symbols   map[*infer.Function]int
+	bodies    map[*infer.Function]*function
+	pending   []*infer.Function
+	anonymous int
+}
+
+func (c *compiler) unsupported(node ast.Node, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diag.New(diag.UnsupportedConstruct, node.Location(), format, a...))
+}
+
+// function adds a function to the module
+func (c *compiler) function(name string, params []local, result valType, sig signature) *function {
+	f := &function{name: name, typ: c.m.typeIndex(sig), params: params, result: result}
+	c.m.functions = append(c.m.functions, f)
+	return f
+}
+
+// stringData returns the address of a string holding s in the data
+func (c *compiler) stringData(s string) int32 {
+	if addr, ok := c.strings[s]; ok {
+		return addr
+	}
+	for len(c.m.data)%4 != 0 {
+		c.m.data = append(c.m.data, 0)
+	}
+	addr := int32(dataOffset + len(c.m.data))
+	c.m.data = binary.LittleEndian.AppendUint32(c.m.data, uint32(len(s)))
+	c.m.data = append(c.m.data, s...)
+	c.strings[s] = addr
+	return addr
+}
+
+// message returns the address of the message of a run time error,
+// formatted as gosling run prints it
+func (c *compiler) message(code diag.Code, loc token.TokenLocation, message string) int32 {
+	return c.stringData(diag.Format(code, loc, message))
+}
+
+func valueType(t types.Type) valType {
+	switch t.(type) {
+	case *types.IntegerType:
+		return i64
+	case *types.BooleanType, *types.StringType, *types.FunctionType:
+		return i32
+	}
+	return 0
+}
+
+// signatureOf is the signature of the code of a closure of type t, which
+// takes the closure first
+func signatureOf(t *types.FunctionType) signature {
+	sig := signature{params: []valType{i32}, result: valueType(t.Result)}
+	for _, p := range t.Parameters {
+		sig.params = append(sig.params, valueType(p))
+	}
+	return sig
+}
+
+// symbol returns the index of fn in the function space, queueing its body
+func (c *compiler) symbol(fn *infer.Function) int {
+	if index, ok := c.symbols[fn]; ok {
+		return index
+	}
+	name := "$fn." + fn.Name
+	if fn.Name == "" {
+		name = fmt.Sprintf("$fn.%d", c.anonymous)
+		c.anonymous++
+	}
+	params := []local{{"$closure.ptr", i32}}
+	for i, p := range fn.Params {
+		params = append(params, local{"$" + p.Name, valueType(fn.Type.Parameters[i])})
+	}
+	f := c.function(name, params, valueType(fn.Type.Result), signatureOf(fn.Type))
+	index := len(c.m.imports) + len(c.m.functions) - 1
+	c.symbols[fn] = index
+	c.bodies[fn] = f
+	c.m.table = append(c.m.table, index)
+	c.pending = append(c.pending, fn)
+	return index
+}
+
+// tableIndex returns where closures of fn find its code
+func (c *compiler) tableIndex(fn *infer.Function) int32 {
+	index := c.symbol(fn)
+	for i, f := range c.m.table {
+		if f == index {
+			return int32(i)
+		}
+	}
+	panic("wasm: function missing from the table")
+}
+
+// emitter lowers the body of one function, or of _start
+type emitter struct {
+	builder
+	fn    *infer.Function // nil for _start
+	temps int
+	// terminated is set once the code being written can no longer be
+	// reached, after a return
+	terminated bool
+}
+
+func (e *emitter) temp(t valType) string {
+	e.temps++
+	name := fmt.Sprintf("$tmp.%d", e.temps)
+	e.declare(name, t)
+	return name
+}
+
+func (c *compiler) main(f *function, program *ast.Program) {
+	e := &emitter{builder: builder{c: c, f: f}}
+	for i, stmt := range program.Statements {
+		t := e.statement(stmt)
+		if t == 0 {
+			continue
+		}
+		es, ok := stmt.(*ast.ExpressionStatement)
+		if i < len(program.Statements)-1 || !ok {
+			e.op("drop")
+			continue
+		}
+		switch c.info.Types[es.Expression].(type) {
+		case *types.IntegerType:
+			e.callHelper("print_int")
+		case *types.BooleanType:
+			e.callHelper("print_bool")
+		case *types.StringType:
+			e.callHelper("print_string")
+		default:
+			c.unsupported(es, "cannot compile %s: printing a function is not supported", es.String())
+		}
+	}
+}
+
+func (c *compiler) lower(fn *infer.Function) {
+	e := &emitter{builder: builder{c: c, f: c.bodies[fn]}, fn: fn}
+	for i, b := range fn.Free {
+		e.declare("$"+b.Name+".cell", i32)
+		e.get("$closure.ptr")
+		e.memory("i32.load", 4+4*i)
+		e.set("$" + b.Name + ".cell")
+	}
+	for _, b := range fn.Locals {
+		if !b.Captured {
+			e.declare("$"+b.Name, valueType(b.Type))
+		}
+	}
+	for _, b := range append(append([]*infer.Binding{}, fn.Params...), fn.Locals...) {
+		if !b.Captured {
+			continue
+		}
+		e.declare("$"+b.Name+".cell", i32)
+		e.i32(8)
+		e.callHelper("alloc")
+		e.set("$" + b.Name + ".cell")
+	}
+	for _, p := range fn.Params {
+		if p.Captured {
+			e.get("$" + p.Name + ".cell")
+			e.get("$" + p.Name)
+			e.memory(store(valueType(p.Type)), 0)
+		}
+	}
+
+	t := e.block(fn.Literal.Body)
+	result := valueType(fn.Type.Result)
+	switch {
+	case e.terminated:
+	case t != 0 && result == 0:
+		e.op("drop")
+	case t == 0 && result != 0:
+		e.op("unreachable")
+	}
+}
+
+// block lowers the statements of b, leaving the value of the last one on
+// the stack and returning its type
+func (e *emitter) block(b *ast.BlockStatement) valType {
+	var t valType
+	if b == nil {
+		return 0
+	}
+	for i, stmt := range b.Statements {
+		t = e.statement(stmt)
+		if t != 0 && i < len(b.Statements)-1 {
+			e.op("drop")
+		}
+	}
+	return t
+}
+
+func (e *emitter) statement(stmt ast.Statement) valType {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		e.storeBinding(e.c.info.Bindings[stmt.Name], stmt.Value, false)
+	case *ast.ReturnStatement:
+		if stmt.ReturnValue != nil {
+			if e.expression(stmt.ReturnValue) != 0 && e.f.result == 0 {
+				e.op("drop")
+			}
+		}
+		e.op("return")
+		e.terminated = true
+	case *ast.ExpressionStatement:
+		return e.expression(stmt.Expression)
+	}
+	return 0
+}
+
+// storeBinding assigns the value of exp to b, keeping the value on the stack if
+// keep is set
+func (e *emitter) storeBinding(b *infer.Binding, exp ast.Expression, keep bool) {
+	t := valueType(b.Type)
+	switch {
+	case b.Owner == nil:
+		e.expression(exp)
+		e.globalSet(e.c.globals[b])
+		if keep {
+			e.globalGet(e.c.globals[b])
+		}
+	case b.Owner != e.fn || b.Captured:
+		e.get("$" + b.Name + ".cell")
+		e.expression(exp)
+		var tmp string
+		if keep {
+			tmp = e.temp(t)
+			e.tee(tmp)
+		}
+		e.memory(store(t), 0)
+		if keep {
+			e.get(tmp)
+		}
+	default:
+		e.expression(exp)
+		if keep {
+			e.tee("$" + b.Name)
+		} else {
+			e.set("$" + b.Name)
+		}
+	}
+}
+
+// cell pushes the address of the cell holding b
+func (e *emitter) cell(b *infer.Binding) {
+	e.get("$" + b.Name + ".cell")
+}
+
+func (e *emitter) loadBinding(b *infer.Binding) {
+	switch {
+	case b.Owner == nil:
+		e.globalGet(e.c.globals[b])
+	case b.Owner != e.fn || b.Captured:
+		e.cell(b)
+		e.memory(load(valueType(b.Type)), 0)
+	default:
+		e.get("$" + b.Name)
+	}
+}
+
+func (e *emitter) expression(exp ast.Expression) valType {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		e.i64(exp.Value)
+	case *ast.Boolean:
+		if exp.Value {
+			e.i32(1)
+		} else {
+			e.i32(0)
+		}
+	case *ast.StringLiteral:
+		e.i32(e.c.stringData(exp.Value))
+	case *ast.Identifier:
+		b := e.c.info.Bindings[exp]
+		if b == nil {
+			e.c.unsupported(exp, "cannot compile the builtin %s as a value, only calls of it", exp.Value)
+			return 0
+		}
+		e.loadBinding(b)
+	case *ast.AssignExpression:
+		e.storeBinding(e.c.info.Bindings[exp.Name], exp.Value, true)
+	case *ast.PrefixExpression:
+		e.expression(exp.Right)
+		if exp.Operator == "!" {
+			e.op("i32.eqz")
+		} else {
+			e.i32(e.c.message(diag.IntegerOverflow, exp.Token.Location, overflow))
+			e.callHelper("neg")
+		}
+	case *ast.InfixExpression:
+		e.infix(exp)
+	case *ast.IfExpression:
+		e.ifExpression(exp)
+	case *ast.ForExpression:
+		e.forExpression(exp)
+	case *ast.FunctionLiteral:
+		e.closure(e.c.info.Functions[exp])
+	case *ast.CallExpression:
+		e.callExpression(exp)
+	default:
+		e.c.unsupported(exp, "cannot compile %s", exp.String())
+	}
+	return valueType(e.c.info.Types[exp])
+}
+
+const overflow = "integer overflow: result does not fit in 64 bits"
+
+var arithmetic = map[string]string{"+": "add", "-": "sub", "*": "mul"}
+
+var comparisons = map[string]string{"<": "i64.lt_s", ">": "i64.gt_s", "==": "i64.eq", "!=": "i64.ne"}
+
+func (e *emitter) infix(exp *ast.InfixExpression) {
+	e.expression(exp.Left)
+	e.expression(exp.Right)
+	loc := exp.Token.Location
+
+	switch e.c.info.Types[exp.Left].(type) {
+	case *types.StringType:
+		if exp.Operator == "+" {
+			e.callHelper("concat")
+			return
+		}
+		e.callHelper("string_equal")
+		if exp.Operator == "!=" {
+			e.op("i32.eqz")
+		}
+		return
+	case *types.BooleanType:
+		if exp.Operator == "==" {
+			e.op("i32.eq")
+		} else {
+			e.op("i32.ne")
+		}
+		return
+	}
+
+	switch exp.Operator {
+	case "+", "-", "*":
+		e.i32(e.c.message(diag.IntegerOverflow, loc, overflow))
+		e.callHelper(arithmetic[exp.Operator])
+	case "/":
+		e.i32(e.c.message(diag.DivisionByZero, loc, "division by zero"))
+		e.i32(e.c.message(diag.IntegerOverflow, loc, overflow))
+		e.callHelper("div")
+	case "%":
+		e.i32(e.c.message(diag.ModuloByZero, loc, "modulo by zero"))
+		e.callHelper("rem")
+	default:
+		e.op(comparisons[exp.Operator])
+	}
+}
+
+func (e *emitter) ifExpression(ie *ast.IfExpression) {
+	t := valueType(e.c.info.Types[ie])
+	e.expression(ie.Condition)
+	e.open("if", t)
+	branch := func(b *ast.BlockStatement) bool {
+		e.terminated = false
+		if got := e.block(b); got != 0 && t == 0 {
+			e.op("drop")
+		}
+		return e.terminated
+	}
+	consTerminated := branch(ie.Consequence)
+	altTerminated := false
+	if ie.Alternative != nil {
+		e.else_()
+		altTerminated = branch(ie.Alternative)
+	}
+	e.end()
+	e.terminated = consTerminated && altTerminated
+}
+
+func (e *emitter) forExpression(fe *ast.ForExpression) {
+	e.open("block", 0)
+	e.open("loop", 0)
+	e.expression(fe.Condition)
+	e.op("i32.eqz")
+	e.brIf(1)
+	if e.block(fe.Body) != 0 {
+		e.op("drop")
+	}
+	e.br(0)
+	e.end()
+	e.end()
+	e.terminated = false
+}
+
+// closure makes a closure of fn holding the cells of its free bindings,
+// which the current function owns or has itself captured
+func (e *emitter) closure(fn *infer.Function) {
+	tmp := e.temp(i32)
+	e.i32(int32(4 + 4*len(fn.Free)))
+	e.callHelper("alloc")
+	e.tee(tmp)
+	e.i32(e.c.tableIndex(fn))
+	e.memory("i32.store", 0)
+	for i, b := range fn.Free {
+		e.get(tmp)
+		e.cell(b)
+		e.memory("i32.store", 4+4*i)
+	}
+	e.get(tmp)
+}
+
+func (e *emitter) callExpression(call *ast.CallExpression) {
+	if ident, ok := call.Function.(*ast.Identifier); ok {
+		b := e.c.info.Bindings[ident]
+		switch {
+		case b == nil && ident.Value == "len":
+			e.expression(call.Arguments[0])
+			e.memory("i32.load", 0)
+			e.op("i64.extend_i32_u")
+			return
+		case b != nil && b.Function != nil && len(b.Function.Free) == 0:
+			// the function needs no closure, call it directly
+			e.i32(0)
+			for _, a := range call.Arguments {
+				e.expression(a)
+			}
+			e.call(e.c.symbol(b.Function))
+			return
+		}
+	}
+
+	closure := e.temp(i32)
+	e.expression(call.Function)
+	e.tee(closure)
+	for _, a := range call.Arguments {
+		e.expression(a)
+	}
+	e.get(closure)
+	e.memory("i32.load", 0)
+	e.callIndirect(signatureOf(e.c.info.Types[call.Function].(*types.FunctionType)))
+}
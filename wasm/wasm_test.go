@@ -0,0 +1,151 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"gosling/ast"
+	"gosling/diag"
+	"gosling/evaluator"
+	"gosling/internal/golden"
+	"gosling/object"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func compile(t *testing.T, program *ast.Program, filename string) *Module {
+	t.Helper()
+	module, diagnostics := Compile(program, filename)
+	if len(diagnostics) > 0 {
+		t.Fatalf("%s: unexpected diagnostics: %v", filename, diagnostics)
+	}
+	return module
+}
+
+// run instantiates a module, which runs its _start, and returns what it
+// wrote to stdout and stderr
+func run(t *testing.T, module *Module) string {
+	t.Helper()
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+
+	var stdout, stderr bytes.Buffer
+	config := wazero.NewModuleConfig().WithStdout(&stdout).WithStderr(&stderr)
+	_, err := r.InstantiateWithConfig(ctx, module.Binary(), config)
+	var exitErr *sys.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("running the module failed: %v\n%s", err, module.Text())
+	}
+	return stdout.String() + stderr.String()
+}
+
+// interpret returns what gosling run --strict-overflow prints for program
+func interpret(program *ast.Program) string {
+	result := evaluator.Eval(context.Background(), program, object.NewEnvironment(), evaluator.Options{StrictOverflow: true})
+	if result == nil || result == evaluator.NULL {
+		return ""
+	}
+	return result.Inspect() + "\n"
+}
+
+// sameOutput compares outputs, allowing integer overflow errors to leave
+// out the value that did not fit, which compiled code does not work out
+func sameOutput(want, got string) bool {
+	const overflow = "[E0116] integer overflow:"
+	if i := strings.Index(want, overflow); i >= 0 {
+		return strings.HasPrefix(got, want[:i+len(overflow)])
+	}
+	return want == got
+}
+
+// TestGolden compiles each program and compares the text of the module
+// with its .wat file in testdata
+func TestGolden(t *testing.T) {
+	for _, path := range golden.Programs(t) {
+		text := compile(t, golden.ParseFile(t, path), filepath.Base(path)).Text()
+		golden.Compare(t, path, ".wat", []byte(text), *update)
+	}
+}
+
+// TestRun runs each program and checks that it prints what the evaluator
+// does
+func TestRun(t *testing.T) {
+	for _, path := range golden.Programs(t) {
+		got := run(t, compile(t, golden.ParseFile(t, path), filepath.Base(path)))
+		if want := interpret(golden.ParseFile(t, path)); !sameOutput(want, got) {
+			t.Errorf("%s: output differs from the evaluator.\nwant=%q\ngot=%q", path, want, got)
+		}
+	}
+}
+
+func TestMatchesEvaluator(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3",
+		"-9223372036854775807 - 1",
+		"9223372036854775807 + 1",
+		"-9223372036854775807 - 2",
+		"3037000500 * 3037000500",
+		"let m = -9223372036854775807 - 1; m / -1",
+		"let m = -9223372036854775807 - 1; m % -1",
+		"let m = -9223372036854775807 - 1; -m",
+		"let m = -9223372036854775807 - 1; m",
+		"-7 / 2",
+		"-7 % 2",
+		"5 % 0",
+		"true == false",
+		"!(1 < 2)",
+		"\"\"",
+		"let s = \"ab\"; s + s == \"abab\"",
+		"\"ab\" != \"ac\"",
+		"len(\"hello\" + \" \" + \"world\")",
+		"let x = 1; x = x + 1; x",
+		"let f = fn(b: bool) { if (b) { return 1; } 2 }; f(true) + f(false)",
+		"let f = fn(b: bool) { if (b) { 1 } else { 2 } }; f(false)",
+		"let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n",
+		"let f = fn() { let x = 1; let g = fn() { x = x + 1 }; g(); g(); x }; f()",
+		"let apply = fn(f: fn(int) -> int, x: int) { f(x) }; apply(fn(x: int) { x * x }, 7)",
+		"let make = fn(s: string) { fn(t: string) { s + t } }; make(\"a\")(\"b\")",
+		"let i = 0; for (i < 5) { i = i + 1; } i",
+		"let grow = fn(s: string, n: int) -> string { if (n == 0) { s } else { grow(s + s, n - 1) } }; len(grow(\"ab\", 16))",
+	}
+
+	for _, input := range inputs {
+		module, diagnostics := Compile(golden.Parse(t, input), "")
+		if len(diagnostics) > 0 {
+			t.Errorf("unexpected diagnostics for %q: %v", input, diagnostics)
+			continue
+		}
+		got := run(t, module)
+		if want := interpret(golden.Parse(t, input)); !sameOutput(want, got) {
+			t.Errorf("output differs from the evaluator for %q.\nwant=%q\ngot=%q", input, want, got)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []struct {
+		input   string
+		code    diag.Code
+		message string
+	}{
+		{"let x = none;", diag.UnsupportedConstruct, "cannot compile none: options are not supported"},
+		{"let f = fn(x) { x };", diag.NoStaticType, "cannot work out the type of f, annotate it"},
+		{"let y = len;", diag.UnsupportedConstruct, "cannot compile the builtin len as a value, only calls of it"},
+		{"let f = fn(x: int) { x }; f", diag.UnsupportedConstruct, "cannot compile f: printing a function is not supported"},
+	}
+
+	for _, tt := range tests {
+		_, diagnostics := Compile(golden.Parse(t, tt.input), "test.gos")
+		golden.Diagnostic(t, tt.input, diagnostics, tt.code, tt.message)
+	}
+}